@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"reflect"
+
+	"github.com/DataDog/agent-payload/v5/gogen"
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type decoder func(body []byte) ([]datadog.Series, error)
+
+// fuzzDiffFormats pairs the decoders of the proxy with reference ones built
+// on the agent payload protobuf bindings.
+var fuzzDiffFormats = map[string][2]decoder{
+	"series-v2": {server.DecodeSeriesV2, referenceSeriesV2},
+	"sketches":  {server.DecodeSketches, referenceSketches},
+}
+
+// fuzzDiff decodes captured payloads, and random mutations of them, with the
+// hand rolled protobuf decoding of the proxy and with the agent payload
+// bindings, printing every payload they disagree on. It returns the exit
+// code, 1 when any payload differs.
+func fuzzDiff(args []string) int {
+	fs := flag.NewFlagSet("fuzz-diff", flag.ExitOnError)
+	format := fs.String("format", "series-v2", "Format of the payloads, series-v2 or sketches")
+	mutations := fs.Int("mutations", 0, "Random mutations of each payload to diff as well")
+	seed := fs.Int64("seed", 0, "Seed of the mutations, random when 0")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: proxy-filter fuzz-diff [flags] <captured payload>...")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	decoders, ok := fuzzDiffFormats[*format]
+	if !ok || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	rnd := clock.NewRand(*seed)
+	var total, differ int
+	for _, name := range fs.Args() {
+		body, err := readPayload(name)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Could not read %s, %v", name, err))
+			return 2
+		}
+		total++
+		if diffPayload(name, body, decoders) {
+			differ++
+		}
+		for i := 0; i < *mutations; i++ {
+			total++
+			if diffPayload(fmt.Sprintf("%s mutation %d", name, i), mutate(body, rnd), decoders) {
+				differ++
+			}
+		}
+	}
+	fmt.Println(fmt.Sprintf("Diffed %d payloads, %d differ", total, differ))
+	if differ > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readPayload reads a captured request body, decompressing it when it starts
+// like a zlib or gzip stream.
+func readPayload(name string) ([]byte, error) {
+	body, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var rc io.ReadCloser
+	switch {
+	case len(body) > 1 && body[0] == 0x1f && body[1] == 0x8b:
+		rc, err = gzip.NewReader(bytes.NewReader(body))
+	case len(body) > 1 && body[0] == 0x78 && (uint16(body[0])<<8|uint16(body[1]))%31 == 0:
+		rc, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// diffPayload reports whether the decoders disagree on body, printing how.
+func diffPayload(name string, body []byte, decoders [2]decoder) bool {
+	actual, err := decoders[0](body)
+	expected, refErr := decoders[1](body)
+	switch {
+	case err == nil && refErr == nil:
+	case err != nil && refErr != nil:
+		return false
+	default:
+		fmt.Println(fmt.Sprintf("%s: proxy error %v, reference error %v", name, err, refErr))
+		return true
+	}
+	if len(actual) != len(expected) {
+		fmt.Println(fmt.Sprintf("%s: proxy decoded %d series, reference %d", name, len(actual), len(expected)))
+		return true
+	}
+	for i := range actual {
+		if reflect.DeepEqual(actual[i], expected[i]) {
+			continue
+		}
+		a, _ := json.Marshal(actual[i])
+		e, _ := json.Marshal(expected[i])
+		fmt.Println(fmt.Sprintf("%s: series %d differs\n  proxy:     %s\n  reference: %s", name, i, a, e))
+		return true
+	}
+	return false
+}
+
+// mutate returns a copy of body with a few random bytes changed, or cut short.
+func mutate(body []byte, rnd *rand.Rand) []byte {
+	out := append([]byte(nil), body...)
+	if len(out) == 0 {
+		return out
+	}
+	for n := 1 + rnd.Intn(4); n > 0; n-- {
+		out[rnd.Intn(len(out))] = byte(rnd.Intn(256))
+	}
+	if rnd.Intn(4) == 0 {
+		out = out[:rnd.Intn(len(out))]
+	}
+	return out
+}
+
+func referenceSeriesV2(body []byte) ([]datadog.Series, error) {
+	var payload gogen.MetricPayload
+	if err := payload.Unmarshal(body); err != nil {
+		return nil, err
+	}
+	types := map[gogen.MetricPayload_MetricType]string{
+		gogen.MetricPayload_COUNT: "count",
+		gogen.MetricPayload_RATE:  "rate",
+		gogen.MetricPayload_GAUGE: "gauge",
+	}
+	var series []datadog.Series
+	for _, s := range payload.Series {
+		d := datadog.Series{Metric: s.Metric}
+		for _, r := range s.Resources {
+			if r.Type == "host" {
+				d.SetHost(r.Name)
+			}
+		}
+		if t, ok := types[s.Type]; ok {
+			d.SetType(t)
+		}
+		if s.Interval > 0 {
+			d.SetInterval(s.Interval)
+		}
+		if s.Tags != nil {
+			d.SetTags(s.Tags)
+		}
+		for _, p := range s.Points {
+			ts, value := float64(p.Timestamp), p.Value
+			d.Points = append(d.Points, []*float64{&ts, &value})
+		}
+		series = append(series, d)
+	}
+	return series, nil
+}
+
+func referenceSketches(body []byte) ([]datadog.Series, error) {
+	var payload gogen.SketchPayload
+	if err := payload.Unmarshal(body); err != nil {
+		return nil, err
+	}
+	var series []datadog.Series
+	for _, s := range payload.Sketches {
+		d := datadog.Series{Metric: s.Metric, Type: datadog.PtrString("distribution")}
+		if s.Host != "" {
+			d.SetHost(s.Host)
+		}
+		if s.Tags != nil {
+			d.SetTags(s.Tags)
+		}
+		for _, p := range s.Distributions {
+			ts, count := float64(p.Ts), float64(p.Cnt)
+			d.Points = append(d.Points, []*float64{&ts, &count})
+		}
+		for _, p := range s.Dogsketches {
+			ts, count := float64(p.Ts), float64(p.Cnt)
+			d.Points = append(d.Points, []*float64{&ts, &count})
+		}
+		series = append(series, d)
+	}
+	return series, nil
+}
@@ -1,32 +1,643 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/DataDog/datadog-go/v5/statsd"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 
-	"github.com/carlosroman/proxy-filter/go/internal/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/config"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
 )
 
+// envPrefix prefixes the environment variables setting the flags, e.g.
+// PROXY_FILTER_BASE_ENDPOINT sets -base-endpoint.
+const envPrefix = "PROXY_FILTER_"
+
+// hashSecretEnv is the environment variable holding the -hash-tag-keys secret,
+// kept out of the flags so that it never shows up in the process list.
+const hashSecretEnv = envPrefix + "HASH_SECRET"
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fuzz-diff" {
+		os.Exit(fuzzDiff(os.Args[2:]))
+	}
+	// proxy-filter validate [flags] checks the flags, the config and its rules
+	// as the proxy would read them, without starting it.
+	validate := len(os.Args) > 1 && os.Args[1] == "validate"
+	if validate {
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
 
+	configFile := flag.String("config", "", "YAML file setting the flags by name, those given on the command line or as "+envPrefix+"<FLAG_NAME> environment variables taking precedence")
+	configWatchInterval := flag.Duration("config-watch-interval", 0, "How often -config is checked for changes, which reload the rules and the routing as SIGHUP does, disabled when 0")
+	configURL := flag.String("config-url", "", "URL of a YAML document setting the flags as -config does, which takes precedence, fetched over HTTP(S), from S3 as s3://<bucket>/<key> with the credentials of AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, from Redis as redis[s]://[[user]:password@]<host:port>[/<db>]?key=<key> or from etcd as etcd[s]://[user:password@]<host:port>/<key>, so that replicas share their rules")
+	configURLInterval := flag.Duration("config-url-interval", time.Minute, "How often -config-url is fetched again, reloading the rules and the routing as SIGHUP does when it changed, e.g. 2s for replicas sharing their rules through Redis or etcd to converge within seconds, disabled when 0")
+	configURLRegion := flag.String("config-url-s3-region", "us-east-1", "Region of the S3 bucket of -config-url")
+	configURLKey := flag.String("config-url-public-key", "", "Base64 Ed25519 public key -config-url must be signed with, its signature being fetched from <config-url>.sig as the base64 signature of its SHA-256 checksum")
 	baseEndpoint := flag.String("base-endpoint", "http://127.0.0.1:8080", "The base endpoint which to proxy all requests to")
 	prefix := flag.String("prefix", "", "The metric name prefix filter")
 	env := flag.String("env", "dev", "The environment the proxy filter runs in")
 	statsdAddr := flag.String("stats-addr", "127.0.0.1:8125", "Address for DogStatsD endpoint")
+	statsdMaxTagSets := flag.Int("statsd-max-tag-sets", 1000, "Distinct tag sets sent for each of the proxy's own metrics before new ones go to an \"other\" bucket, no limit when 0")
 	listenAddr := flag.String("listen-addr", ":8081", "Address for proxy to listen on")
+	acceptRate := flag.Float64("accept-rate", 0, "Connections accepted per second, refusing others with 503, no limit when 0")
+	acceptBurst := flag.Int("accept-burst", 100, "Connections accepted at once above -accept-rate")
+	maxConns := flag.Int("max-conns", 0, "Connections open at once, refusing others with 503, no limit when 0")
+	fdWarnRatio := flag.Float64("fd-warn-ratio", 0.9, "Share of the file descriptor limit above which the proxy reports not ready on the admin API and /readyz, disabled when 0")
+	maxAgents := flag.Int("max-agents", 10000, "Maximum number of agents listed by the admin API in /agents, disabled when 0")
+	sandbox := flag.Bool("sandbox", false, "Exit if the proxy ever writes to disk, to check it runs on a read-only root filesystem")
+	passthroughAddr := flag.String("passthrough-addr", "", "Address to relay TLS connections on by server name, disabled when empty")
+	var passthroughRoutes stringList
+	flag.Var(&passthroughRoutes, "passthrough-route", "Relay TLS connections for <server name pattern>=<host:port> on -passthrough-addr (repeatable)")
+	var dogStatsDAddrs stringList
+	flag.Var(&dogStatsDAddrs, "dogstatsd-addr", "Filter DogStatsD packets received on udp://<host:port> or unix://<path> (repeatable)")
+	dogStatsDUpstream := flag.String("dogstatsd-upstream", "udp://127.0.0.1:8125", "Agent to forward the DogStatsD packets kept to, as udp://<host:port> or unix://<path>")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for requests in flight on shutdown before closing their connections")
+	reloadDrainWindow := flag.Duration("reload-drain-window", 30*time.Second, "Window over which the keep-alive client connections are closed after a reload, so that they reconnect to the new settings gradually, disabled when 0")
+	adminAddr := flag.String("admin-addr", "", "Address for the admin API to listen on, disabled when empty, only a loopback address without -admin-token")
+	healthAddr := flag.String("health-addr", "", "Address for the /healthz and /readyz probes to listen on, disabled when empty")
+	upstreamProbePath := flag.String("upstream-probe-path", "/api/v1/validate", "Path of the base endpoint probed every -upstream-probe-interval, with the API key of DD_API_KEY when set, the proxy reporting not ready on /readyz when it cannot be reached")
+	upstreamProbeInterval := flag.Duration("upstream-probe-interval", 30*time.Second, "How often -upstream-probe-path is probed, disabled when 0")
+	upstreamProbeFailures := flag.Int("upstream-probe-failures", 3, "Probes of -upstream-probe-path that must fail in a row for the proxy to report not ready")
+	upstreamCheckTimeout := flag.Duration("upstream-check-timeout", 2*time.Second, "How long /readyz waits to connect to the base endpoint before reporting not ready, not checked when 0")
+	var adminTokens stringList
+	flag.Var(&adminTokens, "admin-token", "Bearer token for the admin API as [tenant:]token, a tenant scoping it to that tenant's stats (repeatable)")
+	filterPlugins := flag.String("filter-plugins", "", "Comma separated list of Go plugins (.so) exporting a filter.Filter named Filter")
+	calloutAddr := flag.String("callout-addr", "", "Address of a gRPC filter decision service asked about the series of the series endpoint, e.g. http://127.0.0.1:9000. It needs -route flags that bind none of the series-v2, sketches, prometheus or intake endpoints, and cannot be used with -stream-series or -dogstatsd-addr")
+	calloutTimeout := flag.Duration("callout-timeout", 100*time.Millisecond, "Timeout for each call to the filter decision service")
+	calloutFailClosed := flag.Bool("callout-fail-closed", false, "Reject requests when the filter decision service fails instead of forwarding them unfiltered")
+	var dropRules stringList
+	flag.Var(&dropRules, "drop-rule", "Drop series matching metric=<prefix>,host=<pattern>,interval=<seconds>,agent=<versions>, versions being >=7.40.0, <7.40.0 or 7.38.0..7.40.0, or only count them in the decision records with action=audit (repeatable)")
+	var ruleGroups stringList
+	flag.Var(&ruleGroups, "rule-group", "Add a drop rule to a named rule group as group=<name>,<rule>, the rule written as -drop-rule takes it, applying to the series of the routes bound to the group (repeatable)")
+	var routes stringList
+	flag.Var(&routes, "route", "Serve path=<pattern>,endpoint=<endpoint>,group=<rule group>, a trailing slash matching the paths under it, the endpoint being one of series, series-v2, sketches, prometheus, service-checks, events, intake, logs, traces, processes, connections, orchestrator, images, profiles, rum, ci or proxy, replacing the default routes of the Datadog intakes (repeatable)")
+	dropEmpty := flag.Bool("drop-empty", false, "Drop series without points and gauges whose points are all zero")
+	dropEmptyPrefixes := flag.String("drop-empty-prefixes", "", "Comma separated list of metric prefixes -drop-empty applies to, all series when empty")
+	standardMetricPrefixes := flag.String("standard-metric-prefixes", "", "Comma separated list of the prefixes of the metrics that are not custom metrics, kept when the drop-all circuit of the admin API only drops custom metrics, those of the Agent and its core checks when empty")
+	var dropDevices stringList
+	flag.Var(&dropDevices, "drop-device", "Drop series whose device tag matches this pattern, e.g. /var/lib/docker/overlay2/*/merged (repeatable)")
+	stripDevice := flag.Bool("strip-device", false, "Remove the device tag from every forwarded series")
+	var dropRequests stringList
+	flag.Var(&dropRequests, "drop-request", "Answer requests matching path=<route>,tenant=<tenant>,header=<name>:<pattern>,min-size=<bytes> with status=<code> (default the drop response of the path) without forwarding them (repeatable)")
+	var dropResponses stringList
+	flag.Var(&dropResponses, "drop-response", "Answer path=<glob>,status=<code>,retry-after=<seconds>,body=<body> when a whole payload to the path is dropped, body coming last (repeatable, default 202)")
+	var rewriteResponses stringList
+	flag.Var(&rewriteResponses, "rewrite-response", "Rewrite responses with route=<route>,status=<code>,to-status=<code>,body=<body>, body coming last (repeatable)")
+	var serviceCheckRules stringList
+	flag.Var(&serviceCheckRules, "drop-service-check", "Drop service checks matching check=<prefix>,tag=<tag>, tag being repeatable (repeatable)")
+	var eventRules stringList
+	flag.Var(&eventRules, "drop-event", "Drop events matching title=<prefix>,source=<source type>,tag=<tag>, tag being repeatable (repeatable)")
+	var ciRules stringList
+	flag.Var(&ciRules, "drop-ci", "Drop CI Visibility events matching branch=<glob>,author=<glob>,tag=<key>:<value> (repeatable)")
+	var rumRules stringList
+	flag.Var(&rumRules, "drop-rum", "Drop RUM events matching application=<id>,path=<glob>, keeping a share of their views with sample=<rate> (repeatable)")
+	var profileRules stringList
+	flag.Var(&profileRules, "drop-profile", "Drop profile uploads matching service=<service>,env=<env>,tag=<tag> (repeatable)")
+	var metadataRules stringList
+	flag.Var(&metadataRules, "scrub-metadata", "Remove the host metadata field at a dotted path, * matching any key, or replace its value with <path>=<value> (repeatable)")
+	var logRules stringList
+	flag.Var(&logRules, "drop-log", "Drop logs matching service=<service>,source=<source>,status=<status>,tag=<tag>,message=<regex>, message coming last (repeatable)")
+	var traceRules stringList
+	flag.Var(&traceRules, "drop-trace", "Drop traces whose root span matches service=<service>,tag=<key>:<value>,resource=<regex>, resource coming last (repeatable)")
+	var processRules stringList
+	flag.Var(&processRules, "drop-process", "Drop processes and containers matching name=<glob>,tag=<tag> from process agent payloads (repeatable)")
+	var processArgPatterns stringList
+	flag.Var(&processArgPatterns, "scrub-process-arg", "Replace process arguments matching the regex in process agent payloads (repeatable)")
+	var connectionRules stringList
+	flag.Var(&connectionRules, "drop-connection", "Drop network connections to cidr=<cidr>,port=<port> from network performance monitoring payloads (repeatable)")
+	var imageRules stringList
+	flag.Var(&imageRules, "drop-image", "Drop container images and their SBOMs matching registry=<glob>,namespace=<glob> (repeatable)")
+	var orchestratorRules stringList
+	flag.Var(&orchestratorRules, "drop-orchestrator", "Drop Kubernetes resources matching kind=<kind>,namespace=<glob> from cluster agent payloads (repeatable)")
+	unitsFile := flag.String("v2-units", "", "File mapping metric names to units as <name>=<unit> per line, setting the unit of v2 series sent without one")
+	var resourceRules stringList
+	flag.Var(&resourceRules, "v2-resource", "Remove the resources of a type from v2 series, e.g. device, or rename them with <type>=<name> (repeatable)")
+	var contentTypes stringList
+	flag.Var(&contentTypes, "content-type", "Only accept <route>=<type>[,<type>] on a route, rejecting others with 415 (repeatable)")
+	addTags := flag.String("add-tags", "", "Comma separated list of tags to add to every forwarded series, e.g. proxied:true,cluster:eu1")
+	stripTagKeys := flag.String("strip-tag-keys", "", "Comma separated list of tag keys to remove from every forwarded series, e.g. user_email")
+	metricConfigAPI := flag.String("metric-config-api", "", "Base URL of the Datadog API to pull the Metrics without Limits tag configurations from with the keys of DD_API_KEY and DD_APP_KEY, e.g. https://api.datadoghq.eu, stripping from the series of each configured metric the tags it is not queryable by, disabled when empty")
+	metricConfigInterval := flag.Duration("metric-config-interval", 10*time.Minute, "How often the tag configurations of -metric-config-api are pulled again, reloading the rules when they changed, disabled when 0")
+	metricConfigDropTag := flag.String("metric-config-drop-tag", "", "Tag key marking the metrics to drop in their tag configuration of -metric-config-api, none when empty")
+	var renames stringList
+	flag.Var(&renames, "rename", "Rename series from=to, or a prefix with a trailing * on both sides, e.g. legacy.app.*=app.* (repeatable)")
+	var scales stringList
+	flag.Var(&scales, "scale", "Scale point values with metric=<prefix>,multiply=<factor> or divide=<factor>, e.g. metric=app.memory.,divide=1048576 (repeatable)")
+	maxSnappyDecodedSize := flag.Int("max-snappy-decoded-size", server.DefaultMaxSnappyDecodedSize, "Reject with 400 the snappy bodies decoding to more bytes than this, checked before they are decoded")
+	compression := flag.String("compression", "", "Re-compress the bodies the proxy changes as <algorithm>[:<level>], one of gzip, deflate, br, zstd, snappy or identity, instead of with their own encoding")
+	provenance := flag.Bool("provenance-tag", false, "Tag every forwarded series with proxy_filter_version:<hash of the flags set, the filter plugins, the -metric-config-api configurations and the rules added through the admin API>, to tell which rule set let it through")
+	mergeDuplicates := flag.Bool("merge-duplicates", false, "Merge the series of a payload with the same name, type, host and tags")
+	streamSeries := flag.Bool("stream-series", false, "Filter series, v2 series and sketches payloads a series at a time as they are read, bounding the memory a payload takes")
+	var intervals stringList
+	flag.Var(&intervals, "interval", "Fix intervals and convert counts and rates with metric=<prefix>,interval=<seconds>,to=count|rate (repeatable)")
+	var downsamples stringList
+	flag.Var(&downsamples, "downsample", "Keep one point per interval with metric=<prefix>,interval=<seconds> (repeatable)")
+	maxTagValueLength := flag.Int("max-tag-value-length", 0, "Truncate tag values longer than this many bytes, the tags without a key being values of their own, no limit when 0")
+	dropLongTags := flag.Bool("drop-long-tags", false, "Remove tags whose value is longer than -max-tag-value-length instead of truncating them")
+	var redactPatterns stringList
+	flag.Var(&redactPatterns, "redact-tag-value", "Replace the parts of tag values matching this regex, e.g. an email address (repeatable)")
+	hashTagKeys := flag.String("hash-tag-keys", "", "Comma separated list of tag keys whose values are replaced with a hash keyed with "+hashSecretEnv+", e.g. user_id, so that series still group by them")
+	redactPlaceholder := flag.String("redact-placeholder", transform.DefaultRedaction, "Placeholder for redacted parts of tag values")
+	transformAgentVersions := flag.String("transform-agent-versions", "", "Only transform series from agents with these versions, e.g. >=7.40.0, all series when empty")
+	var validateResponses stringList
+	flag.Var(&validateResponses, "validate-response", "Count successful upstream responses on this route without a JSON body (repeatable)")
+
+	var backends stringList
+	flag.Var(&backends, "backend", "Also send every request to this base endpoint (repeatable)")
+	var shards stringList
+	flag.Var(&shards, "shard", "Spread /api/v1/series across the orgs of api_key=<key>[,endpoint=<url>] by the hash of the metric namespace (repeatable)")
+	mirrorEndpoint := flag.String("mirror-endpoint", "", "Also send every POST request unfiltered to this base endpoint in the background, disabled when empty")
+	mirrorMaxInFlight := flag.Int("mirror-max-in-flight", 100, "Requests sent to -mirror-endpoint at once before new ones are skipped")
+	backendMode := flag.String("backend-mode", string(server.PrimaryWins), "Which response clients get with several backends, one of primary-wins, any-success or all-success")
+	backendTimeout := flag.Duration("backend-timeout", 30*time.Second, "Time the other backends are given to answer in the background with -backend-mode primary-wins")
+	var slos stringList
+	flag.Var(&slos, "slo", "Track the burn rate of name=<name>,kind=drops|latency,objective=<0..1>,threshold=<duration>,window=<duration>,prefix=<metric prefix>, a drops SLO only counting the series outside its prefixes, which are repeatable (repeatable)")
+	decisionLog := flag.Bool("decision-log", false, "Log the filter decision record of every request as JSON to stdout")
+	decisionStats := flag.Bool("decision-stats", false, "Count the series each filter dropped, or would have dropped with action=audit, per route in DogStatsD")
+	dropSinkFile := flag.String("drop-sink-file", "", "Append every series the filters drop as a line of JSON to this file, disabled when empty")
+	dropSinkRemoteWrite := flag.String("drop-sink-remote-write", "", "Send every series the filters drop to this Prometheus remote-write URL, e.g. http://127.0.0.1:9090/api/v1/write, disabled when empty")
+	topDrops := flag.Int("top-drops", 0, "Count the dropped series of up to this many metric names, the most dropped ones being served by the admin API at /drops/top, disabled when 0")
+	topDropsStats := flag.Int("top-drops-stats", 0, "Count the dropped series of this many of the most dropped metrics of -top-drops in DogStatsD, tagged by metric, every 10 seconds, disabled when 0")
+	dropSinkQueue := flag.Int("drop-sink-queue", 10000, "Dropped series queued for -drop-sink-remote-write before new ones are lost")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Also export the forwarded series to this OpenTelemetry collector OTLP/HTTP URL, e.g. http://127.0.0.1:4318/v1/metrics, disabled when empty")
+	otlpOnly := flag.Bool("otlp-only", false, "Export the series to -otlp-endpoint instead of forwarding them upstream")
+	otlpQueue := flag.Int("otlp-queue", 10000, "Series queued for -otlp-endpoint before new ones are lost")
+	kafkaEndpoint := flag.String("kafka-rest-endpoint", "", "Publish series to Kafka through the Kafka REST Proxy at this URL, e.g. http://127.0.0.1:8082, disabled when empty")
+	kafkaTopic := flag.String("kafka-topic", "", "Kafka topic the forwarded series are published to, none when empty")
+	kafkaDroppedTopic := flag.String("kafka-dropped-topic", "", "Kafka topic the dropped series are published to, none when empty")
+	kafkaDecisionTopic := flag.String("kafka-decision-topic", "", "Kafka topic the filter decision record of every request is published to, none when empty")
+	kafkaPartitions := flag.Int("kafka-partitions", 0, "Publish each series to the partition of the hash of its metric name among this many, letting Kafka pick from the metric name key when 0")
+	kafkaQueue := flag.Int("kafka-queue", 10000, "Series queued for Kafka before new ones are lost")
+	archiveDir := flag.String("archive-dir", "", "Archive every POST request as it came, gzipped, under this directory, disabled when empty")
+	archiveS3Bucket := flag.String("archive-s3-bucket", "", "Archive every POST request as it came, gzipped, to this S3 bucket with the credentials of AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, disabled when empty")
+	archiveS3Region := flag.String("archive-s3-region", "us-east-1", "Region of -archive-s3-bucket")
+	archiveS3Prefix := flag.String("archive-s3-prefix", "", "Prefix of the keys of the archives in -archive-s3-bucket, e.g. proxy-filter/")
+	archiveS3Endpoint := flag.String("archive-s3-endpoint", "", "Base URL of an S3 compatible service holding -archive-s3-bucket, AWS when empty")
+	archiveRetention := flag.Duration("archive-retention", 7*24*time.Hour, "How long -archive-dir keeps archives, forever when 0, the retention of S3 buckets being set by their lifecycle rules")
+	archiveQueue := flag.Int("archive-queue", 1000, "Requests waiting to be archived before new ones are lost")
+	archiveWorkers := flag.Int("archive-workers", 4, "Requests archived at once")
+	decisionBuffer := flag.Int("decision-buffer", 0, "Keep this many of the last filter decision records for the admin API, disabled when 0")
+	prometheusMetrics := flag.Bool("prometheus-metrics", false, "Serve the proxy's own metrics, as sent to DogStatsD along with request counts, durations and sizes, in the Prometheus format on the admin API at /metrics")
+	var coalesceRoutes stringList
+	flag.Var(&coalesceRoutes, "coalesce-route", "Share one upstream request between identical GET requests in flight on this route (repeatable)")
 
 	flag.Parse()
-	conf := server.Config{BaseEndpoint: *baseEndpoint, MetricsPrefixFilter: *prefix}
+	if err := loadFlags(configFile, nil); err != nil {
+		log.Fatal(err)
+	}
+	var remoteConfig config.Source
+	var remoteDoc []byte
+	if *configURL != "" {
+		var err error
+		remoteConfig, err = newRemoteConfig(*configURL, *configURLRegion, *configURLKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		remoteDoc, _, err = remoteConfig.Fetch()
+		if err != nil {
+			log.Fatal(err)
+		}
+		// The document could set the flags read above, which are set again.
+		if err := reloadFlags(configFile, remoteDoc); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var metricConfigSync *server.MetricConfigSync
+	var metricConfig server.MetricConfigRules
+	if *metricConfigAPI != "" {
+		metricConfigSync = &server.MetricConfigSync{
+			Endpoint: *metricConfigAPI,
+			APIKey:   os.Getenv("DD_API_KEY"),
+			AppKey:   os.Getenv("DD_APP_KEY"),
+			DropTag:  *metricConfigDropTag,
+			Client:   &http.Client{Timeout: 30 * time.Second},
+		}
+		if metricConfigSync.APIKey == "" || metricConfigSync.AppKey == "" {
+			log.Fatal("-metric-config-api needs DD_API_KEY and DD_APP_KEY")
+		}
+	}
+	if metricConfigSync != nil && !validate {
+		// The proxy starts without the rules when Datadog cannot be reached,
+		// they apply once pulled.
+		rules, _, err := metricConfigSync.Fetch(context.Background())
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Could not fetch the metric tag configurations, %v", err))
+		}
+		metricConfig = rules
+	}
+	// rulesConfig builds the filter rules and the routing from the flags, which
+	// SIGHUP reloads.
+	rulesConfig := func() (server.Config, error) {
+		conf := server.Config{BaseEndpoint: *baseEndpoint, MetricsPrefixFilter: *prefix, ValidateResponses: validateResponses, CoalesceRoutes: coalesceRoutes, MergeDuplicates: *mergeDuplicates, StreamSeries: *streamSeries, FDWarnRatio: *fdWarnRatio, UpstreamCheckTimeout: *upstreamCheckTimeout, MaxAgents: *maxAgents}
+		conf.UpstreamProbePath, conf.UpstreamProbeAPIKey, conf.UpstreamProbeFailures = *upstreamProbePath, os.Getenv("DD_API_KEY"), *upstreamProbeFailures
+		var filters filter.Chain
+		var pluginDigests []string
+		if *filterPlugins != "" {
+			for _, path := range strings.Split(*filterPlugins, ",") {
+				f, err := server.LoadFilterPlugin(path)
+				if err != nil {
+					return server.Config{}, err
+				}
+				filters = append(filters, f)
+				if *provenance {
+					digest, err := fileDigest(path)
+					if err != nil {
+						return server.Config{}, err
+					}
+					pluginDigests = append(pluginDigests, digest)
+				}
+			}
+		}
+		for _, rule := range dropRules {
+			r, err := filter.ParseRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			filters = append(filters, r)
+		}
+		for _, rule := range ruleGroups {
+			group, r, err := server.ParseRuleGroup(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			if conf.RuleGroups == nil {
+				conf.RuleGroups = make(map[string]filter.Chain)
+			}
+			conf.RuleGroups[group] = append(conf.RuleGroups[group], r)
+		}
+		for _, route := range routes {
+			r, err := server.ParseRoute(route)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.Routes = append(conf.Routes, r)
+		}
+		if err := server.CheckRoutes(conf.Routes, conf.RuleGroups); err != nil {
+			return server.Config{}, err
+		}
+		if len(dropDevices) > 0 {
+			d, err := filter.NewDevice(dropDevices...)
+			if err != nil {
+				return server.Config{}, err
+			}
+			filters = append(filters, d)
+		}
+		if *dropEmpty {
+			var empty filter.Empty
+			if *dropEmptyPrefixes != "" {
+				empty = strings.Split(*dropEmptyPrefixes, ",")
+			}
+			filters = append(filters, empty)
+		}
+		if metricConfig.Drop != nil {
+			filters = append(filters, metricConfig.Drop)
+		}
+		if len(filters) > 0 {
+			conf.Filter = filters
+		}
+		if *standardMetricPrefixes != "" {
+			conf.StandardMetricPrefixes = strings.Split(*standardMetricPrefixes, ",")
+		}
+		var transforms transform.Chain
+		for _, rule := range renames {
+			r, err := transform.ParseRename(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			transforms = append(transforms, r)
+		}
+		if *addTags != "" {
+			transforms = append(transforms, transform.AddTags(strings.Split(*addTags, ",")))
+		}
+		for _, rule := range scales {
+			s, err := transform.ParseScale(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			transforms = append(transforms, s)
+		}
+		for _, rule := range intervals {
+			i, err := transform.ParseInterval(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			transforms = append(transforms, i)
+		}
+		for _, rule := range downsamples {
+			d, err := transform.ParseDownsample(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			transforms = append(transforms, d)
+		}
+		if *stripTagKeys != "" {
+			transforms = append(transforms, transform.StripTagKeys(strings.Split(*stripTagKeys, ",")))
+		}
+		if *stripDevice {
+			transforms = append(transforms, transform.StripTagKeys{"device"})
+		}
+		if len(redactPatterns) > 0 {
+			r, err := transform.NewRedact(*redactPlaceholder, redactPatterns...)
+			if err != nil {
+				return server.Config{}, err
+			}
+			transforms = append(transforms, r)
+		}
+		if *hashTagKeys != "" {
+			secret := os.Getenv(hashSecretEnv)
+			if secret == "" {
+				return server.Config{}, fmt.Errorf("-hash-tag-keys needs %s", hashSecretEnv)
+			}
+			transforms = append(transforms, transform.NewHashTagValues([]byte(secret), strings.Split(*hashTagKeys, ",")...))
+		}
+		if *maxTagValueLength > 0 {
+			transforms = append(transforms, transform.LimitTagValues{MaxLength: *maxTagValueLength, Drop: *dropLongTags})
+		}
+		if len(transforms) > 0 {
+			conf.Transform = transforms
+			if *transformAgentVersions != "" {
+				versions, err := agent.ParseRange(*transformAgentVersions)
+				if err != nil {
+					return server.Config{}, err
+				}
+				conf.Transform = transform.ForAgents{Versions: versions, Apply: transforms}
+			}
+		}
+		if metricConfig.Strip != nil {
+			// The tags Datadog does not index are stripped last, whatever the
+			// agent.
+			if conf.Transform != nil {
+				conf.Transform = transform.Chain{conf.Transform, metricConfig.Strip}
+			} else {
+				conf.Transform = metricConfig.Strip
+			}
+		}
+		conf.MaxSnappyDecodedSize = *maxSnappyDecodedSize
+		if *compression != "" {
+			c, err := server.ParseCompression(*compression)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.Compression = &c
+		}
+		if *provenance {
+			// The flags name the plugins and the metric configurations, not
+			// what they hold.
+			settings := provenanceSettings()
+			if len(pluginDigests) > 0 {
+				settings["filter-plugins-sha256"] = pluginDigests
+			}
+			if metricConfig.Digest != "" {
+				settings["metric-config-sha256"] = []string{metricConfig.Digest}
+			}
+			conf.ProvenanceTag = server.ProvenanceTag(settings)
+			fmt.Println(fmt.Sprintf("Tagging forwarded series with %s", conf.ProvenanceTag))
+		}
+		if len(backends) > 0 {
+			mode, err := server.ParseBackendMode(*backendMode)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.Backends, conf.BackendMode, conf.BackendTimeout = backends, mode, *backendTimeout
+		}
+		conf.MirrorEndpoint, conf.MirrorMaxInFlight = *mirrorEndpoint, *mirrorMaxInFlight
+		for _, shard := range shards {
+			s, err := server.ParseShard(shard)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.Shards = append(conf.Shards, s)
+		}
+		for _, slo := range slos {
+			s, err := server.ParseSLO(slo)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.SLOs = append(conf.SLOs, s)
+		}
+		for _, token := range adminTokens {
+			t, err := server.ParseAdminToken(token)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.AdminTokens = append(conf.AdminTokens, t)
+		}
+		for _, rule := range serviceCheckRules {
+			r, err := server.ParseServiceCheckRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ServiceCheckRules = append(conf.ServiceCheckRules, r)
+		}
+		for _, rule := range eventRules {
+			r, err := server.ParseEventRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.EventRules = append(conf.EventRules, r)
+		}
+		for _, rule := range ciRules {
+			r, err := server.ParseCIRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.CIRules = append(conf.CIRules, r)
+		}
+		for _, rule := range rumRules {
+			r, err := server.ParseRUMRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.RUMRules = append(conf.RUMRules, r)
+		}
+		for _, rule := range profileRules {
+			r, err := server.ParseProfileRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ProfileRules = append(conf.ProfileRules, r)
+		}
+		for _, rule := range metadataRules {
+			r, err := server.ParseMetadataRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.MetadataRules = append(conf.MetadataRules, r)
+		}
+		for _, rule := range logRules {
+			r, err := server.ParseLogRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.LogRules = append(conf.LogRules, r)
+		}
+		for _, rule := range traceRules {
+			r, err := server.ParseTraceRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.TraceRules = append(conf.TraceRules, r)
+		}
+		for _, rule := range processRules {
+			r, err := server.ParseProcessRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ProcessRules = append(conf.ProcessRules, r)
+		}
+		for _, pattern := range processArgPatterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ProcessArgPatterns = append(conf.ProcessArgPatterns, re)
+		}
+		for _, rule := range connectionRules {
+			r, err := server.ParseConnectionRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ConnectionRules = append(conf.ConnectionRules, r)
+		}
+		for _, rule := range imageRules {
+			r, err := server.ParseImageRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ImageRules = append(conf.ImageRules, r)
+		}
+		for _, rule := range orchestratorRules {
+			r, err := server.ParseOrchestratorRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.OrchestratorRules = append(conf.OrchestratorRules, r)
+		}
+		if *unitsFile != "" {
+			f, err := os.Open(*unitsFile)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.Units, err = server.LoadUnits(f)
+			_ = f.Close()
+			if err != nil {
+				return server.Config{}, err
+			}
+		}
+		for _, rule := range resourceRules {
+			r, err := server.ParseResourceRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ResourceRules = append(conf.ResourceRules, r)
+		}
+		for _, rule := range dropRequests {
+			r, err := server.ParseRequestRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.DropRequests = append(conf.DropRequests, r)
+		}
+		for _, resp := range dropResponses {
+			r, err := server.ParseDropResponse(resp)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.DropResponses = append(conf.DropResponses, r)
+		}
+		for _, rule := range rewriteResponses {
+			r, err := server.ParseResponseRule(rule)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.RewriteResponses = append(conf.RewriteResponses, r)
+		}
+		if len(contentTypes) > 0 {
+			ct, err := server.ParseContentTypes(contentTypes)
+			if err != nil {
+				return server.Config{}, err
+			}
+			conf.ContentTypes = ct
+		}
+		if *calloutAddr != "" {
+			conf.BatchFilter = server.NewGRPCCallout(*calloutAddr, *calloutTimeout, !*calloutFailClosed)
+		}
+		if err := server.CheckBatchFilter(conf); err != nil {
+			return server.Config{}, err
+		}
+		return conf, nil
+	}
+	conf, err := rulesConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The sandbox exits on the first write to disk, which archiving and the
+	// drop sink file both do.
+	if *sandbox && (*archiveDir != "" || *dropSinkFile != "") {
+		log.Fatal("-sandbox cannot be used with -archive-dir or -drop-sink-file")
+	}
+	// The DogStatsD packets are filtered a series at a time, without asking
+	// the filter decision service.
+	if *calloutAddr != "" && len(dogStatsDAddrs) > 0 {
+		log.Fatal("-callout-addr cannot be used with -dogstatsd-addr")
+	}
+	// Without tokens anyone reaching the admin API could change the rules.
+	if *adminAddr != "" && len(conf.AdminTokens) == 0 && !isLoopback(*adminAddr) {
+		log.Fatal("-admin-addr needs -admin-token unless it listens on a loopback address")
+	}
+	if validate {
+		for _, route := range passthroughRoutes {
+			if _, err := server.ParseSNIRoute(route); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for _, addr := range append([]string{*dogStatsDUpstream}, dogStatsDAddrs...) {
+			if _, _, err := server.ParseDogStatsDAddr(addr); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *archiveDir != "" && *archiveS3Bucket != "" {
+			log.Fatal("-archive-dir and -archive-s3-bucket cannot be used together")
+		}
+		fmt.Println("The configuration is valid")
+		os.Exit(0)
+	}
 	httpClient := &http.Client{
 		Transport: &http.Transport{
 			DialContext: (&net.Dialer{
@@ -46,11 +657,92 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	var selfStatsD server.StatsdClient = statsDClient
+	if *prometheusMetrics {
+		telemetry := server.NewTelemetry(statsDClient)
+		conf.DecisionSinks = append(conf.DecisionSinks, telemetry)
+		selfStatsD = telemetry
+	}
+	guardedStatsD := server.NewCardinalityGuard(selfStatsD, *statsdMaxTagSets, conf.Tags)
 
-	handler := server.NewHandler(conf, httpClient, statsDClient)
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/series", handler.MetricsFilter)
-	mux.HandleFunc("/", handler.ProxyHandle)
+	if *decisionLog {
+		conf.DecisionSinks = append(conf.DecisionSinks, server.NewLogSink(os.Stdout))
+	}
+	if *decisionStats {
+		conf.DecisionSinks = append(conf.DecisionSinks, server.StatsdSink{Client: guardedStatsD, Tags: conf.Tags})
+	}
+	if *decisionBuffer > 0 {
+		conf.DecisionSinks = append(conf.DecisionSinks, server.NewDebugBuffer(*decisionBuffer))
+	}
+	if *dropSinkFile != "" {
+		// The dropped series may carry sensitive tags, only the owner reads them.
+		f, err := os.OpenFile(*dropSinkFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		conf.DropSinks = append(conf.DropSinks, server.NewFileDropSink(f))
+	}
+	var topDropsSink *server.TopDrops
+	if *topDrops > 0 {
+		topDropsSink = server.NewTopDrops(*topDrops)
+		conf.DropSinks = append(conf.DropSinks, topDropsSink)
+	}
+	var remoteWriteSink *server.RemoteWriteSink
+	if *dropSinkRemoteWrite != "" {
+		remoteWriteSink = server.NewRemoteWriteSink(*dropSinkRemoteWrite, httpClient, *dropSinkQueue, 1000, 10*time.Second)
+		conf.DropSinks = append(conf.DropSinks, remoteWriteSink)
+	}
+	var otlpExporter *server.OTLPExporter
+	if *otlpEndpoint != "" {
+		otlpExporter = server.NewOTLPExporter(*otlpEndpoint, httpClient, *otlpQueue, 1000, 10*time.Second)
+		conf.Exporters = append(conf.Exporters, otlpExporter)
+		conf.ExportOnly = *otlpOnly
+	}
+	var kafkaSink *server.KafkaSink
+	if *kafkaEndpoint != "" {
+		kafkaSink = server.NewKafkaSink(server.KafkaConfig{
+			Endpoint:      *kafkaEndpoint,
+			Topic:         *kafkaTopic,
+			DroppedTopic:  *kafkaDroppedTopic,
+			DecisionTopic: *kafkaDecisionTopic,
+			Partitions:    *kafkaPartitions,
+			QueueSize:     *kafkaQueue,
+			BatchSize:     1000,
+			FlushInterval: 10 * time.Second,
+		}, httpClient)
+		if *kafkaTopic != "" {
+			conf.Exporters = append(conf.Exporters, kafkaSink)
+		}
+		if *kafkaDroppedTopic != "" {
+			conf.DropSinks = append(conf.DropSinks, kafkaSink)
+		}
+		if *kafkaDecisionTopic != "" {
+			conf.DecisionSinks = append(conf.DecisionSinks, kafkaSink)
+		}
+	}
+	var archiveStore server.ArchiveStore
+	switch {
+	case *archiveDir != "" && *archiveS3Bucket != "":
+		log.Fatal("-archive-dir and -archive-s3-bucket cannot be used together")
+	case *archiveDir != "":
+		archiveStore = server.DirStore{Dir: *archiveDir}
+	case *archiveS3Bucket != "":
+		archiveStore = server.S3Store{
+			Bucket:          *archiveS3Bucket,
+			Region:          *archiveS3Region,
+			Prefix:          *archiveS3Prefix,
+			Endpoint:        *archiveS3Endpoint,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Client:          httpClient,
+		}
+	}
+	if archiveStore != nil {
+		conf.Archiver = server.NewArchiver(archiveStore, server.ArchiverConfig{QueueSize: *archiveQueue, Workers: *archiveWorkers, Retention: *archiveRetention})
+	}
+	handler := server.NewHandler(conf, httpClient, guardedStatsD)
 
 	err = profiler.Start(
 		profiler.WithService("proxy-filter-go"),
@@ -72,24 +764,430 @@ func main() {
 	}
 	defer profiler.Stop()
 
-	httpServer := &http.Server{Addr: *listenAddr, Handler: mux}
+	var listener net.Listener
+	listener, err = net.Listen("tcp", *listenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *acceptRate > 0 || *maxConns > 0 {
+		limits := server.ListenerLimits{AcceptRate: *acceptRate, AcceptBurst: *acceptBurst, MaxConns: *maxConns}
+		listener = server.NewLimitListener(listener, limits, clock.Real, guardedStatsD, conf.Tags)
+	}
+	drainer := server.NewConnDrainer(clock.Real, clock.NewRand(0))
+	// HTTP/2 in cleartext lets gRPC clients call through the proxy.
+	httpServer := &http.Server{Addr: *listenAddr, Handler: h2c.NewHandler(drainer.Middleware(handler.Router()), &http2.Server{}), ConnState: drainer.ConnState, ConnContext: drainer.ConnContext}
 	go func(hs *http.Server) {
-		if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := hs.Serve(listener); err != nil && err != http.ErrServerClosed {
 			fmt.Println(fmt.Sprintf("Something went wrong: %v", err))
 			os.Exit(-1)
 		}
 	}(httpServer)
 
+	if len(conf.SLOs) > 0 {
+		go func() {
+			for range time.Tick(10 * time.Second) {
+				handler.ReportSLOs()
+			}
+		}()
+	}
+
+	go func() {
+		for range time.Tick(10 * time.Second) {
+			if _, err := handler.CheckFDs(); err != nil {
+				fmt.Println(fmt.Sprintf("Could not check file descriptors, %v", err))
+				return
+			}
+		}
+	}()
+	if topDropsSink != nil && *topDropsStats > 0 {
+		go func() {
+			for range time.Tick(10 * time.Second) {
+				topDropsSink.Send(guardedStatsD, *topDropsStats, conf.Tags)
+			}
+		}()
+	}
+	if *upstreamProbeInterval > 0 {
+		go func() {
+			for range time.Tick(*upstreamProbeInterval) {
+				ctx, cancel := context.WithTimeout(context.Background(), *upstreamProbeInterval)
+				handler.ProbeUpstream(ctx)
+				cancel()
+			}
+		}()
+	}
+
+	if *sandbox {
+		sb, err := server.NewSandbox()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			for range time.Tick(10 * time.Second) {
+				if err := sb.Check(); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}()
+	}
+
+	var passthroughListener net.Listener
+	if *passthroughAddr != "" {
+		var routes []server.SNIRoute
+		for _, route := range passthroughRoutes {
+			r, err := server.ParseSNIRoute(route)
+			if err != nil {
+				log.Fatal(err)
+			}
+			routes = append(routes, r)
+		}
+		passthroughListener, err = net.Listen("tcp", *passthroughAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func(l net.Listener) {
+			p := server.NewPassthrough(routes, guardedStatsD, conf.Tags)
+			if err := p.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+				fmt.Println(fmt.Sprintf("Something went wrong with the passthrough: %v", err))
+				os.Exit(-1)
+			}
+		}(passthroughListener)
+	}
+
+	var dogStatsDConns []net.PacketConn
+	if len(dogStatsDAddrs) > 0 {
+		network, address, err := server.ParseDogStatsDAddr(*dogStatsDUpstream)
+		if err != nil {
+			log.Fatal(err)
+		}
+		upstream, err := net.Dial(network, address)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, addr := range dogStatsDAddrs {
+			network, address, err := server.ParseDogStatsDAddr(addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			pc, err := net.ListenPacket(network, address)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dogStatsDConns = append(dogStatsDConns, pc)
+			go func(pc net.PacketConn) {
+				if err := handler.ServeDogStatsD(pc, upstream); err != nil && !errors.Is(err, net.ErrClosed) {
+					fmt.Println(fmt.Sprintf("Something went wrong with the DogStatsD listener: %v", err))
+					os.Exit(-1)
+				}
+			}(pc)
+		}
+	}
+
+	var adminServer *http.Server
+	if *adminAddr != "" {
+		adminServer = &http.Server{Addr: *adminAddr, Handler: handler.Admin()}
+		go func(hs *http.Server) {
+			if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Println(fmt.Sprintf("Something went wrong with the admin API: %v", err))
+				os.Exit(-1)
+			}
+		}(adminServer)
+	}
+	var healthServer *http.Server
+	if *healthAddr != "" {
+		healthServer = &http.Server{Addr: *healthAddr, Handler: handler.Health()}
+		go func(hs *http.Server) {
+			if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Println(fmt.Sprintf("Something went wrong with the health probes: %v", err))
+				os.Exit(-1)
+			}
+		}(healthServer)
+	}
+
 	cs := make(chan os.Signal, 1)
-	signal.Notify(cs, os.Interrupt)
-	<-cs
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	signal.Notify(cs, os.Interrupt, syscall.SIGHUP)
+	var configChanges <-chan struct{}
+	if *configFile != "" && *configWatchInterval > 0 {
+		configChanges = config.NewWatcher(*configFile, *configWatchInterval).C
+	}
+	var remoteChanges <-chan []byte
+	if remoteConfig != nil && *configURLInterval > 0 {
+		remoteChanges = config.Poll(remoteConfig, redactURL(*configURL), *configURLInterval, make(chan struct{}))
+	}
+	var metricConfigChanges <-chan server.MetricConfigRules
+	if metricConfigSync != nil && *metricConfigInterval > 0 {
+		metricConfigChanges = metricConfigSync.Poll(*metricConfigInterval, make(chan struct{}))
+	}
+wait:
+	for {
+		var by string
+		select {
+		case <-configChanges:
+			by = *configFile
+		case remoteDoc = <-remoteChanges:
+			by = redactURL(*configURL)
+		case metricConfig = <-metricConfigChanges:
+			by = *metricConfigAPI
+		case sig := <-cs:
+			if sig != syscall.SIGHUP {
+				break wait
+			}
+			by = "SIGHUP"
+		}
+		fmt.Println(fmt.Sprintf("Reloading the rules (%s)", by))
+		// Only the rules and the routing are reloaded, the listeners and
+		// what runs in the background keep the settings they started with.
+		err := reloadFlags(configFile, remoteDoc)
+		var rules server.Config
+		if err == nil {
+			rules, err = rulesConfig()
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Could not reload, keeping the current rules, %v", err))
+			continue
+		}
+		handler.Reload(rules, by)
+		fmt.Println("Reloaded the rules")
+		if *reloadDrainWindow > 0 {
+			n := drainer.Drain(*reloadDrainWindow)
+			fmt.Println(fmt.Sprintf("Draining %d client connections over %s", n, *reloadDrainWindow))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 	defer cancel()
 	fmt.Println("Attempting to shutdown")
-	if err = httpServer.Shutdown(ctx); err != nil {
+	handler.BeginShutdown()
+	if adminServer != nil {
+		_ = adminServer.Shutdown(ctx)
+	}
+	if passthroughListener != nil {
+		_ = passthroughListener.Close()
+	}
+	for _, pc := range dogStatsDConns {
+		_ = pc.Close()
+	}
+	report, err := drainer.Shutdown(ctx, httpServer)
+	fmt.Println(fmt.Sprintf("Shutdown %s", report))
+	report.Send(guardedStatsD, conf.Tags)
+	if healthServer != nil {
+		_ = healthServer.Shutdown(ctx)
+	}
+	if remoteWriteSink != nil {
+		remoteWriteSink.Close()
+		if lost := remoteWriteSink.Lost(); lost > 0 {
+			fmt.Println(fmt.Sprintf("Lost %d dropped series that did not fit in the remote-write queue", lost))
+		}
+	}
+	if otlpExporter != nil {
+		otlpExporter.Close()
+		if lost := otlpExporter.Lost(); lost > 0 {
+			fmt.Println(fmt.Sprintf("Lost %d series that did not fit in the OTLP queue", lost))
+		}
+	}
+	if kafkaSink != nil {
+		kafkaSink.Close()
+		if lost := kafkaSink.Lost(); lost > 0 {
+			fmt.Println(fmt.Sprintf("Lost %d series that did not fit in the Kafka queue", lost))
+		}
+	}
+	if conf.Archiver != nil {
+		conf.Archiver.Close()
+	}
+	_ = statsDClient.Flush()
+	if err != nil {
 		fmt.Println(fmt.Sprintf("Failed to shutdown server: %v", err))
 		os.Exit(-2)
 	}
 	fmt.Println("Shutdown complete")
 	os.Exit(0)
 }
+
+// loadFlags sets the flags not given on the command line from the environment,
+// then from configFile, when set, and then from remoteDoc, the document of
+// -config-url.
+func loadFlags(configFile *string, remoteDoc []byte) error {
+	if err := config.LoadEnv(flag.CommandLine, envPrefix, os.LookupEnv); err != nil {
+		return err
+	}
+	if *configFile != "" {
+		f, err := os.Open(*configFile)
+		if err != nil {
+			return err
+		}
+		err = config.Load(f, flag.CommandLine)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+	if err := config.Load(bytes.NewReader(remoteDoc), flag.CommandLine); err != nil {
+		return fmt.Errorf("-config-url: %w", err)
+	}
+	return nil
+}
+
+// reloadFlags sets the flags again as on start, their values going back to
+// their defaults before the command line, the environment, configFile and
+// remoteDoc are read, so that the ones removed from them are unset too.
+func reloadFlags(configFile *string, remoteDoc []byte) error {
+	old := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet(old.Name(), flag.ContinueOnError)
+	old.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*stringList); ok {
+			*l = nil
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		flag.CommandLine.Var(f.Value, f.Name, f.Usage)
+	})
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	return loadFlags(configFile, remoteDoc)
+}
+
+// newRemoteConfig creates the remote config of -config-url, an s3://<bucket>/<key>
+// URL being fetched with the AWS credentials of the environment, and
+// redis:// and etcd:// URLs from a key of the store.
+func newRemoteConfig(rawURL, region, publicKey string) (config.Source, error) {
+	var key ed25519.PublicKey
+	if publicKey != "" {
+		var err error
+		if key, err = config.ParsePublicKey(publicKey); err != nil {
+			return nil, fmt.Errorf("-config-url-public-key: %w", err)
+		}
+	}
+	switch {
+	case strings.HasPrefix(rawURL, "redis://") || strings.HasPrefix(rawURL, "rediss://"):
+		store, err := config.ParseRedisURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		store.PublicKey = key
+		return store, nil
+	case strings.HasPrefix(rawURL, "etcd://") || strings.HasPrefix(rawURL, "etcds://"):
+		store, err := config.ParseEtcdURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		store.Client, store.PublicKey = &http.Client{Timeout: 30 * time.Second}, key
+		return store, nil
+	}
+	remote := &config.Remote{URL: rawURL, Client: &http.Client{Timeout: 30 * time.Second}, PublicKey: key}
+	if strings.HasPrefix(rawURL, "s3://") {
+		bucket, object := rawURL[len("s3://"):], ""
+		if i := strings.IndexByte(bucket, '/'); i >= 0 {
+			bucket, object = bucket[:i], bucket[i+1:]
+		}
+		if bucket == "" || object == "" {
+			return nil, fmt.Errorf("expected s3://<bucket>/<key> in %q", rawURL)
+		}
+		store := server.S3Store{
+			Bucket:          bucket,
+			Region:          region,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		remote.URL, remote.Sign = store.ObjectURL(object), store.SignRequest
+	}
+	return remote, nil
+}
+
+// isLoopback reports whether addr, written as host:port, only listens on a
+// loopback interface, e.g. 127.0.0.1:8081 or localhost:8081.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// redactURL returns rawURL without its password, to log it.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// fileDigest returns the hex encoded sha256 hash of the file at path.
+func fileDigest(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// provenanceExcluded lists the flags that do not change what is forwarded, or
+// that hold secrets, which the provenance tag ignores.
+var provenanceExcluded = map[string]bool{
+	"admin-addr":              true,
+	"admin-token":             true,
+	"config":                  true,
+	"config-url":              true,
+	"config-url-interval":     true,
+	"config-url-public-key":   true,
+	"config-url-s3-region":    true,
+	"config-watch-interval":   true,
+	"metric-config-interval":  true,
+	"archive-dir":             true,
+	"archive-queue":           true,
+	"archive-retention":       true,
+	"archive-s3-bucket":       true,
+	"archive-s3-endpoint":     true,
+	"archive-s3-prefix":       true,
+	"archive-s3-region":       true,
+	"archive-workers":         true,
+	"dogstatsd-addr":          true,
+	"dogstatsd-upstream":      true,
+	"drop-sink-file":          true,
+	"drop-sink-queue":         true,
+	"drop-sink-remote-write":  true,
+	"env":                     true,
+	"health-addr":             true,
+	"kafka-decision-topic":    true,
+	"kafka-dropped-topic":     true,
+	"kafka-partitions":        true,
+	"kafka-queue":             true,
+	"kafka-rest-endpoint":     true,
+	"kafka-topic":             true,
+	"listen-addr":             true,
+	"mirror-endpoint":         true,
+	"mirror-max-in-flight":    true,
+	"otlp-endpoint":           true,
+	"otlp-queue":              true,
+	"passthrough-addr":        true,
+	"shard":                   true,
+	"stats-addr":              true,
+	"top-drops":               true,
+	"top-drops-stats":         true,
+	"upstream-probe-failures": true,
+	"upstream-probe-interval": true,
+	"upstream-probe-path":     true,
+	"upstream-check-timeout":  true,
+}
+
+// provenanceSettings returns the values of the flags set on the command line
+// that the provenance tag is a hash of.
+func provenanceSettings() map[string][]string {
+	settings := make(map[string][]string)
+	flag.Visit(func(f *flag.Flag) {
+		if provenanceExcluded[f.Name] {
+			return
+		}
+		if l, ok := f.Value.(*stringList); ok {
+			settings[f.Name] = append([]string(nil), *l...)
+			return
+		}
+		settings[f.Name] = []string{f.Value.String()}
+	})
+	return settings
+}
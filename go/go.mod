@@ -3,24 +3,32 @@ module github.com/carlosroman/proxy-filter/go
 go 1.17
 
 require (
+	github.com/DataDog/agent-payload/v5 v5.0.19
 	github.com/DataDog/datadog-api-client-go v1.11.0
 	github.com/DataDog/datadog-go/v5 v5.1.0
+	github.com/DataDog/zstd v1.5.0
+	github.com/andybalholm/brotli v1.0.4
+	github.com/golang/snappy v0.0.4
 	github.com/stretchr/testify v1.7.1
+	github.com/tinylib/msgp v1.1.2
+	golang.org/x/net v0.0.0-20211020060615-d418f374d309
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/DataDog/dd-trace-go.v1 v1.37.1
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
 require (
 	github.com/DataDog/gostackparse v0.5.0 // indirect
 	github.com/Microsoft/go-winio v0.5.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/pprof v0.0.0-20210423192551-a2663126120b // indirect
 	github.com/google/uuid v1.3.0 // indirect
+	github.com/philhofer/fwd v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/net v0.0.0-20211020060615-d418f374d309 // indirect
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d // indirect
 	golang.org/x/sys v0.0.0-20220227234510-4e6760a101f9 // indirect
+	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/appengine v1.6.6 // indirect
-	google.golang.org/protobuf v1.27.1 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
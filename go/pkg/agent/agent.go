@@ -0,0 +1,161 @@
+// Package agent identifies the Datadog Agent version a request comes from, so
+// filters and transforms can behave differently per version.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrVersionInvalid is returned when a version or version range cannot be
+// parsed.
+var ErrVersionInvalid = errors.New("invalid agent version")
+
+// Version is a Datadog Agent version. The zero Version is an unknown agent.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses versions such as 7.40, 7.40.1 or 7.40.1-rc.3, ignoring
+// anything after the patch number.
+func ParseVersion(s string) (Version, error) {
+	core := s
+	if i := strings.IndexAny(core, "-+ "); i >= 0 {
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Version{}, fmt.Errorf("%w %q", ErrVersionInvalid, s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("%w %q", ErrVersionInvalid, s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+// FromRequest returns the version of the agent sending r, read from the
+// DD-Agent-Version header or else a datadog-agent/<version> User-Agent. It
+// returns the zero Version for other clients.
+func FromRequest(r *http.Request) Version {
+	if v, err := ParseVersion(r.Header.Get("DD-Agent-Version")); err == nil {
+		return v
+	}
+	for _, product := range strings.Fields(r.Header.Get("User-Agent")) {
+		name, version := product, ""
+		if i := strings.IndexByte(product, '/'); i >= 0 {
+			name, version = product[:i], product[i+1:]
+		}
+		if !strings.EqualFold(name, "datadog-agent") {
+			continue
+		}
+		if v, err := ParseVersion(version); err == nil {
+			return v
+		}
+	}
+	return Version{}
+}
+
+// Known reports if v is the version of an agent.
+func (v Version) Known() bool {
+	return v != Version{}
+}
+
+// Compare returns -1, 0 or 1 when v is older than, the same as or newer than
+// o.
+func (v Version) Compare(o Version) int {
+	for _, d := range [...]int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v Version) String() string {
+	if !v.Known() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Range holds the versions from Min, inclusive, to Max, exclusive. A zero Min
+// or Max leaves that side open.
+type Range struct {
+	Min, Max Version
+}
+
+// ParseRange parses >=7.40, <7.45 or 7.40..7.45, the last one being the same
+// as both of the others.
+func ParseRange(s string) (Range, error) {
+	var r Range
+	var err error
+	switch {
+	case strings.HasPrefix(s, ">="):
+		r.Min, err = ParseVersion(s[2:])
+	case strings.HasPrefix(s, "<"):
+		r.Max, err = ParseVersion(s[1:])
+	case strings.Contains(s, ".."):
+		bounds := strings.SplitN(s, "..", 2)
+		if r.Min, err = ParseVersion(bounds[0]); err == nil {
+			r.Max, err = ParseVersion(bounds[1])
+		}
+		if err == nil && r.Min.Compare(r.Max) >= 0 {
+			err = fmt.Errorf("%w range %q, it is empty", ErrVersionInvalid, s)
+		}
+	default:
+		err = fmt.Errorf("%w range %q, expected >=<version>, <<version> or <version>..<version>", ErrVersionInvalid, s)
+	}
+	if err != nil {
+		return Range{}, err
+	}
+	return r, nil
+}
+
+// Contains reports if v is in the range. Unknown versions are in no range.
+func (r Range) Contains(v Version) bool {
+	if !v.Known() {
+		return false
+	}
+	if r.Min.Known() && v.Compare(r.Min) < 0 {
+		return false
+	}
+	return !r.Max.Known() || v.Compare(r.Max) < 0
+}
+
+func (r Range) String() string {
+	switch {
+	case r.Min.Known() && r.Max.Known():
+		return r.Min.String() + ".." + r.Max.String()
+	case r.Max.Known():
+		return "<" + r.Max.String()
+	case r.Min.Known():
+		return ">=" + r.Min.String()
+	}
+	return ""
+}
+
+type versionKey struct{}
+
+// WithVersion returns a copy of ctx carrying the agent version v.
+func WithVersion(ctx context.Context, v Version) context.Context {
+	return context.WithValue(ctx, versionKey{}, v)
+}
+
+// VersionFrom returns the agent version carried by ctx, or the zero Version if
+// there is none.
+func VersionFrom(ctx context.Context) Version {
+	v, _ := ctx.Value(versionKey{}).(Version)
+	return v
+}
@@ -0,0 +1,143 @@
+package agent_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		expected agent.Version
+		invalid  bool
+	}{
+		{
+			name:     "Major and minor",
+			version:  "7.40",
+			expected: agent.Version{Major: 7, Minor: 40},
+		},
+		{
+			name:     "Patch",
+			version:  "7.40.1",
+			expected: agent.Version{Major: 7, Minor: 40, Patch: 1},
+		},
+		{
+			name:     "Pre-release",
+			version:  "7.41.0-rc.3",
+			expected: agent.Version{Major: 7, Minor: 41},
+		},
+		{
+			name:    "Empty",
+			invalid: true,
+		},
+		{
+			name:    "Major only",
+			version: "7",
+			invalid: true,
+		},
+		{
+			name:    "Not a number",
+			version: "7.x",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := agent.ParseVersion(tc.version)
+			if tc.invalid {
+				assert.ErrorIs(t, err, agent.ErrVersionInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "Agent version header",
+			headers:  map[string]string{"DD-Agent-Version": "7.40.1", "User-Agent": "datadog-agent/7.39.0"},
+			expected: "7.40.1",
+		},
+		{
+			name:     "User agent",
+			headers:  map[string]string{"User-Agent": "Go-http-client/1.1 datadog-agent/7.39.0"},
+			expected: "7.39.0",
+		},
+		{
+			name:     "Other client",
+			headers:  map[string]string{"User-Agent": "curl/7.79.1"},
+			expected: "unknown",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/series", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tc.expected, agent.FromRequest(req).String())
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		rng      string
+		version  agent.Version
+		expected bool
+	}{
+		{
+			name:     "At least",
+			rng:      ">=7.40.0",
+			version:  agent.Version{Major: 7, Minor: 40},
+			expected: true,
+		},
+		{
+			name:    "Older than minimum",
+			rng:     ">=7.40.0",
+			version: agent.Version{Major: 7, Minor: 39, Patch: 9},
+		},
+		{
+			name:     "Older than maximum",
+			rng:      "<7.40.0",
+			version:  agent.Version{Major: 6, Minor: 45},
+			expected: true,
+		},
+		{
+			name:    "Maximum is excluded",
+			rng:     "7.38.0..7.40.0",
+			version: agent.Version{Major: 7, Minor: 40},
+		},
+		{
+			name: "Unknown version",
+			rng:  ">=7.40.0",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := agent.ParseRange(tc.rng)
+			require.NoError(t, err)
+			assert.Equal(t, tc.rng, r.String())
+			assert.Equal(t, tc.expected, r.Contains(tc.version))
+		})
+	}
+
+	for _, s := range []string{"", "7.40", ">=seven", "7.40.0..7.38.0"} {
+		_, err := agent.ParseRange(s)
+		assert.ErrorIs(t, err, agent.ErrVersionInvalid, s)
+	}
+}
@@ -0,0 +1,31 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := clock.NewFake(start)
+	assert.Equal(t, start, f.Now())
+
+	f.Advance(10 * time.Second)
+	assert.Equal(t, start.Add(10*time.Second), f.Now())
+	assert.Equal(t, 10*time.Second, clock.Since(f, start))
+
+	f.Set(start)
+	assert.Equal(t, start, f.Now())
+}
+
+func TestNewRand(t *testing.T) {
+	a := clock.NewRand(42)
+	b := clock.NewRand(42)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, a.Int63(), b.Int63())
+	}
+}
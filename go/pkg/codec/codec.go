@@ -0,0 +1,103 @@
+// Package codec decodes request bodies into series for the filters and
+// encodes them back. Formats other than the Datadog JSON payload, such as an
+// envelope wrapping it, register a Codec for their media type.
+package codec
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// Payload is a decoded request body. Only its series go through the filters,
+// anything else it holds is kept for encoding it back.
+type Payload interface {
+	Series() []datadog.Series
+	SetSeries(series []datadog.Series)
+	Encode(w io.Writer) error
+}
+
+// Codec decodes request bodies of a media type. Implementations must be safe
+// for concurrent use.
+type Codec interface {
+	Decode(r io.Reader) (Payload, error)
+}
+
+// Func adapts a function to a Codec.
+type Func func(r io.Reader) (Payload, error)
+
+func (f Func) Decode(r io.Reader) (Payload, error) {
+	return f(r)
+}
+
+// Registry maps media types to codecs. The zero value is empty and ready to
+// use.
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+// Register makes c decode the bodies of mediaType, replacing any codec
+// registered for it before. The codec registered for the empty media type
+// decodes the bodies no other codec does.
+func (r *Registry) Register(mediaType string, c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.codecs == nil {
+		r.codecs = make(map[string]Codec)
+	}
+	r.codecs[mediaType] = c
+}
+
+// Lookup returns the codec for mediaType, falling back to the one for the
+// empty media type.
+func (r *Registry) Lookup(mediaType string) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, ok := r.codecs[mediaType]; ok {
+		return c, true
+	}
+	c, ok := r.codecs[""]
+	return c, ok
+}
+
+// Default is the registry used by the proxy unless told otherwise. It decodes
+// the Datadog JSON payload, also for unknown media types.
+var Default = &Registry{}
+
+// Register adds c to Default.
+func Register(mediaType string, c Codec) {
+	Default.Register(mediaType, c)
+}
+
+func init() {
+	Register("", JSON)
+	Register("application/json", JSON)
+}
+
+// JSON is the codec of the Datadog series JSON payload.
+var JSON Codec = Func(func(r io.Reader) (Payload, error) {
+	p := &jsonPayload{}
+	if err := json.NewDecoder(r).Decode(&p.payload); err != nil {
+		return nil, err
+	}
+	return p, nil
+})
+
+type jsonPayload struct {
+	payload datadog.MetricsPayload
+}
+
+func (p *jsonPayload) Series() []datadog.Series {
+	return p.payload.Series
+}
+
+func (p *jsonPayload) SetSeries(series []datadog.Series) {
+	p.payload.SetSeries(series)
+}
+
+func (p *jsonPayload) Encode(w io.Writer) error {
+	return json.NewEncoder(w).Encode(p.payload)
+}
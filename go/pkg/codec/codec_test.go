@@ -0,0 +1,89 @@
+package codec_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/codec"
+)
+
+func TestJSON(t *testing.T) {
+	// Given a payload
+	body := `{"series":[{"metric":"metric.one","points":[[1650000000,1]]},{"metric":"metric.two","points":[[1650000000,2]]}]}`
+
+	// When we decode it
+	p, err := codec.JSON.Decode(strings.NewReader(body))
+	require.NoError(t, err)
+
+	// Then the series are there
+	series := p.Series()
+	require.Len(t, series, 2)
+	assert.Equal(t, "metric.one", series[0].Metric)
+
+	// When we drop one and encode it back
+	p.SetSeries(series[1:])
+	buf := new(bytes.Buffer)
+	require.NoError(t, p.Encode(buf))
+
+	// Then only the kept series is encoded
+	var actual datadog.MetricsPayload
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &actual))
+	require.Len(t, actual.Series, 1)
+	assert.Equal(t, "metric.two", actual.Series[0].Metric)
+}
+
+func TestRegistry(t *testing.T) {
+	stub := codec.Func(func(r io.Reader) (codec.Payload, error) { return nil, nil })
+	tests := []struct {
+		name      string
+		register  map[string]codec.Codec
+		mediaType string
+		found     bool
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name:      "Registered",
+			register:  map[string]codec.Codec{"application/x-envelope": stub},
+			mediaType: "application/x-envelope",
+			found:     true,
+		},
+		{
+			name:      "Fallback",
+			register:  map[string]codec.Codec{"": codec.JSON},
+			mediaType: "text/plain",
+			found:     true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var reg codec.Registry
+			for mediaType, c := range tc.register {
+				reg.Register(mediaType, c)
+			}
+			actual, found := reg.Lookup(tc.mediaType)
+			assert.Equal(t, tc.found, found)
+			if tc.found {
+				assert.NotNil(t, actual)
+			}
+		})
+	}
+
+	t.Run("Default decodes JSON", func(t *testing.T) {
+		for _, mediaType := range []string{"", "application/json", "text/plain"} {
+			c, ok := codec.Default.Lookup(mediaType)
+			require.True(t, ok)
+			p, err := c.Decode(strings.NewReader(`{"series":[]}`))
+			require.NoError(t, err)
+			assert.Empty(t, p.Series())
+		}
+	})
+}
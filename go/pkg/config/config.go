@@ -0,0 +1,172 @@
+// Package config sets the flags of the proxy from a configuration file, local
+// or remote, or the environment, so that the settings of a deployment, its
+// rules first, live in one structured document or in the environment of its
+// container rather than on a long command line.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// repeatableUsage marks the usage of the flags that can be given several
+// times.
+const repeatableUsage = "(repeatable)"
+
+// Load sets the flags of fs from the YAML mapping read from r, keyed by flag
+// name, e.g.
+//
+//	base-endpoint: https://api.datadoghq.eu
+//	drop-rule:
+//	  - metric: app.debug.
+//	  - {metric: system., host: "*.staging.*"}
+//	add-tags: [proxied:true, cluster:eu1]
+//
+// A mapping is written as the comma separated key=value pairs the rule flags
+// take, in its order, a list value repeating its key. A list sets a
+// repeatable flag once per item, and any other flag to its items joined by
+// commas. The flags already set, e.g. on the command line, are left as they
+// are.
+func Load(r io.Reader, fs *flag.FlagSet) error {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of flag names to values", root.Line)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		f := fs.Lookup(key.Value)
+		if f == nil {
+			return fmt.Errorf("line %d: unknown setting %q", key.Line, key.Value)
+		}
+		if set[f.Name] || value.Tag == "!!null" {
+			continue
+		}
+		values, err := flagValues(value, strings.Contains(f.Usage, repeatableUsage))
+		if err != nil {
+			return fmt.Errorf("line %d: %s, %v", value.Line, f.Name, err)
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, v); err != nil {
+				return fmt.Errorf("line %d: %s, %v", value.Line, f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable LoadEnv reads the flag name from,
+// e.g. PROXY_FILTER_BASE_ENDPOINT for base-endpoint with the prefix
+// PROXY_FILTER_.
+func EnvName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadEnv sets the flags of fs from the environment variables named after
+// them by EnvName, read with lookup, e.g. os.LookupEnv. Each line of a
+// variable sets a repeatable flag once, and empty variables are ignored. The
+// flags already set, e.g. on the command line, are left as they are, so that
+// loading the environment before Load gives the command line precedence over
+// the environment, and the environment over the file.
+func LoadEnv(fs *flag.FlagSet, prefix string, lookup func(string) (string, bool)) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		name := EnvName(prefix, f.Name)
+		v, ok := lookup(name)
+		if !ok || v == "" {
+			return
+		}
+		values := []string{v}
+		if strings.Contains(f.Usage, repeatableUsage) {
+			values = values[:0]
+			for _, line := range strings.Split(v, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values = append(values, line)
+				}
+			}
+		}
+		for _, v := range values {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s: %v", name, serr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// flagValues returns the values a node sets its flag to.
+func flagValues(node *yaml.Node, repeatable bool) ([]string, error) {
+	if node.Kind != yaml.SequenceNode {
+		v, err := flagValue(node)
+		if err != nil {
+			return nil, err
+		}
+		return []string{v}, nil
+	}
+	values := make([]string, 0, len(node.Content))
+	for _, item := range node.Content {
+		v, err := flagValue(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	if !repeatable {
+		return []string{strings.Join(values, ",")}, nil
+	}
+	return values, nil
+}
+
+// flagValue returns the value of a scalar, or the key=value pairs of a
+// mapping.
+func flagValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.MappingNode:
+		var pairs []string
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			switch value.Kind {
+			case yaml.ScalarNode:
+				pairs = append(pairs, key+"="+value.Value)
+			case yaml.SequenceNode:
+				for _, item := range value.Content {
+					if item.Kind != yaml.ScalarNode {
+						return "", fmt.Errorf("expected values in the list of %s", key)
+					}
+					pairs = append(pairs, key+"="+item.Value)
+				}
+			default:
+				return "", fmt.Errorf("expected a value or a list of values for %s", key)
+			}
+		}
+		return strings.Join(pairs, ","), nil
+	}
+	return "", fmt.Errorf("expected a value, a list or a mapping")
+}
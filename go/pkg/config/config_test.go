@@ -0,0 +1,202 @@
+package config_test
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/config"
+)
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+type flags struct {
+	fs           *flag.FlagSet
+	baseEndpoint *string
+	addTags      *string
+	dropEmpty    *bool
+	timeout      *time.Duration
+	dropRules    stringList
+}
+
+func newFlags() *flags {
+	f := &flags{fs: flag.NewFlagSet("test", flag.ContinueOnError)}
+	f.baseEndpoint = f.fs.String("base-endpoint", "http://127.0.0.1:8080", "The base endpoint")
+	f.addTags = f.fs.String("add-tags", "", "Comma separated list of tags")
+	f.dropEmpty = f.fs.Bool("drop-empty", false, "Drop empty series")
+	f.timeout = f.fs.Duration("shutdown-timeout", 10*time.Second, "Shutdown timeout")
+	f.fs.Var(&f.dropRules, "drop-rule", "Drop series matching metric=<prefix>,host=<pattern> (repeatable)")
+	return f
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name                 string
+		args                 []string
+		config               string
+		expectedBaseEndpoint string
+		expectedAddTags      string
+		expectedDropEmpty    bool
+		expectedTimeout      time.Duration
+		expectedDropRules    stringList
+	}{
+		{
+			name: "Scalars",
+			config: `
+base-endpoint: https://api.datadoghq.eu
+drop-empty: true
+shutdown-timeout: 30s
+`,
+			expectedBaseEndpoint: "https://api.datadoghq.eu",
+			expectedDropEmpty:    true,
+			expectedTimeout:      30 * time.Second,
+		},
+		{
+			name: "Lists and rules",
+			config: `
+add-tags: [proxied:true, cluster:eu1]
+drop-rule:
+  - metric=app.debug.
+  - {metric: system., host: "*.staging.*"}
+  - metric: app.
+    agent: [">=7.40.0"]
+`,
+			expectedBaseEndpoint: "http://127.0.0.1:8080",
+			expectedAddTags:      "proxied:true,cluster:eu1",
+			expectedTimeout:      10 * time.Second,
+			expectedDropRules:    stringList{"metric=app.debug.", "metric=system.,host=*.staging.*", "metric=app.,agent=>=7.40.0"},
+		},
+		{
+			name: "Command line wins",
+			args: []string{"-base-endpoint", "https://api.datadoghq.com"},
+			config: `
+base-endpoint: https://api.datadoghq.eu
+shutdown-timeout:
+`,
+			expectedBaseEndpoint: "https://api.datadoghq.com",
+			expectedTimeout:      10 * time.Second,
+		},
+		{
+			name:                 "Empty",
+			expectedBaseEndpoint: "http://127.0.0.1:8080",
+			expectedTimeout:      10 * time.Second,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given flags parsed from the command line
+			f := newFlags()
+			require.NoError(t, f.fs.Parse(tc.args))
+
+			// When the config is loaded
+			err := config.Load(strings.NewReader(tc.config), f.fs)
+
+			// Then the flags are set from it
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBaseEndpoint, *f.baseEndpoint)
+			assert.Equal(t, tc.expectedAddTags, *f.addTags)
+			assert.Equal(t, tc.expectedDropEmpty, *f.dropEmpty)
+			assert.Equal(t, tc.expectedTimeout, *f.timeout)
+			assert.Equal(t, tc.expectedDropRules, f.dropRules)
+		})
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{
+			name:          "Not a mapping",
+			config:        "- base-endpoint",
+			expectedError: "line 1: expected a mapping of flag names to values",
+		},
+		{
+			name:          "Unknown setting",
+			config:        "base-endpoint: https://api.datadoghq.eu\nprefx: app.",
+			expectedError: `line 2: unknown setting "prefx"`,
+		},
+		{
+			name:          "Invalid value",
+			config:        "shutdown-timeout: soon",
+			expectedError: "line 1: shutdown-timeout, ",
+		},
+		{
+			name:          "Nested rule",
+			config:        "drop-rule:\n  - metric: {prefix: app.}",
+			expectedError: "line 2: drop-rule, expected a value or a list of values for metric",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given flags
+			f := newFlags()
+
+			// When an invalid config is loaded
+			err := config.Load(strings.NewReader(tc.config), f.fs)
+
+			// Then it is rejected
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	// Given flags parsed from the command line
+	f := newFlags()
+	require.NoError(t, f.fs.Parse([]string{"-shutdown-timeout", "5s"}))
+
+	// And an environment
+	env := map[string]string{
+		"PROXY_FILTER_BASE_ENDPOINT":    "https://api.datadoghq.eu",
+		"PROXY_FILTER_DROP_EMPTY":       "",
+		"PROXY_FILTER_SHUTDOWN_TIMEOUT": "30s",
+		"PROXY_FILTER_DROP_RULE":        "metric=app.debug.\n  metric=system.,host=*.staging.*\n",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	// When the environment and then a config are loaded
+	require.NoError(t, config.LoadEnv(f.fs, "PROXY_FILTER_", lookup))
+	require.NoError(t, config.Load(strings.NewReader("base-endpoint: https://api.datadoghq.com\nadd-tags: proxied:true"), f.fs))
+
+	// Then the command line wins over the environment, which wins over the config
+	assert.Equal(t, 5*time.Second, *f.timeout)
+	assert.Equal(t, "https://api.datadoghq.eu", *f.baseEndpoint)
+	assert.Equal(t, "proxied:true", *f.addTags)
+	assert.False(t, *f.dropEmpty)
+	assert.Equal(t, stringList{"metric=app.debug.", "metric=system.,host=*.staging.*"}, f.dropRules)
+}
+
+func TestLoadEnv_Invalid(t *testing.T) {
+	// Given an environment with an invalid value
+	f := newFlags()
+	lookup := func(name string) (string, bool) {
+		return "soon", name == "PROXY_FILTER_SHUTDOWN_TIMEOUT"
+	}
+
+	// When it is loaded
+	err := config.LoadEnv(f.fs, "PROXY_FILTER_", lookup)
+
+	// Then it is rejected naming the variable
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "PROXY_FILTER_SHUTDOWN_TIMEOUT: ")
+}
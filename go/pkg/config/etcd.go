@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Etcd fetches a config document from a key of an etcd cluster, through the
+// JSON gateway of its v3 API, e.g. so that the replicas of the proxy behind
+// a load balancer share their rules, converging on a change within the
+// interval they poll it at. The document is only read again once the
+// revision of its key changed.
+type Etcd struct {
+	// Endpoint is the base URL of a member of the cluster, e.g.
+	// http://127.0.0.1:2379.
+	Endpoint string
+	Key      string
+	// Username and Password, when set, authenticate the requests.
+	Username string
+	Password string
+	Client   *http.Client
+	// PublicKey, when set, is the Ed25519 key the document must be signed
+	// with, its signature being read from Key with .sig appended, as Remote
+	// reads it.
+	PublicKey ed25519.PublicKey
+
+	mu       sync.Mutex
+	token    string
+	revision string
+	doc      []byte
+}
+
+// ParseEtcdURL parses etcd://[username:password@]host[:port]/<key>, or
+// etcds:// to connect with TLS.
+func ParseEtcdURL(rawURL string) (*Etcd, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	scheme := map[string]string{"etcd": "http", "etcds": "https"}[u.Scheme]
+	if scheme == "" {
+		return nil, fmt.Errorf("expected an etcd:// or etcds:// URL, got %s", u.Redacted())
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host += ":2379"
+	}
+	e := &Etcd{Endpoint: scheme + "://" + host, Key: u.Path}
+	if u.User != nil {
+		e.Username = u.User.Username()
+		e.Password, _ = u.User.Password()
+	}
+	if e.Key == "" || e.Key == "/" {
+		return nil, fmt.Errorf("expected the key of the config as the path of %s", u.Redacted())
+	}
+	return e, nil
+}
+
+// Fetch returns the document, and whether it changed since the last fetch.
+func (e *Etcd) Fetch() ([]byte, bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	doc, revision, err := e.get(e.Key)
+	if err != nil {
+		return nil, false, err
+	}
+	if revision == e.revision {
+		return e.doc, false, nil
+	}
+	if e.PublicKey != nil {
+		sig, _, err := e.get(e.Key + ".sig")
+		if err != nil {
+			return nil, false, err
+		}
+		if err := verify(e.PublicKey, doc, sig); err != nil {
+			return nil, false, fmt.Errorf("key %q: %w", e.Key, err)
+		}
+	}
+	changed := !bytes.Equal(doc, e.doc)
+	e.doc, e.revision = doc, revision
+	return doc, changed, nil
+}
+
+// get returns the value of key and the revision it was last modified at.
+func (e *Etcd) get(key string) ([]byte, string, error) {
+	var res struct {
+		Kvs []struct {
+			ModRevision string `json:"mod_revision"`
+			Value       []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	req := map[string]interface{}{"key": []byte(key)}
+	if err := e.call("/v3/kv/range", req, &res); err != nil {
+		return nil, "", err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, "", fmt.Errorf("key %q not found", key)
+	}
+	return res.Kvs[0].Value, res.Kvs[0].ModRevision, nil
+}
+
+// call posts req as JSON to path, decoding the response into res. Requests
+// are authenticated with a token obtained with Username and Password, which
+// is obtained again once rejected.
+func (e *Etcd) call(path string, req, res interface{}) error {
+	if e.Username != "" && e.token == "" && path != "/v3/auth/authenticate" {
+		var auth struct {
+			Token string `json:"token"`
+		}
+		if err := e.call("/v3/auth/authenticate", map[string]string{"name": e.Username, "password": e.Password}, &auth); err != nil {
+			return err
+		}
+		e.token = auth.Token
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPost, e.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		r.Header.Set("Authorization", e.token)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e.token = ""
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("got %d from %s, %s", resp.StatusCode, e.Endpoint+path, msg)
+	}
+	// The value is base64 encoded, a third larger than the document.
+	return json.NewDecoder(io.LimitReader(resp.Body, 2*maxRemoteSize)).Decode(res)
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis fetches a config document from a key of a Redis server, e.g. so
+// that the replicas of the proxy behind a load balancer share their rules,
+// converging on a change within the interval they poll it at.
+type Redis struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Username and Password authenticate the connection when Password is
+	// set, Username being optional.
+	Username string
+	Password string
+	// DB is the database holding Key.
+	DB  int
+	Key string
+	// TLS, when set, connects with TLS.
+	TLS *tls.Config
+	// Timeout bounds each fetch, 10 seconds when 0.
+	Timeout time.Duration
+	// PublicKey, when set, is the Ed25519 key the document must be signed
+	// with, its signature being read from Key with .sig appended, as Remote
+	// reads it.
+	PublicKey ed25519.PublicKey
+
+	mu  sync.Mutex
+	doc []byte
+}
+
+// ParseRedisURL parses redis://[[username]:password@]host[:port][/db]?key=<key>,
+// or rediss:// to connect with TLS.
+func ParseRedisURL(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("expected a redis:// or rediss:// URL, got %s", u.Redacted())
+	}
+	r := &Redis{Addr: u.Host, Key: u.Query().Get("key")}
+	if u.Port() == "" {
+		r.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.Username = u.User.Username()
+		r.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if r.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("bad database %q in %s", db, u.Redacted())
+		}
+	}
+	if u.Scheme == "rediss" {
+		r.TLS = &tls.Config{ServerName: u.Hostname()}
+	}
+	if r.Key == "" {
+		return nil, fmt.Errorf("expected the key of the config as ?key=<key> in %s", u.Redacted())
+	}
+	return r, nil
+}
+
+// Fetch returns the document, and whether it changed since the last fetch.
+func (r *Redis) Fetch() ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := []string{r.Key}
+	if r.PublicKey != nil {
+		keys = append(keys, r.Key+".sig")
+	}
+	values, err := r.mget(keys)
+	if err != nil {
+		return nil, false, err
+	}
+	doc := values[0]
+	if doc == nil {
+		return nil, false, fmt.Errorf("key %q not found", r.Key)
+	}
+	if bytes.Equal(doc, r.doc) {
+		return r.doc, false, nil
+	}
+	if r.PublicKey != nil {
+		if err := verify(r.PublicKey, doc, values[1]); err != nil {
+			return nil, false, fmt.Errorf("key %q: %w", r.Key, err)
+		}
+	}
+	r.doc = doc
+	return doc, true, nil
+}
+
+// mget reads keys on a new connection, a value being nil when its key does
+// not exist.
+func (r *Redis) mget(keys []string) ([][]byte, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if r.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.Addr, r.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", r.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	var cmds [][]string
+	if r.Password != "" {
+		if r.Username != "" {
+			cmds = append(cmds, []string{"AUTH", r.Username, r.Password})
+		} else {
+			cmds = append(cmds, []string{"AUTH", r.Password})
+		}
+	}
+	if r.DB != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(r.DB)})
+	}
+	cmds = append(cmds, append([]string{"MGET"}, keys...))
+	// The commands are pipelined, their replies read in order.
+	var buf bytes.Buffer
+	for _, cmd := range cmds {
+		writeRESP(&buf, cmd)
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	var reply interface{}
+	for _, cmd := range cmds {
+		if reply, err = readRESP(br); err != nil {
+			return nil, fmt.Errorf("%s: %w", cmd[0], err)
+		}
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != len(keys) {
+		return nil, fmt.Errorf("MGET: unexpected reply %v", reply)
+	}
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		values[i], _ = item.([]byte)
+	}
+	return values, nil
+}
+
+// writeRESP writes a command as a RESP array of bulk strings.
+func writeRESP(w *bytes.Buffer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readRESP reads a RESP reply: a string for simple strings, an int64 for
+// integers, a []byte for bulk strings, nil for null ones, and a
+// []interface{} for arrays. Error replies are returned as errors.
+func readRESP(br *bufio.Reader) (interface{}, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > maxRemoteSize {
+			return nil, fmt.Errorf("value is larger than %d bytes", maxRemoteSize)
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(br, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > 1024 {
+			return nil, fmt.Errorf("array of %d items is too long", n)
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(br); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRemoteSize bounds the size of a remote config document.
+const maxRemoteSize = 10 << 20
+
+// Source is where a remote config document is fetched from.
+type Source interface {
+	// Fetch returns the document, and whether it changed since the last
+	// fetch.
+	Fetch() ([]byte, bool, error)
+}
+
+// Poll fetches the document of src every interval until stop is closed,
+// sending it on the returned channel whenever it changed. A fetch that fails
+// is logged with the name of src and the document is fetched again on the
+// next interval.
+func Poll(src Source, name string, interval time.Duration, stop <-chan struct{}) <-chan []byte {
+	docs := make(chan []byte)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			doc, changed, err := src.Fetch()
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Could not fetch the config from %s, %v", name, err))
+				continue
+			}
+			if !changed {
+				continue
+			}
+			select {
+			case docs <- doc:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return docs
+}
+
+// Remote fetches a config document from a URL, e.g. so that every replica of
+// the proxy gets its rules from one place. Each fetch is conditional on the
+// ETag of the last document, which is only downloaded again once it changed.
+type Remote struct {
+	// URL of the document, over HTTP or HTTPS.
+	URL    string
+	Client *http.Client
+	// Sign, when set, signs each request, e.g. with the credentials of the
+	// S3 bucket holding the document.
+	Sign func(req *http.Request)
+	// PublicKey, when set, is the Ed25519 key the document must be signed
+	// with. Its signature is fetched from URL with .sig appended, as the
+	// base64 encoded signature of the SHA-256 checksum of the document.
+	// Documents whose signature does not verify are rejected.
+	PublicKey ed25519.PublicKey
+
+	mu   sync.Mutex
+	etag string
+	doc  []byte
+}
+
+// Fetch returns the document, and whether it changed since the last fetch.
+func (r *Remote) Fetch() ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, etag, err := r.get(r.URL, r.etag)
+	if err != nil {
+		return nil, false, err
+	}
+	if doc == nil {
+		return r.doc, false, nil
+	}
+	if bytes.Equal(doc, r.doc) {
+		r.etag = etag
+		return r.doc, false, nil
+	}
+	if r.PublicKey != nil {
+		sig, _, err := r.get(r.URL+".sig", "")
+		if err != nil {
+			return nil, false, err
+		}
+		if err := verify(r.PublicKey, doc, sig); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", r.URL, err)
+		}
+	}
+	r.doc, r.etag = doc, etag
+	return doc, true, nil
+}
+
+// Poll fetches the document every interval until stop is closed, as Poll
+// does.
+func (r *Remote) Poll(interval time.Duration, stop <-chan struct{}) <-chan []byte {
+	return Poll(r, r.URL, interval, stop)
+}
+
+// get fetches url unless its ETag is still etag, returning a nil body then.
+func (r *Remote) get(url, etag string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if r.Sign != nil {
+		r.Sign(req)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, "", fmt.Errorf("got %d from %s, %s", resp.StatusCode, url, msg)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > maxRemoteSize {
+		return nil, "", fmt.Errorf("%s is larger than %d bytes", url, maxRemoteSize)
+	}
+	return body, resp.Header.Get("ETag"), nil
+}
+
+// verify checks sig, the base64 encoded Ed25519 signature of the SHA-256
+// checksum of doc.
+func verify(key ed25519.PublicKey, doc, sig []byte) error {
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature, %v", err)
+	}
+	sum := sha256.Sum256(doc)
+	if !ed25519.Verify(key, sum[:], raw) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// ParsePublicKey parses a base64 encoded Ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected a %d byte Ed25519 public key, got %d bytes", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
@@ -0,0 +1,115 @@
+package config_test
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/config"
+)
+
+func TestRemote_Fetch(t *testing.T) {
+	// Given a document served with an ETag
+	doc, etag := "prefix: app.", `"v1"`
+	var fetches, conditional int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("If-None-Match") == etag {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(doc))
+	}))
+	defer ts.Close()
+	remote := &config.Remote{URL: ts.URL, Client: ts.Client()}
+
+	// When it is fetched
+	got, changed, err := remote.Fetch()
+
+	// Then it is returned as changed
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, doc, string(got))
+
+	// When it is fetched again
+	got, changed, err = remote.Fetch()
+
+	// Then it is not downloaded again
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, doc, string(got))
+	assert.Equal(t, 1, conditional)
+
+	// When it changes
+	doc, etag = "prefix: system.", `"v2"`
+	got, changed, err = remote.Fetch()
+
+	// Then the new document is returned
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, doc, string(got))
+	assert.Equal(t, 3, fetches)
+}
+
+func TestRemote_Fetch_Signed(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	doc := []byte("prefix: app.")
+	sum := sha256.Sum256(doc)
+	valid := base64.StdEncoding.EncodeToString(ed25519.Sign(private, sum[:]))
+
+	tests := []struct {
+		name          string
+		signature     string
+		expectedError string
+	}{
+		{
+			name:      "Valid signature",
+			signature: valid + "\n",
+		},
+		{
+			name:          "Other document signed",
+			signature:     base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte("other"))),
+			expectedError: "signature does not match",
+		},
+		{
+			name:          "Not base64",
+			signature:     "not a signature",
+			expectedError: "invalid signature",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a document served with its signature
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/config.yaml.sig" {
+					_, _ = w.Write([]byte(tc.signature))
+					return
+				}
+				_, _ = w.Write(doc)
+			}))
+			defer ts.Close()
+			remote := &config.Remote{URL: ts.URL + "/config.yaml", Client: ts.Client(), PublicKey: public}
+
+			// When it is fetched
+			got, _, err := remote.Fetch()
+
+			// Then it is returned when its signature verifies
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, doc, got)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"sync"
+	"time"
+)
+
+// Watcher tells when the content of a file changes, reading it every
+// interval. The file is opened by path each time, following symlinks, so it
+// sees the updates of a Kubernetes ConfigMap mounted as a volume, which swap
+// the symlink of its directory, as well as the files written in place. While
+// the file cannot be read, e.g. between a removal and a rename, it is taken
+// as unchanged.
+type Watcher struct {
+	// C receives a value once the file has changed, changes made before it
+	// is received being reported once.
+	C <-chan struct{}
+
+	path     string
+	interval time.Duration
+	changed  chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	sum      []byte
+}
+
+// NewWatcher watches the file at path until closed, the content it has now
+// being the one changes are told from.
+func NewWatcher(path string, interval time.Duration) *Watcher {
+	changed := make(chan struct{}, 1)
+	w := &Watcher{C: changed, path: path, interval: interval, changed: changed, stop: make(chan struct{})}
+	w.sum, _ = w.read()
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// Close stops watching the file.
+func (w *Watcher) Close() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+		sum, err := w.read()
+		if err != nil || bytes.Equal(sum, w.sum) {
+			continue
+		}
+		w.sum = sum
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// read returns the checksum of the content of the file.
+func (w *Watcher) read() ([]byte, error) {
+	b, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
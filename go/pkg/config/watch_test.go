@@ -0,0 +1,48 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/config"
+)
+
+func TestWatcher(t *testing.T) {
+	// Given a config mounted as a ConfigMap, through a symlink to its data
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "v1"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "v1", "config.yaml"), []byte("prefix: app."), 0644))
+	require.NoError(t, os.Symlink("v1", filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "config.yaml"), filepath.Join(dir, "config.yaml")))
+
+	// And it is watched
+	w := config.NewWatcher(filepath.Join(dir, "config.yaml"), 10*time.Millisecond)
+	defer w.Close()
+
+	// When it is written with the same content
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "v1", "config.yaml"), []byte("prefix: app."), 0644))
+
+	// Then no change is told
+	select {
+	case <-w.C:
+		t.Fatal("expected no change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// When the data symlink is swapped to a new version
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "v2"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "v2", "config.yaml"), []byte("prefix: system."), 0644))
+	require.NoError(t, os.Symlink("v2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+	// Then the change is told
+	select {
+	case <-w.C:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change")
+	}
+}
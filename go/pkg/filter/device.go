@@ -0,0 +1,42 @@
+package filter
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// Device drops series whose device tag matches one of its patterns, using
+// path.Match syntax, e.g. /var/lib/docker/overlay2/*/merged or loop*.
+type Device []string
+
+// NewDevice creates a Device filter, checking its patterns are valid.
+func NewDevice(patterns ...string) (Device, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, &RuleError{Rule: p, Err: err}
+		}
+	}
+	return Device(patterns), nil
+}
+
+func (d Device) Filter(_ context.Context, series *datadog.Series) Decision {
+	for _, tag := range series.GetTags() {
+		if !strings.HasPrefix(tag, "device:") {
+			continue
+		}
+		device := strings.TrimPrefix(tag, "device:")
+		for _, p := range d {
+			if ok, _ := path.Match(p, device); ok {
+				return Drop
+			}
+		}
+	}
+	return Keep
+}
+
+func (d Device) String() string {
+	return "device"
+}
@@ -0,0 +1,53 @@
+package filter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+func TestDevice(t *testing.T) {
+	device, err := filter.NewDevice("/var/lib/docker/overlay2/*/merged", "loop*")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		tags     []string
+		expected filter.Decision
+	}{
+		{
+			name:     "Overlay mount",
+			tags:     []string{"env:prod", "device:/var/lib/docker/overlay2/3f1c/merged"},
+			expected: filter.Drop,
+		},
+		{
+			name:     "Loop device",
+			tags:     []string{"device:loop3"},
+			expected: filter.Drop,
+		},
+		{
+			name:     "Other device",
+			tags:     []string{"device:/dev/sda1"},
+			expected: filter.Keep,
+		},
+		{
+			name:     "No device",
+			tags:     []string{"env:prod", "mount:loop3"},
+			expected: filter.Keep,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			series := datadog.Series{Metric: "system.disk.used", Tags: &tc.tags}
+			assert.Equal(t, tc.expected, device.Filter(context.Background(), &series))
+		})
+	}
+
+	_, err = filter.NewDevice("[")
+	assert.ErrorIs(t, err, filter.ErrRuleInvalid)
+}
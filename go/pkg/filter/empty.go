@@ -0,0 +1,47 @@
+package filter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// Empty drops series without points, and gauges whose points are all zero,
+// when their name starts with one of its prefixes. An Empty without prefixes
+// applies to every series.
+type Empty []string
+
+func (e Empty) Filter(_ context.Context, series *datadog.Series) Decision {
+	if !e.matches(series.Metric) {
+		return Keep
+	}
+	if len(series.Points) == 0 {
+		return Drop
+	}
+	if series.Type != nil && series.GetType() != "gauge" {
+		return Keep
+	}
+	for _, point := range series.Points {
+		if len(point) < 2 || point[1] == nil || *point[1] != 0 {
+			return Keep
+		}
+	}
+	return Drop
+}
+
+func (e Empty) matches(metric string) bool {
+	if len(e) == 0 {
+		return true
+	}
+	for _, prefix := range e {
+		if strings.HasPrefix(metric, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e Empty) String() string {
+	return "empty"
+}
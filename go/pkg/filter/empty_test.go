@@ -0,0 +1,70 @@
+package filter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+func TestEmpty(t *testing.T) {
+	point := func(v float64) []*float64 {
+		return []*float64{datadog.PtrFloat64(1650000000), datadog.PtrFloat64(v)}
+	}
+	tests := []struct {
+		name     string
+		empty    filter.Empty
+		series   datadog.Series
+		expected filter.Decision
+	}{
+		{
+			name:     "No points",
+			empty:    filter.Empty{"legacy."},
+			series:   datadog.Series{Metric: "legacy.load"},
+			expected: filter.Drop,
+		},
+		{
+			name:     "All zero gauge",
+			empty:    filter.Empty{"legacy."},
+			series:   datadog.Series{Metric: "legacy.load", Type: datadog.PtrString("gauge"), Points: [][]*float64{point(0), point(0)}},
+			expected: filter.Drop,
+		},
+		{
+			name:     "All zero without type",
+			empty:    filter.Empty{"legacy."},
+			series:   datadog.Series{Metric: "legacy.load", Points: [][]*float64{point(0)}},
+			expected: filter.Drop,
+		},
+		{
+			name:     "Gauge with a value",
+			empty:    filter.Empty{"legacy."},
+			series:   datadog.Series{Metric: "legacy.load", Type: datadog.PtrString("gauge"), Points: [][]*float64{point(0), point(2)}},
+			expected: filter.Keep,
+		},
+		{
+			name:     "All zero count",
+			empty:    filter.Empty{"legacy."},
+			series:   datadog.Series{Metric: "legacy.errors", Type: datadog.PtrString("count"), Points: [][]*float64{point(0)}},
+			expected: filter.Keep,
+		},
+		{
+			name:     "Prefix not matched",
+			empty:    filter.Empty{"legacy."},
+			series:   datadog.Series{Metric: "app.load"},
+			expected: filter.Keep,
+		},
+		{
+			name:     "No prefixes",
+			series:   datadog.Series{Metric: "app.load"},
+			expected: filter.Drop,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.empty.Filter(context.Background(), &tc.series))
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRuleInvalid is returned when a filter cannot be built from its
+// configuration.
+var ErrRuleInvalid = errors.New("invalid filter rule")
+
+// RuleError describes why a rule is invalid. It matches ErrRuleInvalid with
+// errors.Is.
+type RuleError struct {
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("%v %q, %v", ErrRuleInvalid, e.Rule, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RuleError) Is(target error) bool {
+	return target == ErrRuleInvalid
+}
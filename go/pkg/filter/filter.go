@@ -0,0 +1,79 @@
+// Package filter decides which series of a metrics payload are forwarded.
+package filter
+
+import (
+	"context"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// Decision is the outcome of filtering a series.
+type Decision int
+
+const (
+	Keep Decision = iota
+	Drop
+)
+
+// Filter decides if a series is kept or dropped. Implementations must be safe
+// for concurrent use.
+type Filter interface {
+	Filter(ctx context.Context, series *datadog.Series) Decision
+}
+
+// Func adapts a function to a Filter.
+type Func func(ctx context.Context, series *datadog.Series) Decision
+
+func (f Func) Filter(ctx context.Context, series *datadog.Series) Decision {
+	return f(ctx, series)
+}
+
+// Auditor is a filter that is staged rather than enforced. It keeps every
+// series, telling the ones it would drop.
+type Auditor interface {
+	Audits(ctx context.Context, series *datadog.Series) bool
+}
+
+// Chain runs its filters in order and drops a series as soon as one of them
+// does.
+type Chain []Filter
+
+func (c Chain) Filter(ctx context.Context, series *datadog.Series) Decision {
+	if c.First(ctx, series) != nil {
+		return Drop
+	}
+	return Keep
+}
+
+// First returns the filter that drops series, looking into nested chains, or
+// nil if the series is kept.
+func (c Chain) First(ctx context.Context, series *datadog.Series) Filter {
+	for _, f := range c {
+		if inner, ok := f.(Chain); ok {
+			if m := inner.First(ctx, series); m != nil {
+				return m
+			}
+			continue
+		}
+		if f.Filter(ctx, series) == Drop {
+			return f
+		}
+	}
+	return nil
+}
+
+// Audited returns the auditors that would drop series, looking into nested
+// chains, whether or not another filter drops it.
+func (c Chain) Audited(ctx context.Context, series *datadog.Series) []Filter {
+	var res []Filter
+	for _, f := range c {
+		if inner, ok := f.(Chain); ok {
+			res = append(res, inner.Audited(ctx, series)...)
+			continue
+		}
+		if a, ok := f.(Auditor); ok && a.Audits(ctx, series) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
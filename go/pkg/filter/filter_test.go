@@ -0,0 +1,76 @@
+package filter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+func TestChain(t *testing.T) {
+	one := filter.Rule{MetricPrefix: "metric.one"}
+	two := filter.Rule{MetricPrefix: "metric.two"}
+	called := false
+	last := filter.Func(func(_ context.Context, _ *datadog.Series) filter.Decision {
+		called = true
+		return filter.Keep
+	})
+	chain := filter.Chain{one, filter.Chain{two}, last}
+
+	tests := []struct {
+		name             string
+		metric           string
+		expected         filter.Decision
+		expectedFilter   filter.Filter
+		expectLastCalled bool
+	}{
+		{
+			name:           "First filter drops",
+			metric:         "metric.one",
+			expected:       filter.Drop,
+			expectedFilter: one,
+		},
+		{
+			name:           "Nested filter drops",
+			metric:         "metric.two",
+			expected:       filter.Drop,
+			expectedFilter: two,
+		},
+		{
+			name:             "Nothing drops",
+			metric:           "metric.three",
+			expected:         filter.Keep,
+			expectLastCalled: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			series := datadog.Series{Metric: tc.metric}
+			assert.Equal(t, tc.expected, chain.Filter(context.Background(), &series))
+			assert.Equal(t, tc.expectedFilter, chain.First(context.Background(), &series))
+			assert.Equal(t, tc.expectLastCalled, called)
+		})
+	}
+}
+
+func TestChain_Audited(t *testing.T) {
+	// Given a chain with enforced and audit rules
+	audit := filter.Rule{MetricPrefix: "metric.", Audit: true}
+	nested := filter.Rule{MetricPrefix: "metric.one", Audit: true}
+	chain := filter.Chain{filter.Rule{MetricPrefix: "metric.one"}, audit, filter.Chain{nested}}
+
+	// When a series matching all of them is filtered
+	series := datadog.Series{Metric: "metric.one"}
+
+	// Then only the enforced rule drops it
+	assert.Equal(t, filter.Rule{MetricPrefix: "metric.one"}, chain.First(context.Background(), &series))
+
+	// And the audit rules tell they would
+	assert.Equal(t, []filter.Filter{audit, nested}, chain.Audited(context.Background(), &series))
+	other := datadog.Series{Metric: "other"}
+	assert.Empty(t, chain.Audited(context.Background(), &other))
+}
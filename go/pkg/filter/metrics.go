@@ -0,0 +1,34 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// Metrics drops the series whose metric is one of its names, e.g. a
+// blocklist kept elsewhere.
+type Metrics map[string]bool
+
+// NewMetrics returns the filter dropping the series of these metrics.
+func NewMetrics(names ...string) Metrics {
+	m := make(Metrics, len(names))
+	for _, name := range names {
+		m[name] = true
+	}
+	return m
+}
+
+func (m Metrics) Filter(_ context.Context, series *datadog.Series) Decision {
+	if m[series.Metric] {
+		return Drop
+	}
+	return Keep
+}
+
+// String counts the metrics rather than listing them, as it names the filter
+// in tags and labels and a synced blocklist can hold any number of them.
+func (m Metrics) String() string {
+	return fmt.Sprintf("metrics(%d)", len(m))
+}
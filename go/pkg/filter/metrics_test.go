@@ -0,0 +1,18 @@
+package filter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+func TestMetrics(t *testing.T) {
+	m := filter.NewMetrics("app.requests", "app.debug")
+	assert.Equal(t, filter.Drop, m.Filter(context.Background(), &datadog.Series{Metric: "app.requests"}))
+	assert.Equal(t, filter.Keep, m.Filter(context.Background(), &datadog.Series{Metric: "app.requests.count"}))
+	assert.Equal(t, "metrics(2)", m.String())
+}
@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+)
+
+// Rule drops series matching every field it sets. HostPattern uses path.Match
+// syntax, e.g. *.staging.example.com, Interval matches the series interval in
+// seconds and AgentVersions the version of the agent sending it. An Audit rule
+// keeps the series it matches, only telling them as an Auditor, to stage it
+// alongside the enforced ones.
+type Rule struct {
+	MetricPrefix  string
+	HostPattern   string
+	Interval      int64
+	AgentVersions agent.Range
+	Audit         bool
+}
+
+// ParseRule parses a rule written as comma separated key=value pairs with the
+// keys metric, host, interval, agent and action, action being drop, the
+// default, or audit, e.g. host=*.staging.*,interval=10 or
+// metric=system.,agent=<7.40,action=audit.
+func ParseRule(s string) (Rule, error) {
+	var rule Rule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Rule{}, &RuleError{Rule: s, Err: fmt.Errorf("expected key=value in %q", field)}
+		}
+		switch kv[0] {
+		case "metric":
+			rule.MetricPrefix = kv[1]
+		case "host":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return Rule{}, &RuleError{Rule: s, Err: err}
+			}
+			rule.HostPattern = kv[1]
+		case "interval":
+			interval, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || interval <= 0 {
+				return Rule{}, &RuleError{Rule: s, Err: fmt.Errorf("bad interval %q", kv[1])}
+			}
+			rule.Interval = interval
+		case "agent":
+			versions, err := agent.ParseRange(kv[1])
+			if err != nil {
+				return Rule{}, &RuleError{Rule: s, Err: err}
+			}
+			rule.AgentVersions = versions
+		case "action":
+			switch kv[1] {
+			case "drop":
+				rule.Audit = false
+			case "audit":
+				rule.Audit = true
+			default:
+				return Rule{}, &RuleError{Rule: s, Err: fmt.Errorf("expected action drop or audit, got %q", kv[1])}
+			}
+		default:
+			return Rule{}, &RuleError{Rule: s, Err: fmt.Errorf("unknown key %q", kv[0])}
+		}
+	}
+	return rule, nil
+}
+
+func (r Rule) Filter(ctx context.Context, series *datadog.Series) Decision {
+	if !r.Audit && r.matches(ctx, series) {
+		return Drop
+	}
+	return Keep
+}
+
+// Audits tells the series an Audit rule would drop.
+func (r Rule) Audits(ctx context.Context, series *datadog.Series) bool {
+	return r.Audit && r.matches(ctx, series)
+}
+
+func (r Rule) matches(ctx context.Context, series *datadog.Series) bool {
+	if r.MetricPrefix == "" && r.HostPattern == "" && r.Interval == 0 && r.AgentVersions == (agent.Range{}) {
+		return false
+	}
+	if r.MetricPrefix != "" && !strings.HasPrefix(series.Metric, r.MetricPrefix) {
+		return false
+	}
+	if r.HostPattern != "" {
+		if ok, _ := path.Match(r.HostPattern, series.GetHost()); !ok {
+			return false
+		}
+	}
+	if r.Interval != 0 && series.GetInterval() != r.Interval {
+		return false
+	}
+	if r.AgentVersions != (agent.Range{}) && !r.AgentVersions.Contains(agent.VersionFrom(ctx)) {
+		return false
+	}
+	return true
+}
+
+func (r Rule) String() string {
+	var fields []string
+	if r.MetricPrefix != "" {
+		fields = append(fields, "metric="+r.MetricPrefix)
+	}
+	if r.HostPattern != "" {
+		fields = append(fields, "host="+r.HostPattern)
+	}
+	if r.Interval != 0 {
+		fields = append(fields, "interval="+strconv.FormatInt(r.Interval, 10))
+	}
+	if r.AgentVersions != (agent.Range{}) {
+		fields = append(fields, "agent="+r.AgentVersions.String())
+	}
+	if r.Audit {
+		fields = append(fields, "action=audit")
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,162 @@
+package filter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		expected filter.Rule
+		invalid  bool
+	}{
+		{
+			name:     "Metric",
+			rule:     "metric=some.metric",
+			expected: filter.Rule{MetricPrefix: "some.metric"},
+		},
+		{
+			name:     "Host and interval",
+			rule:     "host=*.staging.*,interval=10",
+			expected: filter.Rule{HostPattern: "*.staging.*", Interval: 10},
+		},
+		{
+			name:     "Metric and agent versions",
+			rule:     "metric=system.,agent=7.40.0..7.45.0",
+			expected: filter.Rule{MetricPrefix: "system.", AgentVersions: agent.Range{Min: agent.Version{Major: 7, Minor: 40}, Max: agent.Version{Major: 7, Minor: 45}}},
+		},
+		{
+			name:     "Audit",
+			rule:     "metric=app.,action=audit",
+			expected: filter.Rule{MetricPrefix: "app.", Audit: true},
+		},
+		{
+			name:    "Unknown action",
+			rule:    "metric=app.,action=count",
+			invalid: true,
+		},
+		{
+			name:    "Bad agent versions",
+			rule:    "agent=7.40",
+			invalid: true,
+		},
+		{
+			name:    "Unknown key",
+			rule:    "tag=env:dev",
+			invalid: true,
+		},
+		{
+			name:    "Bad host pattern",
+			rule:    "host=[",
+			invalid: true,
+		},
+		{
+			name:    "Bad interval",
+			rule:    "interval=ten",
+			invalid: true,
+		},
+		{
+			name:    "Missing value",
+			rule:    "metric=",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := filter.ParseRule(tc.rule)
+			if tc.invalid {
+				assert.ErrorIs(t, err, filter.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+			assert.Equal(t, tc.rule, actual.String())
+		})
+	}
+}
+
+func TestRule_Filter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     filter.Rule
+		host     string
+		interval int64
+		version  agent.Version
+		expected filter.Decision
+	}{
+		{
+			name:     "Empty rule",
+			host:     "web-1.staging.example.com",
+			expected: filter.Keep,
+		},
+		{
+			name:     "Host matches",
+			rule:     filter.Rule{HostPattern: "*.staging.example.com"},
+			host:     "web-1.staging.example.com",
+			expected: filter.Drop,
+		},
+		{
+			name:     "Host does not match",
+			rule:     filter.Rule{HostPattern: "*.staging.example.com"},
+			host:     "web-1.prod.example.com",
+			expected: filter.Keep,
+		},
+		{
+			name:     "Host and interval match",
+			rule:     filter.Rule{HostPattern: "*.staging.example.com", Interval: 10},
+			host:     "web-1.staging.example.com",
+			interval: 10,
+			expected: filter.Drop,
+		},
+		{
+			name:     "Interval does not match",
+			rule:     filter.Rule{HostPattern: "*.staging.example.com", Interval: 10},
+			host:     "web-1.staging.example.com",
+			interval: 20,
+			expected: filter.Keep,
+		},
+		{
+			name:     "Metric and host match",
+			rule:     filter.Rule{MetricPrefix: "metric.", HostPattern: "web-*"},
+			host:     "web-1",
+			expected: filter.Drop,
+		},
+		{
+			name:     "Agent version in range",
+			rule:     filter.Rule{MetricPrefix: "metric.", AgentVersions: agent.Range{Max: agent.Version{Major: 7, Minor: 40}}},
+			version:  agent.Version{Major: 7, Minor: 39, Patch: 2},
+			expected: filter.Drop,
+		},
+		{
+			name:     "Agent version out of range",
+			rule:     filter.Rule{MetricPrefix: "metric.", AgentVersions: agent.Range{Max: agent.Version{Major: 7, Minor: 40}}},
+			version:  agent.Version{Major: 7, Minor: 40},
+			expected: filter.Keep,
+		},
+		{
+			name:     "Unknown agent version",
+			rule:     filter.Rule{MetricPrefix: "metric.", AgentVersions: agent.Range{Max: agent.Version{Major: 7, Minor: 40}}},
+			expected: filter.Keep,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := agent.WithVersion(context.Background(), tc.version)
+			series := datadog.Series{Metric: "metric.one"}
+			series.SetHost(tc.host)
+			if tc.interval != 0 {
+				series.SetInterval(tc.interval)
+			}
+			assert.Equal(t, tc.expected, tc.rule.Filter(ctx, &series))
+		})
+	}
+}
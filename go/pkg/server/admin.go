@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type adminTenantKey struct{}
+
+// AdminToken grants access to the admin API. A token with a Tenant only sees
+// the stats of that tenant and cannot use the endpoints about the whole
+// proxy.
+type AdminToken struct {
+	Token  string
+	Tenant string
+}
+
+// ParseAdminToken parses a token written as [tenant:]token, a token without a
+// tenant giving access to everything.
+func ParseAdminToken(s string) (AdminToken, error) {
+	t := AdminToken{Token: s}
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		t.Tenant, t.Token = s[:i], s[i+1:]
+		if t.Tenant == "" {
+			return AdminToken{}, newError(ErrRuleInvalid, fmt.Errorf("expected [tenant:]token, got an empty tenant"))
+		}
+	}
+	if t.Token == "" {
+		return AdminToken{}, newError(ErrRuleInvalid, fmt.Errorf("expected [tenant:]token, got an empty token"))
+	}
+	return t, nil
+}
+
+// AdminTenantFrom returns the tenant an admin request is scoped to, and
+// whether it is scoped at all.
+func AdminTenantFrom(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(adminTenantKey{}).(string)
+	return tenant, ok
+}
+
+// Admin returns the admin API, to mount on its own listener. When
+// Config.AdminTokens is set every request must carry one of them as a bearer
+// token, except readiness probes. Without tokens anyone reaching the listener
+// can use it, so it must then only listen on a loopback address.
+func (h *Handler) Admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/usage", h.adminAuth(h.ProtocolUsage, true))
+	mux.Handle("/backends", h.adminAuth(h.BackendStatus, false))
+	mux.Handle("/slos", h.adminAuth(h.SLOStatus, false))
+	mux.Handle("/fds", h.adminAuth(h.FDStatus, false))
+	mux.Handle("/agents", h.adminAuth(h.FleetStatus, true))
+	mux.Handle("/rules", h.adminAuth(h.RuntimeRules, false))
+	mux.Handle("/rules/", h.adminAuth(h.RuntimeRules, false))
+	mux.Handle("/maintenance", h.adminAuth(h.Maintenance, false))
+	mux.Handle("/drop-all", h.adminAuth(h.DropAll, false))
+	mux.HandleFunc("/ready", h.Readiness)
+	for _, sink := range h.cfg.DecisionSinks {
+		switch d := sink.(type) {
+		case *DebugBuffer:
+			mux.Handle("/decisions", h.adminAuth(d.ServeHTTP, false))
+		case *Telemetry:
+			mux.Handle("/metrics", h.adminAuth(d.ServeHTTP, false))
+		}
+	}
+	for _, sink := range h.cfg.DropSinks {
+		if d, ok := sink.(*TopDrops); ok {
+			mux.Handle("/drops/top", h.adminAuth(d.ServeHTTP, false))
+			break
+		}
+	}
+	return mux
+}
+
+// adminAuth checks the bearer token of admin requests, scoping the request to
+// the tenant of the token. Tenant scoped tokens are only let through when
+// scoped is set, i.e. when next filters what it shows by tenant.
+func (h *Handler) adminAuth(next http.HandlerFunc, scoped bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.cfg.AdminTokens) == 0 {
+			next(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, t := range h.cfg.AdminTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) != 1 {
+				continue
+			}
+			if t.Tenant == "" {
+				next(w, r)
+				return
+			}
+			if !scoped {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), adminTenantKey{}, t.Tenant)))
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
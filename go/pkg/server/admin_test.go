@@ -0,0 +1,156 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseAdminToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		expected server.AdminToken
+		invalid  bool
+	}{
+		{
+			name:     "Global",
+			token:    "c2VjcmV0==",
+			expected: server.AdminToken{Token: "c2VjcmV0=="},
+		},
+		{
+			name:     "Tenant",
+			token:    "team-a:secret",
+			expected: server.AdminToken{Tenant: "team-a", Token: "secret"},
+		},
+		{
+			name:    "Empty tenant",
+			token:   ":secret",
+			invalid: true,
+		},
+		{
+			name:    "Empty token",
+			token:   "team-a:",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseAdminToken(tc.token)
+			if tc.invalid {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestHandler_Admin(t *testing.T) {
+	// Given an upstream
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// And a proxy with a global and a tenant scoped admin token
+	cfg := server.Config{
+		BaseEndpoint: ts.URL,
+		AdminTokens:  []server.AdminToken{{Token: "admin"}, {Token: "team-a-token", Tenant: "team-a"}},
+	}
+	h := server.NewHandler(cfg, ts.Client(), &stubStatsdClient{})
+
+	// And requests from two tenants
+	for _, tenant := range []string{"team-a", "team-b"} {
+		tenant := tenant
+		h.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Tenant") == tenant {
+					server.RequestMetaFrom(r.Context()).Tenant = tenant
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+		r := httptest.NewRequest("POST", "/api/v1/series", nil)
+		r.Header.Set("X-Tenant", tenant)
+		h.ProxyHandle(httptest.NewRecorder(), r)
+	}
+	admin := h.Admin()
+
+	tests := []struct {
+		name            string
+		path            string
+		token           string
+		expectedStatus  int
+		expectedTenants []string
+	}{
+		{
+			name:           "No token",
+			path:           "/usage",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Unknown token",
+			path:           "/usage",
+			token:          "guess",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:            "Global token sees every tenant",
+			path:            "/usage",
+			token:           "admin",
+			expectedStatus:  http.StatusOK,
+			expectedTenants: []string{"team-a", "team-b"},
+		},
+		{
+			name:            "Tenant token sees its own tenant",
+			path:            "/usage",
+			token:           "team-a-token",
+			expectedStatus:  http.StatusOK,
+			expectedTenants: []string{"team-a"},
+		},
+		{
+			name:           "Tenant token cannot see backends",
+			path:           "/backends",
+			token:          "team-a-token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Global token sees backends",
+			path:           "/backends",
+			token:          "admin",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// When we call the admin API
+			r := httptest.NewRequest("GET", tc.path, nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, r)
+
+			// Then access is checked
+			require.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedTenants == nil {
+				return
+			}
+
+			// And only the allowed tenants are shown
+			var usage []server.ProtocolUsage
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&usage))
+			var tenants []string
+			for _, u := range usage {
+				tenants = append(tenants, u.Tenant)
+			}
+			assert.Equal(t, tc.expectedTenants, tenants)
+		})
+	}
+}
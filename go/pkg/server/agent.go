@@ -0,0 +1,21 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+)
+
+// withAgentVersion returns r carrying the version of the agent sending it, for
+// the filters and transforms conditioned on it, and counts the request by
+// major and minor version.
+func (h *Handler) withAgentVersion(r *http.Request) *http.Request {
+	v := agent.FromRequest(r)
+	tag := "agent_version:unknown"
+	if v.Known() {
+		tag = fmt.Sprintf("agent_version:%d.%d", v.Major, v.Minor)
+	}
+	_ = h.statsDClient.Count(agentRequestsCountName, 1, h.tags("route:"+routePattern(r), tag), 1)
+	return r.WithContext(agent.WithVersion(r.Context(), v))
+}
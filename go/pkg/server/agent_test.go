@@ -0,0 +1,78 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_AgentVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		headers         map[string]string
+		expectedTag     string
+		expectedMetrics []string
+	}{
+		{
+			name:            "Old agent header",
+			headers:         map[string]string{"DD-Agent-Version": "7.39.2"},
+			expectedTag:     "agent_version:7.39",
+			expectedMetrics: []string{"metric.one"},
+		},
+		{
+			name:            "New agent user agent",
+			headers:         map[string]string{"User-Agent": "datadog-agent/7.41.0"},
+			expectedTag:     "agent_version:7.41",
+			expectedMetrics: []string{"metric.one", "system.cpu"},
+		},
+		{
+			name:            "Unknown client",
+			headers:         map[string]string{"User-Agent": "curl/7.79.1"},
+			expectedTag:     "agent_version:unknown",
+			expectedMetrics: []string{"metric.one", "system.cpu"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with a rule for old agents
+			cfg := server.Config{
+				Filter: filter.Rule{MetricPrefix: "system.", AgentVersions: agent.Range{Max: agent.Version{Major: 7, Minor: 40}}},
+				Tags:   []string{"one"},
+			}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When an agent sends metrics
+			body, err := json.Marshal(defaultMetricsPayload([]string{"metric.one", "system.cpu"}))
+			require.NoError(t, err)
+			req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+			actual := <-resultChan
+
+			// Then the rule only applies to old agents
+			var payload datadog.MetricsPayload
+			require.NoError(t, json.Unmarshal([]byte(actual.body), &payload))
+			var metrics []string
+			for _, s := range payload.Series {
+				metrics = append(metrics, s.Metric)
+			}
+			assert.Equal(t, tc.expectedMetrics, metrics)
+
+			// And the request is counted by agent version
+			sc.assertCount(t, "proxy_filter.agent_requests.count", 1, []string{"one", "route:/api/v1/series", tc.expectedTag}, 1, true)
+		})
+	}
+}
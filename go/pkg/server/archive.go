@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// archivePruneInterval is how often the archives older than the retention are
+// removed.
+const archivePruneInterval = time.Hour
+
+// ArchiveStore stores the archived requests under a key, a slash separated
+// path.
+type ArchiveStore interface {
+	Put(key string, body []byte) error
+}
+
+// ArchivePruner is an ArchiveStore that can remove the archives stored before
+// a time, to apply ArchiverConfig.Retention.
+type ArchivePruner interface {
+	Prune(before time.Time) error
+}
+
+// ArchiverConfig configures an Archiver.
+type ArchiverConfig struct {
+	// QueueSize requests wait at most to be archived by Workers goroutines,
+	// 1 when 0.
+	QueueSize int
+	Workers   int
+	// Retention is how long archives are kept by stores that can prune them,
+	// forever when 0.
+	Retention time.Duration
+}
+
+// Archiver keeps the requests as they came, e.g. for audit or to replay them,
+// without their Datadog credentials. Each request is stored gzipped in the HTTP/1.1 wire format, which
+// http.ReadRequest reads back, under the key
+// <yyyy>/<mm>/<dd>/<hh>/<route>/<unix nanoseconds>-<sequence>.http.gz, the
+// route having its slashes replaced by _. Requests are archived in the
+// background, the ones that do not fit in the queue being lost rather than
+// slowing the proxy down.
+type Archiver struct {
+	store     ArchiveStore
+	retention time.Duration
+
+	queue chan archivedRequest
+	wg    sync.WaitGroup
+	stop  chan struct{}
+	seq   int64
+}
+
+type archivedRequest struct {
+	at     time.Time
+	method string
+	url    url.URL
+	header http.Header
+	body   []byte
+}
+
+// NewArchiver creates an archiver storing to store. It runs until closed.
+func NewArchiver(store ArchiveStore, cfg ArchiverConfig) *Archiver {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	a := &Archiver{store: store, retention: cfg.Retention, queue: make(chan archivedRequest, cfg.QueueSize), stop: make(chan struct{})}
+	for i := 0; i < workers; i++ {
+		a.wg.Add(1)
+		go a.work()
+	}
+	if _, ok := store.(ArchivePruner); ok && cfg.Retention > 0 {
+		a.wg.Add(1)
+		go a.prune()
+	}
+	return a
+}
+
+// Archive queues r, whose body is raw as it came, received at, reporting
+// false when the queue is full.
+func (a *Archiver) Archive(r *http.Request, raw []byte, at time.Time) bool {
+	req := archivedRequest{at: at, method: r.Method, url: *r.URL, header: r.Header.Clone(), body: raw}
+	stripCredentials(req.header, &req.url)
+	select {
+	case a.queue <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close archives the queued requests and stops the archiver. No request must
+// be passed to the archiver once it is closed.
+func (a *Archiver) Close() {
+	close(a.queue)
+	close(a.stop)
+	a.wg.Wait()
+}
+
+func (a *Archiver) work() {
+	defer a.wg.Done()
+	for req := range a.queue {
+		seq := atomic.AddInt64(&a.seq, 1)
+		key := fmt.Sprintf("%s/%s/%d-%06d.http.gz", req.at.UTC().Format("2006/01/02/15"), archiveRoute(req.url.Path), req.at.UnixNano(), seq)
+		body, err := encodeArchivedRequest(req)
+		if err == nil {
+			err = a.store.Put(key, body)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Could not archive request to %s as %s, %v", req.url.Path, key, err))
+		}
+	}
+}
+
+func (a *Archiver) prune() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(archivePruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := a.store.(ArchivePruner).Prune(time.Now().Add(-a.retention)); err != nil {
+			fmt.Println(fmt.Sprintf("Could not prune archives, %v", err))
+		}
+		select {
+		case <-ticker.C:
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func archiveRoute(path string) string {
+	route := strings.Trim(path, "/")
+	if route == "" {
+		return "_"
+	}
+	return strings.ReplaceAll(route, "/", "_")
+}
+
+// encodeArchivedRequest writes a request in the HTTP/1.1 wire format, its
+// headers sorted, and gzips it.
+func encodeArchivedRequest(req archivedRequest) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	bw := bufio.NewWriter(zw)
+	_, _ = fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", req.method, req.url.RequestURI())
+	keys := make([]string, 0, len(req.header))
+	for key := range req.header {
+		if key != "Content-Length" && key != "Transfer-Encoding" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, v := range req.header[key] {
+			_, _ = fmt.Fprintf(bw, "%s: %s\r\n", key, v)
+		}
+	}
+	_, _ = fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n", len(req.body))
+	_, _ = bw.Write(req.body)
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadArchivedRequest reads back a request stored by an Archiver.
+func ReadArchivedRequest(r io.Reader) (*http.Request, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.ReadRequest(bufio.NewReader(zr))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return req, nil
+}
+
+// archiveRequest queues a POST request to be archived as it came.
+func (h *Handler) archiveRequest(r *http.Request) {
+	if h.cfg.Archiver == nil || r.Method != http.MethodPost || isGRPC(r) {
+		return
+	}
+	raw, err := bufferBody(r)
+	if err != nil {
+		return
+	}
+	if !h.cfg.Archiver.Archive(r, raw, h.clock.Now()) {
+		_ = h.statsDClient.Count(archiveLostCountName, 1, h.tags("route:"+routePattern(r)), 1)
+	}
+}
+
+// DirStore stores archives as files under a directory, pruning them by
+// modification time. The files and directories it creates are only readable
+// by the user running the proxy.
+type DirStore struct {
+	Dir string
+}
+
+func (d DirStore) Put(key string, body []byte) error {
+	path := filepath.Join(d.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0600)
+}
+
+// Prune removes the files modified before before, and the directories left
+// empty.
+func (d DirStore) Prune(before time.Time) error {
+	var dirs []string
+	err := filepath.Walk(d.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != d.Dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if info.ModTime().Before(before) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	// Children come after their parents.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Archiver(t *testing.T) {
+	// Given server is running archiving to a directory
+	dir := t.TempDir()
+	archiver := server.NewArchiver(server.DirStore{Dir: dir}, server.ArchiverConfig{QueueSize: 1})
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		Archiver:            archiver,
+		Clock:               clock.NewFake(time.Date(2022, 4, 15, 5, 30, 0, 0, time.UTC)),
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a request is sent
+	body := mustMarshal(t, defaultMetricsPayload([]string{"system.load.1", "some.metric.disk"}))
+	req := httptest.NewRequest("POST", "/api/v1/series?api_key=abc&source=agent", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", "abc")
+	req.Header.Set("DD-APPLICATION-KEY", "def")
+	h.MetricsFilter(httptest.NewRecorder(), req)
+	<-resultChan
+	archiver.Close()
+
+	// Then it is archived as it came, without its credentials
+
+	files, err := filepath.Glob(filepath.Join(dir, "2022/04/15/05/api_v1_series/*.http.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "1650000600000000000-000001.http.gz", filepath.Base(files[0]))
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	archived, err := server.ReadArchivedRequest(f)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", archived.Method)
+	assert.Equal(t, "/api/v1/series?source=agent", archived.RequestURI)
+	assert.Equal(t, "application/json", archived.Header.Get("Content-Type"))
+	assert.Empty(t, archived.Header.Get("DD-API-KEY"))
+	assert.Empty(t, archived.Header.Get("DD-APPLICATION-KEY"))
+	archivedBody, err := io.ReadAll(archived.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, archivedBody)
+}
+
+func TestDirStore_Prune(t *testing.T) {
+	// Given a directory with an old and a new archive
+	dir := t.TempDir()
+	store := server.DirStore{Dir: dir}
+	require.NoError(t, store.Put("2022/04/15/05/api_v1_series/1.http.gz", []byte("old")))
+	require.NoError(t, store.Put("2022/04/16/05/api_v1_series/2.http.gz", []byte("new")))
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "2022/04/15/05/api_v1_series/1.http.gz"), old, old))
+
+	// When it is pruned
+	require.NoError(t, store.Prune(time.Now().Add(-24*time.Hour)))
+
+	// Then only the new archive is left
+	_, err := os.Stat(filepath.Join(dir, "2022/04/15"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "2022/04/16/05/api_v1_series/2.http.gz"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const decideMethod = "/proxyfilter.v1.FilterDecisionService/Decide"
+
+// BatchFilter decides which series of a payload to drop in a single call. The
+// returned slice holds one verdict per series, true meaning drop. Only the
+// series endpoint asks it, after the filters, as it reads the whole payload
+// first, see CheckBatchFilter.
+type BatchFilter interface {
+	DropBatch(ctx context.Context, series []datadog.Series) ([]bool, error)
+}
+
+// unbatchedEndpoints are the endpoints filtering series that do not ask a
+// BatchFilter.
+var unbatchedEndpoints = map[string]bool{"series-v2": true, "sketches": true, "prometheus": true, "intake": true}
+
+// CheckBatchFilter returns an error when cfg has a BatchFilter that series
+// would get past, when it streams them or routes one of the endpoints
+// series-v2, sketches, prometheus or intake, as DefaultRoutes does when
+// cfg.Routes is empty.
+func CheckBatchFilter(cfg Config) error {
+	if cfg.BatchFilter == nil {
+		return nil
+	}
+	if cfg.StreamSeries {
+		return newError(ErrRuleInvalid, fmt.Errorf("a batch filter cannot be used with streamed series"))
+	}
+	routes := cfg.Routes
+	if len(routes) == 0 {
+		routes = DefaultRoutes
+	}
+	for _, route := range routes {
+		if unbatchedEndpoints[route.Endpoint] {
+			return newError(ErrRuleInvalid, fmt.Errorf("route %s: endpoint %s does not ask the batch filter", route.Path, route.Endpoint))
+		}
+	}
+	return nil
+}
+
+// GRPCCallout is a BatchFilter that asks an external gRPC service for its
+// verdicts. The service implements:
+//
+//	service FilterDecisionService {
+//	  rpc Decide(DecideRequest) returns (DecideResponse);
+//	}
+//	message SeriesDescriptor {
+//	  string metric = 1;
+//	  repeated string tags = 2;
+//	  string host = 3;
+//	  string type = 4;
+//	}
+//	message DecideRequest { repeated SeriesDescriptor series = 1; }
+//	message DecideResponse { repeated bool drop = 1; }
+//
+// When the call fails or times out a fail open callout keeps every series,
+// otherwise the error is returned and the request is rejected.
+type GRPCCallout struct {
+	target     string
+	httpClient *http.Client
+	timeout    time.Duration
+	failOpen   bool
+}
+
+// NewGRPCCallout creates a callout to target, e.g. http://127.0.0.1:9000 for
+// plaintext HTTP/2 or https://decisions.internal for TLS.
+func NewGRPCCallout(target string, timeout time.Duration, failOpen bool) *GRPCCallout {
+	transport := &http2.Transport{}
+	if strings.HasPrefix(target, "http://") {
+		transport.AllowHTTP = true
+		transport.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}
+	}
+	return &GRPCCallout{
+		target:     strings.TrimSuffix(target, "/"),
+		httpClient: &http.Client{Transport: transport},
+		timeout:    timeout,
+		failOpen:   failOpen,
+	}
+}
+
+func (c *GRPCCallout) DropBatch(ctx context.Context, series []datadog.Series) ([]bool, error) {
+	drops, err := c.decide(ctx, series)
+	if err == nil && len(drops) != len(series) {
+		err = fmt.Errorf("got %d verdicts for %d series", len(drops), len(series))
+	}
+	if err != nil {
+		if c.failOpen {
+			fmt.Println(fmt.Sprintf("Filter callout failed, keeping all series, %v", err))
+			return make([]bool, len(series)), nil
+		}
+		return nil, newError(ErrCallout, err)
+	}
+	return drops, nil
+}
+
+func (c *GRPCCallout) decide(ctx context.Context, series []datadog.Series) ([]bool, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	msg := encodeDecideRequest(series)
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(msg)))
+	copy(frame[5:], msg)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.target+decideMethod, bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if c.timeout > 0 {
+		req.Header.Set("Grpc-Timeout", fmt.Sprintf("%dm", c.timeout.Milliseconds()))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		return nil, fmt.Errorf("got gRPC status %s, %s", status, resp.Trailer.Get("Grpc-Message"))
+	}
+	if len(body) < 5 || body[0] != 0 {
+		return nil, fmt.Errorf("got an unexpected gRPC frame of %d bytes", len(body))
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint32(len(body)-5) < n {
+		return nil, fmt.Errorf("got a truncated gRPC frame, want %d bytes but have %d", n, len(body)-5)
+	}
+	return decodeDecideResponse(body[5 : 5+n])
+}
+
+func encodeDecideRequest(series []datadog.Series) []byte {
+	var b []byte
+	for i := range series {
+		var d []byte
+		d = protowire.AppendTag(d, 1, protowire.BytesType)
+		d = protowire.AppendString(d, series[i].Metric)
+		for _, tag := range series[i].GetTags() {
+			d = protowire.AppendTag(d, 2, protowire.BytesType)
+			d = protowire.AppendString(d, tag)
+		}
+		if host := series[i].GetHost(); host != "" {
+			d = protowire.AppendTag(d, 3, protowire.BytesType)
+			d = protowire.AppendString(d, host)
+		}
+		if typ := series[i].GetType(); typ != "" {
+			d = protowire.AppendTag(d, 4, protowire.BytesType)
+			d = protowire.AppendString(d, typ)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	return b
+}
+
+func decodeDecideResponse(b []byte) ([]bool, error) {
+	var drops []bool
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var packed []byte
+			packed, n = protowire.ConsumeBytes(b)
+			for len(packed) > 0 && n >= 0 {
+				v, m := protowire.ConsumeVarint(packed)
+				if m < 0 {
+					return nil, protowire.ParseError(m)
+				}
+				drops = append(drops, v != 0)
+				packed = packed[m:]
+			}
+		case num == 1 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			drops = append(drops, v != 0)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return drops, nil
+}
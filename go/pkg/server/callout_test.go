@@ -0,0 +1,209 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestGRPCCallout_DropBatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		grpcStatus    string
+		delay         time.Duration
+		failOpen      bool
+		expectedDrops []bool
+		expectedErr   bool
+	}{
+		{
+			name:          "Verdicts",
+			grpcStatus:    "0",
+			expectedDrops: []bool{false, true, false},
+		},
+		{
+			name:          "Fail open on error",
+			grpcStatus:    "14",
+			failOpen:      true,
+			expectedDrops: []bool{false, false, false},
+		},
+		{
+			name:        "Fail closed on error",
+			grpcStatus:  "14",
+			expectedErr: true,
+		},
+		{
+			name:          "Fail open on timeout",
+			grpcStatus:    "0",
+			delay:         200 * time.Millisecond,
+			failOpen:      true,
+			expectedDrops: []bool{false, false, false},
+		},
+		{
+			name:        "Fail closed on timeout",
+			grpcStatus:  "0",
+			delay:       200 * time.Millisecond,
+			expectedErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a decision service that drops metrics starting with drop.
+			ds := setupDecisionServer(t, tc.grpcStatus, tc.delay)
+			defer ds.Close()
+			c := server.NewGRPCCallout(ds.URL, 50*time.Millisecond, tc.failOpen)
+
+			// When we ask for verdicts
+			payload := defaultMetricsPayload([]string{"metric.one", "drop.metric", "metric.two"})
+			drops, err := c.DropBatch(context.Background(), payload.Series)
+
+			// Then we get them or the policy applies
+			if tc.expectedErr {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, server.ErrCallout)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedDrops, drops)
+		})
+	}
+}
+
+func TestHandler_MetricsFilter_Callout(t *testing.T) {
+	tests := []struct {
+		name            string
+		grpcStatus      string
+		failOpen        bool
+		expectedStatus  int
+		expectedPayload datadog.MetricsPayload
+	}{
+		{
+			name:            "Verdicts",
+			grpcStatus:      "0",
+			expectedStatus:  418,
+			expectedPayload: defaultMetricsPayload([]string{"metric.one", "metric.two"}),
+		},
+		{
+			name:            "Fail open",
+			grpcStatus:      "14",
+			failOpen:        true,
+			expectedStatus:  418,
+			expectedPayload: defaultMetricsPayload([]string{"metric.one", "drop.metric", "metric.two"}),
+		},
+		{
+			name:           "Fail closed",
+			grpcStatus:     "14",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with a filter callout
+			ds := setupDecisionServer(t, tc.grpcStatus, 0)
+			cfg := server.Config{BatchFilter: server.NewGRPCCallout(ds.URL, time.Second, tc.failOpen)}
+			resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+			ps := httptest.NewServer(http.HandlerFunc(h.MetricsFilter))
+
+			defer func() {
+				ds.Close()
+				ts.Close()
+				ps.Close()
+			}()
+
+			// And we create a request
+			b := new(bytes.Buffer)
+			err := json.NewEncoder(b).Encode(defaultMetricsPayload([]string{"metric.one", "drop.metric", "metric.two"}))
+			require.NoError(t, err)
+
+			// When we make the request
+			resp, err := http.Post(ps.URL+"/api/v1/series", "application/json", b)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			// Then the verdicts are honored
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus != 418 {
+				return
+			}
+			actual := <-resultChan
+			var actualPayload datadog.MetricsPayload
+			require.NoError(t, json.Unmarshal([]byte(actual.body), &actualPayload))
+			assert.Equal(t, tc.expectedPayload, actualPayload)
+		})
+	}
+}
+
+func setupDecisionServer(t *testing.T, grpcStatus string, delay time.Duration) *httptest.Server {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/proxyfilter.v1.FilterDecisionService/Decide", r.URL.Path)
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, len(body), 5)
+
+		var verdicts []byte
+		msg := body[5:]
+		for len(msg) > 0 {
+			_, _, n := protowire.ConsumeTag(msg)
+			msg = msg[n:]
+			descriptor, n := protowire.ConsumeBytes(msg)
+			msg = msg[n:]
+			_, _, m := protowire.ConsumeTag(descriptor)
+			metric, _ := protowire.ConsumeString(descriptor[m:])
+			verdicts = protowire.AppendVarint(verdicts, protowire.EncodeBool(strings.HasPrefix(metric, "drop.")))
+		}
+		resp := protowire.AppendTag(nil, 1, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, verdicts)
+
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+		_, _ = w.Write(append(frame, resp...))
+		w.Header().Set("Grpc-Status", grpcStatus)
+	})
+	return httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+}
+
+func TestCheckBatchFilter(t *testing.T) {
+	callout := server.NewGRPCCallout("http://127.0.0.1:9000", time.Second, true)
+	seriesOnly := []server.Route{{Path: "/api/v1/series", Endpoint: "series"}, {Path: "/", Endpoint: "proxy"}}
+	tests := []struct {
+		name    string
+		cfg     server.Config
+		wantErr bool
+	}{
+		{name: "No batch filter", cfg: server.Config{}},
+		{name: "Series routes", cfg: server.Config{BatchFilter: callout, Routes: seriesOnly}},
+		{name: "Default routes", cfg: server.Config{BatchFilter: callout}, wantErr: true},
+		{name: "V2 series route", cfg: server.Config{BatchFilter: callout, Routes: append(seriesOnly, server.Route{Path: "/api/v2/series", Endpoint: "series-v2"})}, wantErr: true},
+		{name: "Streamed series", cfg: server.Config{BatchFilter: callout, Routes: seriesOnly, StreamSeries: true}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := server.CheckBatchFilter(tc.cfg)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
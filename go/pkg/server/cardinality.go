@@ -0,0 +1,97 @@
+package server
+
+import (
+	"strings"
+	"sync"
+)
+
+// OtherTagValue replaces the tag values of the tag sets past the limit of a
+// CardinalityGuard.
+const OtherTagValue = "other"
+
+// CardinalityGuard caps the distinct tag sets the proxy sends for each of its
+// own metrics, so that tagging by rule, route or prefix cannot make the proxy
+// a cardinality problem of its own. Once a metric has been sent with Limit tag
+// sets, new ones are sent with the value of each of their tags, other than
+// the fixed tags, replaced with OtherTagValue. It also sends gauges and
+// distributions when the client it wraps can.
+type CardinalityGuard struct {
+	client StatsdClient
+	limit  int
+	tags   []string
+	fixed  map[string]bool
+
+	mu   sync.Mutex
+	seen map[string]map[string]bool
+}
+
+// NewCardinalityGuard wraps client, allowing limit tag sets per metric, fixed
+// being the tags every metric is sent with, such as Config.Tags.
+func NewCardinalityGuard(client StatsdClient, limit int, fixed []string) *CardinalityGuard {
+	g := &CardinalityGuard{client: client, limit: limit, tags: fixed, fixed: make(map[string]bool), seen: make(map[string]map[string]bool)}
+	for _, tag := range fixed {
+		g.fixed[tag] = true
+	}
+	return g
+}
+
+func (g *CardinalityGuard) Count(name string, value int64, tags []string, rate float64) error {
+	return g.client.Count(name, value, g.guard(name, tags), rate)
+}
+
+func (g *CardinalityGuard) Gauge(name string, value float64, tags []string, rate float64) error {
+	gc, ok := g.client.(gauger)
+	if !ok {
+		return nil
+	}
+	return gc.Gauge(name, value, g.guard(name, tags), rate)
+}
+
+func (g *CardinalityGuard) Distribution(name string, value float64, tags []string, rate float64) error {
+	d, ok := g.client.(distributer)
+	if !ok {
+		return nil
+	}
+	return d.Distribution(name, value, g.guard(name, tags), rate)
+}
+
+// guard returns tags, or their other bucket when name has too many tag sets.
+func (g *CardinalityGuard) guard(name string, tags []string) []string {
+	if g.limit <= 0 {
+		return tags
+	}
+	key := strings.Join(tags, ",")
+	g.mu.Lock()
+	sets, ok := g.seen[name]
+	if !ok {
+		sets = make(map[string]bool)
+		g.seen[name] = sets
+	}
+	if sets[key] || len(sets) < g.limit {
+		sets[key] = true
+		g.mu.Unlock()
+		return tags
+	}
+	g.mu.Unlock()
+
+	// The proxy sends a known set of metrics, so tagging by name is bounded.
+	capped := append(append(make([]string, 0, len(g.tags)+1), g.tags...), "metric:"+name)
+	_ = g.client.Count(cappedTagSetsCountName, 1, capped, 1)
+	return g.other(tags)
+}
+
+func (g *CardinalityGuard) other(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if g.fixed[tag] {
+			out = append(out, tag)
+			continue
+		}
+		key := tag
+		if i := strings.Index(tag, ":"); i >= 0 {
+			key = tag[:i]
+		}
+		out = append(out, key+":"+OtherTagValue)
+	}
+	return out
+}
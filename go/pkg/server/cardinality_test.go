@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type recordingStatsdClient struct {
+	stubGaugeClient
+	sent [][]string
+}
+
+func (s *recordingStatsdClient) Count(name string, value int64, tags []string, rate float64) error {
+	if name == "metric.one" {
+		s.sent = append(s.sent, tags)
+	}
+	return s.stubGaugeClient.Count(name, value, tags, rate)
+}
+
+func TestCardinalityGuard(t *testing.T) {
+	// Given a guard allowing two tag sets per metric
+	sc := &recordingStatsdClient{}
+	g := server.NewCardinalityGuard(sc, 2, []string{"env:prod"})
+
+	// When a metric is sent with more tag sets than allowed
+	for _, rule := range []string{"a", "b", "a", "c", "d"} {
+		_ = g.Count("metric.one", 1, []string{"env:prod", "rule:" + rule, "bare"}, 1)
+	}
+
+	// Then the tag sets past the limit go to the other bucket, fixed tags kept
+	assert.Equal(t, [][]string{
+		{"env:prod", "rule:a", "bare"},
+		{"env:prod", "rule:b", "bare"},
+		{"env:prod", "rule:a", "bare"},
+		{"env:prod", "rule:other", "bare:other"},
+		{"env:prod", "rule:other", "bare:other"},
+	}, sc.sent)
+
+	// And the capping is counted by metric
+	sc.assertCount(t, "proxy_filter.statsd.capped_tag_sets.count", 1, []string{"env:prod", "metric:metric.one"}, 1, true)
+
+	// And other metrics have their own limit, gauges included
+	_ = g.Gauge("metric.two", 1, []string{"env:prod", "rule:c"}, 1)
+	assert.Contains(t, sc.gauges, "metric.two env:prod")
+}
+
+func TestCardinalityGuard_NoLimit(t *testing.T) {
+	sc := &recordingStatsdClient{}
+	g := server.NewCardinalityGuard(sc, 0, nil)
+	for _, rule := range []string{"a", "b", "c"} {
+		_ = g.Count("metric.one", 1, []string{"rule:" + rule}, 1)
+	}
+	assert.Equal(t, [][]string{{"rule:a"}, {"rule:b"}, {"rule:c"}}, sc.sent)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// CIRule drops the CI Visibility events, such as tests and sessions, run on a
+// git branch matching Branch by an author whose email matches Author, both
+// using path.Match syntax, and carrying every one of Tags among their meta,
+// written as key:value. The fields left empty match any event.
+type CIRule struct {
+	Branch string
+	Author string
+	Tags   []string
+}
+
+// ParseCIRule parses a rule written as comma separated key=value pairs with
+// the keys branch, author and tag, tag being repeatable, e.g.
+// branch=dependabot/*,author=*@users.noreply.github.com.
+func ParseCIRule(s string) (CIRule, error) {
+	var rule CIRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return CIRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "branch", "author":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return CIRule{}, newError(ErrRuleInvalid, err)
+			}
+			if kv[0] == "branch" {
+				rule.Branch = kv[1]
+			} else {
+				rule.Author = kv[1]
+			}
+		case "tag":
+			if !strings.Contains(kv[1], ":") {
+				return CIRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected tag=key:value in %q", field))
+			}
+			rule.Tags = append(rule.Tags, kv[1])
+		default:
+			return CIRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+func (cr CIRule) match(meta map[string]string) bool {
+	if cr.Branch == "" && cr.Author == "" && len(cr.Tags) == 0 {
+		return false
+	}
+	if cr.Branch != "" {
+		if ok, _ := path.Match(cr.Branch, meta["git.branch"]); !ok {
+			return false
+		}
+	}
+	if cr.Author != "" {
+		if ok, _ := path.Match(cr.Author, meta["git.commit.author.email"]); !ok {
+			return false
+		}
+	}
+	for _, tag := range cr.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if v, ok := meta[kv[0]]; !ok || v != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// CIFilter filters the msgpack test cycle payloads sent to /api/v2/citestcycle
+// with Config.CIRules, looking at the meta of each event. Requests left
+// without any event are answered without being forwarded, with 202 unless
+// Config.DropResponses says otherwise.
+func (h *Handler) CIFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).ciFilter)
+}
+
+func (h *Handler) ciFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.CIRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterCIEvents(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if buf == nil {
+		h.writeDropped(w, r, DropResponse{Status: http.StatusAccepted})
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// filterCIEvents returns the request body without the dropped events, or nil
+// when every event was dropped. Every other field is copied as it is.
+func (h *Handler) filterCIEvents(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	n, b, err := msgp.ReadMapHeaderBytes(body)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	out := msgp.AppendMapHeader(nil, n)
+	var total, dropped int64
+	for i := uint32(0); i < n; i++ {
+		var key string
+		rest, err := msgp.Skip(b)
+		if err == nil {
+			key, _, err = msgp.ReadStringBytes(b)
+		}
+		if err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		out = append(out, b[:len(b)-len(rest)]...)
+		b = rest
+		if rest, err = msgp.Skip(b); err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		value := b[:len(b)-len(rest)]
+		b = rest
+		if key != "events" || msgp.IsNil(value) {
+			out = append(out, value...)
+			continue
+		}
+		events, err := h.filterCIEventList(value)
+		if err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		total, dropped = int64(len(events.all)), int64(len(events.all)-len(events.kept))
+		for j := 0; j < len(events.all)-len(events.kept); j++ {
+			meta.RecordDrop("ci_event")
+		}
+		out = msgp.AppendArrayHeader(out, uint32(len(events.kept)))
+		for _, e := range events.kept {
+			out = append(out, e...)
+		}
+	}
+	_ = h.statsDClient.Count(filteredCIEventsCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if total > 0 && total == dropped {
+		return nil, nil
+	}
+	if dropped == 0 {
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(out)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, nil
+}
+
+type ciEvents struct {
+	all, kept [][]byte
+}
+
+// filterCIEventList splits a msgpack array of events, keeping the ones whose
+// content no rule drops.
+func (h *Handler) filterCIEventList(b []byte) (ciEvents, error) {
+	var events ciEvents
+	n, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return events, err
+	}
+	for i := uint32(0); i < n; i++ {
+		rest, err := msgp.Skip(b)
+		if err != nil {
+			return events, err
+		}
+		event := b[:len(b)-len(rest)]
+		b = rest
+		content, err := ciEventContent(event)
+		if err != nil {
+			return events, err
+		}
+		events.all = append(events.all, event)
+		if !h.dropCIEvent(content.Meta) {
+			events.kept = append(events.kept, event)
+		}
+	}
+	return events, nil
+}
+
+// ciEventContent decodes the content of an event, which is laid out as a span.
+func ciEventContent(event []byte) (span, error) {
+	n, b, err := msgp.ReadMapHeaderBytes(event)
+	if err != nil {
+		return span{}, err
+	}
+	for i := uint32(0); i < n; i++ {
+		var key string
+		if key, b, err = msgp.ReadStringBytes(b); err != nil {
+			return span{}, err
+		}
+		if key == "content" && !msgp.IsNil(b) {
+			s, _, err := decodeSpan(b)
+			return s, err
+		}
+		if b, err = msgp.Skip(b); err != nil {
+			return span{}, err
+		}
+	}
+	return span{}, nil
+}
+
+func (h *Handler) dropCIEvent(meta map[string]string) bool {
+	for _, rule := range h.cfg.CIRules {
+		if rule.match(meta) {
+			return true
+		}
+	}
+	return false
+}
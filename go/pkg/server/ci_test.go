@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func encodeCIEvent(b []byte, name string, meta map[string]string) []byte {
+	b = msgp.AppendMapHeader(b, 3)
+	b = msgp.AppendString(b, "type")
+	b = msgp.AppendString(b, "test")
+	b = msgp.AppendString(b, "version")
+	b = msgp.AppendInt(b, 2)
+	b = msgp.AppendString(b, "content")
+	b = msgp.AppendMapHeader(b, 3)
+	b = msgp.AppendString(b, "service")
+	b = msgp.AppendString(b, "api")
+	b = msgp.AppendString(b, "resource")
+	b = msgp.AppendString(b, name)
+	b = msgp.AppendString(b, "meta")
+	return msgp.AppendMapStrStr(b, meta)
+}
+
+func encodeCIPayload(events ...[]byte) []byte {
+	b := msgp.AppendMapHeader(nil, 3)
+	b = msgp.AppendString(b, "version")
+	b = msgp.AppendInt(b, 1)
+	b = msgp.AppendString(b, "metadata")
+	b = msgp.AppendMapStrStr(b, map[string]string{"language": "go"})
+	b = msgp.AppendString(b, "events")
+	b = msgp.AppendArrayHeader(b, uint32(len(events)))
+	for _, e := range events {
+		b = append(b, e...)
+	}
+	return b
+}
+
+func TestParseCIRule(t *testing.T) {
+	rule, err := server.ParseCIRule("branch=dependabot/*,author=*@users.noreply.github.com,tag=ci.provider.name:github")
+	require.NoError(t, err)
+	assert.Equal(t, server.CIRule{
+		Branch: "dependabot/*",
+		Author: "*@users.noreply.github.com",
+		Tags:   []string{"ci.provider.name:github"},
+	}, rule)
+
+	for _, s := range []string{"", "branch=", "branch=[", "tag=fork", "workflow=ci"} {
+		_, err = server.ParseCIRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_CIFilter(t *testing.T) {
+	rules := []server.CIRule{{Branch: "dependabot/*"}, {Author: "*-bot@users.noreply.github.com"}}
+	main := encodeCIEvent(nil, "TestMain", map[string]string{"git.branch": "main", "git.commit.author.email": "dev@example.com"})
+	bump := encodeCIEvent(nil, "TestBump", map[string]string{"git.branch": "dependabot/go_modules", "git.commit.author.email": "dev@example.com"})
+	bot := encodeCIEvent(nil, "TestBot", map[string]string{"git.branch": "renovate", "git.commit.author.email": "renovate-bot@users.noreply.github.com"})
+	tests := []struct {
+		name            string
+		body            []byte
+		expected        []byte
+		expectedDropped int64
+	}{
+		{
+			name:            "Drop by branch and author",
+			body:            encodeCIPayload(main, bump, bot),
+			expected:        encodeCIPayload(main),
+			expectedDropped: 2,
+		},
+		{
+			name:     "Nothing dropped",
+			body:     encodeCIPayload(main),
+			expected: encodeCIPayload(main),
+		},
+		{
+			name:            "Every event dropped",
+			body:            encodeCIPayload(bump, bot),
+			expectedDropped: 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with CI rules
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", server.Config{CIRules: rules, Tags: []string{"one"}})
+			defer ts.Close()
+
+			// When the agent sends a test cycle payload
+			req := httptest.NewRequest("POST", "/api/v2/citestcycle", bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/msgpack")
+			rec := httptest.NewRecorder()
+			h.CIFilter(rec, req)
+
+			// Then only the kept events are forwarded
+			sc.assertCount(t, "proxy_filter.filtered_ci_events.count", tc.expectedDropped, []string{"one"}, 1, true)
+			if tc.expected == nil {
+				assert.Equal(t, http.StatusAccepted, rec.Code)
+				return
+			}
+			assert.Equal(t, http.StatusTeapot, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, tc.expected, []byte(actual.body))
+		})
+	}
+}
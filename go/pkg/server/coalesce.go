@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// coalescedResponse is an upstream response read in full so it can be written
+// to every request waiting on it.
+type coalescedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+type coalescedCall struct {
+	done   chan struct{}
+	resp   *coalescedResponse
+	err    error
+	shared int64
+}
+
+// coalescer makes sure only one call for a key is in flight at a time, the
+// callers arriving while it runs wait for its result instead.
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// do runs fn for key unless a call for it is already in flight, in which case
+// it waits for that call. It returns how many callers shared the result when
+// it ran fn, and -1 when it waited.
+func (c *coalescer) do(key string, fn func() (*coalescedResponse, error)) (*coalescedResponse, int64, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		call.shared++
+		c.mu.Unlock()
+		<-call.done
+		return call.resp, -1, call.err
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.resp, call.err = fn()
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	shared := call.shared
+	c.mu.Unlock()
+	close(call.done)
+	return call.resp, shared, call.err
+}
+
+func (h *Handler) coalesces(r *http.Request) bool {
+	if r.Method != http.MethodGet || h.coalesce == nil {
+		return false
+	}
+	for i := range h.cfg.CoalesceRoutes {
+		if h.cfg.CoalesceRoutes[i] == r.URL.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// coalesceRequest proxies a GET request sharing the upstream response with the
+// identical requests in flight. Requests are identical when they have the same
+// URL and credentials, so responses are never shared across API keys.
+func (h *Handler) coalesceRequest(w http.ResponseWriter, r *http.Request, url string) {
+	callKey := url + "?" + r.URL.RawQuery + "\n" +
+		r.Header.Get("DD-API-KEY") + "\n" +
+		r.Header.Get("DD-APPLICATION-KEY") + "\n" +
+		r.Header.Get("Accept-Encoding")
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	resp, shared, err := h.coalesce.do(callKey, func() (*coalescedResponse, error) {
+		// The request must outlive the client that happened to make it, the
+		// others waiting on it may still be connected.
+		req, err := newUpstreamRequest(context.Background(), r, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		h.countUpstreamResponse(r, resp.StatusCode)
+		body, err := io.ReadAll(h.validateResponse(r, resp))
+		if err != nil {
+			return nil, err
+		}
+		return &coalescedResponse{status: resp.StatusCode, header: resp.Header, body: body}, nil
+	})
+	meta.RecordTiming("upstream", clock.Since(h.clock, start))
+	if shared > 0 {
+		_ = h.statsDClient.Count(coalescedRequestsCountName, shared, h.tags("route:"+routePattern(r)), 1)
+	}
+	if err != nil {
+		h.writeError(w, r, http.StatusBadGateway, newError(ErrUpstream, err))
+		return
+	}
+
+	for key := range resp.header {
+		w.Header().Add(key, resp.header.Get(key))
+	}
+	status, body := h.rewriteResponse(w, r, resp.status, bytes.NewReader(resp.body))
+	w.WriteHeader(status)
+	_, _ = io.Copy(w, body)
+	fmt.Println(fmt.Sprintf("Sent request to %s, got %d, coalesced %t, timings %v", url, resp.status, shared < 0, meta.Timings()))
+}
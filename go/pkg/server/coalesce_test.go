@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_CoalesceRoutes(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		apiKeys       []string
+		expectedCalls int64
+	}{
+		{
+			name:          "Identical GETs coalesced",
+			method:        "GET",
+			path:          "/api/v1/validate",
+			apiKeys:       []string{"key", "key", "key", "key", "key"},
+			expectedCalls: 1,
+		},
+		{
+			name:          "Different API keys not coalesced",
+			method:        "GET",
+			path:          "/api/v1/validate",
+			apiKeys:       []string{"key-one", "key-two"},
+			expectedCalls: 2,
+		},
+		{
+			name:          "POST not coalesced",
+			method:        "POST",
+			path:          "/api/v1/validate",
+			apiKeys:       []string{"key", "key", "key"},
+			expectedCalls: 3,
+		},
+		{
+			name:          "Route not coalesced",
+			method:        "GET",
+			path:          "/api/v1/check_run",
+			apiKeys:       []string{"key", "key", "key"},
+			expectedCalls: 3,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an upstream holding its responses until released
+			var calls int64
+			release := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&calls, 1)
+				<-release
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, `{"valid":true}`)
+			}))
+			defer ts.Close()
+
+			// And the proxy coalesces the validate route
+			sc := &stubStatsdClient{}
+			cfg := server.Config{
+				BaseEndpoint:   ts.URL,
+				CoalesceRoutes: []string{"/api/v1/validate"},
+				Tags:           []string{"one"},
+			}
+			h := server.NewHandler(cfg, ts.Client(), sc)
+
+			// When the requests are in flight at the same time
+			recs := make([]*httptest.ResponseRecorder, len(tc.apiKeys))
+			var wg sync.WaitGroup
+			for i := range tc.apiKeys {
+				recs[i] = httptest.NewRecorder()
+				req := httptest.NewRequest(tc.method, tc.path, nil)
+				req.Header.Set("DD-API-KEY", tc.apiKeys[i])
+				wg.Add(1)
+				go func(rec *httptest.ResponseRecorder) {
+					defer wg.Done()
+					h.ProxyHandle(rec, req)
+				}(recs[i])
+			}
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			// Then upstream is only called once per distinct request
+			assert.Equal(t, tc.expectedCalls, atomic.LoadInt64(&calls))
+
+			// And every client gets the response
+			for _, rec := range recs {
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				assert.Equal(t, `{"valid":true}`, rec.Body.String())
+			}
+
+			// And the saved requests are counted
+			saved := int64(len(tc.apiKeys)) - tc.expectedCalls
+			sc.assertCount(t, "proxy_filter.coalesced_requests.count", saved, []string{"one", "route:/api/v1/validate"}, 1, saved > 0)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The network performance monitoring payloads are process agent payloads
+// holding a CollectorConnections.
+const (
+	collectorTypeConnections = 22
+
+	collectorConnectionsConnections = 3
+
+	connectionRaddr = 6
+
+	addrIP   = 2
+	addrPort = 3
+)
+
+// ConnectionRule drops the network connections whose destination address is
+// in Network and whose destination port is Port. The fields left empty match
+// any connection.
+type ConnectionRule struct {
+	Network *net.IPNet
+	Port    int
+}
+
+// ParseConnectionRule parses a rule written as comma separated key=value
+// pairs with the keys cidr and port, e.g. cidr=10.20.0.0/16,port=5432.
+func ParseConnectionRule(s string) (ConnectionRule, error) {
+	var rule ConnectionRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return ConnectionRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "cidr":
+			_, network, err := net.ParseCIDR(kv[1])
+			if err != nil {
+				return ConnectionRule{}, newError(ErrRuleInvalid, err)
+			}
+			rule.Network = network
+		case "port":
+			port, err := strconv.Atoi(kv[1])
+			if err != nil || port <= 0 || port > 65535 {
+				return ConnectionRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected a port between 1 and 65535, got %q", kv[1]))
+			}
+			rule.Port = port
+		default:
+			return ConnectionRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the connection to ip and port is dropped by the rule.
+func (cr ConnectionRule) Match(ip net.IP, port int) bool {
+	if cr.Network == nil && cr.Port == 0 {
+		return false
+	}
+	if cr.Network != nil && (ip == nil || !cr.Network.Contains(ip)) {
+		return false
+	}
+	return cr.Port == 0 || port == cr.Port
+}
+
+// ConnectionsFilter filters the connections payloads sent to
+// /api/v1/connections with Config.ConnectionRules, looking at the remote
+// address of each connection. Only protobuf payloads are filtered, compressed
+// ones are forwarded as they came.
+func (h *Handler) ConnectionsFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).connectionsFilter)
+}
+
+func (h *Handler) connectionsFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.ConnectionRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterConnections(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+func (h *Handler) filterConnections(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	return h.filterCollector(r, func(typ byte, msg []byte) ([]byte, error) {
+		if typ != collectorTypeConnections {
+			return msg, nil
+		}
+		var dropped int64
+		out, err := filterMessages(msg, func(num protowire.Number, v []byte) ([]byte, bool, error) {
+			if num != collectorConnectionsConnections {
+				return v, true, nil
+			}
+			ip, port, err := decodeRemoteAddr(v)
+			if err != nil {
+				return nil, false, err
+			}
+			if h.dropConnection(ip, port) {
+				meta.RecordDrop("connection")
+				dropped++
+				return nil, false, nil
+			}
+			return v, true, nil
+		})
+		_ = h.statsDClient.Count(filteredConnectionsCountName, dropped, h.cfg.Tags, 1)
+		return out, err
+	})
+}
+
+// decodeRemoteAddr decodes the remote address of a connection, its IP being
+// nil when missing or not parsable.
+func decodeRemoteAddr(b []byte) (ip net.IP, port int, err error) {
+	err = walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != connectionRaddr || typ != protowire.BytesType {
+			return nil
+		}
+		return walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			switch {
+			case num == addrIP && typ == protowire.BytesType:
+				ip = net.ParseIP(string(v))
+			case num == addrPort && typ == protowire.VarintType:
+				p, n := protowire.ConsumeVarint(v)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				port = int(int32(p))
+			}
+			return nil
+		})
+	})
+	return ip, port, err
+}
+
+func (h *Handler) dropConnection(ip net.IP, port int) bool {
+	for _, rule := range h.cfg.ConnectionRules {
+		if rule.Match(ip, port) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"bytes"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type connection struct {
+	raddr string
+	port  int32
+}
+
+// encodeCollectorConnections encodes a process agent payload holding a
+// CollectorConnections with the connections.
+func encodeCollectorConnections(connections ...connection) []byte {
+	b := []byte{3, 0, 22, 0, 0, 0, 0, 1, 0, 0, 0, 0, 98, 90, 0, 0}
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, "web-1")
+	for _, c := range connections {
+		var laddr, raddr, d []byte
+		laddr = protowire.AppendTag(laddr, 2, protowire.BytesType)
+		laddr = protowire.AppendString(laddr, "10.0.0.5")
+		laddr = protowire.AppendTag(laddr, 3, protowire.VarintType)
+		laddr = protowire.AppendVarint(laddr, 40312)
+		raddr = protowire.AppendTag(raddr, 2, protowire.BytesType)
+		raddr = protowire.AppendString(raddr, c.raddr)
+		raddr = protowire.AppendTag(raddr, 3, protowire.VarintType)
+		raddr = protowire.AppendVarint(raddr, uint64(c.port))
+		d = protowire.AppendTag(d, 1, protowire.VarintType)
+		d = protowire.AppendVarint(d, 42)
+		d = protowire.AppendTag(d, 5, protowire.BytesType)
+		d = protowire.AppendBytes(d, laddr)
+		d = protowire.AppendTag(d, 6, protowire.BytesType)
+		d = protowire.AppendBytes(d, raddr)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func TestParseConnectionRule(t *testing.T) {
+	rule, err := server.ParseConnectionRule("cidr=10.20.0.0/16,port=5432")
+	require.NoError(t, err)
+	_, network, _ := net.ParseCIDR("10.20.0.0/16")
+	assert.Equal(t, server.ConnectionRule{Network: network, Port: 5432}, rule)
+
+	for _, s := range []string{"", "cidr=", "cidr=10.20.0.0", "port=0", "port=http", "host=db"} {
+		_, err = server.ParseConnectionRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_ConnectionsFilter(t *testing.T) {
+	_, internal, _ := net.ParseCIDR("10.20.0.0/16")
+	_, v6, _ := net.ParseCIDR("fd00::/8")
+	rules := []server.ConnectionRule{{Network: internal}, {Port: 6379}, {Network: v6, Port: 443}}
+	tests := []struct {
+		name     string
+		sent     []connection
+		expected []connection
+		dropped  int64
+	}{
+		{
+			name: "Drop by CIDR and port",
+			sent: []connection{
+				{raddr: "10.20.3.4", port: 5432},
+				{raddr: "10.30.3.4", port: 5432},
+				{raddr: "10.30.3.5", port: 6379},
+				{raddr: "fd12::1", port: 443},
+				{raddr: "fd12::1", port: 80},
+			},
+			expected: []connection{
+				{raddr: "10.30.3.4", port: 5432},
+				{raddr: "fd12::1", port: 80},
+			},
+			dropped: 3,
+		},
+		{
+			name:     "Nothing dropped",
+			sent:     []connection{{raddr: "8.8.8.8", port: 53}},
+			expected: []connection{{raddr: "8.8.8.8", port: 53}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with connection rules
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", server.Config{ConnectionRules: rules, Tags: []string{"one"}})
+			defer ts.Close()
+
+			// When the process agent sends connections
+			req := httptest.NewRequest("POST", "/api/v1/connections", bytes.NewReader(encodeCollectorConnections(tc.sent...)))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			rec := httptest.NewRecorder()
+			h.ConnectionsFilter(rec, req)
+
+			// Then only the kept connections are forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, encodeCollectorConnections(tc.expected...), []byte(actual.body))
+			sc.assertCount(t, "proxy_filter.filtered_connections.count", tc.dropped, []string{"one"}, 1, true)
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/zstd"
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+)
+
+// DefaultMaxSnappyDecodedSize bounds the size snappy bodies decode to when
+// Config.MaxSnappyDecodedSize is not set, well above what the intakes accept.
+const DefaultMaxSnappyDecodedSize = 64 << 20
+
+// Compression is the algorithm, one of gzip, deflate, br, zstd, snappy or
+// identity, and the level bodies are re-compressed with. A zero Level is the
+// default level of the algorithm, snappy and identity having none.
+type Compression struct {
+	Algorithm string
+	Level     int
+}
+
+// compressionLevels is the range of levels of each algorithm.
+var compressionLevels = map[string][2]int{
+	"gzip":     {gzip.HuffmanOnly, gzip.BestCompression},
+	"deflate":  {zlib.HuffmanOnly, zlib.BestCompression},
+	"br":       {brotli.BestSpeed, brotli.BestCompression},
+	"zstd":     {zstd.BestSpeed, zstd.BestCompression},
+	"snappy":   {0, 0},
+	"identity": {0, 0},
+}
+
+// ParseCompression parses a compression written as algorithm[:level], e.g.
+// zstd:3.
+func ParseCompression(s string) (Compression, error) {
+	c := Compression{Algorithm: strings.ToLower(s)}
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		level, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return Compression{}, newError(ErrRuleInvalid, fmt.Errorf("expected algorithm[:level], got %q", s))
+		}
+		c.Algorithm, c.Level = strings.ToLower(s[:i]), level
+	}
+	levels, ok := compressionLevels[c.Algorithm]
+	if !ok {
+		return Compression{}, newError(ErrRuleInvalid, fmt.Errorf("unknown compression algorithm %q", c.Algorithm))
+	}
+	if c.Level != 0 && (c.Level < levels[0] || c.Level > levels[1]) {
+		return Compression{}, newError(ErrRuleInvalid, fmt.Errorf("expected a %s level between %d and %d, got %d", c.Algorithm, levels[0], levels[1], c.Level))
+	}
+	return c, nil
+}
+
+func (c *Compression) newWriter(w io.Writer) io.WriteCloser {
+	if c.Level == 0 {
+		return newEncoder(c.Algorithm, w)
+	}
+	switch c.Algorithm {
+	case "gzip":
+		zw, _ := gzip.NewWriterLevel(w, c.Level)
+		return zw
+	case "deflate":
+		zw, _ := zlib.NewWriterLevel(w, c.Level)
+		return zw
+	case "br":
+		return brotli.NewWriterLevel(w, c.Level)
+	case "zstd":
+		return zstd.NewWriterLevel(w, c.Level)
+	default:
+		return newEncoder(c.Algorithm, w)
+	}
+}
+
+// contentEncodings returns the encodings of the Content-Encoding of r in the
+// order they were applied, lowercased and without identity.
+func contentEncodings(r *http.Request) []string {
+	var encodings []string
+	for _, header := range r.Header.Values("Content-Encoding") {
+		for _, e := range strings.Split(header, ",") {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e == "" || e == "identity" {
+				continue
+			}
+			encodings = append(encodings, e)
+		}
+	}
+	return encodings
+}
+
+// unsupportedEncoding returns the first encoding of the Content-Encoding of r
+// the handlers cannot decode, or an empty string when they can decode them
+// all.
+func unsupportedEncoding(r *http.Request) string {
+	for _, e := range contentEncodings(r) {
+		switch e {
+		case "gzip", "x-gzip", "deflate", "snappy", "br", "zstd":
+		default:
+			return e
+		}
+	}
+	return ""
+}
+
+// newDecoder returns r decompressed with encoding, snappy blocks decoding to
+// at most maxSnappy bytes.
+func newDecoder(encoding string, r io.Reader, maxSnappy int) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		zr.Multistream(true)
+		return zr, nil
+	case "deflate":
+		return zlib.NewReader(r)
+	case "snappy":
+		return newSnappyReader(r, maxSnappy)
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		return zstd.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
+
+// newEncoder returns a writer to w compressing with encoding, encodings
+// newDecoder does not support being written as they are.
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewWriter(w)
+	case "deflate":
+		return zlib.NewWriter(w)
+	case "snappy":
+		return &snappyWriter{w: w}
+	case "br":
+		return brotli.NewWriter(w)
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return &nopWriterCloser{w}
+	}
+}
+
+// stackedWriter writes to its last writer, each writer writing to the one
+// before it. Closing it closes them from the last one, so each flushes into
+// the next before that one is closed.
+type stackedWriter []io.WriteCloser
+
+func (s stackedWriter) Write(p []byte) (int, error) {
+	return s[len(s)-1].Write(p)
+}
+
+func (s stackedWriter) Close() error {
+	var err error
+	for i := len(s) - 1; i >= 0; i-- {
+		if cerr := s[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// checkSnappySize rejects with 400 a request whose body was last compressed
+// with snappy and declares a decoded size larger than
+// Config.MaxSnappyDecodedSize, reporting whether it did. The size is read from
+// the header of the block, before anything is decoded, and malformed blocks
+// are left to fail decoding. Blocks under other encodings are bounded as they
+// are decoded, by newSnappyReader.
+func (h *Handler) checkSnappySize(w http.ResponseWriter, r *http.Request) bool {
+	encodings := contentEncodings(r)
+	if len(encodings) == 0 || encodings[len(encodings)-1] != "snappy" {
+		return false
+	}
+	max := h.maxSnappyDecodedSize()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return false
+	}
+	n, err := snappy.DecodedLen(raw)
+	if err != nil || n <= max {
+		return false
+	}
+	_ = h.statsDClient.Count(snappyTooLargeCountName, 1, h.tags("route:"+routePattern(r)), 1)
+	http.Error(w, fmt.Sprintf("snappy body decodes to %d bytes, more than the %d allowed", n, max), http.StatusBadRequest)
+	return true
+}
+
+func (h *Handler) maxSnappyDecodedSize() int {
+	if h.cfg.MaxSnappyDecodedSize <= 0 {
+		return DefaultMaxSnappyDecodedSize
+	}
+	return h.cfg.MaxSnappyDecodedSize
+}
+
+// newSnappyReader decodes a body compressed as a single snappy block, the
+// format Prometheus remote write and most relays use, which has to be read
+// whole. Neither the block nor what it declares it decodes to may be larger
+// than a block of max bytes, whichever encodings it sits under, so that a
+// small body cannot make it allocate any size.
+func newSnappyReader(r io.Reader, max int) (io.ReadCloser, error) {
+	limit := snappy.MaxEncodedLen(max)
+	if limit < 0 {
+		return nil, fmt.Errorf("snappy blocks cannot decode to %d bytes", max)
+	}
+	compressed, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) > limit {
+		return nil, fmt.Errorf("snappy body longer than the %d bytes a block decoding to %d can be", limit, max)
+	}
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, fmt.Errorf("snappy body decodes to %d bytes, more than the %d allowed", n, max)
+	}
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+// snappyWriter buffers what is written to it and writes it to w as a single
+// snappy block once closed.
+type snappyWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (s *snappyWriter) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *snappyWriter) Close() error {
+	_, err := s.w.Write(snappy.Encode(nil, s.buf.Bytes()))
+	return err
+}
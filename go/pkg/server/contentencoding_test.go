@@ -0,0 +1,254 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/DataDog/zstd"
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func gzipBytes(b []byte) []byte {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func brotliBytes(b []byte) []byte {
+	buf := new(bytes.Buffer)
+	bw := brotli.NewWriter(buf)
+	_, _ = bw.Write(b)
+	_ = bw.Close()
+	return buf.Bytes()
+}
+
+func TestHandler_MetricsFilter_ContentEncodings(t *testing.T) {
+	payload, err := json.Marshal(defaultMetricsPayload([]string{"metric.one", "some.metric.load"}))
+	require.NoError(t, err)
+	expected := defaultMetricsPayload([]string{"metric.one"})
+	tests := []struct {
+		name     string
+		encoding string
+		encode   func([]byte) []byte
+		decode   func(t *testing.T, b []byte) []byte
+		filtered bool
+	}{
+		{
+			name:     "Identity",
+			encoding: "identity",
+			encode:   func(b []byte) []byte { return b },
+			decode:   func(_ *testing.T, b []byte) []byte { return b },
+			filtered: true,
+		},
+		{
+			name:     "Stacked",
+			encoding: "gzip, br",
+			encode:   func(b []byte) []byte { return brotliBytes(gzipBytes(b)) },
+			decode: func(t *testing.T, b []byte) []byte {
+				zr, err := gzip.NewReader(brotli.NewReader(bytes.NewReader(b)))
+				require.NoError(t, err)
+				out, err := io.ReadAll(zr)
+				require.NoError(t, err)
+				return out
+			},
+			filtered: true,
+		},
+		{
+			name:     "Identity and uppercase",
+			encoding: "identity,GZIP",
+			encode:   gzipBytes,
+			decode: func(t *testing.T, b []byte) []byte {
+				zr, err := gzip.NewReader(bytes.NewReader(b))
+				require.NoError(t, err)
+				out, err := io.ReadAll(zr)
+				require.NoError(t, err)
+				return out
+			},
+			filtered: true,
+		},
+		{
+			name:     "Unknown forwarded as it came",
+			encoding: "gzip, lz4",
+			encode:   func(b []byte) []byte { return append([]byte("not really lz4"), b...) },
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, sc := setupCaptureServer(t, "/api/v1/series", "some.metric")
+			defer ts.Close()
+
+			// When we send series with the encoding
+			body := tc.encode(payload)
+			req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", tc.encoding)
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+
+			// Then the payload is forwarded with the same encoding
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			if !tc.filtered {
+				assert.Equal(t, body, []byte(actual.body))
+				sc.assertCount(t, "proxy_filter.unsupported_content_encoding.count", 1, []string{"one", "two", "three", "route:/api/v1/series", "content_encoding:lz4"}, 1, true)
+				return
+			}
+			var actualPayload datadog.MetricsPayload
+			require.NoError(t, json.Unmarshal(tc.decode(t, []byte(actual.body)), &actualPayload))
+			assert.Equal(t, expected, actualPayload)
+		})
+	}
+}
+
+func TestHandler_MetricsFilter_SnappyTooLarge(t *testing.T) {
+	payload, err := json.Marshal(defaultMetricsPayload([]string{"metric.one", "some.metric.load"}))
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		max      int
+		encoding string
+		encode   func(b []byte) []byte
+		expected int
+	}{
+		{
+			name:     "Under the maximum",
+			max:      len(payload),
+			encoding: "snappy",
+			encode:   func(b []byte) []byte { return snappy.Encode(nil, b) },
+			expected: 418,
+		},
+		{
+			name:     "Over the maximum",
+			max:      len(payload) - 1,
+			encoding: "snappy",
+			encode:   func(b []byte) []byte { return snappy.Encode(nil, b) },
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Under the maximum under gzip",
+			max:      len(payload),
+			encoding: "snappy, gzip",
+			encode:   func(b []byte) []byte { return gzipBytes(snappy.Encode(nil, b)) },
+			expected: 418,
+		},
+		{
+			name:     "Over the maximum under gzip",
+			max:      len(payload) - 1,
+			encoding: "snappy, gzip",
+			encode:   func(b []byte) []byte { return gzipBytes(snappy.Encode(nil, b)) },
+			expected: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with a maximum snappy decoded size
+			cfg := server.Config{MetricsPrefixFilter: "some.metric", MaxSnappyDecodedSize: tc.max, Tags: []string{"one"}}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When we send a snappy payload, which may be compressed again
+			req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(tc.encode(payload)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", tc.encoding)
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+
+			// Then it is only forwarded when it decodes to at most the maximum
+			assert.Equal(t, tc.expected, rec.Code)
+			switch tc.expected {
+			case http.StatusBadRequest:
+				sc.assertCount(t, "proxy_filter.snappy_too_large.count", 1, []string{"one", "route:/api/v1/series"}, 1, true)
+				return
+			case http.StatusInternalServerError:
+				assert.Contains(t, rec.Body.String(), "more than the")
+				return
+			}
+			<-resultChan
+			sc.assertNotCounted(t, "proxy_filter.snappy_too_large.count")
+		})
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	c, err := server.ParseCompression("zstd:3")
+	require.NoError(t, err)
+	assert.Equal(t, server.Compression{Algorithm: "zstd", Level: 3}, c)
+	c, err = server.ParseCompression("GZIP")
+	require.NoError(t, err)
+	assert.Equal(t, server.Compression{Algorithm: "gzip"}, c)
+
+	for _, s := range []string{"", "lz4", "gzip:fast", "gzip:10", "zstd:-1"} {
+		_, err = server.ParseCompression(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_MetricsFilter_Compression(t *testing.T) {
+	tests := []struct {
+		compression server.Compression
+		encoding    string
+		decode      func(b []byte) io.Reader
+	}{
+		{
+			compression: server.Compression{Algorithm: "zstd", Level: 3},
+			encoding:    "zstd",
+			decode:      func(b []byte) io.Reader { return zstd.NewReader(bytes.NewReader(b)) },
+		},
+		{
+			compression: server.Compression{Algorithm: "gzip", Level: 1},
+			encoding:    "gzip",
+			decode: func(b []byte) io.Reader {
+				zr, _ := gzip.NewReader(bytes.NewReader(b))
+				return zr
+			},
+		},
+		{
+			compression: server.Compression{Algorithm: "identity"},
+			decode:      func(b []byte) io.Reader { return bytes.NewReader(b) },
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.compression.Algorithm, func(t *testing.T) {
+			// Given an upstream and a proxy re-compressing with another algorithm
+			var body []byte
+			var encoding string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				encoding = r.Header.Get("Content-Encoding")
+			}))
+			defer ts.Close()
+			cfg := server.Config{BaseEndpoint: ts.URL, MetricsPrefixFilter: "some.metric", Compression: &tc.compression}
+			h := server.NewHandler(cfg, ts.Client(), &stubStatsdClient{})
+
+			// When the agent sends gzipped series, with and without some to drop
+			for _, metrics := range [][]string{{"metric.one", "some.metric.load"}, {"metric.one"}} {
+				payload, err := json.Marshal(defaultMetricsPayload(metrics))
+				require.NoError(t, err)
+				req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(gzipBytes(payload)))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Content-Encoding", "gzip")
+				h.MetricsFilter(httptest.NewRecorder(), req)
+
+				// Then the series kept are sent with the configured algorithm
+				assert.Equal(t, tc.encoding, encoding)
+				var actual datadog.MetricsPayload
+				require.NoError(t, json.NewDecoder(tc.decode(body)).Decode(&actual))
+				assert.Equal(t, defaultMetricsPayload([]string{"metric.one"}), actual)
+			}
+		})
+	}
+}
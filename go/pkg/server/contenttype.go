@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ParseContentTypes parses route expectations written as
+// route=type[,type], e.g. /api/v1/series=application/json, into the map used
+// by Config.ContentTypes.
+func ParseContentTypes(rules []string) (map[string][]string, error) {
+	res := make(map[string][]string, len(rules))
+	for _, rule := range rules {
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, newError(ErrRuleInvalid, fmt.Errorf("expected route=type[,type] in %q", rule))
+		}
+		res[kv[0]] = append(res[kv[0]], strings.Split(kv[1], ",")...)
+	}
+	return res, nil
+}
+
+// checkContentType rejects the request with 415 when its route expects other
+// content types, and reports whether it was rejected.
+func (h *Handler) checkContentType(w http.ResponseWriter, r *http.Request) bool {
+	expected, ok := h.cfg.ContentTypes[r.URL.Path]
+	if !ok {
+		return false
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for i := range expected {
+		if contentType == expected[i] {
+			return false
+		}
+	}
+	_ = h.statsDClient.Count(contentTypeMismatchCountName, 1, h.tags("route:"+routePattern(r), "content_type:"+contentType), 1)
+	fmt.Println(fmt.Sprintf("Rejected request to %s with Content-Type %q, expected %v", r.URL.Path, contentType, expected))
+	w.WriteHeader(http.StatusUnsupportedMediaType)
+	return true
+}
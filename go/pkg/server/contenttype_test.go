@@ -0,0 +1,87 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseContentTypes(t *testing.T) {
+	actual, err := server.ParseContentTypes([]string{
+		"/api/v1/series=application/json",
+		"/api/v2/series=application/x-protobuf,application/json",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"/api/v1/series": {"application/json"},
+		"/api/v2/series": {"application/x-protobuf", "application/json"},
+	}, actual)
+
+	_, err = server.ParseContentTypes([]string{"/api/v1/series"})
+	assert.ErrorIs(t, err, server.ErrRuleInvalid)
+}
+
+func TestHandler_ContentTypes(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		contentType    string
+		expectedStatus int
+	}{
+		{
+			name:           "Expected type",
+			path:           "/api/v1/series",
+			contentType:    "application/json",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "Expected type with parameters",
+			path:           "/api/v1/series",
+			contentType:    "application/json; charset=utf-8",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "Unexpected type",
+			path:           "/api/v1/series",
+			contentType:    "text/html",
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Route without expectations",
+			path:           "/api/v1/validate",
+			contentType:    "text/html",
+			expectedStatus: http.StatusTeapot,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with expected content types
+			cfg := server.Config{
+				ContentTypes: map[string][]string{"/api/v1/series": {"application/json"}},
+				Tags:         []string{"one"},
+			}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When we make a request
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(`{"series":[]}`))
+			req.Header.Set("Content-Type", tc.contentType)
+			rec := httptest.NewRecorder()
+			h.ProxyHandle(rec, req)
+
+			// Then it is only forwarded when the type is expected
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedStatus == http.StatusUnsupportedMediaType {
+				sc.assertCount(t, "proxy_filter.content_type_mismatch.count", 1, []string{"one", "route:/api/v1/series", "content_type:text/html"}, 1, true)
+				return
+			}
+			<-resultChan
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+)
+
+// FilterDecisionRecord is what the proxy decided for a request, produced once
+// the request is handled and passed to every Config.DecisionSinks. Route is
+// the pattern of the route the request matched, e.g. /intake/, and Path the
+// path the client sent it to.
+type FilterDecisionRecord struct {
+	Route           string                   `json:"route"`
+	Path            string                   `json:"path"`
+	Method          string                   `json:"method"`
+	Tenant          string                   `json:"tenant,omitempty"`
+	RuleSet         string                   `json:"rule_set,omitempty"`
+	AgentVersion    string                   `json:"agent_version"`
+	ContentEncoding string                   `json:"content_encoding,omitempty"`
+	Start           time.Time                `json:"start"`
+	Duration        time.Duration            `json:"duration"`
+	Status          int                      `json:"status"`
+	Bytes           int64                    `json:"bytes,omitempty"`
+	Dropped         map[string]int64         `json:"dropped"`
+	Audited         map[string]int64         `json:"audited,omitempty"`
+	Timings         map[string]time.Duration `json:"timings"`
+}
+
+// DecisionSink receives the decision record of every request. Implementations
+// must be safe for concurrent use and should not block.
+type DecisionSink interface {
+	Record(rec FilterDecisionRecord)
+}
+
+// DecisionSinkFunc adapts a function to a DecisionSink.
+type DecisionSinkFunc func(rec FilterDecisionRecord)
+
+func (f DecisionSinkFunc) Record(rec FilterDecisionRecord) {
+	f(rec)
+}
+
+// LogSink writes every record as a line of JSON, e.g. to an audit log.
+type LogSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogSink creates a sink writing to w.
+func NewLogSink(w io.Writer) *LogSink {
+	return &LogSink{w: w}
+}
+
+func (l *LogSink) Record(rec FilterDecisionRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(b, '\n'))
+}
+
+// StatsdSink counts the series each filter dropped, or would have dropped for
+// audit filters, per route pattern.
+type StatsdSink struct {
+	Client StatsdClient
+	Tags   []string
+}
+
+func (s StatsdSink) Record(rec FilterDecisionRecord) {
+	for name, count := range rec.Dropped {
+		tags := make([]string, 0, len(s.Tags)+2)
+		tags = append(tags, s.Tags...)
+		_ = s.Client.Count(decisionDropsCountName, count, append(tags, "route:"+rec.Route, "filter:"+name), 1)
+	}
+	for name, count := range rec.Audited {
+		tags := make([]string, 0, len(s.Tags)+2)
+		tags = append(tags, s.Tags...)
+		_ = s.Client.Count(decisionAuditsCountName, count, append(tags, "route:"+rec.Route, "filter:"+name), 1)
+	}
+}
+
+// DebugBuffer keeps the last records it received, served as JSON on the admin
+// API at /decisions.
+type DebugBuffer struct {
+	mu      sync.Mutex
+	records []FilterDecisionRecord
+	next    int
+	full    bool
+}
+
+// NewDebugBuffer creates a buffer of the last size records.
+func NewDebugBuffer(size int) *DebugBuffer {
+	return &DebugBuffer{records: make([]FilterDecisionRecord, size)}
+}
+
+func (d *DebugBuffer) Record(rec FilterDecisionRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.records) == 0 {
+		return
+	}
+	d.records[d.next] = rec
+	d.next = (d.next + 1) % len(d.records)
+	if d.next == 0 {
+		d.full = true
+	}
+}
+
+// Records returns the buffered records, oldest first.
+func (d *DebugBuffer) Records() []FilterDecisionRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.full {
+		return append([]FilterDecisionRecord{}, d.records[:d.next]...)
+	}
+	return append(append([]FilterDecisionRecord{}, d.records[d.next:]...), d.records[:d.next]...)
+}
+
+func (d *DebugBuffer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d.Records())
+}
+
+// statusRecorder remembers the status and the size of the body written to a
+// response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.size += int64(n)
+	return n, err
+}
+
+// recordDecision passes the decision record of a handled request to the sinks.
+func (h *Handler) recordDecision(r *http.Request, status int, body *countingReader) {
+	if len(h.cfg.DecisionSinks) == 0 {
+		return
+	}
+	meta := RequestMetaFrom(r.Context())
+	rec := FilterDecisionRecord{
+		Route:           routePattern(r),
+		Path:            r.URL.Path,
+		Method:          r.Method,
+		AgentVersion:    agent.VersionFrom(r.Context()).String(),
+		ContentEncoding: r.Header.Get("Content-Encoding"),
+		Status:          status,
+		Bytes:           requestSize(r, body),
+		Dropped:         meta.Dropped(),
+		Audited:         meta.Audited(),
+		Timings:         meta.Timings(),
+	}
+	if meta != nil {
+		rec.Tenant, rec.RuleSet, rec.Start = meta.Tenant, meta.RuleSet, meta.Start
+		rec.Duration = h.clock.Now().Sub(meta.Start)
+	}
+	for _, sink := range h.cfg.DecisionSinks {
+		sink.Record(rec)
+	}
+}
@@ -0,0 +1,153 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_DecisionSinks(t *testing.T) {
+	// Given a proxy with a log sink and a debug buffer
+	clk := clock.NewFake(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	log := new(bytes.Buffer)
+	buffer := server.NewDebugBuffer(2)
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		Tags:                []string{"one"},
+		Clock:               clk,
+		DecisionSinks:       []server.DecisionSink{server.NewLogSink(log), buffer},
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When three requests are made, the first one chunked
+	var sizes []int64
+	for i, metrics := range [][]string{{"metric.one"}, {"some.metric.load", "metric.two"}, {"some.metric.a", "some.metric.b"}} {
+		body, err := json.Marshal(defaultMetricsPayload(metrics))
+		require.NoError(t, err)
+		sizes = append(sizes, int64(len(body)))
+		req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+		req.Header.Set("DD-Agent-Version", "7.40.1")
+		if i == 0 {
+			req.ContentLength = -1
+		}
+		h.MetricsFilter(httptest.NewRecorder(), req)
+		<-resultChan
+	}
+
+	// Then each one is logged
+	var records []server.FilterDecisionRecord
+	dec := json.NewDecoder(log)
+	for dec.More() {
+		var rec server.FilterDecisionRecord
+		require.NoError(t, dec.Decode(&rec))
+		records = append(records, rec)
+	}
+	require.Len(t, records, 3)
+	assert.Equal(t, "/api/v1/series", records[0].Route)
+	assert.Equal(t, "POST", records[0].Method)
+	assert.Equal(t, "7.40.1", records[0].AgentVersion)
+	assert.Equal(t, http.StatusTeapot, records[0].Status)
+	assert.Equal(t, sizes[0], records[0].Bytes)
+	assert.Equal(t, sizes[1], records[1].Bytes)
+	assert.Empty(t, records[0].Dropped)
+	assert.Equal(t, map[string]int64{"metric=some.metric": 1}, records[1].Dropped)
+	assert.Contains(t, records[1].Timings, "upstream")
+
+	// And the buffer keeps the last two
+	buffered := buffer.Records()
+	require.Len(t, buffered, 2)
+	assert.Equal(t, map[string]int64{"metric=some.metric": 1}, buffered[0].Dropped)
+	assert.Equal(t, map[string]int64{"metric=some.metric": 2}, buffered[1].Dropped)
+
+	// And it is served on the admin API
+	rec := httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, httptest.NewRequest("GET", "/decisions", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var served []server.FilterDecisionRecord
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Len(t, served, 2)
+}
+
+func TestHandler_DecisionSinks_Audit(t *testing.T) {
+	// Given a proxy enforcing a rule and auditing another
+	buffer := server.NewDebugBuffer(1)
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		Filter:              filter.Chain{filter.Rule{MetricPrefix: "app.", Audit: true}},
+		DecisionSinks:       []server.DecisionSink{buffer},
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a request matching both is made
+	body := mustMarshal(t, defaultMetricsPayload([]string{"app.debug.count", "some.metric.load", "system.load.1"}))
+	req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+	h.MetricsFilter(httptest.NewRecorder(), req)
+
+	// Then only the enforced rule drops series
+	assert.Equal(t, []string{"app.debug.count", "system.load.1"}, forwardedMetrics(t, <-resultChan))
+
+	// And the matches of the audited rule are recorded
+	records := buffer.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, map[string]int64{"metric=some.metric": 1}, records[0].Dropped)
+	assert.Equal(t, map[string]int64{"metric=app.,action=audit": 1}, records[0].Audited)
+}
+
+func TestStatsdSink(t *testing.T) {
+	sc := &stubStatsdClient{}
+	sink := server.StatsdSink{Client: sc, Tags: []string{"one"}}
+	sink.Record(server.FilterDecisionRecord{Route: "/api/v1/series", Dropped: map[string]int64{"empty": 3}, Audited: map[string]int64{"metric=app.,action=audit": 2}})
+	sc.assertCount(t, "proxy_filter.decision.dropped.count", 3, []string{"one", "route:/api/v1/series", "filter:empty"}, 1, true)
+	sc.assertCount(t, "proxy_filter.decision.audited.count", 2, []string{"one", "route:/api/v1/series", "filter:metric=app.,action=audit"}, 1, true)
+}
+
+func TestHandler_DecisionSinks_RoutePattern(t *testing.T) {
+	// Given a proxy routing by the default routes with a debug buffer
+	buffer := server.NewDebugBuffer(1)
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", server.Config{DecisionSinks: []server.DecisionSink{buffer}})
+	defer ts.Close()
+
+	// When a client sends a request to a path under the catch-all route
+	h.Router().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/some/client/path", nil))
+	<-resultChan
+
+	// Then the record holds the route pattern apart from the path
+	records := buffer.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "/", records[0].Route)
+	assert.Equal(t, "/some/client/path", records[0].Path)
+}
+
+func TestHandler_DecisionSinks_RuleSet(t *testing.T) {
+	// Given a proxy with a debug buffer
+	buffer := server.NewDebugBuffer(2)
+	cfg := server.Config{MetricsPrefixFilter: "some.metric", DecisionSinks: []server.DecisionSink{buffer}}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a request is made before and after the rules are reloaded
+	h.ProxyHandle(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/validate", nil))
+	<-resultChan
+	cfg.BaseEndpoint = ts.URL
+	h.Reload(cfg, "SIGHUP")
+	h.ProxyHandle(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/validate", nil))
+	<-resultChan
+
+	// Then each record holds the version of the rule set it was handled with
+	records := buffer.Records()
+	require.Len(t, records, 2)
+	assert.Equal(t, "1", records[0].RuleSet)
+	assert.Equal(t, "2", records[1].RuleSet)
+}
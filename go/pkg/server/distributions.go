@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"net/http"
+)
+
+// recordDistributions sends, per route pattern, how long a handled request took, how
+// long its upstream round trip took, and the sizes of its request and
+// response bodies as distributions, when the statsd client can send them.
+func (h *Handler) recordDistributions(r *http.Request, sr *statusRecorder, body *countingReader) {
+	d, ok := h.statsDClient.(distributer)
+	if !ok {
+		return
+	}
+	tags := h.tags("route:" + routePattern(r))
+	meta := RequestMetaFrom(r.Context())
+	if meta != nil {
+		_ = d.Distribution(requestDurationDistributionName, h.clock.Now().Sub(meta.Start).Seconds(), tags, 1)
+	}
+	// Requests that were dropped or failed before being sent have no upstream
+	// round trip.
+	if upstream, ok := meta.Timings()["upstream"]; ok {
+		_ = d.Distribution(upstreamDurationDistributionName, upstream.Seconds(), tags, 1)
+	}
+	_ = d.Distribution(requestSizeDistributionName, float64(requestSize(r, body)), tags, 1)
+	_ = d.Distribution(responseSizeDistributionName, float64(sr.size), tags, 1)
+}
+
+// requestSize is what was read of the request body, chunked bodies declaring
+// none, or its Content-Length when larger as a dropped request may not be
+// read.
+func requestSize(r *http.Request, body *countingReader) int64 {
+	if r.ContentLength > body.n {
+		return r.ContentLength
+	}
+	return body.n
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type stubDistributionClient struct {
+	stubStatsdClient
+	mu            sync.Mutex
+	distributions map[string]float64
+}
+
+func (s *stubDistributionClient) Distribution(name string, value float64, tags []string, rate float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.distributions == nil {
+		s.distributions = make(map[string]float64)
+	}
+	s.distributions[name+" "+strings.Join(tags, ",")] = value
+	return nil
+}
+
+func TestHandler_Distributions(t *testing.T) {
+	const payload = `{"series":[]}`
+	tests := []struct {
+		name             string
+		dropRequests     []server.RequestRule
+		chunked          bool
+		expectedUpstream bool
+		expectedResponse string
+	}{
+		{
+			name:             "Forwarded",
+			expectedUpstream: true,
+			expectedResponse: "accepted",
+		},
+		{
+			name:             "Forwarded chunked",
+			chunked:          true,
+			expectedUpstream: true,
+			expectedResponse: "accepted",
+		},
+		{
+			name:             "Dropped",
+			dropRequests:     []server.RequestRule{{Path: "/api/v1/check_run", Status: http.StatusGone}},
+			expectedResponse: "",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an upstream and a proxy sending distributions
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = io.WriteString(w, "accepted")
+			}))
+			defer ts.Close()
+			sc := &stubDistributionClient{}
+			h := server.NewHandler(server.Config{BaseEndpoint: ts.URL, DropRequests: tc.dropRequests, Tags: []string{"one"}}, ts.Client(), sc)
+
+			// When we make a request, which may not declare its length
+			req := httptest.NewRequest("POST", "/api/v1/check_run", strings.NewReader(payload))
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ProxyHandle(rec, req)
+			require.Equal(t, tc.expectedResponse, rec.Body.String())
+
+			// Then the durations and sizes are sent by route
+			tags := " one,route:/api/v1/check_run"
+			sc.mu.Lock()
+			defer sc.mu.Unlock()
+			assert.Contains(t, sc.distributions, "proxy_filter.request.duration"+tags)
+			_, ok := sc.distributions["proxy_filter.upstream.duration"+tags]
+			assert.Equal(t, tc.expectedUpstream, ok)
+			assert.Equal(t, float64(len(payload)), sc.distributions["proxy_filter.request.size"+tags])
+			assert.Equal(t, float64(len(tc.expectedResponse)), sc.distributions["proxy_filter.response.size"+tags])
+		})
+	}
+}
+
+func TestHandler_Distributions_RoutePattern(t *testing.T) {
+	// Given an upstream and a proxy routing by the default routes
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	sc := &stubDistributionClient{}
+	h := server.NewHandler(server.Config{BaseEndpoint: ts.URL}, ts.Client(), sc)
+
+	// When a client sends a request to a path under the catch-all route
+	h.Router().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/some/client/path", nil))
+
+	// Then the distributions are tagged with the route pattern, not the path
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	assert.Contains(t, sc.distributions, "proxy_filter.request.duration route:/")
+	assert.NotContains(t, sc.distributions, "proxy_filter.request.duration route:/some/client/path")
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// maxDogStatsDPacket is the largest datagram read, the largest a Unix socket
+// carries by default.
+const maxDogStatsDPacket = 65535
+
+// dogStatsDTypes maps the DogStatsD metric types to the v1 series types.
+var dogStatsDTypes = map[string]string{"c": "count", "g": "gauge", "d": "distribution"}
+
+// ParseDogStatsDAddr parses a DogStatsD address written as udp://host:port or
+// unix:///path/to/socket, returning the network and address to listen on or
+// dial, the network being udp or unixgram.
+func ParseDogStatsDAddr(s string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(s, "udp://"):
+		address = strings.TrimPrefix(s, "udp://")
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", newError(ErrRuleInvalid, err)
+		}
+		return "udp", address, nil
+	case strings.HasPrefix(s, "unix://") && len(s) > len("unix://"):
+		return "unixgram", strings.TrimPrefix(s, "unix://"), nil
+	}
+	return "", "", newError(ErrRuleInvalid, fmt.Errorf("expected udp://host:port or unix:///path in %q", s))
+}
+
+// ServeDogStatsD reads DogStatsD datagrams from pc until it is closed,
+// writing each one to upstream without the metrics the filters drop. The
+// filters see a metric as a v1 series with a point of its first value, typed
+// count, gauge or distribution for the c, g and d types. Events, service
+// checks and the lines that do not parse are forwarded as they are.
+// Config.Transform rewrites the name and tags of the kept metrics, their
+// values being left as they are.
+func (h *Handler) ServeDogStatsD(pc net.PacketConn, upstream net.Conn) error {
+	buf := make([]byte, maxDogStatsDPacket)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		c := h.current()
+		c.useDropAll("")
+		out, dropped := c.filterDogStatsD(buf[:n])
+		if dropped > 0 {
+			_ = h.statsDClient.Count(filteredDogStatsDCountName, dropped, h.cfg.Tags, 1)
+		}
+		if len(out) == 0 {
+			continue
+		}
+		if _, err := upstream.Write(out); err != nil {
+			fmt.Println(fmt.Sprintf("Could not forward a DogStatsD packet to %s, %v", upstream.RemoteAddr(), err))
+		}
+	}
+}
+
+// filterDogStatsD returns the lines of a datagram the filters keep,
+// rewritten by Config.Transform.
+func (h *Handler) filterDogStatsD(packet []byte) ([]byte, int64) {
+	if (len(h.filters) == 0 && h.cfg.Transform == nil) || h.inMaintenance {
+		return packet, 0
+	}
+	now := float64(h.clock.Now().Unix())
+	lines := bytes.Split(packet, []byte("\n"))
+	kept := lines[:0]
+	var dropped int64
+	changed := false
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		series, ok := parseDogStatsDLine(string(line), now)
+		if ok && h.dropSeries(context.Background(), &series) {
+			dropped++
+			continue
+		}
+		if ok && h.cfg.Transform != nil {
+			if rewritten, ok := h.transformDogStatsDLine(string(line), series); ok {
+				line = []byte(rewritten)
+				changed = true
+			}
+		}
+		kept = append(kept, line)
+	}
+	if dropped == 0 && !changed {
+		return packet, 0
+	}
+	return bytes.Join(kept, []byte("\n")), dropped
+}
+
+// transformDogStatsDLine applies Config.Transform to the series parsed from a
+// metric line, returning the line with the name and tags the transform sets
+// and false when it changes neither.
+func (h *Handler) transformDogStatsDLine(line string, series datadog.Series) (string, bool) {
+	metric, tags := series.Metric, append([]string(nil), series.GetTags()...)
+	h.cfg.Transform.Transform(context.Background(), &series)
+	if series.Metric == metric && equalStrings(series.GetTags(), tags) {
+		return line, false
+	}
+	sections := strings.Split(line, "|")
+	sections[0] = series.Metric + sections[0][strings.IndexByte(sections[0], ':'):]
+	at := -1
+	for i, section := range sections[2:] {
+		if strings.HasPrefix(section, "#") {
+			at = i + 2
+		}
+	}
+	if at < 0 {
+		// The tags follow the sample rate, when there is one.
+		at = 2
+		if len(sections) > 2 && strings.HasPrefix(sections[2], "@") {
+			at = 3
+		}
+		sections = append(sections[:at], append([]string{""}, sections[at:]...)...)
+	}
+	if len(series.GetTags()) == 0 {
+		sections = append(sections[:at], sections[at+1:]...)
+	} else {
+		sections[at] = "#" + strings.Join(series.GetTags(), ",")
+	}
+	return strings.Join(sections, "|"), true
+}
+
+// parseDogStatsDLine parses a metric written as
+// name:value[:value...]|type[|@rate][|#tag,...][|...] received at now,
+// reporting false for events, service checks and malformed lines.
+func parseDogStatsDLine(line string, now float64) (datadog.Series, bool) {
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return datadog.Series{}, false
+	}
+	sections := strings.Split(line, "|")
+	colon := strings.IndexByte(sections[0], ':')
+	if len(sections) < 2 || colon <= 0 {
+		return datadog.Series{}, false
+	}
+	series := datadog.Series{Metric: sections[0][:colon]}
+	if t, ok := dogStatsDTypes[sections[1]]; ok {
+		series.SetType(t)
+	}
+	values := strings.Split(sections[0][colon+1:], ":")
+	if v, err := strconv.ParseFloat(values[0], 64); err == nil {
+		series.Points = [][]*float64{{&now, &v}}
+	}
+	for _, section := range sections[2:] {
+		if strings.HasPrefix(section, "#") && len(section) > 1 {
+			series.SetTags(strings.Split(section[1:], ","))
+		}
+	}
+	return series, true
+}
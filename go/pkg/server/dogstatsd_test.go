@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestParseDogStatsDAddr(t *testing.T) {
+	network, address, err := server.ParseDogStatsDAddr("udp://127.0.0.1:8125")
+	require.NoError(t, err)
+	assert.Equal(t, "udp", network)
+	assert.Equal(t, "127.0.0.1:8125", address)
+
+	network, address, err = server.ParseDogStatsDAddr("unix:///var/run/datadog/dsd.socket")
+	require.NoError(t, err)
+	assert.Equal(t, "unixgram", network)
+	assert.Equal(t, "/var/run/datadog/dsd.socket", address)
+
+	for _, s := range []string{"", "127.0.0.1:8125", "udp://8125", "unix://", "tcp://127.0.0.1:8125"} {
+		_, _, err = server.ParseDogStatsDAddr(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_ServeDogStatsD(t *testing.T) {
+	tests := []struct {
+		name            string
+		packet          string
+		transform       transform.Transform
+		expected        string
+		expectedDropped int64
+	}{
+		{
+			name:            "Filter metric name",
+			packet:          "page.views:1|c\nsome.metric.load:0.5|g|#env:prod\nrequest.time:12:15|ms|@0.5",
+			expected:        "page.views:1|c\nrequest.time:12:15|ms|@0.5",
+			expectedDropped: 1,
+		},
+		{
+			name:            "Filter tag",
+			packet:          "page.views:1|c|#env:dev,team:web\npage.views:1|c|#env:prod",
+			expected:        "page.views:1|c|#env:prod",
+			expectedDropped: 1,
+		},
+		{
+			name:            "Transform tags",
+			packet:          "page.views:1|c|@0.5\npage.views:2|c|#env:prod\nsome.metric.load:0.5|g",
+			transform:       transform.AddTags{"proxied:true"},
+			expected:        "page.views:1|c|@0.5|#proxied:true\npage.views:2|c|#env:prod,proxied:true",
+			expectedDropped: 1,
+		},
+		{
+			name:     "Events, service checks and malformed lines",
+			packet:   "_e{5,4}:title|text|#env:dev\n_sc|some.metric.check|0\nsome.metric",
+			expected: "_e{5,4}:title|text|#env:dev\n_sc|some.metric.check|0\nsome.metric",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an agent listening for DogStatsD packets
+			agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer agent.Close()
+			upstream, err := net.Dial("udp", agent.LocalAddr().String())
+			require.NoError(t, err)
+			defer upstream.Close()
+
+			// And the proxy filtering them
+			cfg := server.Config{MetricsPrefixFilter: "some.metric", Filter: stubTagFilter{tag: "env:dev"}, Transform: tc.transform, Tags: []string{"one"}}
+			_, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer pc.Close()
+			go func() { _ = h.ServeDogStatsD(pc, upstream) }()
+
+			// When a packet is sent
+			client, err := net.Dial("udp", pc.LocalAddr().String())
+			require.NoError(t, err)
+			defer client.Close()
+			_, err = client.Write([]byte(tc.packet))
+			require.NoError(t, err)
+
+			// Then the agent gets the kept lines
+			buf := make([]byte, 1024)
+			require.NoError(t, agent.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, _, err := agent.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, string(buf[:n]))
+			if tc.expectedDropped > 0 {
+				sc.assertCount(t, "proxy_filter.dogstatsd.filtered_metrics.count", tc.expectedDropped, []string{"one"}, 1, true)
+			} else {
+				sc.assertNotCounted(t, "proxy_filter.dogstatsd.filtered_metrics.count")
+			}
+		})
+	}
+}
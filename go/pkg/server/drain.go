@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+type connKey struct{}
+
+// ConnDrainer moves keep-alive client connections off the server gradually,
+// e.g. after its settings change. Connections open when Drain is called are
+// each given a point in the drain window after which their next response
+// carries Connection: close, which makes HTTP/2 connections send GOAWAY.
+// Hook it up with http.Server ConnState and ConnContext, and its Middleware.
+type ConnDrainer struct {
+	inFlight int64
+
+	mu    sync.Mutex
+	conns map[net.Conn]time.Time
+	clock clock.Clock
+	rand  *rand.Rand
+}
+
+// NewConnDrainer creates a drainer using clk to schedule closes and rnd to
+// pick the order connections are closed in.
+func NewConnDrainer(clk clock.Clock, rnd *rand.Rand) *ConnDrainer {
+	return &ConnDrainer{conns: make(map[net.Conn]time.Time), clock: clk, rand: rnd}
+}
+
+// ConnState tracks the open connections, use it as http.Server.ConnState.
+func (d *ConnDrainer) ConnState(c net.Conn, state http.ConnState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		d.conns[c] = time.Time{}
+	case http.StateClosed, http.StateHijacked:
+		delete(d.conns, c)
+	}
+}
+
+// ConnContext makes the connection available to Middleware, use it as
+// http.Server.ConnContext.
+func (d *ConnDrainer) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// Drain schedules every open connection to be closed within window. It
+// returns how many connections were scheduled.
+func (d *ConnDrainer) Drain(window time.Duration) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.clock.Now()
+	order := d.rand.Perm(len(d.conns))
+	i := 0
+	for c := range d.conns {
+		at := now
+		if len(d.conns) > 1 {
+			at = now.Add(window * time.Duration(order[i]) / time.Duration(len(d.conns)-1))
+		}
+		d.conns[c] = at
+		i++
+	}
+	return len(d.conns)
+}
+
+// Middleware asks clients to close connections past their drain point, and
+// keeps count of the requests in flight for Shutdown.
+func (d *ConnDrainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&d.inFlight, 1)
+		defer atomic.AddInt64(&d.inFlight, -1)
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok && d.due(c) {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *ConnDrainer) due(c net.Conn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	at, ok := d.conns[c]
+	return ok && !at.IsZero() && !d.clock.Now().Before(at)
+}
+
+// DrainReport tells how shutting the server down went. Requests were in
+// flight when it started, Drained of them completed before the deadline and
+// ForceClosed had their connection closed under them.
+type DrainReport struct {
+	Requests    int64
+	Drained     int64
+	ForceClosed int64
+	Duration    time.Duration
+}
+
+func (r DrainReport) String() string {
+	return fmt.Sprintf("drained %d of %d requests in flight, force closed %d, in %s", r.Drained, r.Requests, r.ForceClosed, r.Duration)
+}
+
+// Send counts the drained and force closed requests, and sends how long
+// draining took as a gauge when the statsd client can send gauges.
+func (r DrainReport) Send(statsDClient StatsdClient, tags []string) {
+	_ = statsDClient.Count(drainedRequestsCountName, r.Drained, tags, 1)
+	_ = statsDClient.Count(forceClosedRequestsCountName, r.ForceClosed, tags, 1)
+	if g, ok := statsDClient.(gauger); ok {
+		_ = g.Gauge(drainDurationGaugeName, r.Duration.Seconds(), tags, 1)
+	}
+}
+
+// Shutdown shuts hs down gracefully, closing the connections still serving a
+// request once ctx is done, and reports on the requests that were in flight.
+// The error is the one of http.Server.Shutdown.
+func (d *ConnDrainer) Shutdown(ctx context.Context, hs *http.Server) (DrainReport, error) {
+	start := d.clock.Now()
+	report := DrainReport{Requests: atomic.LoadInt64(&d.inFlight)}
+	err := hs.Shutdown(ctx)
+	if err != nil {
+		_ = hs.Close()
+		report.ForceClosed = atomic.LoadInt64(&d.inFlight)
+		if report.ForceClosed > report.Requests {
+			report.ForceClosed = report.Requests
+		}
+	}
+	report.Drained = report.Requests - report.ForceClosed
+	report.Duration = clock.Since(d.clock, start)
+	return report, err
+}
@@ -0,0 +1,166 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestConnDrainer(t *testing.T) {
+	// Given a server draining connections
+	d := server.NewConnDrainer(clock.Real, clock.NewRand(1))
+	ts := httptest.NewUnstartedServer(d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	})))
+	ts.Config.ConnState = d.ConnState
+	ts.Config.ConnContext = d.ConnContext
+	ts.Start()
+	defer ts.Close()
+	client := ts.Client()
+
+	get := func() *http.Response {
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// When we make a request before draining
+	resp := get()
+
+	// Then the connection is kept alive
+	assert.False(t, resp.Close)
+
+	// When we drain
+	assert.Equal(t, 1, d.Drain(0))
+
+	// Then the next response closes the connection
+	resp = get()
+	assert.True(t, resp.Close)
+
+	// And the new connection is not drained
+	resp = get()
+	assert.False(t, resp.Close)
+}
+
+func TestConnDrainer_Window(t *testing.T) {
+	// Given a server draining connections over a long window
+	clk := clock.NewFake(time.Now())
+	d := server.NewConnDrainer(clk, clock.NewRand(1))
+	ts := httptest.NewUnstartedServer(d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	ts.Config.ConnState = d.ConnState
+	ts.Config.ConnContext = d.ConnContext
+	ts.Start()
+	defer ts.Close()
+
+	clients := []*http.Client{
+		{Transport: &http.Transport{}},
+		{Transport: &http.Transport{}},
+	}
+	for _, c := range clients {
+		resp, err := c.Get(ts.URL)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	// When we drain
+	require.Equal(t, 2, d.Drain(time.Hour))
+
+	// Then one connection closes now and the other one at the end of the window
+	closed := func() (closed []bool) {
+		for _, c := range clients {
+			resp, err := c.Get(ts.URL)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			closed = append(closed, resp.Close)
+		}
+		return
+	}
+	first := closed()
+	assert.ElementsMatch(t, []bool{true, false}, first)
+
+	clk.Advance(time.Hour)
+	for i, c := range closed() {
+		assert.Equal(t, !first[i], c)
+	}
+}
+
+func TestConnDrainer_Shutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		expected server.DrainReport
+	}{
+		{
+			name:     "Drained",
+			timeout:  time.Minute,
+			expected: server.DrainReport{Requests: 1, Drained: 1},
+		},
+		{
+			name:     "Force closed",
+			timeout:  10 * time.Millisecond,
+			expected: server.DrainReport{Requests: 1, ForceClosed: 1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a server with a request in flight
+			d := server.NewConnDrainer(clock.Real, clock.NewRand(1))
+			started, release := make(chan struct{}), make(chan struct{})
+			ts := httptest.NewUnstartedServer(d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-release:
+				case <-time.After(time.Second):
+				}
+			})))
+			ts.Config.ConnState = d.ConnState
+			ts.Config.ConnContext = d.ConnContext
+			ts.Start()
+			defer ts.Close()
+			go func() {
+				resp, err := ts.Client().Get(ts.URL)
+				if err == nil {
+					_ = resp.Body.Close()
+				}
+			}()
+			<-started
+
+			// When we shut it down, letting the request complete if there is
+			// time for it
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			if tc.expected.Drained > 0 {
+				time.AfterFunc(10*time.Millisecond, func() { close(release) })
+			}
+			report, err := d.Shutdown(ctx, ts.Config)
+
+			// Then the report tells what happened to it
+			if tc.expected.ForceClosed > 0 {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Positive(t, report.Duration)
+			report.Duration = 0
+			assert.Equal(t, tc.expected, report)
+
+			// And it is sent to statsd
+			sc := &stubGaugeClient{}
+			report.Send(sc, []string{"one", "two"})
+			sc.assertCount(t, "proxy_filter.shutdown.drained_requests.count", tc.expected.Drained, []string{"one", "two"}, 1, true)
+			sc.assertCount(t, "proxy_filter.shutdown.force_closed_requests.count", tc.expected.ForceClosed, []string{"one", "two"}, 1, true)
+			assert.Contains(t, sc.gauges, "proxy_filter.shutdown.drain_duration one")
+		})
+	}
+}
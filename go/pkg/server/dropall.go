@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// DefaultStandardMetricPrefixes are the prefixes of the metrics the Datadog
+// Agent and its core checks send, which are not billed as custom metrics.
+var DefaultStandardMetricPrefixes = []string{
+	"datadog.", "system.", "process.", "ntp.", "disk.", "network.",
+	"docker.", "containerd.", "container.", "kubernetes.", "kubernetes_state.",
+	"trace.", "runtime.",
+}
+
+// DropAllScope is what the drop-all circuit drops.
+type DropAllScope struct {
+	// Routes limits the circuit to the requests to these paths, every path
+	// when empty.
+	Routes []string `json:"routes,omitempty"`
+	// CustomMetrics only drops the custom metrics, the series whose metric
+	// starts with none of Config.StandardMetricPrefixes, instead of whole
+	// requests. They are dropped from DogStatsD too when Routes is empty.
+	CustomMetrics bool `json:"custom_metrics,omitempty"`
+}
+
+func parseDropAllScope(raw json.RawMessage) (interface{}, error) {
+	scope := &DropAllScope{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, scope); err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range scope.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("route %q does not start with /", route)
+		}
+	}
+	return scope, nil
+}
+
+// covers reports whether the circuit applies to path, DogStatsD having none.
+func (s *DropAllScope) covers(path string) bool {
+	if len(s.Routes) == 0 {
+		return true
+	}
+	for _, route := range s.Routes {
+		if path != "" && route == path {
+			return true
+		}
+	}
+	return false
+}
+
+// DropAll serves the drop-all circuit of the admin API, a kill switch for
+// the metric storms that threaten the quota of the Datadog account. While it
+// is on, the POST requests to the routes of its scope are answered as
+// dropped without being forwarded, or only their custom metrics are dropped.
+// It is off in maintenance mode, which forwards everything as it came.
+func (h *Handler) DropAll(w http.ResponseWriter, r *http.Request) {
+	h.serveToggle(w, r, h.dropAll, "Drop-all circuit", parseDropAllScope)
+}
+
+// useDropAll adds the filter dropping the custom metrics after the other
+// filters when the drop-all circuit covers path.
+func (h *Handler) useDropAll(path string) {
+	s := h.dropAllScope
+	if s == nil || !s.CustomMetrics || !s.covers(path) {
+		return
+	}
+	prefixes := h.cfg.StandardMetricPrefixes
+	if len(prefixes) == 0 {
+		prefixes = DefaultStandardMetricPrefixes
+	}
+	h.filters = append(h.filters[:len(h.filters):len(h.filters)], customMetrics(prefixes))
+}
+
+// dropAllRequest answers the POST request as dropped when the drop-all
+// circuit covers it, and reports whether it did.
+func (h *Handler) dropAllRequest(w http.ResponseWriter, r *http.Request) bool {
+	s := h.dropAllScope
+	if s == nil || s.CustomMetrics || r.Method != http.MethodPost || !s.covers(r.URL.Path) {
+		return false
+	}
+	_ = h.statsDClient.Count(dropAllRequestsCountName, 1, h.tags("route:"+routePattern(r)), 1)
+	h.writeDropped(w, r, DropResponse{Status: http.StatusAccepted})
+	return true
+}
+
+// customMetrics drops the series whose metric starts with none of its
+// prefixes.
+type customMetrics []string
+
+func (c customMetrics) Filter(_ context.Context, series *datadog.Series) filter.Decision {
+	for _, prefix := range c {
+		if strings.HasPrefix(series.Metric, prefix) {
+			return filter.Keep
+		}
+	}
+	return filter.Drop
+}
+
+func (c customMetrics) String() string {
+	return "drop-all:custom-metrics"
+}
@@ -0,0 +1,130 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_DropAll(t *testing.T) {
+	// Given server is running
+	at := time.Date(2022, 4, 15, 5, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(at)
+	resultChan, ts, h, sd := setupCaptureServerWithConfig(t, "", server.Config{Clock: clk})
+	defer ts.Close()
+	admin := h.Admin()
+	call := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/drop-all", strings.NewReader(body))
+		req.Header.Set("X-Changed-By", "jane")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+	send := func() *httptest.ResponseRecorder {
+		body := mustMarshal(t, defaultMetricsPayload([]string{"app.requests", "system.load.1"}))
+		req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.MetricsFilter(rec, req)
+		return rec
+	}
+
+	// When the drop-all circuit is turned on for a route a while
+	rec := call("PUT", `{"enabled": true, "for": "10m", "scope": {"routes": ["/api/v1/series"]}}`)
+
+	// Then it is on until then
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"enabled": true, "until": "2022-04-15T05:40:00Z", "at": "2022-04-15T05:30:00Z", "by": "jane", "scope": {"routes": ["/api/v1/series"]}}`, call("GET", "").Body.String())
+
+	// And the requests to the route are answered as dropped without being forwarded
+	assert.Equal(t, http.StatusAccepted, send().Code)
+	assert.Len(t, resultChan, 0)
+	sd.assertCount(t, "proxy_filter.drop_all.requests.count", 1, []string{"route:/api/v1/series"}, 1, true)
+
+	// And the requests to other routes are forwarded
+	h.ServiceChecksFilter(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/check_run", strings.NewReader("[]")))
+	assert.Equal(t, "/api/v1/check_run", (<-resultChan).path)
+
+	// When the while is over
+	clk.Advance(10 * time.Minute)
+
+	// Then the requests are forwarded again
+	send()
+	assert.Equal(t, []string{"app.requests", "system.load.1"}, forwardedMetrics(t, <-resultChan))
+	assert.JSONEq(t, `{"enabled": false, "at": "2022-04-15T05:30:00Z", "by": "jane"}`, call("GET", "").Body.String())
+}
+
+func TestHandler_DropAll_CustomMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		expected []string
+	}{
+		{
+			name:     "Default standard metrics",
+			expected: []string{"system.load.1", "datadog.agent.running"},
+		},
+		{
+			name:     "Standard metrics of the config",
+			prefixes: []string{"app."},
+			expected: []string{"app.requests"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", server.Config{StandardMetricPrefixes: tc.prefixes})
+			defer ts.Close()
+
+			// When the drop-all circuit is turned on for the custom metrics
+			rec := httptest.NewRecorder()
+			h.Admin().ServeHTTP(rec, httptest.NewRequest("PUT", "/drop-all", strings.NewReader(`{"enabled": true, "scope": {"custom_metrics": true}}`)))
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			// Then only the standard metrics are forwarded
+			body := mustMarshal(t, defaultMetricsPayload([]string{"app.requests", "system.load.1", "datadog.agent.running"}))
+			req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			h.MetricsFilter(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.expected, forwardedMetrics(t, <-resultChan))
+		})
+	}
+}
+
+func TestHandler_DropAll_Maintenance(t *testing.T) {
+	// Given server is running with the drop-all circuit on
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", server.Config{})
+	defer ts.Close()
+	admin := h.Admin()
+	admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/drop-all", strings.NewReader(`{"enabled": true}`)))
+
+	// When maintenance mode is turned on
+	admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/maintenance", strings.NewReader(`{"enabled": true}`)))
+
+	// Then the requests are forwarded as they came
+	body := mustMarshal(t, defaultMetricsPayload([]string{"app.requests"}))
+	h.MetricsFilter(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body)))
+	assert.Equal(t, []string{"app.requests"}, forwardedMetrics(t, <-resultChan))
+}
+
+func TestHandler_DropAll_Invalid(t *testing.T) {
+	// Given an admin API
+	h := server.NewHandler(server.Config{}, http.DefaultClient, &stubStatsdClient{})
+
+	// When the drop-all circuit is turned on with a bad scope
+	rec := httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, httptest.NewRequest("PUT", "/drop-all", strings.NewReader(`{"enabled": true, "scope": {"routes": ["api/v1/series"]}}`)))
+
+	// Then it is rejected
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"enabled": false}`, httptestGet(h.Admin(), "/drop-all"))
+}
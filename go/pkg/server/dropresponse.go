@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DropResponse is what the proxy answers to the requests whose path matches
+// Path, using path.Match syntax, when it drops their whole payload. Agents
+// back off differently depending on the status, e.g. on 429 or 403, so it
+// can be tuned to keep them from retrying dropped data. RetryAfter, in
+// seconds, is sent as the Retry-After header when set.
+type DropResponse struct {
+	Path       string
+	Status     int
+	RetryAfter int
+	Body       string
+}
+
+// ParseDropResponse parses a response written as comma separated key=value
+// pairs with the keys path, status, retry-after and body, body taking the
+// rest of the string so it comes last, e.g. path=/api/v2/logs,status=429,body={}.
+func ParseDropResponse(s string) (DropResponse, error) {
+	var resp DropResponse
+	for rest := s; rest != ""; {
+		var field string
+		if strings.HasPrefix(rest, "body=") {
+			field, rest = rest, ""
+		} else if i := strings.Index(rest, ","); i >= 0 {
+			field, rest = rest[:i], rest[i+1:]
+		} else {
+			field, rest = rest, ""
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || (kv[1] == "" && kv[0] != "body") {
+			return DropResponse{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "path":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return DropResponse{}, newError(ErrRuleInvalid, err)
+			}
+			resp.Path = kv[1]
+		case "status":
+			status, err := strconv.Atoi(kv[1])
+			if err != nil || status < 100 || status > 599 {
+				return DropResponse{}, newError(ErrRuleInvalid, fmt.Errorf("bad status %q", kv[1]))
+			}
+			resp.Status = status
+		case "retry-after":
+			seconds, err := strconv.Atoi(kv[1])
+			if err != nil || seconds <= 0 {
+				return DropResponse{}, newError(ErrRuleInvalid, fmt.Errorf("bad retry-after %q", kv[1]))
+			}
+			resp.RetryAfter = seconds
+		case "body":
+			resp.Body = kv[1]
+		default:
+			return DropResponse{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	if resp.Path == "" || resp.Status == 0 {
+		return DropResponse{}, newError(ErrRuleInvalid, fmt.Errorf("expected a path and a status in %q", s))
+	}
+	return resp, nil
+}
+
+// writeDropped answers a request whose whole payload was dropped with the
+// first of Config.DropResponses matching its path, or with fallback.
+func (h *Handler) writeDropped(w http.ResponseWriter, r *http.Request, fallback DropResponse) {
+	resp := fallback
+	for _, dr := range h.cfg.DropResponses {
+		if ok, _ := path.Match(dr.Path, r.URL.Path); ok {
+			resp = dr
+			break
+		}
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusAccepted
+	}
+	if resp.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(resp.RetryAfter))
+	}
+	if resp.Body != "" {
+		contentType := "text/plain; charset=utf-8"
+		if json.Valid([]byte(resp.Body)) {
+			contentType = "application/json"
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.Status)
+	_, _ = io.WriteString(w, resp.Body)
+}
@@ -0,0 +1,93 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseDropResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     string
+		expected server.DropResponse
+		invalid  bool
+	}{
+		{
+			name:     "Status",
+			resp:     "path=/api/v1/*,status=403",
+			expected: server.DropResponse{Path: "/api/v1/*", Status: http.StatusForbidden},
+		},
+		{
+			name:     "Everything",
+			resp:     `path=/api/v2/logs,status=429,retry-after=30,body={"errors":["dropped, by policy"]}`,
+			expected: server.DropResponse{Path: "/api/v2/logs", Status: http.StatusTooManyRequests, RetryAfter: 30, Body: `{"errors":["dropped, by policy"]}`},
+		},
+		{
+			name:    "Missing status",
+			resp:    "path=/api/v2/logs",
+			invalid: true,
+		},
+		{
+			name:    "Bad retry after",
+			resp:    "path=/api/v2/logs,status=429,retry-after=soon",
+			invalid: true,
+		},
+		{
+			name:    "Unknown key",
+			resp:    "route=/api/v2/logs,status=429",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseDropResponse(tc.resp)
+			if tc.invalid {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestHandler_DropResponses(t *testing.T) {
+	// Given server is running with drop responses for logs and series
+	cfg := server.Config{
+		LogRules:     []server.LogRule{{Service: "web"}},
+		DropRequests: []server.RequestRule{{Path: "/api/v1/series"}},
+		DropResponses: []server.DropResponse{
+			{Path: "/api/v2/logs", Status: http.StatusTooManyRequests, RetryAfter: 30},
+			{Path: "/api/v1/*", Status: http.StatusForbidden, Body: `{"errors":["dropped"]}`},
+		},
+	}
+	_, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When every log of a request is dropped
+	req := httptest.NewRequest("POST", "/api/v2/logs", strings.NewReader(`[{"message":"hello","service":"web"}]`))
+	rec := httptest.NewRecorder()
+	h.LogsFilter(rec, req)
+
+	// Then the drop response of logs is sent
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	assert.Empty(t, rec.Body.String())
+
+	// When a request is dropped by a rule without a status
+	req = httptest.NewRequest("POST", "/api/v1/series", strings.NewReader("{}"))
+	rec = httptest.NewRecorder()
+	h.ProxyHandle(rec, req)
+
+	// Then the drop response of its path is sent
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"errors":["dropped"]}`, rec.Body.String())
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DroppedSeries is a series a filter dropped, passed to every
+// Config.DropSinks.
+type DroppedSeries struct {
+	Route  string         `json:"route,omitempty"`
+	Tenant string         `json:"tenant,omitempty"`
+	Filter string         `json:"filter"`
+	Series datadog.Series `json:"series"`
+}
+
+// DropSink receives the series the filters drop, to keep them somewhere
+// instead of discarding them. Implementations must be safe for concurrent use
+// and should not block.
+type DropSink interface {
+	Dropped(rec DroppedSeries)
+}
+
+// DropSinkFunc adapts a function to a DropSink.
+type DropSinkFunc func(rec DroppedSeries)
+
+func (f DropSinkFunc) Dropped(rec DroppedSeries) {
+	f(rec)
+}
+
+// sinkDropped passes a series the named filter dropped to the drop sinks.
+func (h *Handler) sinkDropped(ctx context.Context, filter string, series *datadog.Series) {
+	if len(h.cfg.DropSinks) == 0 {
+		return
+	}
+	rec := DroppedSeries{Filter: filter, Series: *series}
+	if meta := RequestMetaFrom(ctx); meta != nil {
+		rec.Route, rec.Tenant = meta.Route, meta.Tenant
+	}
+	for _, sink := range h.cfg.DropSinks {
+		sink.Dropped(rec)
+	}
+}
+
+// FileDropSink writes every dropped series as a line of JSON, e.g. to a local
+// file kept to check what the filters drop.
+type FileDropSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFileDropSink creates a sink writing to w.
+func NewFileDropSink(w io.Writer) *FileDropSink {
+	return &FileDropSink{w: w}
+}
+
+func (f *FileDropSink) Dropped(rec DroppedSeries) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, _ = f.w.Write(append(b, '\n'))
+}
+
+// RemoteWriteSink sends the dropped series to a Prometheus remote-write
+// endpoint, e.g. a local Prometheus or VictoriaMetrics keeping them for a
+// short while. The series are queued and sent in batches from a goroutine, the
+// ones that do not fit in the queue being lost rather than slowing requests
+// down. A series is named after its metric, with the characters Prometheus
+// does not allow replaced by _, and labelled with its host, its tags and the
+// filter that dropped it as proxy_filter.
+type RemoteWriteSink struct {
+	endpoint string
+	client   *http.Client
+	batch    int
+	interval time.Duration
+
+	queue chan DroppedSeries
+	done  chan struct{}
+	lost  int64
+}
+
+// NewRemoteWriteSink creates a sink sending to endpoint with client, queuing
+// up to size series and sending them every interval or once batch of them are
+// queued. It runs until closed.
+func NewRemoteWriteSink(endpoint string, client *http.Client, size, batch int, interval time.Duration) *RemoteWriteSink {
+	s := &RemoteWriteSink{
+		endpoint: endpoint,
+		client:   client,
+		batch:    batch,
+		interval: interval,
+		queue:    make(chan DroppedSeries, size),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *RemoteWriteSink) Dropped(rec DroppedSeries) {
+	select {
+	case s.queue <- rec:
+	default:
+		atomic.AddInt64(&s.lost, 1)
+	}
+}
+
+// Lost returns how many series did not fit in the queue.
+func (s *RemoteWriteSink) Lost() int64 {
+	return atomic.LoadInt64(&s.lost)
+}
+
+// Close sends the queued series and stops the sink. No series must be passed
+// to the sink once it is closed.
+func (s *RemoteWriteSink) Close() {
+	close(s.queue)
+	<-s.done
+}
+
+func (s *RemoteWriteSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var pending []DroppedSeries
+	for {
+		select {
+		case rec, ok := <-s.queue:
+			if !ok {
+				s.send(pending)
+				return
+			}
+			pending = append(pending, rec)
+			if len(pending) < s.batch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		s.send(pending)
+		pending = pending[:0]
+	}
+}
+
+func (s *RemoteWriteSink) send(recs []DroppedSeries) {
+	if len(recs) == 0 {
+		return
+	}
+	body := snappy.Encode(nil, encodeRemoteWrite(recs))
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Could not send %d dropped series to %s, %v", len(recs), s.endpoint, err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Could not send %d dropped series to %s, %v", len(recs), s.endpoint, err))
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		fmt.Println(fmt.Sprintf("Could not send %d dropped series to %s, got %d", len(recs), s.endpoint, resp.StatusCode))
+	}
+}
+
+// encodeRemoteWrite encodes the dropped series as a remote-write WriteRequest.
+// Tags without a value are labels set to true, and only the first value of a
+// tag repeated with several values is kept.
+func encodeRemoteWrite(recs []DroppedSeries) []byte {
+	var b []byte
+	for _, rec := range recs {
+		labels := map[string]string{promNameLabel: promName(rec.Series.Metric, true), "proxy_filter": rec.Filter}
+		if host := rec.Series.GetHost(); host != "" {
+			labels["host"] = host
+		}
+		for _, tag := range rec.Series.GetTags() {
+			key, value := tag, "true"
+			if i := strings.IndexByte(tag, ':'); i >= 0 {
+				key, value = tag[:i], tag[i+1:]
+			}
+			key = promName(key, false)
+			if _, ok := labels[key]; !ok && value != "" {
+				labels[key] = value
+			}
+		}
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var ts []byte
+		for _, name := range names {
+			var lb []byte
+			lb = protowire.AppendTag(lb, promLabelName, protowire.BytesType)
+			lb = protowire.AppendString(lb, name)
+			lb = protowire.AppendTag(lb, promLabelValue, protowire.BytesType)
+			lb = protowire.AppendString(lb, labels[name])
+			ts = protowire.AppendTag(ts, promSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		for _, point := range rec.Series.Points {
+			if len(point) < 2 || point[0] == nil || point[1] == nil {
+				continue
+			}
+			var sb []byte
+			sb = protowire.AppendTag(sb, promSampleValue, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(*point[1]))
+			sb = protowire.AppendTag(sb, promSampleTimestamp, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(int64(*point[0]*1000)))
+			ts = protowire.AppendTag(ts, promSeriesSamples, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+		b = protowire.AppendTag(b, promWriteTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
+
+// promName replaces the characters of s Prometheus does not allow in metric
+// names, or in label names when metric is false, with _.
+func promName(s string, metric bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		case c == ':' && metric:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
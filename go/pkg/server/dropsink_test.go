@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_DropSinks(t *testing.T) {
+	// Given a remote-write endpoint
+	received := make(chan []byte, 1)
+	rw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		decoded, err := snappy.Decode(nil, body)
+		if err == nil && r.Header.Get("Content-Encoding") == "snappy" {
+			received <- decoded
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer rw.Close()
+
+	// And server is running with a file and a remote-write drop sink
+	file := new(bytes.Buffer)
+	remoteWrite := server.NewRemoteWriteSink(rw.URL, rw.Client(), 10, 10, time.Hour)
+	cfg := server.Config{
+		Filter:    stubFilter{metric: "some.metric.disk"},
+		DropSinks: []server.DropSink{server.NewFileDropSink(file), remoteWrite},
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a payload with a dropped series is sent
+	payload := datadog.MetricsPayload{Series: []datadog.Series{
+		{Metric: "system.load.1", Points: [][]*float64{{datadog.PtrFloat64(1650000000), datadog.PtrFloat64(1)}}},
+		{
+			Metric: "some.metric.disk",
+			Host:   datadog.PtrString("web-1"),
+			Points: [][]*float64{{datadog.PtrFloat64(1650000000), datadog.PtrFloat64(2.5)}},
+			Tags:   &[]string{"env:prod", "device.name:sda", "env:dev", "canary"},
+		},
+	}}
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	h.MetricsFilter(httptest.NewRecorder(), req)
+	<-resultChan
+	remoteWrite.Close()
+
+	// Then the dropped series is written to the file
+	var rec server.DroppedSeries
+	require.NoError(t, json.Unmarshal(file.Bytes(), &rec))
+	assert.Equal(t, "/api/v1/series", rec.Route)
+	assert.Equal(t, "server_test.stubFilter", rec.Filter)
+	assert.Equal(t, payload.Series[1], rec.Series)
+
+	// And it is sent to the remote-write endpoint
+	expected := encodeWriteRequest(promSeries{
+		labels: [][2]string{
+			{"__name__", "some_metric_disk"},
+			{"canary", "true"},
+			{"device_name", "sda"},
+			{"env", "prod"},
+			{"host", "web-1"},
+			{"proxy_filter", "server_test.stubFilter"},
+		},
+		samples: []promSample{{value: 2.5, ms: 1650000000000}},
+	})
+	assert.Equal(t, expected, <-received)
+	assert.Equal(t, int64(0), remoteWrite.Lost())
+}
+
+func TestRemoteWriteSink_Lost(t *testing.T) {
+	// Given a sink with a queue of one series and a stuck endpoint
+	release := make(chan struct{})
+	rw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer rw.Close()
+	sink := server.NewRemoteWriteSink(rw.URL, rw.Client(), 1, 1, time.Hour)
+
+	// When more series are dropped than are sent and queued
+	for i := 0; i < 10; i++ {
+		sink.Dropped(server.DroppedSeries{Series: datadog.Series{Metric: "system.load.1"}})
+	}
+
+	// Then the others are lost
+	assert.GreaterOrEqual(t, sink.Lost(), int64(8))
+	close(release)
+	sink.Close()
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+var (
+	// ErrDecode is returned when a request payload cannot be decompressed or
+	// decoded.
+	ErrDecode = errors.New("could not decode payload")
+	// ErrEncode is returned when a filtered payload cannot be encoded or
+	// compressed again.
+	ErrEncode = errors.New("could not encode payload")
+	// ErrUpstream is returned when the request to the base endpoint cannot be
+	// created or sent.
+	ErrUpstream = errors.New("could not send request upstream")
+	// ErrRuleInvalid is returned when a filter cannot be built from its
+	// configuration.
+	ErrRuleInvalid = filter.ErrRuleInvalid
+	// ErrPlugin is returned when a filter plugin cannot be opened.
+	ErrPlugin = errors.New("could not load plugin")
+	// ErrCallout is returned when a fail closed filter callout cannot give its
+	// verdicts.
+	ErrCallout = errors.New("filter callout failed")
+)
+
+// Error ties an underlying error to one of the Err values above, so callers can
+// branch with errors.Is on the failure mode and errors.As on the cause.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v, %v", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func newError(kind, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
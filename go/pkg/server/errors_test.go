@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestError(t *testing.T) {
+	cause := &json.SyntaxError{Offset: 3}
+	err := &server.Error{Kind: server.ErrDecode, Err: cause}
+
+	assert.True(t, errors.Is(err, server.ErrDecode))
+	assert.False(t, errors.Is(err, server.ErrUpstream))
+
+	var syntaxErr *json.SyntaxError
+	require.True(t, errors.As(err, &syntaxErr))
+	assert.Equal(t, int64(3), syntaxErr.Offset)
+	assert.Equal(t, server.ErrDecode.Error()+", "+cause.Error(), err.Error())
+}
+
+func TestHandler_ErrorHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		baseEndpoint string
+		body         string
+		expectedKind error
+	}{
+		{
+			name:         "Decode",
+			body:         "{not json",
+			expectedKind: server.ErrDecode,
+		},
+		{
+			name:         "Upstream",
+			baseEndpoint: "http://127.0.0.1:0",
+			body:         `{"series":[]}`,
+			expectedKind: server.ErrUpstream,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a handler with an error handler
+			var actual error
+			cfg := server.Config{
+				BaseEndpoint:        tc.baseEndpoint,
+				MetricsPrefixFilter: "some.metric",
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					actual = err
+					w.WriteHeader(http.StatusTeapot)
+				},
+			}
+			h := server.NewHandler(cfg, http.DefaultClient, &stubStatsdClient{})
+
+			// When we make a request
+			req := httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+
+			// Then the error handler gets the failure mode
+			assert.Equal(t, http.StatusTeapot, rec.Code)
+			require.Error(t, actual)
+			assert.ErrorIs(t, actual, tc.expectedKind)
+		})
+	}
+}
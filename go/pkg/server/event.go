@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// EventRule drops the events whose title starts with TitlePrefix, whose source
+// type is Source and that carry every one of Tags.
+type EventRule struct {
+	TitlePrefix string
+	Source      string
+	Tags        []string
+}
+
+// ParseEventRule parses a rule written as comma separated key=value pairs with
+// the keys title, source and tag, tag being repeatable, e.g.
+// source=kubernetes,title=Scaled.
+func ParseEventRule(s string) (EventRule, error) {
+	var rule EventRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return EventRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "title":
+			rule.TitlePrefix = kv[1]
+		case "source":
+			rule.Source = kv[1]
+		case "tag":
+			rule.Tags = append(rule.Tags, kv[1])
+		default:
+			return EventRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the event is dropped by the rule.
+func (er EventRule) Match(title, source string, tags []string) bool {
+	if er.TitlePrefix == "" && er.Source == "" && len(er.Tags) == 0 {
+		return false
+	}
+	if !strings.HasPrefix(title, er.TitlePrefix) {
+		return false
+	}
+	if er.Source != "" && source != er.Source {
+		return false
+	}
+	return hasTags(tags, er.Tags)
+}
+
+// event is what the rules look at in an event.
+type event struct {
+	Title  string   `json:"title"`
+	Source string   `json:"source_type_name"`
+	Tags   []string `json:"tags"`
+}
+
+// EventsFilter filters events with Config.EventRules, both the single events
+// posted to /api/v1/events and the events of agent payloads posted to
+// /intake/, whose host metadata is also scrubbed with Config.MetadataRules. A
+// dropped single event is answered with 202 without being forwarded.
+func (h *Handler) EventsFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).eventsFilter)
+}
+
+func (h *Handler) eventsFilter(w http.ResponseWriter, r *http.Request) {
+	intake := strings.HasPrefix(r.URL.Path, "/intake")
+	if (len(h.cfg.EventRules) == 0 && (!intake || len(h.cfg.MetadataRules) == 0)) || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterEvents(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if buf == nil {
+		h.writeDropped(w, r, DropResponse{Status: http.StatusAccepted, Body: `{"status":"ok"}`})
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// filterEvents returns the request body without the dropped events, or nil
+// when the request only held a dropped event.
+func (h *Handler) filterEvents(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	out := body
+	var dropped int64
+	if strings.HasPrefix(r.URL.Path, "/intake") {
+		out, dropped, err = h.filterIntakeEvents(body)
+		if err == nil {
+			var scrubbed int64
+			out, scrubbed, err = h.scrubMetadata(out)
+			if len(h.cfg.MetadataRules) > 0 {
+				_ = h.statsDClient.Count(scrubbedMetadataCountName, scrubbed, h.cfg.Tags, 1)
+			}
+		}
+	} else {
+		var e event
+		err = json.Unmarshal(body, &e)
+		if err == nil && h.dropEvent(e) {
+			dropped = 1
+			out = nil
+		}
+	}
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	for i := int64(0); i < dropped; i++ {
+		meta.RecordDrop("event")
+	}
+	_ = h.statsDClient.Count(filteredEventsCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if out == nil {
+		return nil, nil
+	}
+	if bytes.Equal(out, body) {
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(out)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, nil
+}
+
+// filterIntakeEvents drops events from an agent intake payload, whose events
+// are grouped by source type, keeping every other field as it is.
+func (h *Handler) filterIntakeEvents(body []byte) ([]byte, int64, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, 0, err
+	}
+	raw, ok := payload["events"]
+	if !ok {
+		return body, 0, nil
+	}
+	var bySource map[string][]json.RawMessage
+	if err := json.Unmarshal(raw, &bySource); err != nil {
+		return nil, 0, err
+	}
+	var dropped int64
+	for source, events := range bySource {
+		kept := events[:0]
+		for _, e := range events {
+			var ev event
+			if err := json.Unmarshal(e, &ev); err != nil {
+				return nil, 0, err
+			}
+			if ev.Source == "" {
+				ev.Source = source
+			}
+			if h.dropEvent(ev) {
+				dropped++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		if len(kept) == 0 {
+			delete(bySource, source)
+			continue
+		}
+		bySource[source] = kept
+	}
+	if dropped == 0 {
+		return body, 0, nil
+	}
+	raw, err := json.Marshal(bySource)
+	if err != nil {
+		return nil, 0, err
+	}
+	payload["events"] = raw
+	out, err := json.Marshal(payload)
+	return out, dropped, err
+}
+
+func (h *Handler) dropEvent(e event) bool {
+	for _, rule := range h.cfg.EventRules {
+		if rule.Match(e.Title, e.Source, e.Tags) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseEventRule(t *testing.T) {
+	rule, err := server.ParseEventRule("source=kubernetes,title=Scaled,tag=env:dev")
+	require.NoError(t, err)
+	assert.Equal(t, server.EventRule{TitlePrefix: "Scaled", Source: "kubernetes", Tags: []string{"env:dev"}}, rule)
+
+	for _, s := range []string{"", "title=", "host=web-1"} {
+		_, err = server.ParseEventRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_EventsFilter(t *testing.T) {
+	rules := []server.EventRule{{Source: "kubernetes", TitlePrefix: "Scaled"}}
+	tests := []struct {
+		name            string
+		path            string
+		body            string
+		expectedStatus  int
+		expectedBody    string
+		expectedDropped int64
+	}{
+		{
+			name:           "Kept event",
+			path:           "/api/v1/events",
+			body:           `{"title":"Deployed web","text":"v1.2.3","source_type_name":"kubernetes"}`,
+			expectedStatus: http.StatusTeapot,
+			expectedBody:   `{"title":"Deployed web","text":"v1.2.3","source_type_name":"kubernetes"}`,
+		},
+		{
+			name:            "Dropped event",
+			path:            "/api/v1/events",
+			body:            `{"title":"Scaled up replica set web-6d4 to 3","source_type_name":"kubernetes"}`,
+			expectedStatus:  http.StatusAccepted,
+			expectedDropped: 1,
+		},
+		{
+			name: "Intake events",
+			path: "/intake/",
+			body: `{"internalHostname":"web-1","events":{
+				"kubernetes":[{"title":"Scaled up replica set web-6d4 to 3"},{"title":"Deployed web"}],
+				"autoscaler":[{"title":"Scaled down node group","source_type_name":"kubernetes"}],
+				"docker":[{"title":"Scaled container"}]
+			}}`,
+			expectedStatus: http.StatusTeapot,
+			expectedBody: `{"internalHostname":"web-1","events":{
+				"kubernetes":[{"title":"Deployed web"}],
+				"docker":[{"title":"Scaled container"}]
+			}}`,
+			expectedDropped: 2,
+		},
+		{
+			name:           "Intake without events",
+			path:           "/intake/",
+			body:           `{"internalHostname":"web-1","gohai":"{}"}`,
+			expectedStatus: http.StatusTeapot,
+			expectedBody:   `{"internalHostname":"web-1","gohai":"{}"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with event rules
+			cfg := server.Config{EventRules: rules, Tags: []string{"one"}}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When events are sent
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.EventsFilter(rec, req)
+
+			// Then only the kept events are forwarded
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedBody != "" {
+				actual := <-resultChan
+				assert.JSONEq(t, tc.expectedBody, actual.body)
+			}
+			sc.assertCount(t, "proxy_filter.filtered_events.count", tc.expectedDropped, []string{"one"}, 1, true)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// BackendMode decides which response the client gets when requests are sent
+// to several backends.
+type BackendMode string
+
+const (
+	// PrimaryWins answers with the response of the primary backend as soon
+	// as it comes, whatever the others answer. It is the default.
+	PrimaryWins BackendMode = "primary-wins"
+	// AnySuccess answers with the first successful response, preferring the
+	// primary, or the response of the primary if none succeeded.
+	AnySuccess BackendMode = "any-success"
+	// AllSuccess answers with the response of the primary if every backend
+	// succeeded, or else with the first failure.
+	AllSuccess BackendMode = "all-success"
+)
+
+// ParseBackendMode returns the BackendMode named s, an empty s being
+// PrimaryWins.
+func ParseBackendMode(s string) (BackendMode, error) {
+	switch m := BackendMode(s); m {
+	case "":
+		return PrimaryWins, nil
+	case PrimaryWins, AnySuccess, AllSuccess:
+		return m, nil
+	}
+	return "", newError(ErrRuleInvalid, fmt.Errorf("unknown backend mode %q", s))
+}
+
+// BackendStatus is the health of a backend as seen from the requests sent to
+// it.
+type BackendStatus struct {
+	Endpoint            string        `json:"endpoint"`
+	Healthy             bool          `json:"healthy"`
+	ConsecutiveFailures int64         `json:"consecutive_failures"`
+	Failures            int64         `json:"failures"`
+	LastSuccess         time.Time     `json:"last_success"`
+	LastFailure         time.Time     `json:"last_failure"`
+	LastLatency         time.Duration `json:"last_latency"`
+}
+
+type backendTracker struct {
+	mu       sync.Mutex
+	statuses []BackendStatus
+}
+
+func newBackendTracker(endpoints []string) *backendTracker {
+	b := &backendTracker{statuses: make([]BackendStatus, len(endpoints))}
+	for i := range endpoints {
+		b.statuses[i] = BackendStatus{Endpoint: endpoints[i], Healthy: true}
+	}
+	return b
+}
+
+func (b *backendTracker) record(i int, ok bool, at time.Time, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &b.statuses[i]
+	s.LastLatency = latency
+	if ok {
+		s.Healthy, s.ConsecutiveFailures, s.LastSuccess = true, 0, at
+		return
+	}
+	s.Healthy = false
+	s.ConsecutiveFailures++
+	s.Failures++
+	s.LastFailure = at
+}
+
+func (b *backendTracker) list() []BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BackendStatus(nil), b.statuses...)
+}
+
+// Backends returns the health of the primary and the other backends, in the
+// order they were configured, or nil when there are no other backends.
+func (h *Handler) Backends() []BackendStatus {
+	backends := h.current().backends
+	if backends == nil {
+		return nil
+	}
+	return backends.list()
+}
+
+// BackendStatus serves Backends as JSON, mount it on the admin listener.
+func (h *Handler) BackendStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.Backends())
+}
+
+type backendResult struct {
+	resp *http.Response
+	err  error
+}
+
+func (br backendResult) ok() bool {
+	return br.err == nil && br.resp.StatusCode >= 200 && br.resp.StatusCode <= 299
+}
+
+// defaultBackendTimeout bounds the requests to the other backends the client
+// does not wait for when Config.BackendTimeout is not set.
+const defaultBackendTimeout = 30 * time.Second
+
+// fanOut sends the request to the primary and every other backend at once and
+// answers the client according to Config.BackendMode. With PrimaryWins the
+// client is answered as soon as the primary is, the other backends being sent
+// to in the background.
+func (h *Handler) fanOut(w http.ResponseWriter, r *http.Request, body io.ReadCloser) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = io.ReadAll(body)
+		_ = body.Close()
+		if err != nil {
+			h.writeError(w, r, http.StatusInternalServerError, newError(ErrUpstream, err))
+			return
+		}
+	}
+	endpoints := append([]string{h.cfg.BaseEndpoint}, h.cfg.Backends...)
+	waited := len(endpoints)
+	if h.cfg.BackendMode == PrimaryWins || h.cfg.BackendMode == "" {
+		waited = 1
+	}
+	reqs := make([]*http.Request, len(endpoints))
+	cancels := make([]context.CancelFunc, len(endpoints))
+	for i := range endpoints {
+		ctx, cancel := r.Context(), context.CancelFunc(func() {})
+		if i >= waited {
+			// The request outlives the one of the client, within its timeout.
+			ctx, cancel = context.WithTimeout(context.Background(), h.backendTimeout())
+		}
+		req, err := newUpstreamRequest(ctx, r, endpoints[i]+r.URL.Path, bytes.NewReader(payload))
+		if err != nil {
+			cancel()
+			for _, c := range cancels[:i] {
+				c()
+			}
+			h.writeError(w, r, http.StatusInternalServerError, newError(ErrUpstream, err))
+			return
+		}
+		reqs[i], cancels[i] = req, cancel
+	}
+
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	for i := waited; i < len(reqs); i++ {
+		go h.sendInBackground(r, i, reqs[i], cancels[i])
+	}
+	results := make([]backendResult, waited)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = h.sendToBackend(r, i, reqs[i])
+		}(i)
+	}
+	wg.Wait()
+	meta.RecordTiming("upstream", clock.Since(h.clock, start))
+
+	chosen := h.chooseResult(results)
+	for i := range results {
+		if results[i].resp != nil && i != chosen {
+			_, _ = io.Copy(io.Discard, results[i].resp.Body)
+			_ = results[i].resp.Body.Close()
+		}
+	}
+	if results[chosen].err != nil {
+		h.writeError(w, r, http.StatusBadGateway, newError(ErrUpstream, results[chosen].err))
+		return
+	}
+	resp := results[chosen].resp
+	defer resp.Body.Close()
+	h.writeResponse(w, r, resp)
+	fmt.Println(fmt.Sprintf("Sent request to %s on %d backends, got %d from %s, dropped %v, timings %v", r.URL.Path, len(endpoints), resp.StatusCode, endpoints[chosen], meta.Dropped(), meta.Timings()))
+}
+
+func (h *Handler) backendTimeout() time.Duration {
+	if h.cfg.BackendTimeout > 0 {
+		return h.cfg.BackendTimeout
+	}
+	return defaultBackendTimeout
+}
+
+// sendInBackground sends req to backend i without the client waiting for its
+// response, which is only recorded, cancel ending it.
+func (h *Handler) sendInBackground(r *http.Request, i int, req *http.Request, cancel context.CancelFunc) {
+	defer cancel()
+	res := h.sendToBackend(r, i, req)
+	if res.resp != nil {
+		_, _ = io.Copy(io.Discard, res.resp.Body)
+		_ = res.resp.Body.Close()
+	}
+}
+
+func (h *Handler) sendToBackend(r *http.Request, i int, req *http.Request) backendResult {
+	start := h.clock.Now()
+	resp, err := h.httpClient.Do(req)
+	res := backendResult{resp: resp, err: err}
+	if err == nil {
+		h.countUpstreamResponse(r, resp.StatusCode, "backend:"+req.URL.Host)
+	}
+	if !res.ok() {
+		status := "error"
+		if err == nil {
+			status = fmt.Sprintf("%d", resp.StatusCode)
+		}
+		_ = h.statsDClient.Count(backendFailuresCountName, 1, h.tags("route:"+routePattern(r), "backend:"+req.URL.Host, "status_code:"+status), 1)
+	}
+	h.backends.record(i, res.ok(), h.clock.Now(), clock.Since(h.clock, start))
+	return res
+}
+
+// chooseResult returns the index of the result to answer the client with, the
+// primary being first.
+func (h *Handler) chooseResult(results []backendResult) int {
+	switch h.cfg.BackendMode {
+	case AnySuccess:
+		for i := range results {
+			if results[i].ok() {
+				return i
+			}
+		}
+	case AllSuccess:
+		for i := range results {
+			if !results[i].ok() {
+				return i
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,188 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseBackendMode(t *testing.T) {
+	for s, expected := range map[string]server.BackendMode{
+		"":             server.PrimaryWins,
+		"primary-wins": server.PrimaryWins,
+		"any-success":  server.AnySuccess,
+		"all-success":  server.AllSuccess,
+	} {
+		actual, err := server.ParseBackendMode(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+	_, err := server.ParseBackendMode("majority")
+	assert.ErrorIs(t, err, server.ErrRuleInvalid)
+}
+
+func TestHandler_Backends(t *testing.T) {
+	tests := []struct {
+		name            string
+		mode            server.BackendMode
+		primaryStatus   int
+		secondaryStatus int
+		expectedStatus  int
+		expectedBody    string
+		expectedHealthy []bool
+	}{
+		{
+			name:            "Primary wins over failed secondary",
+			mode:            server.PrimaryWins,
+			primaryStatus:   http.StatusAccepted,
+			secondaryStatus: http.StatusServiceUnavailable,
+			expectedStatus:  http.StatusAccepted,
+			expectedBody:    "primary",
+			expectedHealthy: []bool{true, false},
+		},
+		{
+			name:            "Primary wins when it fails",
+			mode:            server.PrimaryWins,
+			primaryStatus:   http.StatusServiceUnavailable,
+			secondaryStatus: http.StatusAccepted,
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedBody:    "primary",
+			expectedHealthy: []bool{false, true},
+		},
+		{
+			name:            "Any success",
+			mode:            server.AnySuccess,
+			primaryStatus:   http.StatusServiceUnavailable,
+			secondaryStatus: http.StatusAccepted,
+			expectedStatus:  http.StatusAccepted,
+			expectedBody:    "secondary",
+			expectedHealthy: []bool{false, true},
+		},
+		{
+			name:            "All success with a failure",
+			mode:            server.AllSuccess,
+			primaryStatus:   http.StatusAccepted,
+			secondaryStatus: http.StatusServiceUnavailable,
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedBody:    "secondary",
+			expectedHealthy: []bool{true, false},
+		},
+		{
+			name:            "All success",
+			mode:            server.AllSuccess,
+			primaryStatus:   http.StatusAccepted,
+			secondaryStatus: http.StatusOK,
+			expectedStatus:  http.StatusAccepted,
+			expectedBody:    "primary",
+			expectedHealthy: []bool{true, true},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a primary and a secondary backend
+			backend := func(name string, status int, received chan string) *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					received <- string(body)
+					w.WriteHeader(status)
+					_, _ = io.WriteString(w, name)
+				}))
+			}
+			primaryReceived, secondaryReceived := make(chan string, 1), make(chan string, 1)
+			primary := backend("primary", tc.primaryStatus, primaryReceived)
+			defer primary.Close()
+			secondary := backend("secondary", tc.secondaryStatus, secondaryReceived)
+			defer secondary.Close()
+
+			// And the proxy sends to both
+			sc := &stubStatsdClient{}
+			cfg := server.Config{BaseEndpoint: primary.URL, Backends: []string{secondary.URL}, BackendMode: tc.mode, Tags: []string{"one"}}
+			h := server.NewHandler(cfg, http.DefaultClient, sc)
+
+			// When we make a request
+			rec := httptest.NewRecorder()
+			h.ProxyHandle(rec, httptest.NewRequest("POST", "/api/v1/series", strings.NewReader("{payload}")))
+
+			// Then both backends got the payload
+			assert.Equal(t, "{payload}", <-primaryReceived)
+			assert.Equal(t, "{payload}", <-secondaryReceived)
+
+			// And the client gets the response chosen by the mode
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedBody, rec.Body.String())
+
+			// And the secondary was answered, in the background with primary-wins
+			require.Eventually(t, func() bool {
+				s := h.Backends()[1]
+				return !s.LastSuccess.IsZero() || !s.LastFailure.IsZero()
+			}, time.Second, 10*time.Millisecond)
+
+			// And the failure is counted
+			sc.assertCount(t, "proxy_filter.backend_failures.count", 1, []string{"one", "route:/api/v1/series", "backend:" + strings.TrimPrefix(primary.URL, "http://"), "status_code:503"}, 1, !tc.expectedHealthy[0])
+			sc.assertCount(t, "proxy_filter.backend_failures.count", 1, []string{"one", "route:/api/v1/series", "backend:" + strings.TrimPrefix(secondary.URL, "http://"), "status_code:503"}, 1, !tc.expectedHealthy[1])
+
+			// And the health of the backends is served
+			rec = httptest.NewRecorder()
+			h.BackendStatus(rec, httptest.NewRequest("GET", "/backends", nil))
+			var statuses []server.BackendStatus
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+			require.Len(t, statuses, 2)
+			for i, s := range statuses {
+				assert.Equal(t, []string{primary.URL, secondary.URL}[i], s.Endpoint)
+				assert.Equal(t, tc.expectedHealthy[i], s.Healthy)
+			}
+		})
+	}
+}
+
+func TestHandler_BackendsPrimaryWinsInBackground(t *testing.T) {
+	// Given a primary and a secondary backend that does not answer
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer primary.Close()
+	release, cancelled := make(chan struct{}), make(chan struct{})
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client went away once the body is read
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-release:
+		}
+	}))
+	defer secondary.Close()
+	defer close(release)
+
+	// And the proxy sends to both, giving the secondary 50ms
+	cfg := server.Config{BaseEndpoint: primary.URL, Backends: []string{secondary.URL}, BackendMode: server.PrimaryWins, BackendTimeout: 50 * time.Millisecond}
+	h := server.NewHandler(cfg, http.DefaultClient, &stubStatsdClient{})
+
+	// When we make a request
+	rec := httptest.NewRecorder()
+	h.ProxyHandle(rec, httptest.NewRequest("POST", "/api/v1/series", strings.NewReader("{payload}")))
+
+	// Then the client gets the response of the primary without waiting for
+	// the secondary
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.True(t, h.Backends()[1].LastFailure.IsZero())
+
+	// And the request to the secondary is cancelled once it times out
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request to the secondary was not cancelled")
+	}
+	require.Eventually(t, func() bool {
+		return !h.Backends()[1].Healthy
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// FDUsage is how many file descriptors the process has open, how many of them
+// are sockets, and how many it may open.
+type FDUsage struct {
+	Open    int `json:"open"`
+	Sockets int `json:"sockets"`
+	Limit   int `json:"limit"`
+}
+
+// fdState remembers whether the last check found the process close to running
+// out of file descriptors.
+type fdState struct {
+	mu        sync.Mutex
+	exhausted bool
+}
+
+// CheckFDs reads the file descriptor usage of the process and sends it as
+// gauges, when the statsd client can send gauges. Once more than
+// Config.FDWarnRatio of the limit is open the proxy reports itself as not
+// ready, until usage drops below it again. Call it periodically.
+func (h *Handler) CheckFDs() (FDUsage, error) {
+	usage, err := processFDs()
+	if err != nil {
+		return FDUsage{}, err
+	}
+	if g, ok := h.statsDClient.(gauger); ok {
+		_ = g.Gauge(fdsOpenGaugeName, float64(usage.Open), h.cfg.Tags, 1)
+		_ = g.Gauge(fdsSocketsGaugeName, float64(usage.Sockets), h.cfg.Tags, 1)
+		_ = g.Gauge(fdsLimitGaugeName, float64(usage.Limit), h.cfg.Tags, 1)
+	}
+	if h.cfg.FDWarnRatio <= 0 || usage.Limit <= 0 {
+		return usage, nil
+	}
+	exhausted := float64(usage.Open) > h.cfg.FDWarnRatio*float64(usage.Limit)
+	h.fds.mu.Lock()
+	changed := exhausted != h.fds.exhausted
+	h.fds.exhausted = exhausted
+	h.fds.mu.Unlock()
+	if changed && exhausted {
+		fmt.Println(fmt.Sprintf("Running out of file descriptors, %d of %d open of which %d sockets, reporting not ready", usage.Open, usage.Limit, usage.Sockets))
+	} else if changed {
+		fmt.Println(fmt.Sprintf("File descriptors back to %d of %d open, reporting ready", usage.Open, usage.Limit))
+	}
+	return usage, nil
+}
+
+// Ready reports if the proxy can take more traffic, which it cannot once it
+// is shutting down, when the last CheckFDs found it about to run out of file
+// descriptors, or when ProbeUpstream failed too many times in a row.
+func (h *Handler) Ready() bool {
+	if atomic.LoadInt32(&h.health.shuttingDown) == 1 {
+		return false
+	}
+	if _, down := h.upstreamProbe(); down {
+		return false
+	}
+	h.fds.mu.Lock()
+	defer h.fds.mu.Unlock()
+	return !h.fds.exhausted
+}
+
+// Readiness answers 200 when Ready and 503 otherwise, for load balancer and
+// orchestrator probes.
+func (h *Handler) Readiness(w http.ResponseWriter, _ *http.Request) {
+	if !h.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// FDStatus serves the current file descriptor usage as JSON.
+func (h *Handler) FDStatus(w http.ResponseWriter, _ *http.Request) {
+	usage, err := processFDs()
+	if err != nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		_, _ = fmt.Fprintf(w, "%v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(usage)
+}
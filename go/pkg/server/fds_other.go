@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package server
+
+import (
+	"errors"
+)
+
+func processFDs() (FDUsage, error) {
+	return FDUsage{}, errors.New("file descriptor usage is not supported on this platform")
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_CheckFDs(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("file descriptor usage is only read on linux and darwin")
+	}
+	tests := []struct {
+		name          string
+		warnRatio     float64
+		expectedReady bool
+	}{
+		{
+			name:          "Disabled",
+			expectedReady: true,
+		},
+		{
+			name:          "Below threshold",
+			warnRatio:     1,
+			expectedReady: true,
+		},
+		{
+			name:      "Above threshold",
+			warnRatio: 1e-9,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a proxy with a file descriptor threshold
+			sc := &stubGaugeClient{}
+			h := server.NewHandler(server.Config{FDWarnRatio: tc.warnRatio, Tags: []string{"one", "two"}}, http.DefaultClient, sc)
+			admin := h.Admin()
+
+			// When file descriptors are checked
+			usage, err := h.CheckFDs()
+			require.NoError(t, err)
+
+			// Then the usage is reported
+			assert.Greater(t, usage.Open, 0)
+			assert.Greater(t, usage.Limit, 0)
+			assert.Equal(t, float64(usage.Open), sc.gauges["proxy_filter.fds.open one"])
+			assert.Equal(t, float64(usage.Limit), sc.gauges["proxy_filter.fds.limit one"])
+
+			// And readiness flips once above the threshold
+			assert.Equal(t, tc.expectedReady, h.Ready())
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+			if tc.expectedReady {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func processFDs() (FDUsage, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return FDUsage{}, err
+	}
+	dir := "/proc/self/fd"
+	if _, err := os.Stat(dir); err != nil {
+		dir = "/dev/fd"
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return FDUsage{}, err
+	}
+	// The directory being read holds a descriptor of its own.
+	usage := FDUsage{Open: len(entries) - 1, Limit: int(limit.Cur)}
+	for _, e := range entries {
+		if target, err := os.Readlink(filepath.Join(dir, e.Name())); err == nil && strings.HasPrefix(target, "socket:") {
+			usage.Sockets++
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+)
+
+// AgentStatus is what the proxy saw of one agent flushing through it, an
+// agent being told apart by its host and the hash of its API key.
+type AgentStatus struct {
+	Tenant     string    `json:"tenant,omitempty"`
+	Host       string    `json:"host"`
+	APIKeyHash string    `json:"api_key_hash,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Requests   int64     `json:"requests"`
+	Errors     int64     `json:"errors"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	// RequestsPerMinute and ErrorRate are averaged since FirstSeen.
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+	ErrorRate         float64 `json:"error_rate"`
+}
+
+type fleetKey struct {
+	tenant, host, apiKeyHash string
+}
+
+// fleetTracker keeps the status of at most max agents, forgetting the one
+// seen the longest ago to make room for a new one.
+type fleetTracker struct {
+	mu     sync.Mutex
+	max    int
+	agents map[fleetKey]*AgentStatus
+}
+
+func newFleetTracker(max int) *fleetTracker {
+	return &fleetTracker{max: max, agents: make(map[fleetKey]*AgentStatus)}
+}
+
+// record counts the request of an agent, as an error when answered with a
+// 5xx or 4xx status.
+func (f *fleetTracker) record(r *http.Request, status int, at time.Time) {
+	key := fleetKey{tenant: tenantOf(r), host: agentHost(r)}
+	if apiKey := r.Header.Get("DD-API-KEY"); apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		key.apiKeyHash = hex.EncodeToString(sum[:8])
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.agents[key]
+	if !ok {
+		if len(f.agents) >= f.max {
+			f.evictOldest()
+		}
+		a = &AgentStatus{Tenant: key.tenant, Host: key.host, APIKeyHash: key.apiKeyHash, FirstSeen: at}
+		f.agents[key] = a
+	}
+	if v := agent.VersionFrom(r.Context()); v.Known() {
+		a.Version = v.String()
+	}
+	a.Requests++
+	if status >= http.StatusBadRequest {
+		a.Errors++
+	}
+	a.LastSeen = at
+}
+
+func (f *fleetTracker) evictOldest() {
+	var oldest fleetKey
+	var found bool
+	for k, a := range f.agents {
+		if !found || a.LastSeen.Before(f.agents[oldest].LastSeen) {
+			oldest, found = k, true
+		}
+	}
+	delete(f.agents, oldest)
+}
+
+func (f *fleetTracker) statuses(now time.Time) []AgentStatus {
+	f.mu.Lock()
+	res := make([]AgentStatus, 0, len(f.agents))
+	for _, a := range f.agents {
+		res = append(res, *a)
+	}
+	f.mu.Unlock()
+	for i := range res {
+		a := &res[i]
+		if d := now.Sub(a.FirstSeen); d > 0 {
+			a.RequestsPerMinute = float64(a.Requests) / d.Minutes()
+		}
+		a.ErrorRate = float64(a.Errors) / float64(a.Requests)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.APIKeyHash < b.APIKeyHash
+	})
+	return res
+}
+
+// agentHost is the hostname the agent reports, or the address it connects
+// from when it reports none.
+func agentHost(r *http.Request) string {
+	if host := r.Header.Get("X-Datadog-Hostname"); host != "" {
+		return host
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Fleet returns the agents seen since the handler was created, sorted by
+// tenant and host. It is empty unless Config.MaxAgents is set.
+func (h *Handler) Fleet() []AgentStatus {
+	if h.fleet == nil {
+		return nil
+	}
+	return h.fleet.statuses(h.clock.Now())
+}
+
+// FleetStatus serves Fleet as JSON, only showing the agents of their own
+// tenant to tenant scoped admin tokens.
+func (h *Handler) FleetStatus(w http.ResponseWriter, r *http.Request) {
+	agents := h.Fleet()
+	if tenant, scoped := AdminTenantFrom(r.Context()); scoped {
+		own := agents[:0]
+		for i := range agents {
+			if agents[i].Tenant == tenant {
+				own = append(own, agents[i])
+			}
+		}
+		agents = own
+	}
+	if agents == nil {
+		agents = []AgentStatus{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(agents)
+}
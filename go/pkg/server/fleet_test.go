@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Fleet(t *testing.T) {
+	// Given an upstream failing some requests and a proxy tracking two agents
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/check_run" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	h := server.NewHandler(server.Config{BaseEndpoint: ts.URL, MaxAgents: 2, Clock: clk}, ts.Client(), &stubStatsdClient{})
+
+	// When three agents flush through it, the first one the longest ago
+	for _, req := range []struct {
+		host, apiKey, path string
+	}{
+		{host: "old", apiKey: "key-a", path: "/api/v1/series"},
+		{host: "web-1", apiKey: "key-a", path: "/api/v1/series"},
+		{host: "web-1", apiKey: "key-a", path: "/api/v1/check_run"},
+		{host: "web-2", apiKey: "key-b", path: "/api/v1/series"},
+		{host: "web-1", apiKey: "key-a", path: "/api/v1/series"},
+	} {
+		clk.Advance(time.Minute)
+		r := httptest.NewRequest("POST", req.path, nil)
+		r.Header.Set("X-Datadog-Hostname", req.host)
+		r.Header.Set("DD-API-KEY", req.apiKey)
+		r.Header.Set("User-Agent", "datadog-agent/7.40.1")
+		h.ProxyHandle(httptest.NewRecorder(), r)
+	}
+
+	// Then the two agents seen last are served with their rates
+	rec := httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, httptest.NewRequest("GET", "/agents", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var actual []server.AgentStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&actual))
+	hash := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:8])
+	}
+	assert.Equal(t, []server.AgentStatus{
+		{
+			Host:              "web-1",
+			APIKeyHash:        hash("key-a"),
+			Version:           "7.40.1",
+			Requests:          3,
+			Errors:            1,
+			FirstSeen:         start.Add(2 * time.Minute),
+			LastSeen:          start.Add(5 * time.Minute),
+			RequestsPerMinute: 1,
+			ErrorRate:         1.0 / 3,
+		},
+		{
+			Host:              "web-2",
+			APIKeyHash:        hash("key-b"),
+			Version:           "7.40.1",
+			Requests:          1,
+			FirstSeen:         start.Add(4 * time.Minute),
+			LastSeen:          start.Add(4 * time.Minute),
+			RequestsPerMinute: 1,
+		},
+	}, actual)
+}
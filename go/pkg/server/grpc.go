@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// isGRPC reports whether r is a gRPC call, which needs HTTP/2 up to the
+// upstream, its body and response streamed and its trailers forwarded.
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// newGRPCTransport returns the transport forwarding gRPC calls to endpoint,
+// over HTTP/2 negotiated with TLS by the transport of the client for https
+// endpoints, or over HTTP/2 in cleartext for http ones.
+func newGRPCTransport(endpoint string, httpClient *http.Client) http.RoundTripper {
+	if strings.HasPrefix(endpoint, "http://") {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}
+	}
+	if httpClient == nil || httpClient.Transport == nil {
+		return http.DefaultTransport
+	}
+	return httpClient.Transport
+}
+
+// proxyGRPC forwards a gRPC call to the base endpoint as it comes, streaming
+// the request and response bodies and forwarding the response trailers.
+func (h *Handler) proxyGRPC(w http.ResponseWriter, r *http.Request) {
+	target, err := url.Parse(h.cfg.BaseEndpoint)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, newError(ErrUpstream, err))
+		return
+	}
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = target.Path + req.URL.Path
+			req.Host = target.Host
+		},
+		Transport: h.grpcTransport,
+		// Streamed responses are flushed as they come.
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			h.countUpstreamResponse(r, resp.StatusCode)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			h.writeError(w, r, http.StatusBadGateway, newError(ErrUpstream, err))
+		},
+	}
+	rp.ServeHTTP(w, r)
+}
@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_ProxyHandle_GRPC(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+	})
+	tests := []struct {
+		name  string
+		start func() *httptest.Server
+	}{
+		{
+			name: "TLS",
+			start: func() *httptest.Server {
+				ts := httptest.NewUnstartedServer(upstream)
+				ts.EnableHTTP2 = true
+				ts.StartTLS()
+				return ts
+			},
+		},
+		{
+			name: "Cleartext",
+			start: func() *httptest.Server {
+				return httptest.NewServer(h2c.NewHandler(upstream, &http2.Server{}))
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a gRPC upstream
+			ts := tc.start()
+			defer ts.Close()
+
+			// And server is running
+			h := server.NewHandler(server.Config{BaseEndpoint: ts.URL}, ts.Client(), &stubStatsdClient{})
+
+			// When a gRPC call is made
+			req := httptest.NewRequest("POST", "/grpc.Service/Method", bytes.NewBufferString("\x00\x00\x00\x00\x02hi"))
+			req.Header.Set("Content-Type", "application/grpc")
+			rec := httptest.NewRecorder()
+			h.ProxyHandle(rec, req)
+
+			// Then it reaches upstream over HTTP/2 with its trailers returned
+			res := rec.Result()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "\x00\x00\x00\x00\x02hi", string(body))
+			assert.Equal(t, "0", res.Trailer.Get("Grpc-Status"))
+		})
+	}
+}
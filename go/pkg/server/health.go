@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthState is the state of the process the health probes report.
+type healthState struct {
+	started      time.Time
+	shuttingDown int32
+
+	mu           sync.Mutex
+	probe        *UpstreamProbe
+	upstreamDown bool
+}
+
+// HealthReport is what /healthz and /readyz answer with.
+type HealthReport struct {
+	// Status is ok, or unavailable when a readiness check failed.
+	Status       string    `json:"status"`
+	Started      time.Time `json:"started"`
+	ShuttingDown bool      `json:"shutting_down"`
+	Maintenance  bool      `json:"maintenance"`
+	DropAll      bool      `json:"drop_all"`
+	// Upstream is the last ProbeUpstream, if any.
+	Upstream *UpstreamProbe `json:"upstream,omitempty"`
+	// Checks are the readiness checks, ok or why they failed.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Health returns the liveness and readiness probes, /healthz and /readyz, to
+// mount on their own listener so that orchestrators reach them without the
+// admin API tokens. /healthz answers 200 as long as the proxy serves
+// requests. /readyz answers 503 when it should not be sent traffic: once it
+// is shutting down, when it is about to run out of file descriptors, when
+// ProbeUpstream failed too many times in a row, or when it cannot connect to
+// the base endpoint within Config.UpstreamCheckTimeout.
+func (h *Handler) Health() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.Healthz)
+	mux.HandleFunc("/readyz", h.Readyz)
+	return mux
+}
+
+// Healthz serves the state of the proxy as JSON, for liveness probes.
+func (h *Handler) Healthz(w http.ResponseWriter, _ *http.Request) {
+	h.writeHealth(w, h.healthReport(nil))
+}
+
+// Readyz serves the state of the proxy and its readiness checks as JSON, with
+// 503 when one of them failed.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	c := h.current()
+	checks := map[string]string{"shutdown": "ok", "file_descriptors": "ok"}
+	if atomic.LoadInt32(&h.health.shuttingDown) == 1 {
+		checks["shutdown"] = "shutting down"
+	}
+	h.fds.mu.Lock()
+	if h.fds.exhausted {
+		checks["file_descriptors"] = "running out of file descriptors"
+	}
+	h.fds.mu.Unlock()
+	if probe, down := h.upstreamProbe(); probe != nil {
+		checks["upstream_probe"] = "ok"
+		if down {
+			checks["upstream_probe"] = fmt.Sprintf("%d probes failed in a row, %s", probe.ConsecutiveFailures, probe.Error)
+		}
+	}
+	if timeout := c.cfg.UpstreamCheckTimeout; timeout > 0 {
+		checks["upstream"] = "ok"
+		if err := dialUpstream(r.Context(), c.cfg.BaseEndpoint, timeout); err != nil {
+			checks["upstream"] = err.Error()
+		}
+	}
+	h.writeHealth(w, h.healthReport(checks))
+}
+
+// BeginShutdown reports the proxy as not ready from then on, so that load
+// balancers stop sending it traffic while it drains.
+func (h *Handler) BeginShutdown() {
+	atomic.StoreInt32(&h.health.shuttingDown, 1)
+}
+
+func (h *Handler) healthReport(checks map[string]string) HealthReport {
+	now := h.clock.Now()
+	report := HealthReport{
+		Status:       "ok",
+		Started:      h.health.started,
+		ShuttingDown: atomic.LoadInt32(&h.health.shuttingDown) == 1,
+		Maintenance:  h.maintenance.enabled(now),
+		DropAll:      h.dropAll.enabled(now),
+		Checks:       checks,
+	}
+	report.Upstream, _ = h.upstreamProbe()
+	for _, result := range checks {
+		if result != "ok" {
+			report.Status = "unavailable"
+		}
+	}
+	return report
+}
+
+func (h *Handler) writeHealth(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// dialUpstream opens, and closes, a TCP connection to the host of endpoint.
+func dialUpstream(ctx context.Context, endpoint string, timeout time.Duration) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
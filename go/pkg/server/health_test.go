@@ -0,0 +1,100 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Health(t *testing.T) {
+	// An address nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := "http://" + l.Addr().String()
+	require.NoError(t, l.Close())
+
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	tests := []struct {
+		name           string
+		endpoint       string
+		timeout        time.Duration
+		shutdown       bool
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{
+			name:           "Ready",
+			endpoint:       upstream.URL,
+			timeout:        time.Second,
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"shutdown": "ok", "file_descriptors": "ok", "upstream": "ok"},
+		},
+		{
+			name:           "Upstream not checked",
+			endpoint:       closed,
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"shutdown": "ok", "file_descriptors": "ok"},
+		},
+		{
+			name:           "Upstream unreachable",
+			endpoint:       closed,
+			timeout:        time.Second,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Shutting down",
+			endpoint:       upstream.URL,
+			timeout:        time.Second,
+			shutdown:       true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"shutdown": "shutting down", "file_descriptors": "ok", "upstream": "ok"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a proxy checking its upstream
+			started := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+			h := server.NewHandler(server.Config{BaseEndpoint: tc.endpoint, UpstreamCheckTimeout: tc.timeout, Clock: clock.NewFake(started)}, http.DefaultClient, &stubStatsdClient{})
+			if tc.shutdown {
+				h.BeginShutdown()
+			}
+			probes := h.Health()
+
+			// When it is probed for liveness
+			rec := httptest.NewRecorder()
+			probes.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+			// Then it is alive
+			assert.Equal(t, http.StatusOK, rec.Code)
+			var report server.HealthReport
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+			assert.Equal(t, server.HealthReport{Status: "ok", Started: started, ShuttingDown: tc.shutdown}, report)
+
+			// When it is probed for readiness
+			rec = httptest.NewRecorder()
+			probes.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+			// Then the checks are reported
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			report = server.HealthReport{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+			if tc.expectedChecks != nil {
+				assert.Equal(t, tc.expectedChecks, report.Checks)
+			} else {
+				assert.NotEqual(t, "ok", report.Checks["upstream"])
+			}
+			assert.Equal(t, !tc.shutdown, h.Ready())
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// Field numbers of the container image and SBOM messages, as defined by the
+// agent payload protos.
+const (
+	contImagePayloadImages = 2
+
+	contImageName     = 2
+	contImageRegistry = 3
+
+	sbomPayloadEntities = 4
+
+	sbomEntityID = 2
+)
+
+// ImageRule drops the container images, and their SBOMs, pulled from a
+// registry matching Registry and whose repository sits in a namespace
+// matching Namespace, both using path.Match syntax. The namespace of
+// registry.example.com/team/tools/app is team/tools, and images without a
+// registry come from docker.io. The fields left empty match any image.
+type ImageRule struct {
+	Registry  string
+	Namespace string
+}
+
+// ParseImageRule parses a rule written as comma separated key=value pairs with
+// the keys registry and namespace, e.g. registry=*.internal.example.com.
+func ParseImageRule(s string) (ImageRule, error) {
+	var rule ImageRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return ImageRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		if _, err := path.Match(kv[1], ""); err != nil {
+			return ImageRule{}, newError(ErrRuleInvalid, err)
+		}
+		switch kv[0] {
+		case "registry":
+			rule.Registry = kv[1]
+		case "namespace":
+			rule.Namespace = kv[1]
+		default:
+			return ImageRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the image of the given registry and namespace is
+// dropped by the rule.
+func (ir ImageRule) Match(registry, namespace string) bool {
+	if ir.Registry == "" && ir.Namespace == "" {
+		return false
+	}
+	if ir.Registry != "" {
+		if ok, _ := path.Match(ir.Registry, registry); !ok {
+			return false
+		}
+	}
+	if ir.Namespace != "" {
+		if ok, _ := path.Match(ir.Namespace, namespace); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitImage splits an image reference such as
+// registry.example.com/team/app:1.2@sha256:... into its registry and
+// namespace, following the docker conventions for references without a
+// registry.
+func splitImage(ref string) (registry, namespace string) {
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		ref = ref[:i]
+	}
+	parts := strings.Split(ref, "/")
+	registry = "docker.io"
+	if len(parts) > 1 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		registry, parts = parts[0], parts[1:]
+	}
+	if registry == "docker.io" && len(parts) == 1 {
+		return registry, "library"
+	}
+	return registry, strings.Join(parts[:len(parts)-1], "/")
+}
+
+// ImagesFilter filters the container image metadata sent to /api/v2/contimage
+// and the SBOMs sent to /api/v2/sbom with Config.ImageRules.
+func (h *Handler) ImagesFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).imagesFilter)
+}
+
+func (h *Handler) imagesFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.ImageRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterImages(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// filterImages returns the request body without the dropped images or SBOMs.
+// Every other field is copied as it is.
+func (h *Handler) filterImages(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	field, kind := protowire.Number(contImagePayloadImages), "image"
+	if strings.HasSuffix(r.URL.Path, "/sbom") {
+		field, kind = sbomPayloadEntities, "sbom"
+	}
+	var dropped int64
+	out, err := filterMessages(body, func(num protowire.Number, v []byte) ([]byte, bool, error) {
+		if num != field {
+			return v, true, nil
+		}
+		registry, namespace, err := decodeImage(v, kind == "sbom")
+		if err != nil {
+			return nil, false, err
+		}
+		if h.dropImage(registry, namespace) {
+			meta.RecordDrop(kind)
+			dropped++
+			return nil, false, nil
+		}
+		return v, true, nil
+	})
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	_ = h.statsDClient.Count(filteredImagesCountName, dropped, h.tags("kind:"+kind), 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if dropped == 0 {
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(out)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, nil
+}
+
+// decodeImage decodes the registry and namespace of a container image, or of
+// the image an SBOM entity is about when sbom is set.
+func decodeImage(b []byte, sbom bool) (registry, namespace string, err error) {
+	var name string
+	err = walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch {
+		case sbom && num == sbomEntityID, !sbom && num == contImageName:
+			name = string(v)
+		case !sbom && num == contImageRegistry:
+			registry = string(v)
+		}
+		return nil
+	})
+	reg, namespace := splitImage(name)
+	if registry == "" {
+		registry = reg
+	}
+	return registry, namespace, err
+}
+
+func (h *Handler) dropImage(registry, namespace string) bool {
+	for _, rule := range h.cfg.ImageRules {
+		if rule.Match(registry, namespace) {
+			return true
+		}
+	}
+	return false
+}
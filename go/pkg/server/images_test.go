@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+// encodeContImages encodes a ContainerImagePayload with images of the names.
+func encodeContImages(names ...string) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, "v1")
+	for _, name := range names {
+		var d []byte
+		d = protowire.AppendTag(d, 1, protowire.BytesType)
+		d = protowire.AppendString(d, "sha256:"+name)
+		d = protowire.AppendTag(d, 2, protowire.BytesType)
+		d = protowire.AppendString(d, name)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	return b
+}
+
+// encodeSBOMs encodes an SBOMPayload with entities about the images.
+func encodeSBOMs(ids ...string) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, "web-1")
+	for _, id := range ids {
+		var d []byte
+		d = protowire.AppendTag(d, 1, protowire.VarintType)
+		d = protowire.AppendVarint(d, 0)
+		d = protowire.AppendTag(d, 2, protowire.BytesType)
+		d = protowire.AppendString(d, id)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	return b
+}
+
+func TestParseImageRule(t *testing.T) {
+	rule, err := server.ParseImageRule("registry=*.internal.example.com,namespace=platform/*")
+	require.NoError(t, err)
+	assert.Equal(t, server.ImageRule{Registry: "*.internal.example.com", Namespace: "platform/*"}, rule)
+
+	for _, s := range []string{"", "registry=", "namespace=[", "tag=latest"} {
+		_, err = server.ParseImageRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_ImagesFilter(t *testing.T) {
+	rules := []server.ImageRule{{Registry: "*.internal.example.com"}, {Registry: "docker.io", Namespace: "library"}, {Namespace: "secret/*"}}
+	tests := []struct {
+		name     string
+		path     string
+		body     []byte
+		expected []byte
+		dropped  int64
+		kind     string
+	}{
+		{
+			name: "Container images",
+			path: "/api/v2/contimage",
+			body: encodeContImages(
+				"registry.internal.example.com/team/app:1.2",
+				"gcr.io/datadoghq/agent:7",
+				"redis",
+				"ghcr.io/secret/tools/builder",
+				"acme/web@sha256:abc",
+			),
+			expected: encodeContImages("gcr.io/datadoghq/agent:7", "acme/web@sha256:abc"),
+			dropped:  3,
+			kind:     "image",
+		},
+		{
+			name:     "SBOMs",
+			path:     "/api/v2/sbom",
+			body:     encodeSBOMs("registry.internal.example.com/team/app@sha256:abc", "gcr.io/datadoghq/agent@sha256:def"),
+			expected: encodeSBOMs("gcr.io/datadoghq/agent@sha256:def"),
+			dropped:  1,
+			kind:     "sbom",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with image rules
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", server.Config{ImageRules: rules, Tags: []string{"one"}})
+			defer ts.Close()
+
+			// When the agent sends images
+			req := httptest.NewRequest("POST", tc.path, bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			rec := httptest.NewRecorder()
+			h.ImagesFilter(rec, req)
+
+			// Then only the kept images are forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, tc.expected, []byte(actual.body))
+			sc.assertCount(t, "proxy_filter.filtered_images.count", tc.dropped, []string{"one", "kind:" + tc.kind}, 1, true)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// kafkaContentType is the content type of the JSON records of the Kafka REST
+// Proxy v2 API.
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// KafkaSink publishes series to Kafka through a Kafka REST Proxy, as JSON
+// records keyed by metric name. It is an Exporter publishing the forwarded
+// series to Topic and a DropSink publishing the dropped ones, with the filter
+// that dropped them, to DroppedTopic, either being disabled when empty. It is
+// also a DecisionSink publishing the decision record of every request, keyed
+// by route, to DecisionTopic when set. With Partitions set, a record goes to
+// the partition of the hash of its key, otherwise Kafka picks the partition
+// from the key. The records are
+// queued and published in batches from a goroutine, the ones that do not fit
+// in the queue being lost rather than slowing requests down.
+type KafkaSink struct {
+	endpoint      string
+	client        *http.Client
+	topic         string
+	droppedTopic  string
+	decisionTopic string
+	partitions    int
+	batch         int
+	interval      time.Duration
+
+	queue chan kafkaRecord
+	done  chan struct{}
+	lost  int64
+}
+
+// KafkaConfig configures a KafkaSink.
+type KafkaConfig struct {
+	// Endpoint is the base URL of the Kafka REST Proxy, e.g.
+	// http://127.0.0.1:8082.
+	Endpoint      string
+	Topic         string
+	DroppedTopic  string
+	DecisionTopic string
+	Partitions    int
+	// QueueSize records are queued at most, and published every
+	// FlushInterval or once BatchSize of them are queued.
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+type kafkaRecord struct {
+	topic string
+	key   string
+	value interface{}
+}
+
+// NewKafkaSink creates a sink publishing with client. It runs until closed.
+func NewKafkaSink(cfg KafkaConfig, client *http.Client) *KafkaSink {
+	k := &KafkaSink{
+		endpoint:      strings.TrimSuffix(cfg.Endpoint, "/"),
+		client:        client,
+		topic:         cfg.Topic,
+		droppedTopic:  cfg.DroppedTopic,
+		decisionTopic: cfg.DecisionTopic,
+		partitions:    cfg.Partitions,
+		batch:         cfg.BatchSize,
+		interval:      cfg.FlushInterval,
+		queue:         make(chan kafkaRecord, cfg.QueueSize),
+		done:          make(chan struct{}),
+	}
+	go k.run()
+	return k
+}
+
+func (k *KafkaSink) Export(series datadog.Series) {
+	if k.topic != "" {
+		k.enqueue(kafkaRecord{topic: k.topic, key: series.Metric, value: series})
+	}
+}
+
+func (k *KafkaSink) Dropped(rec DroppedSeries) {
+	if k.droppedTopic != "" {
+		k.enqueue(kafkaRecord{topic: k.droppedTopic, key: rec.Series.Metric, value: rec})
+	}
+}
+
+func (k *KafkaSink) Record(rec FilterDecisionRecord) {
+	if k.decisionTopic != "" {
+		k.enqueue(kafkaRecord{topic: k.decisionTopic, key: rec.Route, value: rec})
+	}
+}
+
+func (k *KafkaSink) enqueue(rec kafkaRecord) {
+	select {
+	case k.queue <- rec:
+	default:
+		atomic.AddInt64(&k.lost, 1)
+	}
+}
+
+// Lost returns how many records did not fit in the queue.
+func (k *KafkaSink) Lost() int64 {
+	return atomic.LoadInt64(&k.lost)
+}
+
+// Close publishes the queued records and stops the sink. No series or records
+// must be passed to the sink once it is closed.
+func (k *KafkaSink) Close() {
+	close(k.queue)
+	<-k.done
+}
+
+func (k *KafkaSink) run() {
+	defer close(k.done)
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	var pending []kafkaRecord
+	for {
+		select {
+		case rec, ok := <-k.queue:
+			if !ok {
+				k.publish(pending)
+				return
+			}
+			pending = append(pending, rec)
+			if len(pending) < k.batch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		k.publish(pending)
+		pending = pending[:0]
+	}
+}
+
+// kafkaProduce is the body of a Kafka REST Proxy v2 produce request.
+type kafkaProduce struct {
+	Records []kafkaProduceRecord `json:"records"`
+}
+
+type kafkaProduceRecord struct {
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value"`
+	Partition *int        `json:"partition,omitempty"`
+}
+
+// publish sends the records in one request per topic.
+func (k *KafkaSink) publish(recs []kafkaRecord) {
+	var topics []string
+	byTopic := make(map[string][]kafkaProduceRecord)
+	for _, rec := range recs {
+		if _, ok := byTopic[rec.topic]; !ok {
+			topics = append(topics, rec.topic)
+		}
+		pr := kafkaProduceRecord{Key: rec.key, Value: rec.value}
+		if k.partitions > 0 {
+			p := kafkaPartition(rec.key, k.partitions)
+			pr.Partition = &p
+		}
+		byTopic[rec.topic] = append(byTopic[rec.topic], pr)
+	}
+	for _, topic := range topics {
+		if err := k.produce(topic, byTopic[topic]); err != nil {
+			fmt.Println(fmt.Sprintf("Could not publish %d records to Kafka topic %s, %v", len(byTopic[topic]), topic, err))
+		}
+	}
+}
+
+func (k *KafkaSink) produce(topic string, recs []kafkaProduceRecord) error {
+	body, err := json.Marshal(kafkaProduce{Records: recs})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, k.endpoint+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("got %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// kafkaPartition returns the partition of a metric name, the same for every
+// series of a metric.
+func kafkaPartition(name string, partitions int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return int(h.Sum32() % uint32(partitions))
+}
@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type kafkaRecord struct {
+	Key       string                 `json:"key"`
+	Value     map[string]interface{} `json:"value"`
+	Partition *int                   `json:"partition"`
+}
+
+func TestKafkaSink(t *testing.T) {
+	tests := []struct {
+		name       string
+		partitions int
+	}{
+		{name: "Key partitioning"},
+		{name: "Hash partitioning", partitions: 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a Kafka REST Proxy
+			published := make(map[string][]kafkaRecord)
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				var produce struct {
+					Records []kafkaRecord `json:"records"`
+				}
+				if r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" || json.Unmarshal(body, &produce) != nil {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					return
+				}
+				published[r.URL.Path] = append(published[r.URL.Path], produce.Records...)
+			}))
+			defer proxy.Close()
+
+			// And server is running publishing the forwarded and dropped series
+			sink := server.NewKafkaSink(server.KafkaConfig{
+				Endpoint:      proxy.URL + "/",
+				Topic:         "metrics",
+				DroppedTopic:  "dropped",
+				DecisionTopic: "decisions",
+				Partitions:    tc.partitions,
+				QueueSize:     10,
+				BatchSize:     10,
+				FlushInterval: time.Hour,
+			}, proxy.Client())
+			cfg := server.Config{MetricsPrefixFilter: "some.metric", Exporters: []server.Exporter{sink}, DropSinks: []server.DropSink{sink}, DecisionSinks: []server.DecisionSink{sink}}
+			resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When a payload is sent
+			body, err := json.Marshal(defaultMetricsPayload([]string{"system.load.1", "some.metric.disk"}))
+			require.NoError(t, err)
+			req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			h.MetricsFilter(httptest.NewRecorder(), req)
+			<-resultChan
+			sink.Close()
+
+			// Then each series is published to its topic keyed by metric name
+			require.Len(t, published["/topics/metrics"], 1)
+			kept := published["/topics/metrics"][0]
+			assert.Equal(t, "system.load.1", kept.Key)
+			assert.Equal(t, "system.load.1", kept.Value["metric"])
+			require.Len(t, published["/topics/dropped"], 1)
+			dropped := published["/topics/dropped"][0]
+			assert.Equal(t, "some.metric.disk", dropped.Key)
+			assert.Equal(t, "/api/v1/series", dropped.Value["route"])
+			assert.Equal(t, "some.metric.disk", dropped.Value["series"].(map[string]interface{})["metric"])
+
+			// And the decision record of the request is published keyed by route
+			require.Len(t, published["/topics/decisions"], 1)
+			decision := published["/topics/decisions"][0]
+			assert.Equal(t, "/api/v1/series", decision.Key)
+			assert.Equal(t, map[string]interface{}{"metric=some.metric": float64(1)}, decision.Value["dropped"])
+
+			// And to the partition of its name when partitioned
+			if tc.partitions == 0 {
+				assert.Nil(t, kept.Partition)
+				return
+			}
+			require.NotNil(t, kept.Partition)
+			hash := fnv.New32a()
+			_, _ = hash.Write([]byte("system.load.1"))
+			assert.Equal(t, int(hash.Sum32()%uint32(tc.partitions)), *kept.Partition)
+			assert.Equal(t, int64(0), sink.Lost())
+		})
+	}
+}
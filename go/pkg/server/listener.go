@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// overloadedResponse is written to connections refused by a LimitListener,
+// without reading their request, so clients back off instead of retrying at
+// once.
+const overloadedResponse = "HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\nContent-Length: 0\r\nRetry-After: 1\r\n\r\n"
+
+// ListenerLimits caps the connections a LimitListener accepts.
+type ListenerLimits struct {
+	// AcceptRate is how many connections are accepted per second, with bursts
+	// of up to AcceptBurst. There is no rate limit when it is 0.
+	AcceptRate  float64
+	AcceptBurst int
+	// MaxConns is how many connections may be open at once, no limit when 0.
+	MaxConns int
+}
+
+// LimitListener refuses connections over its limits with an immediate 503,
+// before they reach the HTTP server, so a flood of new connections, e.g. from
+// agents restarting together, cannot exhaust file descriptors.
+type LimitListener struct {
+	net.Listener
+	limits       ListenerLimits
+	clock        clock.Clock
+	statsDClient StatsdClient
+	tags         []string
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	open   int
+}
+
+// NewLimitListener wraps l, counting the refused connections with
+// statsDClient.
+func NewLimitListener(l net.Listener, limits ListenerLimits, clk clock.Clock, statsDClient StatsdClient, tags []string) *LimitListener {
+	if limits.AcceptBurst < 1 {
+		limits.AcceptBurst = 1
+	}
+	return &LimitListener{
+		Listener:     l,
+		limits:       limits,
+		clock:        clk,
+		statsDClient: statsDClient,
+		tags:         tags,
+		tokens:       float64(limits.AcceptBurst),
+		last:         clk.Now(),
+	}
+}
+
+func (l *LimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		reason := l.admit()
+		if reason == "" {
+			return &limitedConn{Conn: c, release: l.release}, nil
+		}
+		_ = l.statsDClient.Count(rejectedConnectionsCountName, 1, append(append([]string{}, l.tags...), "reason:"+reason), 1)
+		go refuse(c)
+	}
+}
+
+// admit takes a connection slot, returning why there is none otherwise.
+func (l *LimitListener) admit() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.MaxConns > 0 && l.open >= l.limits.MaxConns {
+		return "max_conns"
+	}
+	if l.limits.AcceptRate > 0 {
+		now := l.clock.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.limits.AcceptRate
+		if burst := float64(l.limits.AcceptBurst); l.tokens > burst {
+			l.tokens = burst
+		}
+		l.last = now
+		if l.tokens < 1 {
+			return "accept_rate"
+		}
+		l.tokens--
+	}
+	l.open++
+	return ""
+}
+
+func (l *LimitListener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.open--
+}
+
+func refuse(c net.Conn) {
+	_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.Write([]byte(overloadedResponse))
+	_ = c.Close()
+}
+
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestLimitListener(t *testing.T) {
+	tests := []struct {
+		name           string
+		limits         server.ListenerLimits
+		expectedReason string
+	}{
+		{
+			name:           "Max connections",
+			limits:         server.ListenerLimits{MaxConns: 1},
+			expectedReason: "reason:max_conns",
+		},
+		{
+			name:           "Accept rate",
+			limits:         server.ListenerLimits{AcceptRate: 1},
+			expectedReason: "reason:accept_rate",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given a server behind a limited listener
+			clk := clock.NewFake(time.Date(2022, 4, 15, 10, 0, 0, 0, time.UTC))
+			sc := &stubStatsdClient{}
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			l := server.NewLimitListener(inner, tc.limits, clk, sc, []string{"one"})
+			hs := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "ok")
+			})}
+			go func() { _ = hs.Serve(l) }()
+			defer hs.Close()
+
+			get := func(c net.Conn) *http.Response {
+				_, err := io.WriteString(c, "GET / HTTP/1.1\r\nHost: proxy\r\n\r\n")
+				require.NoError(t, err)
+				resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+				require.NoError(t, err)
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				return resp
+			}
+
+			// When a client opens a connection
+			first, err := net.Dial("tcp", inner.Addr().String())
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, get(first).StatusCode)
+
+			// Then the next one is refused straight away
+			second, err := net.Dial("tcp", inner.Addr().String())
+			require.NoError(t, err)
+			resp, err := http.ReadResponse(bufio.NewReader(second), nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			assert.True(t, resp.Close)
+			_ = second.Close()
+			sc.assertCount(t, "proxy_filter.rejected_connections.count", 1, []string{"one", tc.expectedReason}, 1, true)
+
+			// And connections are accepted again once the first one is closed
+			// and a second has passed
+			_ = first.Close()
+			clk.Advance(time.Second)
+			assert.Eventually(t, func() bool {
+				c, err := net.Dial("tcp", inner.Addr().String())
+				if err != nil {
+					return false
+				}
+				defer c.Close()
+				return get(c).StatusCode == http.StatusOK
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
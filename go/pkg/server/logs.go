@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// LogRule drops the logs matching every field it sets. Status is compared
+// ignoring case, Tags must all be among the ddtags of the log and
+// MessagePattern must match part of its message.
+type LogRule struct {
+	Service        string
+	Source         string
+	Status         string
+	Tags           []string
+	MessagePattern *regexp.Regexp
+}
+
+// ParseLogRule parses a rule written as comma separated key=value pairs with
+// the keys service, source, status, tag and message, tag being repeatable,
+// e.g. service=web,status=debug or source=nginx,message=GET /healthz. The
+// message key must come last as its regex takes the rest of the rule, commas
+// included.
+func ParseLogRule(s string) (LogRule, error) {
+	if s == "" {
+		return LogRule{}, newError(ErrRuleInvalid, fmt.Errorf("empty rule"))
+	}
+	var rule LogRule
+	rest := s
+	for rest != "" {
+		var field string
+		if strings.HasPrefix(rest, "message=") {
+			field, rest = rest, ""
+		} else {
+			field = rest
+			if i := strings.IndexByte(rest, ','); i >= 0 {
+				field, rest = rest[:i], rest[i+1:]
+			} else {
+				rest = ""
+			}
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return LogRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "service":
+			rule.Service = kv[1]
+		case "source":
+			rule.Source = kv[1]
+		case "status":
+			rule.Status = kv[1]
+		case "tag":
+			rule.Tags = append(rule.Tags, kv[1])
+		case "message":
+			re, err := regexp.Compile(kv[1])
+			if err != nil {
+				return LogRule{}, newError(ErrRuleInvalid, err)
+			}
+			rule.MessagePattern = re
+		default:
+			return LogRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// logEntry is what the rules look at in a log.
+type logEntry struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Service string `json:"service"`
+	Source  string `json:"ddsource"`
+	Tags    string `json:"ddtags"`
+}
+
+func (lr LogRule) match(l logEntry) bool {
+	if lr.Service == "" && lr.Source == "" && lr.Status == "" && len(lr.Tags) == 0 && lr.MessagePattern == nil {
+		return false
+	}
+	if lr.Service != "" && l.Service != lr.Service {
+		return false
+	}
+	if lr.Source != "" && l.Source != lr.Source {
+		return false
+	}
+	if lr.Status != "" && !strings.EqualFold(l.Status, lr.Status) {
+		return false
+	}
+	if len(lr.Tags) > 0 && !hasTags(strings.Split(l.Tags, ","), lr.Tags) {
+		return false
+	}
+	return lr.MessagePattern == nil || lr.MessagePattern.MatchString(l.Message)
+}
+
+// LogsFilter filters the logs sent to /api/v2/logs with Config.LogRules. Kept
+// logs are forwarded as they came, and requests left without any log are
+// answered without being forwarded, with 202 unless Config.DropResponses says
+// otherwise.
+func (h *Handler) LogsFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).logsFilter)
+}
+
+func (h *Handler) logsFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.LogRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterLogs(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if buf == nil {
+		h.writeDropped(w, r, DropResponse{Status: http.StatusAccepted, Body: "{}"})
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// filterLogs returns the request body without the dropped logs, or nil when
+// every log was dropped.
+func (h *Handler) filterLogs(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	// The intake takes a single log as well as an array of them.
+	var logs []json.RawMessage
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		logs = []json.RawMessage{trimmed}
+	} else if err = json.Unmarshal(body, &logs); err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	kept := make([]json.RawMessage, 0, len(logs))
+	for _, raw := range logs {
+		var l logEntry
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		if h.dropLog(l) {
+			meta.RecordDrop("log")
+			continue
+		}
+		kept = append(kept, raw)
+	}
+	_ = h.statsDClient.Count(filteredLogsCountName, int64(len(logs)-len(kept)), h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	if len(kept) == len(logs) {
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	err = json.NewEncoder(rw).Encode(kept)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, nil
+}
+
+func (h *Handler) dropLog(l logEntry) bool {
+	for _, rule := range h.cfg.LogRules {
+		if rule.match(l) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseLogRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		expected server.LogRule
+		invalid  bool
+	}{
+		{
+			name:     "Service and status",
+			rule:     "service=web,status=debug",
+			expected: server.LogRule{Service: "web", Status: "debug"},
+		},
+		{
+			name:     "Source, tags and message with commas",
+			rule:     "source=nginx,tag=env:dev,message=GET /healthz(,|$)",
+			expected: server.LogRule{Source: "nginx", Tags: []string{"env:dev"}, MessagePattern: regexp.MustCompile("GET /healthz(,|$)")},
+		},
+		{
+			name:    "Bad message",
+			rule:    "message=(",
+			invalid: true,
+		},
+		{
+			name:    "Unknown key",
+			rule:    "host=web-1",
+			invalid: true,
+		},
+		{
+			name:    "Empty",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseLogRule(tc.rule)
+			if tc.invalid {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestHandler_LogsFilter(t *testing.T) {
+	rules := []server.LogRule{
+		{Service: "web", Status: "debug"},
+		{Source: "nginx", MessagePattern: regexp.MustCompile(`GET /healthz `)},
+		{Tags: []string{"env:dev", "team:sandbox"}},
+	}
+	tests := []struct {
+		name            string
+		body            string
+		gzip            bool
+		expectedBody    string
+		expectedDropped int64
+	}{
+		{
+			name: "Array of logs",
+			body: `[
+				{"message":"starting","status":"DEBUG","service":"web"},
+				{"message":"started","status":"info","service":"web"},
+				{"message":"GET /healthz 200","ddsource":"nginx","service":"web"},
+				{"message":"GET /api 200","ddsource":"nginx","service":"web"},
+				{"message":"hello","ddtags":"env:dev,team:sandbox,host:a"},
+				{"message":"hello","ddtags":"env:dev,team:web"}
+			]`,
+			expectedBody: `[
+				{"message":"started","status":"info","service":"web"},
+				{"message":"GET /api 200","ddsource":"nginx","service":"web"},
+				{"message":"hello","ddtags":"env:dev,team:web"}
+			]`,
+			expectedDropped: 3,
+		},
+		{
+			name:         "Single log gzip",
+			body:         `{"message":"started","status":"info","service":"web"}`,
+			gzip:         true,
+			expectedBody: `{"message":"started","status":"info","service":"web"}`,
+		},
+		{
+			name:            "Every log dropped",
+			body:            `[{"message":"starting","status":"debug","service":"web"}]`,
+			expectedDropped: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with log rules
+			cfg := server.Config{LogRules: rules, Tags: []string{"one"}}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When logs are sent
+			body := []byte(tc.body)
+			if tc.gzip {
+				buf := new(bytes.Buffer)
+				zw := gzip.NewWriter(buf)
+				_, _ = zw.Write(body)
+				_ = zw.Close()
+				body = buf.Bytes()
+			}
+			req := httptest.NewRequest("POST", "/api/v2/logs", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			rec := httptest.NewRecorder()
+			h.LogsFilter(rec, req)
+
+			// Then only the kept logs are forwarded
+			sc.assertCount(t, "proxy_filter.filtered_logs.count", tc.expectedDropped, []string{"one"}, 1, true)
+			if tc.expectedBody == "" {
+				assert.Equal(t, http.StatusAccepted, rec.Code)
+				return
+			}
+			assert.Equal(t, http.StatusTeapot, rec.Code)
+			actual := <-resultChan
+			forwarded := actual.body
+			if tc.gzip {
+				zr, err := gzip.NewReader(strings.NewReader(actual.body))
+				require.NoError(t, err)
+				b, err := io.ReadAll(zr)
+				require.NoError(t, err)
+				forwarded = string(b)
+			}
+			assert.JSONEq(t, tc.expectedBody, forwarded)
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package server
+
+import "net/http"
+
+// Maintenance serves the maintenance switch of the admin API. In maintenance
+// mode filtering is off, telling the proxy apart from the cause of an
+// incident without restarting it: requests are forwarded as they came, no
+// content type check, drop rule, filter or transform applying to them,
+// upstream responses are sent back as they came, and DogStatsD packets are
+// forwarded whole. Mirroring and archiving carry on.
+func (h *Handler) Maintenance(w http.ResponseWriter, r *http.Request) {
+	h.serveToggle(w, r, h.maintenance, "Maintenance mode", nil)
+}
@@ -0,0 +1,116 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Maintenance(t *testing.T) {
+	// Given server is running with a prefix filter and drop rules for requests
+	at := time.Date(2022, 4, 15, 5, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(at)
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		DropRequests:        []server.RequestRule{{Path: "/api/v1/check_run"}},
+		Clock:               clk,
+	}
+	resultChan, ts, h, sd := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+	admin := h.Admin()
+	call := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/maintenance", strings.NewReader(body))
+		req.Header.Set("X-Changed-By", "jane")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+	send := func() []string {
+		body := mustMarshal(t, defaultMetricsPayload([]string{"some.metric.load", "system.load.1"}))
+		req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.MetricsFilter(httptest.NewRecorder(), req)
+		return forwardedMetrics(t, <-resultChan)
+	}
+
+	// When maintenance mode is turned on for a while
+	rec := call("PUT", `{"enabled": true, "for": "15m"}`)
+
+	// Then it is on until then
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"enabled": true, "until": "2022-04-15T05:45:00Z", "at": "2022-04-15T05:30:00Z", "by": "jane"}`, call("GET", "").Body.String())
+
+	// And series are forwarded without being filtered
+	assert.Equal(t, []string{"some.metric.load", "system.load.1"}, send())
+	sd.assertCount(t, "proxy_filter.maintenance.requests.count", 1, []string{"route:/api/v1/series"}, 1, true)
+
+	// And requests are not dropped
+	h.ServiceChecksFilter(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/check_run", strings.NewReader("[]")))
+	assert.Equal(t, "/api/v1/check_run", (<-resultChan).path)
+
+	// When the while is over
+	clk.Advance(15 * time.Minute)
+
+	// Then filtering is back on
+	assert.Equal(t, []string{"system.load.1"}, send())
+	assert.JSONEq(t, `{"enabled": false, "at": "2022-04-15T05:30:00Z", "by": "jane"}`, call("GET", "").Body.String())
+
+	// When maintenance mode is turned on then off
+	call("PUT", `{"enabled": true}`)
+	assert.Equal(t, []string{"some.metric.load", "system.load.1"}, send())
+	call("PUT", `{"enabled": false}`)
+
+	// Then filtering is back on
+	assert.Equal(t, []string{"system.load.1"}, send())
+}
+
+func TestHandler_Maintenance_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "Bad duration",
+			method:         "PUT",
+			body:           `{"enabled": true, "for": "soon"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not JSON",
+			method:         "PUT",
+			body:           "on",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown method",
+			method:         "POST",
+			body:           `{"enabled": true}`,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an admin API
+			h := server.NewHandler(server.Config{}, http.DefaultClient, &stubStatsdClient{})
+
+			// When an invalid request is made
+			rec := httptest.NewRecorder()
+			h.Admin().ServeHTTP(rec, httptest.NewRequest(tc.method, "/maintenance", strings.NewReader(tc.body)))
+
+			// Then it is rejected
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.JSONEq(t, `{"enabled": false}`, httptestGet(h.Admin(), "/maintenance"))
+		})
+	}
+}
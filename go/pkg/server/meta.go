@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type requestMetaKey struct{}
+
+// RequestMeta holds what the proxy learns about a request as it goes through
+// the handler, the filters and the forwarder. A nil *RequestMeta is valid and
+// ignores everything recorded on it.
+type RequestMeta struct {
+	Route  string
+	Tenant string
+	// RuleSet is the version of the rule set the request is handled with,
+	// as listed by RuleVersions.
+	RuleSet string
+	Start   time.Time
+
+	mu      sync.Mutex
+	dropped map[string]int64
+	audited map[string]int64
+	timings map[string]time.Duration
+}
+
+// NewRequestMeta creates the metadata for a request to route starting at start.
+func NewRequestMeta(route string, start time.Time) *RequestMeta {
+	return &RequestMeta{
+		Route:   route,
+		Start:   start,
+		dropped: make(map[string]int64),
+		timings: make(map[string]time.Duration),
+	}
+}
+
+// WithRequestMeta returns a copy of ctx carrying meta.
+func WithRequestMeta(ctx context.Context, meta *RequestMeta) context.Context {
+	return context.WithValue(ctx, requestMetaKey{}, meta)
+}
+
+// RequestMetaFrom returns the metadata carried by ctx, or nil if there is none.
+func RequestMetaFrom(ctx context.Context) *RequestMeta {
+	meta, _ := ctx.Value(requestMetaKey{}).(*RequestMeta)
+	return meta
+}
+
+func tenantOf(r *http.Request) string {
+	if meta := RequestMetaFrom(r.Context()); meta != nil {
+		return meta.Tenant
+	}
+	return ""
+}
+
+// RecordDrop counts a series dropped by the named filter.
+func (m *RequestMeta) RecordDrop(filter string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped[filter]++
+}
+
+// RecordAudit counts a series an audit filter would have dropped.
+func (m *RequestMeta) RecordAudit(filter string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.audited == nil {
+		m.audited = make(map[string]int64)
+	}
+	m.audited[filter]++
+}
+
+// RecordTiming adds d to the time spent in the named stage.
+func (m *RequestMeta) RecordTiming(stage string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timings[stage] += d
+}
+
+// Dropped returns a copy of the series dropped so far keyed by filter.
+func (m *RequestMeta) Dropped() map[string]int64 {
+	res := make(map[string]int64)
+	if m == nil {
+		return res
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range m.dropped {
+		res[k] = v
+	}
+	return res
+}
+
+// Audited returns a copy of the series audit filters would have dropped so
+// far keyed by filter, nil when there are none.
+func (m *RequestMeta) Audited() map[string]int64 {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.audited) == 0 {
+		return nil
+	}
+	res := make(map[string]int64, len(m.audited))
+	for k, v := range m.audited {
+		res[k] = v
+	}
+	return res
+}
+
+// Timings returns a copy of the time spent so far keyed by stage.
+func (m *RequestMeta) Timings() map[string]time.Duration {
+	res := make(map[string]time.Duration)
+	if m == nil {
+		return res
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range m.timings {
+		res[k] = v
+	}
+	return res
+}
+
+// withRequestMeta makes sure r carries a RequestMeta, reusing one set by an
+// outer layer if present.
+func withRequestMeta(r *http.Request, start time.Time) (*http.Request, *RequestMeta) {
+	if meta := RequestMetaFrom(r.Context()); meta != nil {
+		return r, meta
+	}
+	meta := NewRequestMeta(r.URL.Path, start)
+	return r.WithContext(WithRequestMeta(r.Context(), meta)), meta
+}
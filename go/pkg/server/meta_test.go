@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestRequestMeta_Nil(t *testing.T) {
+	var meta *server.RequestMeta
+	meta.RecordDrop("prefix")
+	meta.RecordTiming("decode", time.Second)
+	assert.Empty(t, meta.Dropped())
+	assert.Empty(t, meta.Timings())
+	assert.Nil(t, server.RequestMetaFrom(context.Background()))
+}
+
+func TestHandler_MetricsFilter_RequestMeta(t *testing.T) {
+	// Given server is running with a prefix and a series filter
+	sf := &metaFilter{}
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		Filter:              sf,
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// And an outer layer that sets the request metadata
+	meta := server.NewRequestMeta("/api/v1/series", time.Now())
+	meta.Tenant = "team-a"
+
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(defaultMetricsPayload([]string{"metric.one", "some.metric.load", "metric.two"}))
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/series", b)
+	req = req.WithContext(server.WithRequestMeta(req.Context(), meta))
+	rec := httptest.NewRecorder()
+
+	// When we make the request
+	h.MetricsFilter(rec, req)
+	<-resultChan
+
+	// Then the filters and forwarder saw the same metadata
+	assert.Equal(t, 418, rec.Code)
+	assert.Same(t, meta, sf.meta)
+	assert.Equal(t, map[string]int64{"metric=some.metric": 1}, meta.Dropped())
+	timings := meta.Timings()
+	for _, stage := range []string{"decode", "filter", "encode", "upstream"} {
+		assert.Contains(t, timings, stage)
+	}
+}
+
+func TestHandler_MetricsFilter_Clock(t *testing.T) {
+	// Given server is running with a fake clock
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		Clock:               clock.NewFake(start),
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	var meta *server.RequestMeta
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			meta = server.RequestMetaFrom(r.Context())
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	// When we make the request
+	rec := httptest.NewRecorder()
+	h.MetricsFilter(rec, httptest.NewRequest("POST", "/api/v1/series", bytes.NewBufferString(`{"series":[{"metric":"some.metric.load","points":[[1650000000,1]]}]}`)))
+	<-resultChan
+
+	// Then every time comes from the clock
+	require.NotNil(t, meta)
+	assert.Equal(t, start, meta.Start)
+	assert.Equal(t, map[string]time.Duration{"decode": 0, "filter": 0, "encode": 0, "upstream": 0}, meta.Timings())
+}
+
+type metaFilter struct {
+	meta *server.RequestMeta
+}
+
+func (m *metaFilter) Filter(ctx context.Context, _ *datadog.Series) filter.Decision {
+	m.meta = server.RequestMetaFrom(ctx)
+	return filter.Keep
+}
+
+func TestHandler_ProxyHandle_RequestMeta(t *testing.T) {
+	// Given server is running
+	resultChan, ts, h, _ := setupCaptureServer(t, "", "")
+	defer ts.Close()
+
+	meta := server.NewRequestMeta("/some/path", time.Now())
+	req := httptest.NewRequest("GET", "/some/path", nil)
+	req = req.WithContext(server.WithRequestMeta(req.Context(), meta))
+	rec := httptest.NewRecorder()
+
+	// When we make the request
+	h.ProxyHandle(rec, req)
+	<-resultChan
+
+	// Then the upstream timing is recorded
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Contains(t, meta.Timings(), "upstream")
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MetadataRule scrubs a field of the host metadata payloads agents post to
+// /intake/, such as installed packages, cloud metadata or host tags. Path
+// holds the keys leading to the field, * matching any key, and descends into
+// fields holding JSON as a string, like gohai. The field is removed unless
+// Replace is set, in which case its value is replaced with Value.
+type MetadataRule struct {
+	Path    []string
+	Replace bool
+	Value   string
+}
+
+// ParseMetadataRule parses a rule written as a dotted path, removing the
+// field, or path=value, replacing its value, e.g. meta.instance-id or
+// gohai.network.ipaddress=redacted.
+func ParseMetadataRule(s string) (MetadataRule, error) {
+	kv := strings.SplitN(s, "=", 2)
+	rule := MetadataRule{Path: strings.Split(kv[0], ".")}
+	for _, key := range rule.Path {
+		if key == "" {
+			return MetadataRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected path or path=value in %q", s))
+		}
+	}
+	if len(kv) == 2 {
+		rule.Replace, rule.Value = true, kv[1]
+	}
+	return rule, nil
+}
+
+func (mr MetadataRule) String() string {
+	if mr.Replace {
+		return strings.Join(mr.Path, ".") + "=" + mr.Value
+	}
+	return strings.Join(mr.Path, ".")
+}
+
+// scrubMetadata applies Config.MetadataRules to a JSON payload, returning it
+// as it came when no field was scrubbed, and how many fields were.
+func (h *Handler) scrubMetadata(body []byte) ([]byte, int64, error) {
+	if len(h.cfg.MetadataRules) == 0 {
+		return body, 0, nil
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var payload interface{}
+	if err := d.Decode(&payload); err != nil {
+		return nil, 0, err
+	}
+	var scrubbed int64
+	for _, rule := range h.cfg.MetadataRules {
+		var n int64
+		payload, n = scrubField(payload, rule.Path, rule)
+		scrubbed += n
+	}
+	if scrubbed == 0 {
+		return body, 0, nil
+	}
+	out, err := json.Marshal(payload)
+	return out, scrubbed, err
+}
+
+// scrubField applies rule to the fields at path below v, returning the new v
+// and how many fields were scrubbed.
+func scrubField(v interface{}, path []string, rule MetadataRule) (interface{}, int64) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		var scrubbed int64
+		for key, child := range t {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+			if len(path) > 1 {
+				var n int64
+				t[key], n = scrubField(child, path[1:], rule)
+				scrubbed += n
+				continue
+			}
+			if rule.Replace {
+				t[key] = rule.Value
+			} else {
+				delete(t, key)
+			}
+			scrubbed++
+		}
+		return t, scrubbed
+	case string:
+		// Some fields, like gohai, hold their JSON as a string.
+		d := json.NewDecoder(strings.NewReader(t))
+		d.UseNumber()
+		var inner map[string]interface{}
+		if d.Decode(&inner) != nil {
+			return t, 0
+		}
+		scrubbedInner, n := scrubField(inner, path, rule)
+		if n == 0 {
+			return t, 0
+		}
+		b, err := json.Marshal(scrubbedInner)
+		if err != nil {
+			return t, 0
+		}
+		return string(b), n
+	}
+	return v, 0
+}
@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseMetadataRule(t *testing.T) {
+	rule, err := server.ParseMetadataRule("meta.instance-id")
+	require.NoError(t, err)
+	assert.Equal(t, server.MetadataRule{Path: []string{"meta", "instance-id"}}, rule)
+
+	rule, err = server.ParseMetadataRule("gohai.network.ipaddress=redacted")
+	require.NoError(t, err)
+	assert.Equal(t, server.MetadataRule{Path: []string{"gohai", "network", "ipaddress"}, Replace: true, Value: "redacted"}, rule)
+
+	for _, s := range []string{"", "meta..hostname", "=redacted"} {
+		_, err = server.ParseMetadataRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_EventsFilter_Metadata(t *testing.T) {
+	tests := []struct {
+		name             string
+		rules            []string
+		expectedBody     string
+		expectedScrubbed int64
+	}{
+		{
+			name:  "Remove and replace",
+			rules: []string{"host-tags.*", "meta.instance-id", "gohai.network.ipaddress=redacted"},
+			expectedBody: `{"internalHostname":"web-1","meta":{"hostname":"web-1"},"host-tags":{},
+				"gohai":"{\"network\":{\"ipaddress\":\"redacted\"},\"platform\":{\"os\":\"GNU/Linux\"}}",
+				"resources":{"processes":{"snaps":[[1650000000,2]]}}}`,
+			expectedScrubbed: 4,
+		},
+		{
+			name:  "Nothing to scrub",
+			rules: []string{"packages"},
+			expectedBody: `{"internalHostname":"web-1","meta":{"hostname":"web-1","instance-id":"i-0abc"},
+				"host-tags":{"system":["env:prod"],"google cloud platform":["zone:europe-west1-b"]},
+				"gohai":"{\"network\":{\"ipaddress\":\"10.0.0.7\"},\"platform\":{\"os\":\"GNU/Linux\"}}",
+				"resources":{"processes":{"snaps":[[1650000000,2]]}}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with metadata rules
+			cfg := server.Config{Tags: []string{"one"}}
+			for _, s := range tc.rules {
+				rule, err := server.ParseMetadataRule(s)
+				require.NoError(t, err)
+				cfg.MetadataRules = append(cfg.MetadataRules, rule)
+			}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When an agent posts its host metadata
+			body := `{"internalHostname":"web-1","meta":{"hostname":"web-1","instance-id":"i-0abc"},
+				"host-tags":{"system":["env:prod"],"google cloud platform":["zone:europe-west1-b"]},
+				"gohai":"{\"network\":{\"ipaddress\":\"10.0.0.7\"},\"platform\":{\"os\":\"GNU/Linux\"}}",
+				"resources":{"processes":{"snaps":[[1650000000,2]]}}}`
+			req := httptest.NewRequest("POST", "/intake/", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			h.EventsFilter(rec, req)
+
+			// Then the scrubbed payload is forwarded
+			assert.Equal(t, http.StatusTeapot, rec.Code)
+			actual := <-resultChan
+			assert.JSONEq(t, tc.expectedBody, actual.body)
+			sc.assertCount(t, "proxy_filter.scrubbed_metadata.count", tc.expectedScrubbed, []string{"one"}, 1, true)
+		})
+	}
+}
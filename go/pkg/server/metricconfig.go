@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+// MetricConfigRules are the rules derived from the tag configurations of
+// Metrics without Limits.
+type MetricConfigRules struct {
+	// Drop drops the metrics whose configuration holds the drop tag, nil when
+	// there are none.
+	Drop filter.Filter
+	// Strip keeps, on the series of each other configured metric, only the
+	// tags Datadog indexes for it, or removes the ones it excludes for a
+	// configuration in exclude mode, nil when there are none.
+	Strip transform.Transform
+	// Digest is a hash of the tag configurations, the same ones always
+	// giving the same digest, e.g. to tell the rule sets apart.
+	Digest string
+}
+
+// MetricConfigSync pulls the tag configurations of Metrics without Limits
+// from the Datadog API, so that the governance decisions made in Datadog
+// apply at the proxy: the tags a metric is not queryable by are stripped
+// before they are sent, and the metrics configured with DropTag are dropped.
+type MetricConfigSync struct {
+	// Endpoint is the base URL of the Datadog API, e.g.
+	// https://api.datadoghq.com.
+	Endpoint string
+	APIKey   string
+	AppKey   string
+	// DropTag, when set, is the tag key marking in its configuration a
+	// metric to drop.
+	DropTag string
+	Client  *http.Client
+
+	mu      sync.Mutex
+	configs map[string]tagConfig
+}
+
+// tagConfig is the tag configuration of a metric, its tags being the ones
+// Datadog indexes or, in exclude mode, the ones it does not.
+type tagConfig struct {
+	Tags    []string
+	Exclude bool
+}
+
+// Fetch returns the rules of the tag configurations, and whether they
+// changed since the last fetch.
+func (s *MetricConfigSync) Fetch(ctx context.Context) (MetricConfigRules, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	configs, err := s.tagConfigs(ctx)
+	if err != nil {
+		return MetricConfigRules{}, false, err
+	}
+	changed := s.configs == nil || !reflect.DeepEqual(configs, s.configs)
+	s.configs = configs
+	return s.rules(), changed, nil
+}
+
+// Poll fetches the rules every interval until stop is closed, sending them on
+// the returned channel whenever they changed. A fetch that fails is logged
+// and the rules are fetched again on the next interval.
+func (s *MetricConfigSync) Poll(interval time.Duration, stop <-chan struct{}) <-chan MetricConfigRules {
+	rules := make(chan MetricConfigRules)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			r, changed, err := s.Fetch(context.Background())
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Could not fetch the metric tag configurations, %v", err))
+				continue
+			}
+			if !changed {
+				continue
+			}
+			select {
+			case rules <- r:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return rules
+}
+
+// tagConfigs returns the tag configuration of each metric, its tags sorted,
+// following the pages of the listing.
+func (s *MetricConfigSync) tagConfigs(ctx context.Context) (map[string]tagConfig, error) {
+	base, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/") + "/api/v2/metrics?filter%5Bconfigured%5D=true")
+	if err != nil {
+		return nil, err
+	}
+	configs := make(map[string]tagConfig)
+	seen := make(map[string]bool)
+	for page := base; !seen[page.String()]; {
+		seen[page.String()] = true
+		next, err := s.tagConfigsPage(ctx, page, configs)
+		if err != nil {
+			return nil, err
+		}
+		if next == "" {
+			break
+		}
+		if page, err = base.Parse(next); err != nil {
+			return nil, fmt.Errorf("bad next page %q, %w", next, err)
+		}
+		// The keys are only sent to the host they were configured for.
+		if page.Scheme != base.Scheme || page.Host != base.Host {
+			return nil, fmt.Errorf("next page %s is not on %s", page.Redacted(), base.Host)
+		}
+	}
+	return configs, nil
+}
+
+// tagConfigsPage adds the tag configurations listed at u to configs and
+// returns the link to the next page, empty on the last one.
+func (s *MetricConfigSync) tagConfigsPage(ctx context.Context, u *url.URL, configs map[string]tagConfig) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("DD-API-KEY", s.APIKey)
+	req.Header.Set("DD-APPLICATION-KEY", s.AppKey)
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("got %d from %s, %s", resp.StatusCode, u, msg)
+	}
+	var body struct {
+		Data []struct {
+			Type       string `json:"type"`
+			ID         string `json:"id"`
+			Attributes struct {
+				Tags            []string `json:"tags"`
+				ExcludeTagsMode bool     `json:"exclude_tags_mode"`
+			} `json:"attributes"`
+		} `json:"data"`
+		Links struct {
+			Next string `json:"next"`
+		} `json:"links"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	for _, d := range body.Data {
+		// The metrics without a tag configuration are listed as type metrics.
+		if d.Type != "manage_tags" || d.ID == "" {
+			continue
+		}
+		tags := append([]string{}, d.Attributes.Tags...)
+		sort.Strings(tags)
+		configs[d.ID] = tagConfig{Tags: tags, Exclude: d.Attributes.ExcludeTagsMode}
+	}
+	return body.Links.Next, nil
+}
+
+func (s *MetricConfigSync) rules() MetricConfigRules {
+	var drop []string
+	keep := make(transform.KeepTagKeys)
+	strip := make(transform.StripMetricTagKeys)
+	metrics := make([]string, 0, len(s.configs))
+	for metric := range s.configs {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	hash := sha256.New()
+	for _, metric := range metrics {
+		config := s.configs[metric]
+		tags := config.Tags
+		_, _ = fmt.Fprintf(hash, "%s exclude=%t %s\n", metric, config.Exclude, strings.Join(tags, ","))
+		if i := sort.SearchStrings(tags, s.DropTag); s.DropTag != "" && i < len(tags) && tags[i] == s.DropTag {
+			drop = append(drop, metric)
+			continue
+		}
+		if config.Exclude {
+			strip[metric] = tags
+			continue
+		}
+		keep[metric] = tags
+	}
+	rules := MetricConfigRules{Digest: hex.EncodeToString(hash.Sum(nil))}
+	if len(drop) > 0 {
+		rules.Drop = filter.NewMetrics(drop...)
+	}
+	switch {
+	case len(keep) > 0 && len(strip) > 0:
+		rules.Strip = transform.Chain{keep, strip}
+	case len(keep) > 0:
+		rules.Strip = keep
+	case len(strip) > 0:
+		rules.Strip = strip
+	}
+	return rules
+}
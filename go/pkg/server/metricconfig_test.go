@@ -0,0 +1,132 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestMetricConfigSync_Fetch(t *testing.T) {
+	// Given the Datadog API lists the metrics with their tag configurations
+	body := `{"data": [
+		{"type": "manage_tags", "id": "app.requests", "attributes": {"tags": ["service", "env"], "metric_type": "count"}},
+		{"type": "manage_tags", "id": "app.debug", "attributes": {"tags": ["proxy_filter_drop"]}},
+		{"type": "metrics", "id": "app.errors"}
+	]}`
+	var query, apiKey, appKey string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, apiKey, appKey = r.URL.RawQuery, r.Header.Get("DD-API-KEY"), r.Header.Get("DD-APPLICATION-KEY")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+	sync := &server.MetricConfigSync{Endpoint: ts.URL, APIKey: "api", AppKey: "app", DropTag: "proxy_filter_drop", Client: ts.Client()}
+
+	// When they are fetched
+	rules, changed, err := sync.Fetch(context.Background())
+
+	// Then the configured metrics are listed with the keys
+	require.NoError(t, err)
+	assert.Equal(t, "filter%5Bconfigured%5D=true", query)
+	assert.Equal(t, []string{"api", "app"}, []string{apiKey, appKey})
+
+	// And the rules derived from them are returned as changed
+	assert.True(t, changed)
+	assert.Equal(t, filter.NewMetrics("app.debug"), rules.Drop)
+	assert.Equal(t, transform.KeepTagKeys{"app.requests": {"env", "service"}}, rules.Strip)
+	series := datadog.Series{Metric: "app.requests", Tags: &[]string{"env:prod", "user_email:jane@example.com", "service:web"}}
+	rules.Strip.Transform(context.Background(), &series)
+	assert.Equal(t, []string{"env:prod", "service:web"}, series.GetTags())
+
+	// When they are fetched again
+	again, changed, err := sync.Fetch(context.Background())
+
+	// Then they have not changed
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, rules.Digest, again.Digest)
+
+	// When a configuration changes
+	body = `{"data": [{"type": "manage_tags", "id": "app.requests", "attributes": {"tags": ["env"]}}]}`
+	rules, changed, err = sync.Fetch(context.Background())
+
+	// Then the new rules are returned
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.NotEqual(t, again.Digest, rules.Digest)
+	assert.Nil(t, rules.Drop)
+	assert.Equal(t, transform.KeepTagKeys{"app.requests": {"env"}}, rules.Strip)
+}
+
+func TestMetricConfigSync_Fetch_Pages(t *testing.T) {
+	// Given the Datadog API lists the configurations over two pages, one of
+	// them in exclude mode
+	pages := map[string]string{
+		"": `{"data": [{"type": "manage_tags", "id": "app.requests", "attributes": {"tags": ["service", "env"]}}],
+			"links": {"next": "/api/v2/metrics?filter%5Bconfigured%5D=true&page%5Bcursor%5D=abc"}}`,
+		"abc": `{"data": [{"type": "manage_tags", "id": "app.latency", "attributes": {"tags": ["user_email"], "exclude_tags_mode": true}}],
+			"links": {"next": ""}}`,
+	}
+	var cursors []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("page[cursor]")
+		cursors = append(cursors, cursor)
+		_, _ = w.Write([]byte(pages[cursor]))
+	}))
+	defer ts.Close()
+	sync := &server.MetricConfigSync{Endpoint: ts.URL, Client: ts.Client()}
+
+	// When they are fetched
+	rules, _, err := sync.Fetch(context.Background())
+
+	// Then every page is read
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "abc"}, cursors)
+
+	// And the tags of the metric in exclude mode are the ones stripped
+	assert.Equal(t, transform.Chain{
+		transform.KeepTagKeys{"app.requests": {"env", "service"}},
+		transform.StripMetricTagKeys{"app.latency": {"user_email"}},
+	}, rules.Strip)
+	series := datadog.Series{Metric: "app.latency", Tags: &[]string{"env:prod", "user_email:jane@example.com", "service:web"}}
+	rules.Strip.Transform(context.Background(), &series)
+	assert.Equal(t, []string{"env:prod", "service:web"}, series.GetTags())
+}
+
+func TestMetricConfigSync_Fetch_Error(t *testing.T) {
+	// Given the Datadog API rejects the keys
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors": ["Forbidden"]}`))
+	}))
+	defer ts.Close()
+	sync := &server.MetricConfigSync{Endpoint: ts.URL, Client: ts.Client()}
+
+	// When the configurations are fetched
+	_, _, err := sync.Fetch(context.Background())
+
+	// Then it fails
+	assert.EqualError(t, err, "got 403 from "+ts.URL+`/api/v2/metrics?filter%5Bconfigured%5D=true, {"errors": ["Forbidden"]}`)
+
+	// Given the next page is linked on another host
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data": [], "links": {"next": "https://elsewhere.example.com/api/v2/metrics"}}`))
+	}))
+	defer ts.Close()
+	sync = &server.MetricConfigSync{Endpoint: ts.URL, Client: ts.Client()}
+
+	// When the configurations are fetched
+	_, _, err = sync.Fetch(context.Background())
+
+	// Then it fails rather than sending the keys there
+	assert.EqualError(t, err, "next page https://elsewhere.example.com/api/v2/metrics is not on "+strings.TrimPrefix(ts.URL, "http://"))
+}
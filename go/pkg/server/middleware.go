@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Middleware wraps the handling of a request, e.g. to authenticate, rate
+// limit or log it. The request already carries its RequestMeta.
+type Middleware func(next http.Handler) http.Handler
+
+// Use adds middleware around ProxyHandle and MetricsFilter. Middleware runs in
+// the order it was added, the first one being the outermost. It runs before
+// the maintenance switch, the content type checks, the mirror, the archive
+// and the drops, and the usage, the decision sinks, the distributions and the
+// fleet record every request, the ones it answers itself too.
+func (h *Handler) Use(mw ...Middleware) {
+	h.middleware = append(h.middleware, mw...)
+}
+
+// serve handles r with next, and the handler with the rules last loaded
+// throughout.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, next func(h *Handler, w http.ResponseWriter, r *http.Request)) {
+	h = h.current()
+	h.useRuleGroup(r)
+	h.useDropAll(r.URL.Path)
+	r, meta := withRequestMeta(r, h.clock.Now())
+	if meta.RuleSet == "" && h.ruleSet.version > 0 {
+		meta.RuleSet = strconv.FormatInt(h.ruleSet.version, 10)
+	}
+	r = h.withAgentVersion(r)
+	// Recorded once handled, by then the middleware has set the tenant.
+	defer h.usage.record(r)
+	sr := &statusRecorder{ResponseWriter: w}
+	w = sr
+	body := &countingReader{ReadCloser: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+	defer func() {
+		h.recordDecision(r, sr.status, body)
+		h.recordDistributions(r, sr, body)
+	}()
+	if h.fleet != nil {
+		defer func() { h.fleet.record(r, sr.status, h.clock.Now()) }()
+	}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.inMaintenance {
+			_ = h.statsDClient.Count(maintenanceRequestsCountName, 1, h.tags("route:"+routePattern(r)), 1)
+			h.cfg.ContentTypes, h.cfg.DropRequests, h.cfg.RewriteResponses = nil, nil, nil
+			next = (*Handler).proxyHandle
+		}
+		if h.checkContentType(w, r) {
+			return
+		}
+		h.mirrorRequest(r)
+		h.archiveRequest(r)
+		if h.dropAllRequest(w, r) || h.dropRequest(w, r) {
+			return
+		}
+		// Bodies that cannot be decoded are forwarded as they came rather than
+		// failing to parse or being corrupted.
+		if e := unsupportedEncoding(r); e != "" {
+			_ = h.statsDClient.Count(unsupportedEncodingCountName, 1, h.tags("route:"+routePattern(r), "content_encoding:"+e), 1)
+			h.proxyRequest(w, r, r.Body)
+			return
+		}
+		if h.checkSnappySize(w, r) {
+			return
+		}
+		next(h, w, r)
+	})
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,115 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Use(t *testing.T) {
+	// Given server is running
+	resultChan, ts, h, _ := setupCaptureServer(t, "", "some.metric")
+	defer ts.Close()
+
+	// And two middleware recording the order they run in
+	var order []string
+	record := func(name string) server.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.NotNil(t, server.RequestMetaFrom(r.Context()))
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h.Use(record("first"), record("second"))
+
+	for name, handle := range map[string]http.HandlerFunc{"ProxyHandle": h.ProxyHandle, "MetricsFilter": h.MetricsFilter} {
+		t.Run(name, func(t *testing.T) {
+			order = nil
+
+			// When we make a request
+			rec := httptest.NewRecorder()
+			handle(rec, httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(`{"series":[]}`)))
+			<-resultChan
+
+			// Then the middleware ran around the handler in order
+			assert.Equal(t, http.StatusTeapot, rec.Code)
+			assert.Equal(t, []string{"first", "second"}, order)
+		})
+	}
+}
+
+func TestHandler_Use_ShortCircuit(t *testing.T) {
+	// Given server is running
+	_, ts, h, _ := setupCaptureServer(t, "", "")
+	defer ts.Close()
+
+	// And a middleware rejecting requests without an API key
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("DD-API-KEY") == "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	// When we make a request without an API key
+	rec := httptest.NewRecorder()
+	h.ProxyHandle(rec, httptest.NewRequest("GET", "/api/v1/validate", nil))
+
+	// Then it never reaches upstream
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestHandler_Use_Order(t *testing.T) {
+	// Given server is running with expected content types and a debug buffer
+	buffer := server.NewDebugBuffer(1)
+	cfg := server.Config{
+		ContentTypes:  map[string][]string{"/api/v1/series": {"application/json"}},
+		DecisionSinks: []server.DecisionSink{buffer},
+	}
+	_, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// And a middleware rejecting requests without an API key
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("DD-API-KEY") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	// When we make a request without an API key and of an unexpected type
+	req := httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(`{"series":[]}`))
+	req.Header.Set("Content-Type", "text/html")
+	rec := httptest.NewRecorder()
+	h.ProxyHandle(rec, req)
+
+	// Then the middleware answers before the content type is checked
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// And the decision sinks still record the request
+	records := buffer.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, http.StatusUnauthorized, records[0].Status)
+
+	// When the request has an API key
+	req.Header.Set("DD-API-KEY", "key")
+	rec = httptest.NewRecorder()
+	h.ProxyHandle(rec, req)
+
+	// Then the content type is checked
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// defaultMirrorMaxInFlight bounds the mirrored requests in flight when
+// Config.MirrorMaxInFlight is not set.
+const defaultMirrorMaxInFlight = 100
+
+// credentialHeaders and credentialQueryKeys carry the Datadog credentials of a
+// request, which only the intake gets.
+var (
+	credentialHeaders   = []string{"DD-API-KEY", "DD-APPLICATION-KEY"}
+	credentialQueryKeys = []string{"api_key", "application_key"}
+)
+
+// stripCredentials removes the Datadog credentials from header and from the
+// query of u.
+func stripCredentials(header http.Header, u *url.URL) {
+	for _, key := range credentialHeaders {
+		header.Del(key)
+	}
+	q := u.Query()
+	stripped := false
+	for _, key := range credentialQueryKeys {
+		if _, ok := q[key]; ok {
+			q.Del(key)
+			stripped = true
+		}
+	}
+	if stripped {
+		u.RawQuery = q.Encode()
+	}
+}
+
+func newMirrorSlots(n int) chan struct{} {
+	if n <= 0 {
+		n = defaultMirrorMaxInFlight
+	}
+	return make(chan struct{}, n)
+}
+
+// mirrorRequest sends a POST request as it came to Config.MirrorEndpoint in
+// the background, reading its body whole so that the handler still gets it.
+// The request is skipped when too many are in flight, so that a slow secondary
+// backend cannot hold the memory of every payload, and it is sent without the
+// Datadog credentials of the client.
+func (h *Handler) mirrorRequest(r *http.Request) {
+	if h.mirrorSlots == nil || r.Method != http.MethodPost || isGRPC(r) {
+		return
+	}
+	select {
+	case h.mirrorSlots <- struct{}{}:
+	default:
+		_ = h.statsDClient.Count(mirrorSkippedCountName, 1, h.tags("route:"+routePattern(r)), 1)
+		return
+	}
+	route := routePattern(r)
+	raw, err := bufferBody(r)
+	if err != nil {
+		<-h.mirrorSlots
+		return
+	}
+	// The mirrored request outlives the one of the client.
+	req, err := newUpstreamRequest(context.Background(), r, h.cfg.MirrorEndpoint+r.URL.Path, bytes.NewReader(raw))
+	if err != nil {
+		<-h.mirrorSlots
+		_ = h.statsDClient.Count(mirrorFailuresCountName, 1, h.tags("route:"+route, "status_code:error"), 1)
+		return
+	}
+	stripCredentials(req.Header, req.URL)
+	go func() {
+		defer func() { <-h.mirrorSlots }()
+		resp, err := h.httpClient.Do(req)
+		if err != nil {
+			_ = h.statsDClient.Count(mirrorFailuresCountName, 1, h.tags("route:"+route, "status_code:error"), 1)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			_ = h.statsDClient.Count(mirrorFailuresCountName, 1, h.tags("route:"+route, fmt.Sprintf("status_code:%d", resp.StatusCode)), 1)
+		}
+	}()
+}
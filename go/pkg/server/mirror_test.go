@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Mirror(t *testing.T) {
+	// Given a secondary backend
+	mirrored := make(chan result, 1)
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- result{path: r.URL.Path, body: string(body), apiKey: r.Header.Get("DD-API-KEY") + r.Header.Get("DD-APPLICATION-KEY"), params: r.URL.Query()}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer mirror.Close()
+
+	// And server is running with a filter mirroring to it
+	cfg := server.Config{MetricsPrefixFilter: "some.metric", MirrorEndpoint: mirror.URL, Tags: []string{"one"}}
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a payload is sent
+	body, err := json.Marshal(defaultMetricsPayload([]string{"system.load.1", "some.metric.disk"}))
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/series?api_key=abc&application_key=def&source=agent", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", "abc")
+	req.Header.Set("DD-APPLICATION-KEY", "def")
+	rec := httptest.NewRecorder()
+	h.MetricsFilter(rec, req)
+
+	// Then the filtered payload is sent to the primary with the credentials
+	assert.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	expected, err := json.Marshal(defaultMetricsPayload([]string{"system.load.1"}))
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), actual.body)
+	assert.Equal(t, "abc", actual.apiKey)
+	assert.Equal(t, "abc", actual.params.Get("api_key"))
+
+	// And the payload as it came to the secondary, without the credentials
+	actual = <-mirrored
+	assert.Equal(t, "/api/v1/series", actual.path)
+	assert.Equal(t, string(body), actual.body)
+	assert.Empty(t, actual.apiKey)
+	assert.Equal(t, url.Values{"source": []string{"agent"}}, actual.params)
+	sc.assertNotCounted(t, "proxy_filter.mirror.failures.count")
+}
+
+func TestHandler_Mirror_Skipped(t *testing.T) {
+	// Given a secondary backend that does not answer
+	release := make(chan struct{})
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer mirror.Close()
+	defer close(release)
+
+	// And server is running mirroring one request at a time
+	cfg := server.Config{MirrorEndpoint: mirror.URL, MirrorMaxInFlight: 1, Tags: []string{"one"}}
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When two requests are sent
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/v1/check_run", bytes.NewBufferString(`[]`))
+		h.ProxyHandle(httptest.NewRecorder(), req)
+		<-resultChan
+	}
+
+	// Then the second one is not mirrored
+	sc.assertCount(t, "proxy_filter.mirror.skipped.count", 1, []string{"one", "route:/api/v1/check_run"}, 1, true)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// orchestratorKinds maps the process agent payload types of the orchestrator
+// collectors to the kind of resource they hold.
+var orchestratorKinds = map[byte]string{
+	41: "pod",
+	42: "replicaset",
+	43: "deployment",
+	44: "service",
+	45: "node",
+	47: "job",
+	48: "cronjob",
+	49: "daemonset",
+	50: "statefulset",
+	51: "persistentvolume",
+	52: "persistentvolumeclaim",
+	53: "role",
+	54: "rolebinding",
+	55: "clusterrole",
+	56: "clusterrolebinding",
+	57: "serviceaccount",
+	58: "ingress",
+}
+
+// Field numbers of the orchestrator messages, as defined by the agent payload
+// protos. Every collector message holds its resources in the same field and
+// every resource starts with its metadata.
+const (
+	orchestratorResources = 2
+
+	resourceMetadata = 1
+
+	metadataNamespace = 2
+)
+
+// OrchestratorRule drops the Kubernetes resources of the kind Kind, any kind
+// when empty, in the namespaces matching Namespace, using path.Match syntax.
+type OrchestratorRule struct {
+	Kind      string
+	Namespace string
+}
+
+// ParseOrchestratorRule parses a rule written as comma separated key=value
+// pairs with the keys kind and namespace, e.g. kind=pod,namespace=kube-*.
+func ParseOrchestratorRule(s string) (OrchestratorRule, error) {
+	var rule OrchestratorRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return OrchestratorRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "kind":
+			rule.Kind = strings.ToLower(kv[1])
+		case "namespace":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return OrchestratorRule{}, newError(ErrRuleInvalid, err)
+			}
+			rule.Namespace = kv[1]
+		default:
+			return OrchestratorRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the resource is dropped by the rule.
+func (or OrchestratorRule) Match(kind, namespace string) bool {
+	if or.Kind == "" && or.Namespace == "" {
+		return false
+	}
+	if or.Kind != "" && or.Kind != kind {
+		return false
+	}
+	if or.Namespace == "" {
+		return true
+	}
+	ok, _ := path.Match(or.Namespace, namespace)
+	return ok
+}
+
+// OrchestratorFilter filters the Kubernetes resources the cluster agent sends
+// to /api/v2/orch with Config.OrchestratorRules. Only protobuf payloads are
+// filtered, compressed ones are forwarded as they came.
+func (h *Handler) OrchestratorFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).orchestratorFilter)
+}
+
+func (h *Handler) orchestratorFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.OrchestratorRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	meta := RequestMetaFrom(r.Context())
+	buf, err := h.filterCollector(r, func(typ byte, msg []byte) ([]byte, error) {
+		kind, ok := orchestratorKinds[typ]
+		if !ok {
+			return msg, nil
+		}
+		var dropped int64
+		out, err := filterMessages(msg, func(num protowire.Number, v []byte) ([]byte, bool, error) {
+			if num != orchestratorResources {
+				return v, true, nil
+			}
+			namespace, err := decodeNamespace(v)
+			if err != nil {
+				return nil, false, err
+			}
+			if h.dropResource(kind, namespace) {
+				meta.RecordDrop(kind)
+				dropped++
+				return nil, false, nil
+			}
+			return v, true, nil
+		})
+		_ = h.statsDClient.Count(filteredResourcesCountName, dropped, h.tags("kind:"+kind), 1)
+		return out, err
+	})
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// decodeNamespace returns the namespace in the metadata of a resource.
+func decodeNamespace(b []byte) (string, error) {
+	var namespace string
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != resourceMetadata || typ != protowire.BytesType {
+			return nil
+		}
+		return walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			if num == metadataNamespace && typ == protowire.BytesType {
+				namespace = string(v)
+			}
+			return nil
+		})
+	})
+	return namespace, err
+}
+
+func (h *Handler) dropResource(kind, namespace string) bool {
+	for _, rule := range h.cfg.OrchestratorRules {
+		if rule.Match(kind, namespace) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+// encodeCollectorPods encodes a cluster agent payload holding a CollectorPod
+// with a pod in each of the namespaces.
+func encodeCollectorPods(namespaces ...string) []byte {
+	b := []byte{3, 0, 41, 0, 0, 0, 0, 1, 0, 0, 0, 0, 98, 90, 0, 0}
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, "prod-cluster")
+	for _, namespace := range namespaces {
+		var m []byte
+		m = protowire.AppendTag(m, 1, protowire.BytesType)
+		m = protowire.AppendString(m, "web-"+namespace)
+		m = protowire.AppendTag(m, 2, protowire.BytesType)
+		m = protowire.AppendString(m, namespace)
+		var pod []byte
+		pod = protowire.AppendTag(pod, 1, protowire.BytesType)
+		pod = protowire.AppendBytes(pod, m)
+		pod = protowire.AppendTag(pod, 2, protowire.BytesType)
+		pod = protowire.AppendString(pod, "10.0.0.1")
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, pod)
+	}
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func TestParseOrchestratorRule(t *testing.T) {
+	rule, err := server.ParseOrchestratorRule("kind=Pod,namespace=kube-*")
+	require.NoError(t, err)
+	assert.Equal(t, server.OrchestratorRule{Kind: "pod", Namespace: "kube-*"}, rule)
+
+	for _, s := range []string{"", "kind=", "namespace=[", "name=web"} {
+		_, err = server.ParseOrchestratorRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_OrchestratorFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []server.OrchestratorRule
+		expected []string
+		dropped  int64
+	}{
+		{
+			name:     "Drop namespace",
+			rules:    []server.OrchestratorRule{{Namespace: "kube-*"}},
+			expected: []string{"default", "payments"},
+			dropped:  2,
+		},
+		{
+			name:     "Drop kind in namespace",
+			rules:    []server.OrchestratorRule{{Kind: "pod", Namespace: "payments"}},
+			expected: []string{"default", "kube-system", "kube-public"},
+			dropped:  1,
+		},
+		{
+			name:     "Other kind kept",
+			rules:    []server.OrchestratorRule{{Kind: "deployment"}},
+			expected: []string{"default", "kube-system", "payments", "kube-public"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", server.Config{OrchestratorRules: tc.rules})
+			defer ts.Close()
+
+			// When the cluster agent sends pods
+			body := encodeCollectorPods("default", "kube-system", "payments", "kube-public")
+			req := httptest.NewRequest("POST", "/api/v2/orch", bytes.NewReader(body))
+			rec := httptest.NewRecorder()
+			h.OrchestratorFilter(rec, req)
+
+			// Then the pods left are forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, encodeCollectorPods(tc.expected...), []byte(actual.body))
+			sc.assertCount(t, "proxy_filter.filtered_orchestrator_resources.count", tc.dropped, []string{"kind:pod"}, 1, true)
+		})
+	}
+}
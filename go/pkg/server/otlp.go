@@ -0,0 +1,273 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// otlpDeltaTemporality is AGGREGATION_TEMPORALITY_DELTA of the OTLP metrics
+// protos.
+const otlpDeltaTemporality = 1
+
+// Exporter receives every series forwarded to the v1 and v2 series intakes,
+// and from Prometheus remote-write, once the filters kept and rewrote it.
+// Implementations must be safe for concurrent use and should not block.
+type Exporter interface {
+	Export(series datadog.Series)
+}
+
+// ExporterFunc adapts a function to an Exporter.
+type ExporterFunc func(series datadog.Series)
+
+func (f ExporterFunc) Export(series datadog.Series) {
+	f(series)
+}
+
+// export passes a forwarded series to the exporters.
+func (h *Handler) export(series datadog.Series) {
+	for _, e := range h.cfg.Exporters {
+		e.Export(series)
+	}
+}
+
+// exports reports whether the series requests have to be decoded for the
+// exporters, even without anything else to do with them.
+func (h *Handler) exports() bool {
+	return len(h.cfg.Exporters) > 0 || h.cfg.ExportOnly
+}
+
+// writeExported answers a series request whose series went to the exporters
+// only, with what the intakes answer to accepted payloads.
+func (h *Handler) writeExported(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = io.WriteString(w, "{}")
+}
+
+// OTLPExporter pushes the forwarded series to an OpenTelemetry collector
+// with OTLP over HTTP, encoded as JSON. The series are queued and sent in
+// batches from a goroutine, the ones that do not fit in the queue being lost
+// rather than slowing requests down. A series is a metric of the resource of
+// its host, with its tags as attributes, counts being delta sums over their
+// interval and every other type gauges.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+	batch    int
+	interval time.Duration
+
+	queue chan datadog.Series
+	done  chan struct{}
+	lost  int64
+}
+
+// NewOTLPExporter creates an exporter sending to endpoint, e.g.
+// http://127.0.0.1:4318/v1/metrics, with client, queuing up to size series
+// and sending them every interval or once batch of them are queued. It runs
+// until closed.
+func NewOTLPExporter(endpoint string, client *http.Client, size, batch int, interval time.Duration) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		client:   client,
+		batch:    batch,
+		interval: interval,
+		queue:    make(chan datadog.Series, size),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(series datadog.Series) {
+	select {
+	case e.queue <- series:
+	default:
+		atomic.AddInt64(&e.lost, 1)
+	}
+}
+
+// Lost returns how many series did not fit in the queue.
+func (e *OTLPExporter) Lost() int64 {
+	return atomic.LoadInt64(&e.lost)
+}
+
+// Close sends the queued series and stops the exporter. No series must be
+// passed to the exporter once it is closed.
+func (e *OTLPExporter) Close() {
+	close(e.queue)
+	<-e.done
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	var pending []datadog.Series
+	for {
+		select {
+		case series, ok := <-e.queue:
+			if !ok {
+				e.send(pending)
+				return
+			}
+			pending = append(pending, series)
+			if len(pending) < e.batch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		e.send(pending)
+		pending = pending[:0]
+	}
+}
+
+func (e *OTLPExporter) send(series []datadog.Series) {
+	if len(series) == 0 {
+		return
+	}
+	body, err := json.Marshal(newOTLPRequest(series))
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Could not export %d series to %s, %v", len(series), e.endpoint, err))
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Could not export %d series to %s, %v", len(series), e.endpoint, err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Could not export %d series to %s, %v", len(series), e.endpoint, err))
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		fmt.Println(fmt.Sprintf("Could not export %d series to %s, got %d", len(series), e.endpoint, resp.StatusCode))
+	}
+}
+
+// The JSON encoding of the OTLP ExportMetricsServiceRequest, 64-bit integers
+// being strings.
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// newOTLPRequest groups series by host, each host being a resource with a
+// host.name attribute.
+func newOTLPRequest(series []datadog.Series) otlpRequest {
+	byHost := make(map[string][]otlpMetric)
+	for _, s := range series {
+		host := s.GetHost()
+		byHost[host] = append(byHost[host], newOTLPMetric(s))
+	}
+	hosts := make([]string, 0, len(byHost))
+	for host := range byHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	req := otlpRequest{ResourceMetrics: make([]otlpResourceMetrics, 0, len(hosts))}
+	for _, host := range hosts {
+		rm := otlpResourceMetrics{ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "proxy-filter"}, Metrics: byHost[host]}}}
+		if host != "" {
+			rm.Resource.Attributes = []otlpAttribute{{Key: "host.name", Value: otlpAnyValue{StringValue: host}}}
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+	}
+	return req
+}
+
+// newOTLPMetric converts a series, its tags without a value being attributes
+// with an empty one.
+func newOTLPMetric(s datadog.Series) otlpMetric {
+	var attrs []otlpAttribute
+	for _, tag := range s.GetTags() {
+		key, value := tag, ""
+		if i := strings.IndexByte(tag, ':'); i >= 0 {
+			key, value = tag[:i], tag[i+1:]
+		}
+		attrs = append(attrs, otlpAttribute{Key: key, Value: otlpAnyValue{StringValue: value}})
+	}
+	isCount := s.GetType() == "count"
+	interval := s.GetInterval()
+	points := make([]otlpDataPoint, 0, len(s.Points))
+	for _, point := range s.Points {
+		if len(point) < 2 || point[0] == nil || point[1] == nil {
+			continue
+		}
+		ts := int64(*point[0])
+		dp := otlpDataPoint{Attributes: attrs, TimeUnixNano: unixNano(ts), AsDouble: *point[1]}
+		if isCount && interval > 0 {
+			dp.StartTimeUnixNano = unixNano(ts - interval)
+		}
+		points = append(points, dp)
+	}
+	if isCount {
+		return otlpMetric{Name: s.Metric, Sum: &otlpSum{DataPoints: points, AggregationTemporality: otlpDeltaTemporality}}
+	}
+	return otlpMetric{Name: s.Metric, Gauge: &otlpGauge{DataPoints: points}}
+}
+
+func unixNano(seconds int64) string {
+	return strconv.FormatInt(seconds*int64(time.Second), 10)
+}
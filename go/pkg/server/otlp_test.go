@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type exported struct {
+	sync.Mutex
+	metrics []string
+}
+
+func (e *exported) Export(series datadog.Series) {
+	e.Lock()
+	defer e.Unlock()
+	e.metrics = append(e.metrics, series.Metric)
+}
+
+func TestHandler_Exporters(t *testing.T) {
+	tests := []struct {
+		name       string
+		exportOnly bool
+		route      string
+		body       []byte
+		mediaType  string
+		serve      func(h server.Handler) http.HandlerFunc
+	}{
+		{
+			name:      "v1 series",
+			route:     "/api/v1/series",
+			body:      mustMarshal(t, defaultMetricsPayload([]string{"system.load.1", "some.metric.disk"})),
+			mediaType: "application/json",
+			serve:     func(h server.Handler) http.HandlerFunc { return h.MetricsFilter },
+		},
+		{
+			name:      "v2 series",
+			route:     "/api/v2/series",
+			body:      encodeSeriesV2(seriesV2{metric: "system.load.1"}, seriesV2{metric: "some.metric.disk"}),
+			mediaType: "application/x-protobuf",
+			serve:     func(h server.Handler) http.HandlerFunc { return h.MetricsFilterV2 },
+		},
+		{
+			name:       "v1 series export only",
+			exportOnly: true,
+			route:      "/api/v1/series",
+			body:       mustMarshal(t, defaultMetricsPayload([]string{"system.load.1", "some.metric.disk"})),
+			mediaType:  "application/json",
+			serve:      func(h server.Handler) http.HandlerFunc { return h.MetricsFilter },
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with an exporter
+			e := &exported{}
+			cfg := server.Config{MetricsPrefixFilter: "some.metric", Exporters: []server.Exporter{e}, ExportOnly: tc.exportOnly}
+			resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When we make the request
+			req := httptest.NewRequest("POST", tc.route, bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.mediaType)
+			rec := httptest.NewRecorder()
+			tc.serve(h)(rec, req)
+
+			// Then the kept series are exported
+			assert.Equal(t, []string{"system.load.1"}, e.metrics)
+
+			// And forwarded unless exported only
+			if tc.exportOnly {
+				assert.Equal(t, http.StatusAccepted, rec.Code)
+				assert.Equal(t, "{}", rec.Body.String())
+				assert.Empty(t, resultChan)
+				return
+			}
+			assert.Equal(t, 418, rec.Code)
+			<-resultChan
+		})
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	// Given an OpenTelemetry collector
+	received := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.Header.Get("Content-Type") + " " + string(body)
+	}))
+	defer collector.Close()
+	e := server.NewOTLPExporter(collector.URL, collector.Client(), 10, 10, time.Hour)
+
+	// When series are exported
+	count := datadog.Series{
+		Metric: "app.requests",
+		Type:   datadog.PtrString("count"),
+		Host:   datadog.PtrString("web-1"),
+		Points: [][]*float64{{datadog.PtrFloat64(1650000010), datadog.PtrFloat64(3)}},
+		Tags:   &[]string{"env:prod", "canary"},
+	}
+	count.SetInterval(10)
+	e.Export(count)
+	e.Export(datadog.Series{
+		Metric: "system.load.1",
+		Points: [][]*float64{{datadog.PtrFloat64(1650000000), datadog.PtrFloat64(0.5)}},
+	})
+	e.Close()
+
+	// Then they are sent as OTLP JSON grouped by host
+	expected := `application/json {"resourceMetrics":[` +
+		`{"resource":{},"scopeMetrics":[{"scope":{"name":"proxy-filter"},"metrics":[` +
+		`{"name":"system.load.1","gauge":{"dataPoints":[{"timeUnixNano":"1650000000000000000","asDouble":0.5}]}}]}]},` +
+		`{"resource":{"attributes":[{"key":"host.name","value":{"stringValue":"web-1"}}]},"scopeMetrics":[{"scope":{"name":"proxy-filter"},"metrics":[` +
+		`{"name":"app.requests","sum":{"dataPoints":[{"attributes":[{"key":"env","value":{"stringValue":"prod"}},{"key":"canary","value":{"stringValue":""}}],` +
+		`"startTimeUnixNano":"1650000000000000000","timeUnixNano":"1650000010000000000","asDouble":3}],"aggregationTemporality":1}}]}]}]}`
+	assert.Equal(t, expected, <-received)
+	assert.Equal(t, int64(0), e.Lost())
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	return b
+}
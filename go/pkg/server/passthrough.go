@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNIRoute sends the TLS connections whose server name matches Pattern, using
+// path.Match syntax, to Upstream as they are.
+type SNIRoute struct {
+	Pattern  string
+	Upstream string
+}
+
+// ParseSNIRoute parses a route written as pattern=host:port, e.g.
+// *.logs.datadoghq.com=agent-intake.logs.datadoghq.com:10516.
+func ParseSNIRoute(s string) (SNIRoute, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return SNIRoute{}, newError(ErrRuleInvalid, fmt.Errorf("expected pattern=host:port in %q", s))
+	}
+	if _, err := path.Match(kv[0], ""); err != nil {
+		return SNIRoute{}, newError(ErrRuleInvalid, err)
+	}
+	if _, _, err := net.SplitHostPort(kv[1]); err != nil {
+		return SNIRoute{}, newError(ErrRuleInvalid, err)
+	}
+	return SNIRoute{Pattern: kv[0], Upstream: kv[1]}, nil
+}
+
+// Passthrough relays TCP connections that are not HTTP, such as logs sent over
+// TLS, to the upstream picked by the server name of their TLS ClientHello.
+// TLS is not terminated, the bytes are copied both ways and counted per
+// server name once the connection closes.
+type Passthrough struct {
+	routes       []SNIRoute
+	statsDClient StatsdClient
+	tags         []string
+	dialer       net.Dialer
+}
+
+// NewPassthrough creates a passthrough using the first matching route for
+// each connection.
+func NewPassthrough(routes []SNIRoute, statsDClient StatsdClient, tags []string) *Passthrough {
+	return &Passthrough{routes: routes, statsDClient: statsDClient, tags: tags, dialer: net.Dialer{Timeout: 10 * time.Second}}
+}
+
+// Serve relays the connections accepted on l until it is closed.
+func (p *Passthrough) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.relay(c)
+	}
+}
+
+func (p *Passthrough) relay(c net.Conn) {
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	serverName, hello, err := peekServerName(c)
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Could not read the TLS ClientHello from %s, %v", c.RemoteAddr(), err))
+		return
+	}
+	route, ok := p.route(serverName)
+	if !ok {
+		_ = p.statsDClient.Count(unroutedConnectionsCountName, 1, p.tagged("server_name:"+serverName), 1)
+		return
+	}
+	upstream, err := p.dialer.Dial("tcp", route.Upstream)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Could not connect to %s for %q, %v", route.Upstream, serverName, err))
+		return
+	}
+	defer upstream.Close()
+
+	var wg sync.WaitGroup
+	var sent, received int64
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sent, _ = io.Copy(upstream, io.MultiReader(bytes.NewReader(hello), c))
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			_ = tc.CloseWrite()
+		}
+	}()
+	received, _ = io.Copy(c, upstream)
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.CloseWrite()
+	}
+	wg.Wait()
+	tags := p.tagged("server_name:"+serverName, "upstream:"+route.Upstream)
+	_ = p.statsDClient.Count(passthroughSentBytesCountName, sent, tags, 1)
+	_ = p.statsDClient.Count(passthroughReceivedBytesCountName, received, tags, 1)
+}
+
+func (p *Passthrough) route(serverName string) (SNIRoute, bool) {
+	for _, r := range p.routes {
+		if ok, _ := path.Match(r.Pattern, serverName); ok {
+			return r, true
+		}
+	}
+	return SNIRoute{}, false
+}
+
+func (p *Passthrough) tagged(extra ...string) []string {
+	tags := make([]string, 0, len(p.tags)+len(extra))
+	return append(append(tags, p.tags...), extra...)
+}
+
+var errHelloRead = errors.New("hello read")
+
+// peekServerName reads the TLS ClientHello of c, returning its server name and
+// the bytes read so they can be replayed to the upstream.
+func peekServerName(c net.Conn) (string, []byte, error) {
+	var read bytes.Buffer
+	var serverName string
+	err := tls.Server(readOnlyConn{r: io.TeeReader(c, &read), Conn: c}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !errors.Is(err, errHelloRead) {
+		return "", nil, err
+	}
+	return serverName, read.Bytes(), nil
+}
+
+// readOnlyConn lets the TLS server read a ClientHello without answering it.
+type readOnlyConn struct {
+	r io.Reader
+	net.Conn
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c readOnlyConn) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
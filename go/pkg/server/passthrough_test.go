@@ -0,0 +1,115 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseSNIRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		route    string
+		expected server.SNIRoute
+		err      bool
+	}{
+		{
+			name:     "Route",
+			route:    "*.logs.datadoghq.com=agent-intake.logs.datadoghq.com:10516",
+			expected: server.SNIRoute{Pattern: "*.logs.datadoghq.com", Upstream: "agent-intake.logs.datadoghq.com:10516"},
+		},
+		{
+			name:  "Missing upstream",
+			route: "*.logs.datadoghq.com",
+			err:   true,
+		},
+		{
+			name:  "Missing port",
+			route: "*=agent-intake.logs.datadoghq.com",
+			err:   true,
+		},
+		{
+			name:  "Bad pattern",
+			route: "[=localhost:10516",
+			err:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseSNIRoute(tc.route)
+			if tc.err {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	// Given a TLS upstream and a passthrough routing example.com to it
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+	sc := &stubStatsdClient{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	p := server.NewPassthrough([]server.SNIRoute{{Pattern: "example.com", Upstream: ts.Listener.Addr().String()}}, sc, []string{"one"})
+	go func() { _ = p.Serve(l) }()
+
+	// When a client sends a request for example.com through it
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, l.Addr().String())
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("https://example.com/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+
+	// Then the upstream answers it over the TLS session of the client
+	assert.Equal(t, "ok", string(body))
+
+	// And the bytes relayed both ways are counted
+	tags := []string{"one", "server_name:example.com", "upstream:" + ts.Listener.Addr().String()}
+	assert.Eventually(t, func() bool {
+		sc.Lock()
+		defer sc.Unlock()
+		sent, ok := sc.counts["proxy_filter.passthrough.sent_bytes.count"]
+		received := sc.counts["proxy_filter.passthrough.received_bytes.count"]
+		return ok && sent.value > 0 && received.value > 0
+	}, time.Second, 10*time.Millisecond)
+	sc.Lock()
+	assert.Equal(t, tags, sc.counts["proxy_filter.passthrough.sent_bytes.count"].tags)
+	sc.Unlock()
+
+	// When a client connects for a server name without a route
+	c, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: "other.org", InsecureSkipVerify: true})
+
+	// Then the connection is closed and counted
+	require.Error(t, err)
+	assert.Nil(t, c)
+	assert.Eventually(t, func() bool {
+		sc.Lock()
+		defer sc.Unlock()
+		_, ok := sc.counts["proxy_filter.passthrough.unrouted.count"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	sc.assertCount(t, "proxy_filter.passthrough.unrouted.count", 1, []string{"one", "server_name:other.org"}, 1, true)
+}
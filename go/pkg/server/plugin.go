@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+const filterSymbol = "Filter"
+
+// LoadFilterPlugin opens the Go plugin at path and returns the filter it
+// exports as Filter. The symbol can either be a variable of a type that
+// implements filter.Filter or a variable declared as a filter.Filter.
+func LoadFilterPlugin(path string) (filter.Filter, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, newError(ErrPlugin, fmt.Errorf("could not open %s, %w", path, err))
+	}
+
+	sym, err := p.Lookup(filterSymbol)
+	if err != nil {
+		return nil, newError(ErrPlugin, fmt.Errorf("could not find %s in %s, %w", filterSymbol, path, err))
+	}
+
+	switch f := sym.(type) {
+	case *filter.Filter:
+		if *f == nil {
+			return nil, newError(ErrRuleInvalid, fmt.Errorf("%s in %s is nil", filterSymbol, path))
+		}
+		return *f, nil
+	case filter.Filter:
+		return f, nil
+	}
+	return nil, newError(ErrRuleInvalid, fmt.Errorf("%s in %s is a %T which does not implement filter.Filter", filterSymbol, path, sym))
+}
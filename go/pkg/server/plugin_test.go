@@ -0,0 +1,24 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestLoadFilterPlugin(t *testing.T) {
+	// Given a plugin path that does not exist
+	path := t.TempDir() + "/missing.so"
+
+	// When we load the plugin
+	f, err := server.LoadFilterPlugin(path)
+
+	// Then we get an error
+	require.Error(t, err)
+	assert.ErrorIs(t, err, server.ErrPlugin)
+	assert.Nil(t, f)
+	assert.Contains(t, err.Error(), path)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UpstreamProbe is the result of the last ProbeUpstream.
+type UpstreamProbe struct {
+	URL                 string        `json:"url"`
+	Healthy             bool          `json:"healthy"`
+	Status              int           `json:"status,omitempty"`
+	Error               string        `json:"error,omitempty"`
+	Latency             time.Duration `json:"latency"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	At                  time.Time     `json:"at"`
+}
+
+// ProbeUpstream sends a GET request to Config.UpstreamProbePath on the base
+// endpoint, through the same client as the proxied requests, and sends
+// whether it succeeded and how long it took as gauges, when the statsd client
+// can send gauges. The upstream is healthy when it answers with anything but
+// a 5xx, e.g. a 403 for a missing API key still proves it reachable. After
+// Config.UpstreamProbeFailures failed probes in a row the proxy reports
+// itself as not ready, until a probe succeeds again. Call it periodically.
+func (h *Handler) ProbeUpstream(ctx context.Context) UpstreamProbe {
+	c := h.current()
+	probe := UpstreamProbe{URL: strings.TrimSuffix(c.cfg.BaseEndpoint, "/") + c.cfg.UpstreamProbePath, At: h.clock.Now()}
+	status, err := c.probeUpstream(ctx, probe.URL)
+	probe.Latency = h.clock.Now().Sub(probe.At)
+	probe.Status = status
+	switch {
+	case err != nil:
+		probe.Error = err.Error()
+	case status >= http.StatusInternalServerError:
+		probe.Error = fmt.Sprintf("got %d", status)
+	default:
+		probe.Healthy = true
+	}
+
+	failures := c.cfg.UpstreamProbeFailures
+	if failures < 1 {
+		failures = 1
+	}
+	h.health.mu.Lock()
+	if !probe.Healthy {
+		probe.ConsecutiveFailures = 1
+		if h.health.probe != nil {
+			probe.ConsecutiveFailures += h.health.probe.ConsecutiveFailures
+		}
+	}
+	wasDown := h.health.upstreamDown
+	h.health.upstreamDown = probe.ConsecutiveFailures >= failures
+	h.health.probe = &probe
+	down := h.health.upstreamDown
+	h.health.mu.Unlock()
+
+	if g, ok := h.statsDClient.(gauger); ok {
+		healthy := 0.0
+		if probe.Healthy {
+			healthy = 1
+		}
+		_ = g.Gauge(upstreamHealthyGaugeName, healthy, h.cfg.Tags, 1)
+		_ = g.Gauge(upstreamProbeLatencyGaugeName, probe.Latency.Seconds(), h.cfg.Tags, 1)
+	}
+	if down && !wasDown {
+		fmt.Println(fmt.Sprintf("Upstream %s failed %d probes in a row, %s, reporting not ready", probe.URL, probe.ConsecutiveFailures, probe.Error))
+	} else if wasDown && !down {
+		fmt.Println(fmt.Sprintf("Upstream %s is healthy again, reporting ready", probe.URL))
+	}
+	return probe
+}
+
+func (h *Handler) probeUpstream(ctx context.Context, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if h.cfg.UpstreamProbeAPIKey != "" {
+		req.Header.Set("DD-API-KEY", h.cfg.UpstreamProbeAPIKey)
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// upstreamProbe returns the last probe, and whether enough of them failed
+// in a row for the proxy to be reported as not ready.
+func (h *Handler) upstreamProbe() (*UpstreamProbe, bool) {
+	h.health.mu.Lock()
+	defer h.health.mu.Unlock()
+	return h.health.probe, h.health.upstreamDown
+}
@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_ProbeUpstream(t *testing.T) {
+	// Given an upstream answering with a status that can change
+	var status int32 = http.StatusForbidden
+	var apiKey atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey.Store(r.URL.Path + " " + r.Header.Get("DD-API-KEY"))
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer upstream.Close()
+	sc := &stubGaugeClient{}
+	cfg := server.Config{
+		BaseEndpoint:          upstream.URL,
+		Tags:                  []string{"one", "two"},
+		UpstreamProbePath:     "/api/v1/validate",
+		UpstreamProbeAPIKey:   "key",
+		UpstreamProbeFailures: 2,
+	}
+	h := server.NewHandler(cfg, upstream.Client(), sc)
+
+	// When it is probed while rejecting the API key
+	probe := h.ProbeUpstream(context.Background())
+
+	// Then it is healthy, being reachable
+	assert.True(t, probe.Healthy)
+	assert.Equal(t, http.StatusForbidden, probe.Status)
+	assert.Equal(t, "/api/v1/validate key", apiKey.Load())
+	assert.Equal(t, 1.0, sc.gauges["proxy_filter.upstream.healthy one"])
+	assert.True(t, h.Ready())
+
+	// When it fails once
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	probe = h.ProbeUpstream(context.Background())
+
+	// Then it is unhealthy but the proxy stays ready
+	assert.False(t, probe.Healthy)
+	assert.Equal(t, "got 502", probe.Error)
+	assert.Equal(t, 0.0, sc.gauges["proxy_filter.upstream.healthy one"])
+	assert.True(t, h.Ready())
+
+	// When it fails again
+	probe = h.ProbeUpstream(context.Background())
+
+	// Then the proxy is not ready
+	assert.Equal(t, 2, probe.ConsecutiveFailures)
+	assert.False(t, h.Ready())
+	rec := httptest.NewRecorder()
+	h.Health().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"upstream_probe":"2 probes failed in a row, got 502"`)
+
+	// When it recovers
+	atomic.StoreInt32(&status, http.StatusOK)
+	probe = h.ProbeUpstream(context.Background())
+
+	// Then the proxy is ready again
+	assert.True(t, probe.Healthy)
+	assert.Equal(t, 0, probe.ConsecutiveFailures)
+	assert.True(t, h.Ready())
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// The process agent payloads start with a header of version, encoding, type,
+// subscription, org ID and timestamp, followed by the message.
+const (
+	collectorHeaderLength = 16
+
+	collectorEncodingProtobuf = 0
+
+	collectorTypeProc      = 12
+	collectorTypeContainer = 39
+)
+
+// Field numbers of the process agent messages, as defined by the agent
+// payload protos.
+const (
+	collectorProcProcesses       = 3
+	collectorProcContainers      = 10
+	collectorContainerContainers = 2
+
+	processCommand = 3
+	processTags    = 22
+
+	commandArgs = 1
+	commandExe  = 8
+	commandComm = 9
+
+	containerName = 3
+	containerTags = 24
+)
+
+// ScrubbedArg replaces the process arguments matching Config.ProcessArgPatterns.
+const ScrubbedArg = "********"
+
+// ProcessRule drops the processes and containers whose name matches Name,
+// using path.Match syntax, and that carry every one of Tags. The name of a
+// process is its command name, falling back to the base of its executable.
+type ProcessRule struct {
+	Name string
+	Tags []string
+}
+
+// ParseProcessRule parses a rule written as comma separated key=value pairs
+// with the keys name and tag, tag being repeatable, e.g. name=java*,tag=env:dev.
+func ParseProcessRule(s string) (ProcessRule, error) {
+	var rule ProcessRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return ProcessRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "name":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return ProcessRule{}, newError(ErrRuleInvalid, err)
+			}
+			rule.Name = kv[1]
+		case "tag":
+			rule.Tags = append(rule.Tags, kv[1])
+		default:
+			return ProcessRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the process or container is dropped by the rule.
+func (pr ProcessRule) Match(name string, tags []string) bool {
+	if pr.Name == "" && len(pr.Tags) == 0 {
+		return false
+	}
+	if pr.Name != "" {
+		if ok, _ := path.Match(pr.Name, name); !ok {
+			return false
+		}
+	}
+	return hasTags(tags, pr.Tags)
+}
+
+// ProcessFilter filters the process and container payloads sent to
+// /api/v1/collector with Config.ProcessRules, and scrubs the arguments of the
+// kept processes with Config.ProcessArgPatterns. Only protobuf payloads are
+// filtered, compressed ones are forwarded as they came.
+func (h *Handler) ProcessFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).processFilter)
+}
+
+func (h *Handler) processFilter(w http.ResponseWriter, r *http.Request) {
+	if (len(h.cfg.ProcessRules) == 0 && len(h.cfg.ProcessArgPatterns) == 0) || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterProcesses(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+func (h *Handler) filterProcesses(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	return h.filterCollector(r, func(typ byte, msg []byte) ([]byte, error) {
+		var processes, containers protowire.Number
+		switch typ {
+		case collectorTypeProc:
+			processes, containers = collectorProcProcesses, collectorProcContainers
+		case collectorTypeContainer:
+			containers = collectorContainerContainers
+		default:
+			return msg, nil
+		}
+		var dropped int64
+		out, err := filterMessages(msg, func(num protowire.Number, v []byte) ([]byte, bool, error) {
+			if num != processes && num != containers {
+				return v, true, nil
+			}
+			kind, name, tags, err := decodeProcess(v, num == processes)
+			if err != nil {
+				return nil, false, err
+			}
+			if h.dropProcess(name, tags) {
+				meta.RecordDrop(kind)
+				dropped++
+				return nil, false, nil
+			}
+			if num == processes {
+				v = h.scrubProcess(v)
+			}
+			return v, true, nil
+		})
+		_ = h.statsDClient.Count(filteredProcessesCountName, dropped, h.cfg.Tags, 1)
+		return out, err
+	})
+}
+
+// filterCollector reads a process agent payload and replaces its message
+// with what filter returns for it. Payloads not encoded as plain protobuf, and
+// those filter leaves unchanged, are forwarded as they came.
+func (h *Handler) filterCollector(r *http.Request, filter func(typ byte, msg []byte) ([]byte, error)) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	if len(body) < collectorHeaderLength {
+		return nil, newError(ErrDecode, fmt.Errorf("payload of %d bytes is shorter than its header", len(body)))
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	if body[1] != collectorEncodingProtobuf {
+		meta.RecordTiming("filter", clock.Since(h.clock, start))
+		return h.unchangedBody(r, raw)
+	}
+	msg, err := filter(body[2], body[collectorHeaderLength:])
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if bytes.Equal(msg, body[collectorHeaderLength:]) {
+		return h.unchangedBody(r, raw)
+	}
+	out := append(append(make([]byte, 0, collectorHeaderLength+len(msg)), body[:collectorHeaderLength]...), msg...)
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(out)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, nil
+}
+
+// filterMessages calls fn with the content of every length delimited field of
+// a protobuf message, keeping the field with the content fn returns unless it
+// says to drop it. Every other field is copied as it is.
+func filterMessages(msg []byte, fn func(num protowire.Number, v []byte) ([]byte, bool, error)) ([]byte, error) {
+	out := make([]byte, 0, len(msg))
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		m := protowire.ConsumeFieldValue(num, typ, msg[n:])
+		if m < 0 {
+			return nil, protowire.ParseError(m)
+		}
+		f := msg[:n+m]
+		msg = msg[n+m:]
+		if typ != protowire.BytesType {
+			out = append(out, f...)
+			continue
+		}
+		raw, _ := protowire.ConsumeBytes(f[n:])
+		v, keep, err := fn(num, raw)
+		if err != nil {
+			return nil, err
+		}
+		if !keep {
+			continue
+		}
+		out = protowire.AppendTag(out, num, protowire.BytesType)
+		out = protowire.AppendBytes(out, v)
+	}
+	return out, nil
+}
+
+// decodeProcess decodes what the rules look at in a process, or in a
+// container when process is false.
+func decodeProcess(b []byte, process bool) (kind, name string, tags []string, err error) {
+	if !process {
+		err = walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			switch {
+			case num == containerName && typ == protowire.BytesType:
+				name = string(v)
+			case num == containerTags && typ == protowire.BytesType:
+				tags = append(tags, string(v))
+			}
+			return nil
+		})
+		return "container", name, tags, err
+	}
+	var exe string
+	err = walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == processTags && typ == protowire.BytesType:
+			tags = append(tags, string(v))
+		case num == processCommand && typ == protowire.BytesType:
+			return walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == commandComm && typ == protowire.BytesType:
+					name = string(v)
+				case num == commandExe && typ == protowire.BytesType:
+					exe = string(v)
+				case num == commandArgs && typ == protowire.BytesType && exe == "":
+					exe = string(v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if name == "" && exe != "" {
+		name = path.Base(exe)
+	}
+	return "process", name, tags, err
+}
+
+func (h *Handler) dropProcess(name string, tags []string) bool {
+	for _, rule := range h.cfg.ProcessRules {
+		if rule.Match(name, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubProcess replaces the command arguments of a process known to be well
+// formed that match Config.ProcessArgPatterns with ScrubbedArg.
+func (h *Handler) scrubProcess(b []byte) []byte {
+	if len(h.cfg.ProcessArgPatterns) == 0 {
+		return b
+	}
+	return rewriteFields(b, processCommand, func(command []byte) []byte {
+		return rewriteFields(command, commandArgs, func(arg []byte) []byte {
+			for _, re := range h.cfg.ProcessArgPatterns {
+				if re.Match(arg) {
+					return []byte(ScrubbedArg)
+				}
+			}
+			return arg
+		})
+	})
+}
+
+// rewriteFields replaces the content of the length delimited fields num of a
+// message known to be well formed with what fn returns for it.
+func rewriteFields(b []byte, num protowire.Number, fn func([]byte) []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		m := protowire.ConsumeFieldValue(n, typ, b[l:])
+		field := b[:l+m]
+		b = b[l+m:]
+		if n != num || typ != protowire.BytesType {
+			out = append(out, field...)
+			continue
+		}
+		v, _ := protowire.ConsumeBytes(field[l:])
+		out = protowire.AppendTag(out, num, protowire.BytesType)
+		out = protowire.AppendBytes(out, fn(v))
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type processV1 struct {
+	args []string
+	exe  string
+	tags []string
+}
+
+// encodeCollectorProc encodes a process agent payload of the given encoding
+// holding a CollectorProc with the processes and the names of containers.
+func encodeCollectorProc(encoding byte, processes []processV1, containers ...string) []byte {
+	b := []byte{3, encoding, 12, 0, 0, 0, 0, 1, 0, 0, 0, 0, 98, 90, 0, 0}
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, "web-1")
+	for _, p := range processes {
+		var c []byte
+		for _, arg := range p.args {
+			c = protowire.AppendTag(c, 1, protowire.BytesType)
+			c = protowire.AppendString(c, arg)
+		}
+		if p.exe != "" {
+			c = protowire.AppendTag(c, 8, protowire.BytesType)
+			c = protowire.AppendString(c, p.exe)
+		}
+		var d []byte
+		d = protowire.AppendTag(d, 1, protowire.VarintType)
+		d = protowire.AppendVarint(d, 42)
+		d = protowire.AppendTag(d, 3, protowire.BytesType)
+		d = protowire.AppendBytes(d, c)
+		for _, tag := range p.tags {
+			d = protowire.AppendTag(d, 22, protowire.BytesType)
+			d = protowire.AppendString(d, tag)
+		}
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	for _, name := range containers {
+		var d []byte
+		d = protowire.AppendTag(d, 3, protowire.BytesType)
+		d = protowire.AppendString(d, name)
+		b = protowire.AppendTag(b, 10, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	return b
+}
+
+func TestParseProcessRule(t *testing.T) {
+	rule, err := server.ParseProcessRule("name=java*,tag=env:dev,tag=team:a")
+	require.NoError(t, err)
+	assert.Equal(t, server.ProcessRule{Name: "java*", Tags: []string{"env:dev", "team:a"}}, rule)
+
+	for _, s := range []string{"", "name=", "name=[", "pid=1"} {
+		_, err = server.ParseProcessRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_ProcessFilter(t *testing.T) {
+	java := processV1{args: []string{"/usr/bin/java", "-Dpassword=hunter2", "-jar", "app.jar"}, tags: []string{"env:dev"}}
+	nginx := processV1{args: []string{"nginx: master process"}, exe: "/usr/sbin/nginx"}
+	tests := []struct {
+		name          string
+		cfg           server.Config
+		encoding      byte
+		sent          []processV1
+		containers    []string
+		expected      []processV1
+		expContainers []string
+		dropped       int64
+	}{
+		{
+			name:          "Drop by name",
+			cfg:           server.Config{ProcessRules: []server.ProcessRule{{Name: "nginx"}, {Name: "redis-*"}}},
+			sent:          []processV1{java, nginx},
+			containers:    []string{"redis-cache", "web"},
+			expected:      []processV1{java},
+			expContainers: []string{"web"},
+			dropped:       2,
+		},
+		{
+			name:     "Drop by tag",
+			cfg:      server.Config{ProcessRules: []server.ProcessRule{{Tags: []string{"env:dev"}}}},
+			sent:     []processV1{java, nginx},
+			expected: []processV1{nginx},
+			dropped:  1,
+		},
+		{
+			name: "Scrub arguments",
+			cfg:  server.Config{ProcessArgPatterns: []*regexp.Regexp{regexp.MustCompile(`(?i)password=`)}},
+			sent: []processV1{java, nginx},
+			expected: []processV1{
+				{args: []string{"/usr/bin/java", "********", "-jar", "app.jar"}, tags: []string{"env:dev"}},
+				nginx,
+			},
+		},
+		{
+			name:     "Compressed payload forwarded",
+			cfg:      server.Config{ProcessRules: []server.ProcessRule{{Name: "nginx"}}},
+			encoding: 3,
+			sent:     []processV1{java, nginx},
+			expected: []processV1{java, nginx},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", tc.cfg)
+			defer ts.Close()
+
+			// When we send a process payload
+			body := encodeCollectorProc(tc.encoding, tc.sent, tc.containers...)
+			req := httptest.NewRequest("POST", "/api/v1/collector", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			rec := httptest.NewRecorder()
+			h.ProcessFilter(rec, req)
+
+			// Then the filtered payload is forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, encodeCollectorProc(tc.encoding, tc.expected, tc.expContainers...), []byte(actual.body))
+			if tc.encoding == 0 && len(tc.cfg.ProcessRules) > 0 {
+				sc.assertCount(t, "proxy_filter.filtered_processes.count", tc.dropped, nil, 1, true)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// ProfileRule drops the profile uploads of Service in Env that carry every one
+// of Tags, the fields left empty matching any upload.
+type ProfileRule struct {
+	Service string
+	Env     string
+	Tags    []string
+}
+
+// ParseProfileRule parses a rule written as comma separated key=value pairs
+// with the keys service, env and tag, tag being repeatable, e.g.
+// env=dev,tag=team:sandbox.
+func ParseProfileRule(s string) (ProfileRule, error) {
+	var rule ProfileRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return ProfileRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "service":
+			rule.Service = kv[1]
+		case "env":
+			rule.Env = kv[1]
+		case "tag":
+			rule.Tags = append(rule.Tags, kv[1])
+		default:
+			return ProfileRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the upload with the tags is dropped by the rule.
+func (pr ProfileRule) Match(tags []string) bool {
+	if pr.Service == "" && pr.Env == "" && len(pr.Tags) == 0 {
+		return false
+	}
+	want := pr.Tags
+	if pr.Service != "" {
+		want = append([]string{"service:" + pr.Service}, want...)
+	}
+	if pr.Env != "" {
+		want = append([]string{"env:" + pr.Env}, want...)
+	}
+	return hasTags(tags, want)
+}
+
+// ProfileFilter filters the profile uploads sent to /profiling/v1/input with
+// Config.ProfileRules. Kept uploads are forwarded as they came, dropped ones
+// are answered with 202 unless Config.DropResponses says otherwise.
+func (h *Handler) ProfileFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).profileFilter)
+}
+
+func (h *Handler) profileFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.ProfileRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, newError(ErrDecode, err))
+		return
+	}
+	tags, err := profileTags(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, newError(ErrDecode, err))
+		return
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	for _, rule := range h.cfg.ProfileRules {
+		if rule.Match(tags) {
+			meta.RecordDrop("profile")
+			_ = h.statsDClient.Count(filteredProfilesCountName, 1, h.cfg.Tags, 1)
+			h.writeDropped(w, r, DropResponse{Status: http.StatusAccepted})
+			return
+		}
+	}
+	h.proxyRequest(w, r, io.NopCloser(bytes.NewReader(body)))
+}
+
+// profileTags returns the tags of a multipart profile upload, found in the
+// tags_profiler field of its event part or, for older clients, in its tags[]
+// form fields.
+func profileTags(contentType string, body []byte) ([]string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/form-data" {
+		return nil, fmt.Errorf("expected a multipart form, got %q", mediaType)
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var tags []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return tags, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch part.FormName() {
+		case "event":
+			var event struct {
+				Tags string `json:"tags_profiler"`
+			}
+			if err := json.NewDecoder(part).Decode(&event); err != nil {
+				return nil, err
+			}
+			if event.Tags != "" {
+				tags = append(tags, strings.Split(event.Tags, ",")...)
+			}
+		case "tags[]":
+			tag, err := io.ReadAll(part)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, string(tag))
+		}
+	}
+}
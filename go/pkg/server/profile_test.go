@@ -0,0 +1,109 @@
+package server_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+// encodeProfile encodes a profile upload the way the profilers do, with its
+// tags in the event part or, for older clients, as tags[] form fields.
+func encodeProfile(t *testing.T, legacy bool, tags ...string) (*bytes.Buffer, string) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	if legacy {
+		for _, tag := range tags {
+			require.NoError(t, mw.WriteField("tags[]", tag))
+		}
+	} else {
+		fw, err := mw.CreateFormFile("event", "event.json")
+		require.NoError(t, err)
+		_, _ = fw.Write([]byte(`{"attachments":["cpu.pprof"],"tags_profiler":"` + strings.Join(tags, ",") + `","family":"go","version":"4"}`))
+	}
+	fw, err := mw.CreateFormFile("cpu.pprof", "cpu.pprof")
+	require.NoError(t, err)
+	_, _ = fw.Write([]byte{0x1f, 0x8b, 0x08, 0x00})
+	require.NoError(t, mw.Close())
+	return buf, mw.FormDataContentType()
+}
+
+func TestParseProfileRule(t *testing.T) {
+	rule, err := server.ParseProfileRule("service=web,env=dev,tag=team:sandbox")
+	require.NoError(t, err)
+	assert.Equal(t, server.ProfileRule{Service: "web", Env: "dev", Tags: []string{"team:sandbox"}}, rule)
+
+	for _, s := range []string{"", "env=", "host=web-1"} {
+		_, err = server.ParseProfileRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_ProfileFilter(t *testing.T) {
+	rules := []server.ProfileRule{{Env: "dev"}, {Service: "batch", Tags: []string{"team:data"}}}
+	tests := []struct {
+		name    string
+		legacy  bool
+		tags    []string
+		dropped bool
+	}{
+		{
+			name: "Kept",
+			tags: []string{"service:web", "env:prod", "host:web-1"},
+		},
+		{
+			name:    "Dropped by env",
+			tags:    []string{"service:web", "env:dev"},
+			dropped: true,
+		},
+		{
+			name:    "Dropped by service and tag",
+			tags:    []string{"service:batch", "env:prod", "team:data"},
+			dropped: true,
+		},
+		{
+			name:    "Legacy dropped",
+			legacy:  true,
+			tags:    []string{"service:web", "env:dev"},
+			dropped: true,
+		},
+		{
+			name:   "Legacy kept",
+			legacy: true,
+			tags:   []string{"service:batch", "env:prod"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with profile rules
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", server.Config{ProfileRules: rules, Tags: []string{"one"}})
+			defer ts.Close()
+
+			// When a profile is uploaded
+			body, contentType := encodeProfile(t, tc.legacy, tc.tags...)
+			sent := body.String()
+			req := httptest.NewRequest("POST", "/profiling/v1/input", body)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+			h.ProfileFilter(rec, req)
+
+			// Then it is dropped or forwarded as it came
+			if tc.dropped {
+				assert.Equal(t, http.StatusAccepted, rec.Code)
+				sc.assertCount(t, "proxy_filter.filtered_profiles.count", 1, []string{"one"}, 1, true)
+				return
+			}
+			assert.Equal(t, http.StatusTeapot, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, sent, actual.body)
+			sc.assertNotCounted(t, "proxy_filter.filtered_profiles.count")
+		})
+	}
+}
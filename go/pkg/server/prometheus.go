@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// Field numbers of the Prometheus remote-write WriteRequest protobuf, as
+// defined by the prompb protos.
+const (
+	promWriteTimeseries = 1
+
+	promSeriesLabels  = 1
+	promSeriesSamples = 2
+
+	promLabelName  = 1
+	promLabelValue = 2
+
+	promSampleValue     = 1
+	promSampleTimestamp = 2
+)
+
+// promNameLabel is the label holding the name of a Prometheus metric.
+const promNameLabel = "__name__"
+
+// PrometheusFilter receives Prometheus remote-write requests, snappy
+// compressed WriteRequest protobufs, and forwards their samples to
+// /api/v1/series as gauge series. A series is named after its __name__ label,
+// with its other labels as name:value tags and its samples as points. The
+// filters see the series as they are forwarded, and the kept ones are
+// rewritten like the v1 series.
+func (h *Handler) PrometheusFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).prometheusFilter)
+}
+
+func (h *Handler) prometheusFilter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, r, http.StatusMethodNotAllowed, newError(ErrDecode, fmt.Errorf("method %s is not allowed", r.Method)))
+		return
+	}
+
+	// The series are forwarded as a JSON payload, compressed with
+	// Config.Compression when set.
+	fr := r.Clone(r.Context())
+	fr.URL.Path = "/api/v1/series"
+	fr.Header.Set("Content-Type", "application/json")
+	fr.Header.Del("Content-Encoding")
+	fr.Header.Del("X-Prometheus-Remote-Write-Version")
+	buf, err := h.convertRemoteWrite(r, fr)
+	if err != nil {
+		// Prometheus retries 5xx responses, which a malformed payload would
+		// never get past.
+		h.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if h.cfg.ExportOnly {
+		h.writeExported(w)
+		return
+	}
+	h.proxyRequest(w, fr, io.NopCloser(buf))
+}
+
+// convertRemoteWrite returns the series of the remote-write request r kept by
+// the filters, as the body of the forwarded request fr.
+func (h *Handler) convertRemoteWrite(r, fr *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	begin := h.clock.Now()
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	var series []datadog.Series
+	err = walkFields(body, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != promWriteTimeseries || typ != protowire.BytesType {
+			return nil
+		}
+		s, err := decodePromSeries(v)
+		if err == nil && len(s.Points) > 0 {
+			series = append(series, s)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, begin))
+
+	start := h.clock.Now()
+	kept := make([]datadog.Series, 0, len(series))
+	slo := h.newSLOCounts()
+	for i := range series {
+		slo.add(series[i].Metric)
+		if h.dropSeries(r.Context(), &series[i]) {
+			continue
+		}
+		slo.keep(series[i].Metric)
+		h.rewriteSeries(r.Context(), &series[i])
+		kept = append(kept, series[i])
+	}
+	dropped := int64(len(series) - len(kept))
+	_ = h.statsDClient.Count(metricsFilteredCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(fr, buf)
+	err = json.NewEncoder(rw).Encode(datadog.MetricsPayload{Series: kept})
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	h.recordSLOs(slo, clock.Since(h.clock, begin))
+	return buf, nil
+}
+
+// decodePromSeries decodes a remote-write TimeSeries into a gauge series. The
+// timestamps of its samples are in milliseconds, and the series left without
+// samples are not forwarded.
+func decodePromSeries(b []byte) (datadog.Series, error) {
+	series := datadog.Series{Type: datadog.PtrString("gauge")}
+	var tags []string
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == promSeriesLabels && typ == protowire.BytesType:
+			var name, value string
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == promLabelName && typ == protowire.BytesType:
+					name = string(v)
+				case num == promLabelValue && typ == protowire.BytesType:
+					value = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if name == promNameLabel {
+				series.Metric = value
+			} else {
+				tags = append(tags, name+":"+value)
+			}
+		case num == promSeriesSamples && typ == protowire.BytesType:
+			var value, ts float64
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == promSampleValue && typ == protowire.Fixed64Type:
+					bits, _ := protowire.ConsumeFixed64(v)
+					value = math.Float64frombits(bits)
+				case num == promSampleTimestamp && typ == protowire.VarintType:
+					t, _ := protowire.ConsumeVarint(v)
+					ts = float64(int64(t) / 1000)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// NaN marks a stale series, which has no JSON encoding.
+			if !math.IsNaN(value) && !math.IsInf(value, 0) {
+				series.Points = append(series.Points, []*float64{&ts, &value})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return series, err
+	}
+	if series.Metric == "" {
+		return series, fmt.Errorf("series without a %s label", promNameLabel)
+	}
+	if tags != nil {
+		sort.Strings(tags)
+		series.SetTags(tags)
+	}
+	return series, nil
+}
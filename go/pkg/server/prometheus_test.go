@@ -0,0 +1,147 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+type promSample struct {
+	value float64
+	ms    int64
+}
+
+type promSeries struct {
+	labels  [][2]string
+	samples []promSample
+}
+
+func encodeWriteRequest(series ...promSeries) []byte {
+	var b []byte
+	for _, s := range series {
+		var d []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l[0])
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l[1])
+			d = protowire.AppendTag(d, 1, protowire.BytesType)
+			d = protowire.AppendBytes(d, lb)
+		}
+		for _, sample := range s.samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(sample.value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(sample.ms))
+			d = protowire.AppendTag(d, 2, protowire.BytesType)
+			d = protowire.AppendBytes(d, sb)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	return b
+}
+
+func TestHandler_PrometheusFilter(t *testing.T) {
+	// Given server is running with a prefix filter and a transform
+	cfg := server.Config{MetricsPrefixFilter: "go_", Transform: transform.AddTags{"proxied:true"}, Tags: []string{"one"}}
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// And a remote-write request
+	body := encodeWriteRequest(
+		promSeries{
+			labels:  [][2]string{{"__name__", "http_requests_total"}, {"job", "web"}, {"code", "200"}},
+			samples: []promSample{{value: 3, ms: 1650000000123}, {value: math.NaN(), ms: 1650000015000}},
+		},
+		promSeries{
+			labels:  [][2]string{{"__name__", "go_goroutines"}, {"job", "web"}},
+			samples: []promSample{{value: 12, ms: 1650000000000}},
+		},
+		promSeries{
+			labels:  [][2]string{{"__name__", "up"}},
+			samples: []promSample{{value: math.NaN(), ms: 1650000000000}},
+		},
+	)
+	req := httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(snappy.Encode(nil, body)))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	// When we make the request
+	rec := httptest.NewRecorder()
+	h.PrometheusFilter(rec, req)
+
+	// Then the kept series are forwarded as a v1 payload
+	require.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	assert.Equal(t, "/api/v1/series", actual.path)
+	assert.Equal(t, "application/json", actual.contentRequestTypeHeader)
+	var actualPayload datadog.MetricsPayload
+	require.NoError(t, json.Unmarshal([]byte(actual.body), &actualPayload))
+	expected := datadog.MetricsPayload{Series: []datadog.Series{{
+		Metric: "http_requests_total",
+		Type:   datadog.PtrString("gauge"),
+		Points: [][]*float64{{datadog.PtrFloat64(1650000000), datadog.PtrFloat64(3)}},
+		Tags:   &[]string{"code:200", "job:web", "proxied:true"},
+	}}}
+	assert.Equal(t, expected, actualPayload)
+	sc.assertCount(t, "proxy_filter.filtered_metrics.count", 1, []string{"one"}, 1, true)
+}
+
+func TestHandler_PrometheusFilter_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           []byte
+		expectedStatus int
+	}{
+		{
+			name:           "Not a POST",
+			method:         "GET",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Series without a name",
+			method:         "POST",
+			body:           encodeWriteRequest(promSeries{labels: [][2]string{{"job", "web"}}, samples: []promSample{{value: 1}}}),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Truncated",
+			method:         "POST",
+			body:           encodeWriteRequest(promSeries{labels: [][2]string{{"__name__", "up"}}})[:4],
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			_, ts, h, _ := setupCaptureServerWithConfig(t, "", server.Config{})
+			defer ts.Close()
+
+			// When we make the request
+			req := httptest.NewRequest(tc.method, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, tc.body)))
+			req.Header.Set("Content-Encoding", "snappy")
+			rec := httptest.NewRecorder()
+			h.PrometheusFilter(rec, req)
+
+			// Then it is rejected without reaching upstream
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+		})
+	}
+}
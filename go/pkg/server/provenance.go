@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ProvenanceTagKey is the key of the tag naming the rule set that let a series
+// through.
+const ProvenanceTagKey = "proxy_filter_version"
+
+// ProvenanceTag returns the tag naming the rule set made of settings, mapping
+// each setting to its values. The same settings always give the same tag,
+// whatever their order.
+func ProvenanceTag(settings map[string][]string) string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		for _, v := range settings[name] {
+			_, _ = fmt.Fprintf(hash, "%s=%s\n", name, v)
+		}
+	}
+	return ProvenanceTagKey + ":" + hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// liveProvenanceTag returns the tag naming the rule set made of the config
+// tagged with tag and the rules added through the admin API, tag itself when
+// there are none, and none when tag is empty.
+func liveProvenanceTag(tag string, runtime []RuntimeRule) string {
+	if tag == "" || len(runtime) == 0 {
+		return tag
+	}
+	rules := make([]string, 0, len(runtime))
+	for _, rule := range runtime {
+		rules = append(rules, rule.Rule)
+	}
+	return ProvenanceTag(map[string][]string{"config": {tag}, "runtime": rules})
+}
+
+// tagProvenance appends Config.ProvenanceTag to the tags of a v2 series known
+// to be well formed.
+func (h *Handler) tagProvenance(b []byte) []byte {
+	if h.cfg.ProvenanceTag == "" {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len(h.cfg.ProvenanceTag)+2)
+	out = append(out, b...)
+	out = protowire.AppendTag(out, v2SeriesTags, protowire.BytesType)
+	return protowire.AppendString(out, h.cfg.ProvenanceTag)
+}
@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestProvenanceTag(t *testing.T) {
+	tag := server.ProvenanceTag(map[string][]string{"drop": {"metric=a.", "metric=b."}, "prefix": {"c."}})
+	assert.Regexp(t, `^proxy_filter_version:[0-9a-f]{12}$`, tag)
+	assert.Equal(t, tag, server.ProvenanceTag(map[string][]string{"prefix": {"c."}, "drop": {"metric=a.", "metric=b."}}))
+	assert.NotEqual(t, tag, server.ProvenanceTag(map[string][]string{"drop": {"metric=b.", "metric=a."}, "prefix": {"c."}}))
+	assert.NotEqual(t, tag, server.ProvenanceTag(map[string][]string{"drop": {"metric=a."}, "prefix": {"c."}}))
+}
+
+func TestHandler_ProvenanceTag(t *testing.T) {
+	tag := server.ProvenanceTag(map[string][]string{"prefix": {"drop."}})
+	cfg := server.Config{ProvenanceTag: tag, MetricsPrefixFilter: "drop."}
+
+	t.Run("v1 series", func(t *testing.T) {
+		// Given server is running with a provenance tag
+		resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+		defer ts.Close()
+
+		// When we send series
+		body := `{"series":[{"metric":"keep.me","points":[],"tags":["env:dev"]},{"metric":"drop.me","points":[]}]}`
+		req := httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.MetricsFilter(httptest.NewRecorder(), req)
+
+		// Then the kept series carry the tag
+		actual := <-resultChan
+		var payload datadog.MetricsPayload
+		require.NoError(t, json.Unmarshal([]byte(actual.body), &payload))
+		require.Len(t, payload.Series, 1)
+		assert.Equal(t, []string{"env:dev", tag}, payload.Series[0].GetTags())
+	})
+
+	t.Run("v2 series", func(t *testing.T) {
+		// Given server is running with a provenance tag
+		resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+		defer ts.Close()
+
+		// When we send series
+		body := encodeSeriesV2(seriesV2{metric: "keep.me", tags: []string{"env:dev"}}, seriesV2{metric: "drop.me"})
+		h.MetricsFilterV2(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v2/series", bytes.NewReader(body)))
+
+		// Then the kept series carry the tag
+		actual := <-resultChan
+		series, err := server.DecodeSeriesV2([]byte(actual.body))
+		require.NoError(t, err)
+		require.Len(t, series, 1)
+		assert.Equal(t, []string{"env:dev", tag}, series[0].GetTags())
+	})
+}
+
+func TestHandler_ProvenanceTag_RuntimeRules(t *testing.T) {
+	// Given server is running with a provenance tag
+	tag := server.ProvenanceTag(map[string][]string{"prefix": {"drop."}})
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", server.Config{ProvenanceTag: tag, MetricsPrefixFilter: "drop."})
+	defer ts.Close()
+	admin := func(method, path, body string) int {
+		rec := httptest.NewRecorder()
+		h.Admin().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec.Code
+	}
+	forwardedTags := func() []string {
+		body := `{"series":[{"metric":"keep.me","points":[]}]}`
+		req := httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.MetricsFilter(httptest.NewRecorder(), req)
+		var payload datadog.MetricsPayload
+		require.NoError(t, json.Unmarshal([]byte((<-resultChan).body), &payload))
+		require.Len(t, payload.Series, 1)
+		return payload.Series[0].GetTags()
+	}
+
+	// When a rule is added through the admin API
+	require.Equal(t, http.StatusCreated, admin("POST", "/rules", `{"rule": "metric=app.debug."}`))
+
+	// Then the series carry the tag of the new rule set, as listed by its version
+	versions := h.RuleVersions()
+	require.Len(t, versions, 2)
+	assert.Equal(t, tag, versions[0].ProvenanceTag)
+	assert.Regexp(t, `^proxy_filter_version:[0-9a-f]{12}$`, versions[1].ProvenanceTag)
+	assert.NotEqual(t, tag, versions[1].ProvenanceTag)
+	assert.Equal(t, []string{versions[1].ProvenanceTag}, forwardedTags())
+
+	// When the rules are rolled back to the first version
+	require.Equal(t, http.StatusOK, admin("POST", "/rules/versions/1/rollback", ""))
+
+	// Then the series carry the tag of the config again
+	assert.Equal(t, []string{tag}, forwardedTags())
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	blamedHeader  = "X-Proxy-Filter-Blamed"
+	droppedHeader = "X-Proxy-Filter-Dropped"
+
+	// maxBlamedNames and maxBlamedSize bound the blamed header, as an error
+	// body of up to maxValidatedBodySize can quote far more than clients and
+	// load balancers accept in a header.
+	maxBlamedNames = 20
+	maxBlamedSize  = 1024
+)
+
+// quotedName matches the metric names and fields the intake quotes in its
+// error messages, e.g. Invalid metric name 'app.load!'.
+var quotedName = regexp.MustCompile(`['"` + "`" + `]([^'"` + "`" + `\s]+)['"` + "`" + `]`)
+
+// explainRejection logs why upstream rejected a request with a 4xx, along with
+// what the proxy did to the request, and counts the rejection. The names
+// blamed by upstream and the number of series the proxy dropped are added to
+// the response headers so they can be seen from the client. It returns the
+// body to send back to the client.
+func (h *Handler) explainRejection(w http.ResponseWriter, r *http.Request, resp *http.Response, body io.Reader) io.Reader {
+	if resp.StatusCode < 400 || resp.StatusCode > 499 {
+		return body
+	}
+	_ = h.statsDClient.Count(upstreamRejectionsCountName, 1, h.tags("route:"+routePattern(r), fmt.Sprintf("status_code:%d", resp.StatusCode)), 1)
+
+	buf, err := io.ReadAll(io.LimitReader(body, maxValidatedBodySize))
+	if err != nil {
+		return io.MultiReader(bytes.NewReader(buf), body)
+	}
+	errs := rejectionErrors(resp, buf)
+	blamed := blamedNames(errs)
+	dropped := RequestMetaFrom(r.Context()).Dropped()
+	var total int64
+	for _, n := range dropped {
+		total += n
+	}
+	if len(blamed) > 0 {
+		w.Header().Set(blamedHeader, strings.Join(blamed, ","))
+	}
+	w.Header().Set(droppedHeader, strconv.FormatInt(total, 10))
+	fmt.Println(fmt.Sprintf("Upstream rejected request to %s from tenant %q with %d, errors %q, blamed %q, dropped %v", r.URL.Path, tenantOf(r), resp.StatusCode, errs, blamed, dropped))
+	return io.MultiReader(bytes.NewReader(buf), body)
+}
+
+// rejectionErrors returns the messages of an intake error body, which looks
+// like {"errors":["..."]}, or the body itself when it is not one.
+func rejectionErrors(resp *http.Response, body []byte) []string {
+	rc, err := decodeResponseBody(resp, body)
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	decoded, err := io.ReadAll(rc)
+	if err != nil {
+		return nil
+	}
+	var payload struct {
+		Errors []string `json:"errors"`
+	}
+	if err = json.Unmarshal(decoded, &payload); err != nil || len(payload.Errors) == 0 {
+		if msg := strings.TrimSpace(string(decoded)); msg != "" {
+			return []string{msg}
+		}
+		return nil
+	}
+	return payload.Errors
+}
+
+// blamedNames returns the distinct metric names and fields quoted in errs, at
+// most maxBlamedNames of them and as many as fit in maxBlamedSize bytes once
+// joined by commas. A name too long to fit is skipped.
+func blamedNames(errs []string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	size := -1
+	for _, e := range errs {
+		for _, m := range quotedName.FindAllStringSubmatch(e, -1) {
+			if len(res) == maxBlamedNames {
+				return res
+			}
+			if seen[m[1]] || size+1+len(m[1]) > maxBlamedSize {
+				continue
+			}
+			seen[m[1]] = true
+			size += 1 + len(m[1])
+			res = append(res, m[1])
+		}
+	}
+	return res
+}
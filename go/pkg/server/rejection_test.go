@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_UpstreamRejection(t *testing.T) {
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = io.WriteString(zw, s)
+		_ = zw.Close()
+		return buf.String()
+	}
+	blaming := func(names ...string) string {
+		errs := make([]string, len(names))
+		for i, name := range names {
+			errs[i] = fmt.Sprintf("Invalid metric name '%s'", name)
+		}
+		b, _ := json.Marshal(map[string][]string{"errors": errs})
+		return string(b)
+	}
+	var many []string
+	for i := 0; i < 30; i++ {
+		many = append(many, fmt.Sprintf("metric.%d", i))
+	}
+	tests := []struct {
+		name            string
+		status          int
+		encoding        string
+		body            string
+		expectedBlamed  string
+		expectedDropped string
+	}{
+		{
+			name:            "Intake error blaming a metric",
+			status:          http.StatusBadRequest,
+			body:            `{"errors":["Invalid metric name 'some.metric!'","Field \"points\" is required"]}`,
+			expectedBlamed:  "some.metric!,points",
+			expectedDropped: "1",
+		},
+		{
+			name:            "Gzipped intake error",
+			status:          http.StatusBadRequest,
+			encoding:        "gzip",
+			body:            gzipped(`{"errors":["Invalid metric name 'some.metric!'"]}`),
+			expectedBlamed:  "some.metric!",
+			expectedDropped: "1",
+		},
+		{
+			name:            "Intake error blaming too many metrics",
+			status:          http.StatusBadRequest,
+			body:            blaming(append([]string{"metric.0", "metric.0"}, many...)...),
+			expectedBlamed:  strings.Join(many[:20], ","),
+			expectedDropped: "1",
+		},
+		{
+			name:            "Intake error blaming a metric too long for the header",
+			status:          http.StatusBadRequest,
+			body:            blaming(strings.Repeat("a", 1025), "some.metric!"),
+			expectedBlamed:  "some.metric!",
+			expectedDropped: "1",
+		},
+		{
+			name:            "Plain text error",
+			status:          http.StatusForbidden,
+			body:            "Forbidden",
+			expectedDropped: "1",
+		},
+		{
+			name:            "Rate limited",
+			status:          http.StatusTooManyRequests,
+			body:            "Too Many Requests",
+			expectedDropped: "1",
+		},
+		{
+			name:   "Accepted",
+			status: http.StatusAccepted,
+			body:   "{}",
+		},
+		{
+			name:   "Unavailable",
+			status: http.StatusServiceUnavailable,
+			body:   "Service Unavailable",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an upstream rejecting payloads
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.encoding != "" {
+					w.Header().Set("Content-Encoding", tc.encoding)
+				}
+				w.WriteHeader(tc.status)
+				_, _ = io.WriteString(w, tc.body)
+			}))
+			defer ts.Close()
+			sc := &stubStatsdClient{}
+			h := server.NewHandler(server.Config{BaseEndpoint: ts.URL, MetricsPrefixFilter: "drop.", Tags: []string{"one"}}, ts.Client(), sc)
+
+			// When we send a payload with a series the proxy drops
+			payload, err := json.Marshal(defaultMetricsPayload([]string{"some.metric!", "drop.me"}))
+			require.NoError(t, err)
+			req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(payload))
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+
+			// Then the response is passed on
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.body, rec.Body.String())
+
+			// And enriched with what upstream blamed and the proxy dropped
+			assert.Equal(t, tc.expectedBlamed, rec.Header().Get("X-Proxy-Filter-Blamed"))
+			assert.Equal(t, tc.expectedDropped, rec.Header().Get("X-Proxy-Filter-Dropped"))
+
+			// And the response of upstream is counted by status
+			sc.assertCount(t, "proxy_filter.upstream_responses.count", 1, []string{"one", "route:/api/v1/series", "status_code:" + strconv.Itoa(tc.status), "status_class:" + strconv.Itoa(tc.status/100) + "xx"}, 1, true)
+
+			// And the rejection is counted
+			if tc.expectedDropped == "" {
+				sc.assertNotCounted(t, "proxy_filter.upstream_rejections.count")
+				return
+			}
+			sc.assertCount(t, "proxy_filter.upstream_rejections.count", 1, []string{"one", "route:/api/v1/series", "status_code:" + strconv.Itoa(tc.status)}, 1, true)
+		})
+	}
+}
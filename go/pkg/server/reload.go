@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// handlerRules holds the parts of a Handler that Reload replaces.
+type handlerRules struct {
+	cfg           Config
+	filters       filter.Chain
+	grpcTransport http.RoundTripper
+	backends      *backendTracker
+	coalesce      *coalescer
+	router        *http.ServeMux
+}
+
+// liveRules holds the rules every request starts with, shared by the copies
+// of a Handler.
+type liveRules struct {
+	mu          sync.Mutex
+	rules       atomic.Value
+	versions    []ruleVersion
+	nextVersion int64
+	// active is the ruleSet in effect, stored by recordVersion.
+	active atomic.Value
+}
+
+// ruleSet names the rule set a request is handled with.
+type ruleSet struct {
+	version       int64
+	provenanceTag string
+}
+
+func newHandlerRules(cfg Config, httpClient *http.Client) *handlerRules {
+	rules := &handlerRules{cfg: cfg, router: newRouter(cfg.Routes)}
+	if cfg.MetricsPrefixFilter != "" {
+		rules.filters = append(rules.filters, filter.Rule{MetricPrefix: cfg.MetricsPrefixFilter})
+	}
+	if cfg.Filter != nil {
+		rules.filters = append(rules.filters, cfg.Filter)
+	}
+	rules.grpcTransport = newGRPCTransport(cfg.BaseEndpoint, httpClient)
+	if len(cfg.Backends) > 0 {
+		rules.backends = newBackendTracker(append([]string{cfg.BaseEndpoint}, cfg.Backends...))
+	}
+	if len(cfg.CoalesceRoutes) > 0 {
+		rules.coalesce = newCoalescer()
+	}
+	return rules
+}
+
+// Reload replaces the filter rules and the routing of the handler with the
+// ones of cfg, e.g. on SIGHUP. Requests in flight finish with the rules they
+// started with, the next ones get the new rules, so no request is dropped.
+// What the handler tracks or sends in the background outlives the reload,
+// so it keeps the Tags, SLOs, FDWarnRatio, MaxAgents, AdminTokens,
+// MirrorEndpoint, MirrorMaxInFlight, Archiver, DecisionSinks, DropSinks,
+// Exporters, ExportOnly, Codecs, Clock and ErrorHandler it was created with,
+// ignoring the ones of cfg, and the rules added through the admin API. The
+// new rule set is recorded as a version changed by by, e.g. SIGHUP. Reload is
+// safe for concurrent use.
+func (h *Handler) Reload(cfg Config, by string) {
+	h.live.mu.Lock()
+	defer h.live.mu.Unlock()
+	cur := h.live.rules.Load().(*handlerRules)
+	kept := cur.cfg
+	cfg.Tags, cfg.SLOs, cfg.FDWarnRatio, cfg.MaxAgents, cfg.AdminTokens = kept.Tags, kept.SLOs, kept.FDWarnRatio, kept.MaxAgents, kept.AdminTokens
+	cfg.MirrorEndpoint, cfg.MirrorMaxInFlight, cfg.Archiver = kept.MirrorEndpoint, kept.MirrorMaxInFlight, kept.Archiver
+	cfg.DecisionSinks, cfg.DropSinks, cfg.Exporters, cfg.ExportOnly = kept.DecisionSinks, kept.DropSinks, kept.Exporters, kept.ExportOnly
+	cfg.Codecs, cfg.Clock, cfg.ErrorHandler = kept.Codecs, kept.Clock, kept.ErrorHandler
+	rules := newHandlerRules(cfg, h.httpClient)
+	// The health of the backends and the calls being coalesced carry over
+	// when they do not change.
+	if cfg.BaseEndpoint == kept.BaseEndpoint {
+		rules.grpcTransport = cur.grpcTransport
+		if rules.backends != nil && equalStrings(cfg.Backends, kept.Backends) {
+			rules.backends = cur.backends
+		}
+	}
+	if rules.coalesce != nil && cur.coalesce != nil {
+		rules.coalesce = cur.coalesce
+	}
+	h.live.rules.Store(rules)
+	h.recordVersion(by, "reload")
+}
+
+// current returns a copy of the handler with the rules last loaded, for a
+// request to use throughout.
+func (h *Handler) current() *Handler {
+	if h.live == nil {
+		return h
+	}
+	rules := h.live.rules.Load().(*handlerRules)
+	c := *h
+	c.cfg, c.filters, c.grpcTransport, c.backends, c.coalesce, c.router = rules.cfg, rules.filters, rules.grpcTransport, rules.backends, rules.coalesce, rules.router
+	c.ruleSet, _ = h.live.active.Load().(ruleSet)
+	// The rules added through the admin API change the provenance tag of the
+	// config.
+	if c.cfg.ProvenanceTag != "" && c.ruleSet.provenanceTag != "" {
+		c.cfg.ProvenanceTag = c.ruleSet.provenanceTag
+	}
+	c.inMaintenance = h.maintenance.enabled(h.clock.Now())
+	c.dropAllScope = nil
+	if !c.inMaintenance && h.dropAll.enabled(h.clock.Now()) {
+		c.dropAllScope, _ = h.dropAll.scopeOf().(*DropAllScope)
+	}
+	if runtime := h.runtime.filters(); len(runtime) > 0 {
+		c.filters = append(rules.filters[:len(rules.filters):len(rules.filters)], runtime...)
+	}
+	return &c
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
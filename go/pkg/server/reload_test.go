@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Reload(t *testing.T) {
+	// Given server is running with a filter that holds the series it sees
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	cfg := server.Config{
+		Filter: filter.Func(func(_ context.Context, series *datadog.Series) filter.Decision {
+			once.Do(func() { close(started) })
+			<-release
+			if strings.HasPrefix(series.Metric, "some.metric") {
+				return filter.Drop
+			}
+			return filter.Keep
+		}),
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+	send := func() {
+		body := mustMarshal(t, defaultMetricsPayload([]string{"some.metric.load", "system.load.1"}))
+		req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.MetricsFilter(httptest.NewRecorder(), req)
+	}
+
+	// And a request in flight
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		send()
+	}()
+	<-started
+
+	// When the rules are reloaded
+	h.Reload(server.Config{BaseEndpoint: ts.URL, MetricsPrefixFilter: "system."}, "test")
+	close(release)
+
+	// Then the request in flight finishes with the rules it started with
+	assert.Equal(t, []string{"system.load.1"}, forwardedMetrics(t, <-resultChan))
+	<-done
+
+	// And the next one gets the new rules
+	send()
+	assert.Equal(t, []string{"some.metric.load"}, forwardedMetrics(t, <-resultChan))
+}
+
+func forwardedMetrics(t *testing.T, res result) []string {
+	var payload datadog.MetricsPayload
+	require.NoError(t, json.Unmarshal([]byte(res.body), &payload))
+	var metrics []string
+	for _, series := range payload.Series {
+		metrics = append(metrics, series.Metric)
+	}
+	return metrics
+}
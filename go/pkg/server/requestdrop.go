@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// RequestRule drops a whole request matching every field it sets, answering
+// the client with Status instead of forwarding it. HeaderPattern uses
+// path.Match syntax against the value of Header, e.g. old-exporter/1.* for
+// User-Agent, and MinSize matches requests declaring a Content-Length of at
+// least that many bytes, or, for a chunked request, whose body is.
+type RequestRule struct {
+	Path          string
+	Tenant        string
+	Header        string
+	HeaderPattern string
+	MinSize       int64
+	// Status is sent back to the client, when unset the drop response of
+	// the path is, which defaults to 202 so clients do not retry.
+	Status int
+}
+
+// ParseRequestRule parses a rule written as comma separated key=value pairs
+// with the keys path, tenant, header, min-size and status, where header is
+// written as name:pattern, e.g. header=User-Agent:old-exporter/1.*,status=410.
+func ParseRequestRule(s string) (RequestRule, error) {
+	var rule RequestRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return RequestRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "path":
+			rule.Path = kv[1]
+		case "tenant":
+			rule.Tenant = kv[1]
+		case "header":
+			nv := strings.SplitN(kv[1], ":", 2)
+			if len(nv) != 2 || nv[0] == "" {
+				return RequestRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected header=name:pattern in %q", field))
+			}
+			if _, err := path.Match(nv[1], ""); err != nil {
+				return RequestRule{}, newError(ErrRuleInvalid, err)
+			}
+			rule.Header, rule.HeaderPattern = http.CanonicalHeaderKey(nv[0]), nv[1]
+		case "min-size":
+			size, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || size <= 0 {
+				return RequestRule{}, newError(ErrRuleInvalid, fmt.Errorf("bad min-size %q", kv[1]))
+			}
+			rule.MinSize = size
+		case "status":
+			status, err := strconv.Atoi(kv[1])
+			if err != nil || status < 100 || status > 599 {
+				return RequestRule{}, newError(ErrRuleInvalid, fmt.Errorf("bad status %q", kv[1]))
+			}
+			rule.Status = status
+		default:
+			return RequestRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the request is dropped by the rule.
+func (rr RequestRule) Match(r *http.Request) bool {
+	if rr.Path == "" && rr.Tenant == "" && rr.Header == "" && rr.MinSize == 0 {
+		return false
+	}
+	if rr.Path != "" && r.URL.Path != rr.Path {
+		return false
+	}
+	if rr.Tenant != "" && tenantOf(r) != rr.Tenant {
+		return false
+	}
+	if rr.Header != "" {
+		if ok, _ := path.Match(rr.HeaderPattern, r.Header.Get(rr.Header)); !ok {
+			return false
+		}
+	}
+	if rr.MinSize != 0 && bodySize(r, rr.MinSize) < rr.MinSize {
+		return false
+	}
+	return true
+}
+
+// bodySize returns the Content-Length of the request, or when it declares none
+// the size of its body up to limit, putting back the bytes it read so that a
+// large chunked body is not buffered whole to be matched.
+func bodySize(r *http.Request, limit int64) int64 {
+	if r.ContentLength >= 0 || r.Body == nil {
+		return r.ContentLength
+	}
+	head, _ := io.ReadAll(io.LimitReader(r.Body, limit))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	return int64(len(head))
+}
+
+func (rr RequestRule) String() string {
+	var fields []string
+	if rr.Path != "" {
+		fields = append(fields, "path="+rr.Path)
+	}
+	if rr.Tenant != "" {
+		fields = append(fields, "tenant="+rr.Tenant)
+	}
+	if rr.Header != "" {
+		fields = append(fields, "header="+rr.Header+":"+rr.HeaderPattern)
+	}
+	if rr.MinSize != 0 {
+		fields = append(fields, "min-size="+strconv.FormatInt(rr.MinSize, 10))
+	}
+	return strings.Join(fields, ",")
+}
+
+// dropRequest answers the request when one of Config.DropRequests matches it,
+// and reports whether it did.
+func (h *Handler) dropRequest(w http.ResponseWriter, r *http.Request) bool {
+	for _, rule := range h.cfg.DropRequests {
+		if !rule.Match(r) {
+			continue
+		}
+		_ = h.statsDClient.Count(droppedRequestsCountName, 1, h.tags("route:"+routePattern(r), "rule:"+rule.String()), 1)
+		if rule.Status != 0 {
+			fmt.Println(fmt.Sprintf("Dropped request to %s matching %s, answered %d", r.URL.Path, rule, rule.Status))
+			w.WriteHeader(rule.Status)
+			return true
+		}
+		fmt.Println(fmt.Sprintf("Dropped request to %s matching %s", r.URL.Path, rule))
+		h.writeDropped(w, r, DropResponse{Status: http.StatusAccepted})
+		return true
+	}
+	return false
+}
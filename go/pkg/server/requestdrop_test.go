@@ -0,0 +1,159 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseRequestRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		expected server.RequestRule
+		invalid  bool
+	}{
+		{
+			name:     "Header",
+			rule:     "header=user-agent:old-exporter/1.*",
+			expected: server.RequestRule{Header: "User-Agent", HeaderPattern: "old-exporter/1.*"},
+		},
+		{
+			name:     "Everything",
+			rule:     "path=/api/v1/series,tenant=team-a,min-size=1024,status=410",
+			expected: server.RequestRule{Path: "/api/v1/series", Tenant: "team-a", MinSize: 1024, Status: http.StatusGone},
+		},
+		{
+			name:    "Header without pattern",
+			rule:    "header=User-Agent",
+			invalid: true,
+		},
+		{
+			name:    "Bad status",
+			rule:    "path=/api/v1/series,status=1000",
+			invalid: true,
+		},
+		{
+			name:    "Unknown key",
+			rule:    "metric=some.metric",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseRequestRule(tc.rule)
+			if tc.invalid {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestHandler_DropRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		userAgent      string
+		tenant         string
+		body           string
+		chunked        bool
+		expectedStatus int
+		expectedRule   string
+	}{
+		{
+			name:           "Forwarded",
+			userAgent:      "datadog-agent/7.35.0",
+			body:           "{}",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "Dropped by user agent",
+			userAgent:      "old-exporter/1.2",
+			body:           "{}",
+			expectedStatus: http.StatusAccepted,
+			expectedRule:   "header=User-Agent:old-exporter/1.*",
+		},
+		{
+			name:           "Dropped by tenant and size",
+			userAgent:      "datadog-agent/7.35.0",
+			tenant:         "noisy",
+			body:           strings.Repeat(" ", 64) + "{}",
+			expectedStatus: http.StatusTooManyRequests,
+			expectedRule:   "tenant=noisy,min-size=64",
+		},
+		{
+			name:           "Small payload from tenant forwarded",
+			userAgent:      "datadog-agent/7.35.0",
+			tenant:         "noisy",
+			body:           "{}",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "Dropped by tenant and size when chunked",
+			userAgent:      "datadog-agent/7.35.0",
+			tenant:         "noisy",
+			body:           strings.Repeat(" ", 64) + "{}",
+			chunked:        true,
+			expectedStatus: http.StatusTooManyRequests,
+			expectedRule:   "tenant=noisy,min-size=64",
+		},
+		{
+			name:           "Small chunked payload from tenant forwarded",
+			userAgent:      "datadog-agent/7.35.0",
+			tenant:         "noisy",
+			body:           strings.Repeat(" ", 32) + "{}",
+			chunked:        true,
+			expectedStatus: http.StatusTeapot,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with request drop rules
+			cfg := server.Config{Tags: []string{"one"}}
+			for _, rule := range []string{"header=User-Agent:old-exporter/1.*", "tenant=noisy,min-size=64,status=429"} {
+				rr, err := server.ParseRequestRule(rule)
+				require.NoError(t, err)
+				cfg.DropRequests = append(cfg.DropRequests, rr)
+			}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// And a middleware setting the tenant
+			h.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					server.RequestMetaFrom(r.Context()).Tenant = tc.tenant
+					next.ServeHTTP(w, r)
+				})
+			})
+
+			// When we make a request, which may not declare its length
+			req := httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(tc.body))
+			req.Header.Set("User-Agent", tc.userAgent)
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ProxyHandle(rec, req)
+
+			// Then it is answered with the expected status
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedRule == "" {
+				// And the body is forwarded whole
+				res := <-resultChan
+				assert.Equal(t, tc.body, res.body)
+				return
+			}
+
+			// And the dropped request is counted
+			sc.assertCount(t, "proxy_filter.dropped_requests.count", 1, []string{"one", "route:/api/v1/series", "rule:" + tc.expectedRule}, 1, true)
+		})
+	}
+}
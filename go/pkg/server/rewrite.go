@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ResponseRule rewrites the upstream responses to Route for clients that
+// cannot handle them. Status restricts the rule to responses with that status,
+// ToStatus replaces the status and, with RewriteBody set, Body replaces the
+// body.
+type ResponseRule struct {
+	Route       string
+	Status      int
+	ToStatus    int
+	Body        string
+	RewriteBody bool
+}
+
+// ParseResponseRule parses a rule written as comma separated key=value pairs
+// with the keys route, status, to-status and body, e.g.
+// route=/api/v2/series,status=202,to-status=200. The body key must come last
+// as it takes the rest of the rule, commas included.
+func ParseResponseRule(s string) (ResponseRule, error) {
+	var rule ResponseRule
+	rest := s
+	for rest != "" {
+		var field string
+		if strings.HasPrefix(rest, "body=") {
+			field, rest = rest, ""
+		} else {
+			field = rest
+			if i := strings.IndexByte(rest, ','); i >= 0 {
+				field, rest = rest[:i], rest[i+1:]
+			} else {
+				rest = ""
+			}
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return ResponseRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "route":
+			rule.Route = kv[1]
+		case "status", "to-status":
+			status, err := strconv.Atoi(kv[1])
+			if err != nil || status < 100 || status > 599 {
+				return ResponseRule{}, newError(ErrRuleInvalid, fmt.Errorf("bad %s %q", kv[0], kv[1]))
+			}
+			if kv[0] == "status" {
+				rule.Status = status
+			} else {
+				rule.ToStatus = status
+			}
+		case "body":
+			rule.Body, rule.RewriteBody = kv[1], true
+		default:
+			return ResponseRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	if rule.Route == "" {
+		return ResponseRule{}, newError(ErrRuleInvalid, fmt.Errorf("missing route in %q", s))
+	}
+	if rule.ToStatus == 0 && !rule.RewriteBody {
+		return ResponseRule{}, newError(ErrRuleInvalid, fmt.Errorf("nothing to rewrite in %q", s))
+	}
+	return rule, nil
+}
+
+// rewriteResponse applies the first of Config.RewriteResponses matching the
+// response, returning the status and body to send to the client.
+func (h *Handler) rewriteResponse(w http.ResponseWriter, r *http.Request, status int, body io.Reader) (int, io.Reader) {
+	for _, rule := range h.cfg.RewriteResponses {
+		if rule.Route != r.URL.Path || (rule.Status != 0 && rule.Status != status) {
+			continue
+		}
+		_ = h.statsDClient.Count(rewrittenResponsesCountName, 1, h.tags("route:"+routePattern(r), fmt.Sprintf("status_code:%d", status)), 1)
+		if rule.RewriteBody {
+			// The upstream body is still read so validation sees it.
+			_, _ = io.Copy(io.Discard, body)
+			w.Header().Del("Content-Encoding")
+			w.Header().Del("Content-Length")
+			body = strings.NewReader(rule.Body)
+		}
+		if rule.ToStatus != 0 {
+			status = rule.ToStatus
+		}
+		return status, body
+	}
+	return status, body
+}
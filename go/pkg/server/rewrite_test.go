@@ -0,0 +1,133 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseResponseRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		expected server.ResponseRule
+		invalid  bool
+	}{
+		{
+			name:     "Status",
+			rule:     "route=/api/v2/series,status=202,to-status=200",
+			expected: server.ResponseRule{Route: "/api/v2/series", Status: 202, ToStatus: 200},
+		},
+		{
+			name:     "Body with commas",
+			rule:     `route=/api/v2/series,body={"errors":[],"status":"ok"}`,
+			expected: server.ResponseRule{Route: "/api/v2/series", Body: `{"errors":[],"status":"ok"}`, RewriteBody: true},
+		},
+		{
+			name:     "Empty body",
+			rule:     "route=/api/v2/series,body=",
+			expected: server.ResponseRule{Route: "/api/v2/series", RewriteBody: true},
+		},
+		{
+			name:    "Missing route",
+			rule:    "status=202,to-status=200",
+			invalid: true,
+		},
+		{
+			name:    "Nothing to rewrite",
+			rule:    "route=/api/v2/series,status=202",
+			invalid: true,
+		},
+		{
+			name:    "Bad status",
+			rule:    "route=/api/v2/series,to-status=ok",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseResponseRule(tc.rule)
+			if tc.invalid {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestHandler_RewriteResponses(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		status         int
+		expectedStatus int
+		expectedBody   string
+		expectCalled   bool
+	}{
+		{
+			name:           "Status rewritten",
+			path:           "/api/v2/series",
+			status:         http.StatusAccepted,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"errors":[]}`,
+			expectCalled:   true,
+		},
+		{
+			name:           "Other status untouched",
+			path:           "/api/v2/series",
+			status:         http.StatusForbidden,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"errors":[]}`,
+		},
+		{
+			name:           "Body rewritten",
+			path:           "/api/v1/check_run",
+			status:         http.StatusAccepted,
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   `{"status":"ok"}`,
+			expectCalled:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an upstream returning the response
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = io.WriteString(w, `{"errors":[]}`)
+			}))
+			defer ts.Close()
+
+			// And the proxy rewrites responses
+			sc := &stubStatsdClient{}
+			cfg := server.Config{BaseEndpoint: ts.URL, Tags: []string{"one"}}
+			for _, rule := range []string{"route=/api/v2/series,status=202,to-status=200", `route=/api/v1/check_run,body={"status":"ok"}`} {
+				rr, err := server.ParseResponseRule(rule)
+				require.NoError(t, err)
+				cfg.RewriteResponses = append(cfg.RewriteResponses, rr)
+			}
+			h := server.NewHandler(cfg, ts.Client(), sc)
+
+			// When we make a request
+			rec := httptest.NewRecorder()
+			h.ProxyHandle(rec, httptest.NewRequest("POST", tc.path, nil))
+
+			// Then the response is rewritten
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Equal(t, tc.expectedBody, rec.Body.String())
+
+			// And the rewrite is counted
+			sc.assertCount(t, "proxy_filter.rewritten_responses.count", 1, []string{"one", "route:" + tc.path, "status_code:202"}, 1, tc.expectCalled)
+			if !tc.expectCalled {
+				sc.assertNotCounted(t, "proxy_filter.rewritten_responses.count")
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// Route binds the requests to a path to the endpoint handling them, and to
+// a rule group whose rules their series go through besides the filters of
+// the config.
+type Route struct {
+	// Path is a http.ServeMux pattern, a trailing slash matching every path
+	// under it, e.g. /intake/.
+	Path     string
+	Endpoint string
+	Group    string
+}
+
+// endpoints maps the endpoint names routes are written with to the handlers
+// serving them.
+var endpoints = map[string]func(h *Handler, w http.ResponseWriter, r *http.Request){
+	"series":         (*Handler).MetricsFilter,
+	"series-v2":      (*Handler).MetricsFilterV2,
+	"sketches":       (*Handler).SketchesFilter,
+	"prometheus":     (*Handler).PrometheusFilter,
+	"service-checks": (*Handler).ServiceChecksFilter,
+	"events":         (*Handler).EventsFilter,
+	"intake":         (*Handler).IntakeFilter,
+	"logs":           (*Handler).LogsFilter,
+	"traces":         (*Handler).TracesFilter,
+	"processes":      (*Handler).ProcessFilter,
+	"connections":    (*Handler).ConnectionsFilter,
+	"orchestrator":   (*Handler).OrchestratorFilter,
+	"images":         (*Handler).ImagesFilter,
+	"profiles":       (*Handler).ProfileFilter,
+	"rum":            (*Handler).RUMFilter,
+	"ci":             (*Handler).CIFilter,
+	"proxy":          (*Handler).ProxyHandle,
+}
+
+// DefaultRoutes binds the intakes of the Datadog agents to their endpoints,
+// and every other path to the proxy one, which forwards them as they come.
+// They apply when Config.Routes is empty.
+var DefaultRoutes = []Route{
+	{Path: "/api/v1/series", Endpoint: "series"},
+	{Path: "/api/v2/series", Endpoint: "series-v2"},
+	{Path: "/api/beta/sketches", Endpoint: "sketches"},
+	{Path: "/api/v1/write", Endpoint: "prometheus"},
+	{Path: "/api/v1/check_run", Endpoint: "service-checks"},
+	{Path: "/api/v1/events", Endpoint: "events"},
+	{Path: "/intake/", Endpoint: "intake"},
+	{Path: "/api/v2/logs", Endpoint: "logs"},
+	{Path: "/v0.4/traces", Endpoint: "traces"},
+	{Path: "/api/v1/collector", Endpoint: "processes"},
+	{Path: "/api/v1/connections", Endpoint: "connections"},
+	{Path: "/api/v2/orch", Endpoint: "orchestrator"},
+	{Path: "/api/v2/contimage", Endpoint: "images"},
+	{Path: "/api/v2/sbom", Endpoint: "images"},
+	{Path: "/profiling/v1/input", Endpoint: "profiles"},
+	{Path: "/api/v2/rum", Endpoint: "rum"},
+	{Path: "/api/v2/citestcycle", Endpoint: "ci"},
+	{Path: "/", Endpoint: "proxy"},
+}
+
+// ParseRoute parses a route written as comma separated key=value pairs with
+// the keys path, endpoint and group, e.g.
+// path=/api/v1/series,endpoint=series,group=metrics. The endpoint is one of
+// series, series-v2, sketches, prometheus, service-checks, events, intake,
+// logs, traces, processes, connections, orchestrator, images, profiles, rum,
+// ci or proxy.
+func ParseRoute(s string) (Route, error) {
+	var route Route
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Route{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "path":
+			if !strings.HasPrefix(kv[1], "/") {
+				return Route{}, newError(ErrRuleInvalid, fmt.Errorf("path %q does not start with /", kv[1]))
+			}
+			route.Path = kv[1]
+		case "endpoint":
+			if _, ok := endpoints[kv[1]]; !ok {
+				return Route{}, newError(ErrRuleInvalid, fmt.Errorf("unknown endpoint %q", kv[1]))
+			}
+			route.Endpoint = kv[1]
+		case "group":
+			route.Group = kv[1]
+		default:
+			return Route{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	if route.Path == "" || route.Endpoint == "" {
+		return Route{}, newError(ErrRuleInvalid, fmt.Errorf("expected a path and an endpoint in %q", s))
+	}
+	return route, nil
+}
+
+// ParseRuleGroup parses a drop rule of a rule group, written as group=<name>
+// followed by the rule as filter.ParseRule takes it, e.g.
+// group=metrics,metric=app.debug.
+func ParseRuleGroup(s string) (string, filter.Rule, error) {
+	kv := strings.SplitN(s, ",", 2)
+	if len(kv) != 2 || !strings.HasPrefix(kv[0], "group=") || kv[0] == "group=" {
+		return "", filter.Rule{}, newError(ErrRuleInvalid, fmt.Errorf("expected group=<name>,<rule> in %q", s))
+	}
+	rule, err := filter.ParseRule(kv[1])
+	if err != nil {
+		return "", filter.Rule{}, err
+	}
+	return strings.TrimPrefix(kv[0], "group="), rule, nil
+}
+
+// CheckRoutes returns an error for the first route whose path is already
+// routed, or bound to a rule group groups does not define.
+func CheckRoutes(routes []Route, groups map[string]filter.Chain) error {
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if seen[route.Path] {
+			return newError(ErrRuleInvalid, fmt.Errorf("path %q is routed twice", route.Path))
+		}
+		seen[route.Path] = true
+		if _, ok := groups[route.Group]; route.Group != "" && !ok {
+			return newError(ErrRuleInvalid, fmt.Errorf("route %s: unknown rule group %q", route.Path, route.Group))
+		}
+	}
+	return nil
+}
+
+// boundRoute is what the router matches a request to.
+type boundRoute struct {
+	serve func(h *Handler, w http.ResponseWriter, r *http.Request)
+	group string
+}
+
+func (b boundRoute) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+func newRouter(routes []Route) *http.ServeMux {
+	if len(routes) == 0 {
+		routes = DefaultRoutes
+	}
+	mux := http.NewServeMux()
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		// http.ServeMux panics on a pattern registered twice, the first
+		// route wins as CheckRoutes rejects them.
+		if seen[route.Path] || endpoints[route.Endpoint] == nil {
+			continue
+		}
+		seen[route.Path] = true
+		mux.Handle(route.Path, boundRoute{serve: endpoints[route.Endpoint], group: route.Group})
+	}
+	return mux
+}
+
+// Router serves the requests by Config.Routes, or DefaultRoutes, as they
+// were last loaded, answering 404 to paths no route matches.
+func (h *Handler) Router() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next, pattern := h.current().router.Handler(r)
+		route, ok := next.(boundRoute)
+		if !ok {
+			// Not found, or redirected to the path with a trailing slash.
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), routePatternKey{}, pattern)
+		if route.group != "" {
+			ctx = context.WithValue(ctx, ruleGroupKey{}, route.group)
+		}
+		route.serve(h, w, r.WithContext(ctx))
+	})
+}
+
+type ruleGroupKey struct{}
+
+type routePatternKey struct{}
+
+// routePattern returns the pattern of the route r matched, e.g. /intake/, or
+// its path when it did not go through the router.
+func routePattern(r *http.Request) string {
+	if pattern, ok := r.Context().Value(routePatternKey{}).(string); ok {
+		return pattern
+	}
+	return r.URL.Path
+}
+
+// useRuleGroup adds the rules of the group the route of r is bound to after
+// the other filters.
+func (h *Handler) useRuleGroup(r *http.Request) {
+	group, _ := r.Context().Value(ruleGroupKey{}).(string)
+	if rules := h.cfg.RuleGroups[group]; len(rules) > 0 {
+		h.filters = append(h.filters[:len(h.filters):len(h.filters)], rules)
+	}
+}
+
+// groupFilters returns the filters of the rule groups, by group name.
+func groupFilters(groups map[string]filter.Chain) []ActiveFilter {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var res []ActiveFilter
+	for _, name := range names {
+		for _, f := range groups[name] {
+			res = append(res, ActiveFilter{Filter: filterName(f), Group: name})
+		}
+	}
+	return res
+}
@@ -0,0 +1,163 @@
+package server_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		name        string
+		route       string
+		expected    server.Route
+		expectedErr bool
+	}{
+		{
+			name:     "Endpoint",
+			route:    "path=/api/v1/series,endpoint=series",
+			expected: server.Route{Path: "/api/v1/series", Endpoint: "series"},
+		},
+		{
+			name:     "Rule group",
+			route:    "path=/intake/,endpoint=intake,group=hosts",
+			expected: server.Route{Path: "/intake/", Endpoint: "intake", Group: "hosts"},
+		},
+		{
+			name:        "Unknown endpoint",
+			route:       "path=/api/v1/series,endpoint=metrics",
+			expectedErr: true,
+		},
+		{
+			name:        "No endpoint",
+			route:       "path=/api/v1/series",
+			expectedErr: true,
+		},
+		{
+			name:        "Relative path",
+			route:       "path=api/v1/series,endpoint=series",
+			expectedErr: true,
+		},
+		{
+			name:        "Unknown key",
+			route:       "path=/api/v1/series,endpoint=series,method=POST",
+			expectedErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			route, err := server.ParseRoute(tc.route)
+			if tc.expectedErr {
+				assert.True(t, errors.Is(err, server.ErrRuleInvalid))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, route)
+		})
+	}
+}
+
+func TestParseRuleGroup(t *testing.T) {
+	group, rule, err := server.ParseRuleGroup("group=debug,metric=app.debug.,host=*.staging.*")
+	require.NoError(t, err)
+	assert.Equal(t, "debug", group)
+	assert.Equal(t, filter.Rule{MetricPrefix: "app.debug.", HostPattern: "*.staging.*"}, rule)
+
+	for _, s := range []string{"metric=app.debug.", "group=,metric=app.", "group=debug", "group=debug,prefix=app."} {
+		_, _, err := server.ParseRuleGroup(s)
+		assert.True(t, errors.Is(err, server.ErrRuleInvalid), s)
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	groups := map[string]filter.Chain{"debug": {filter.Rule{MetricPrefix: "app.debug."}}}
+	assert.NoError(t, server.CheckRoutes([]server.Route{{Path: "/api/v1/series", Endpoint: "series", Group: "debug"}}, groups))
+	assert.Error(t, server.CheckRoutes([]server.Route{{Path: "/api/v1/series", Endpoint: "series", Group: "hosts"}}, groups))
+	assert.Error(t, server.CheckRoutes([]server.Route{{Path: "/", Endpoint: "proxy"}, {Path: "/", Endpoint: "series"}}, groups))
+}
+
+func TestHandler_Router(t *testing.T) {
+	// Given server is running with a prefix filter and routes bound to rule groups
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		RuleGroups:          map[string]filter.Chain{"debug": {filter.Rule{MetricPrefix: "app.debug."}}},
+		Routes: []server.Route{
+			{Path: "/api/v1/series", Endpoint: "series", Group: "debug"},
+			{Path: "/custom/series", Endpoint: "series"},
+		},
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+	router := h.Router()
+	send := func(path string) *httptest.ResponseRecorder {
+		body := mustMarshal(t, defaultMetricsPayload([]string{"some.metric.load", "app.debug.count", "system.load.1"}))
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// When series are sent to the route bound to the group
+	send("/api/v1/series")
+
+	// Then the rules of the group apply besides the filters of the config
+	res := <-resultChan
+	assert.Equal(t, "/api/v1/series", res.path)
+	assert.Equal(t, []string{"system.load.1"}, forwardedMetrics(t, res))
+
+	// When series are sent to a route without a group
+	send("/custom/series")
+
+	// Then only the filters of the config apply
+	res = <-resultChan
+	assert.Equal(t, "/custom/series", res.path)
+	assert.Equal(t, []string{"app.debug.count", "system.load.1"}, forwardedMetrics(t, res))
+
+	// And paths without a route are not found
+	assert.Equal(t, http.StatusNotFound, send("/api/v2/series").Code)
+
+	// And the rules of the group are listed with it
+	assert.Equal(t, []server.ActiveFilter{{Filter: "metric=some.metric"}, {Filter: "metric=app.debug.", Group: "debug"}}, h.ActiveFilters())
+}
+
+func TestHandler_Router_Reload(t *testing.T) {
+	// Given server is running with the default routes
+	resultChan, ts, h, _ := setupCaptureServer(t, "", "")
+	defer ts.Close()
+	router := h.Router()
+
+	// When the routes are reloaded
+	h.Reload(server.Config{BaseEndpoint: ts.URL, Routes: []server.Route{{Path: "/api/v1/series", Endpoint: "series"}}}, "test")
+
+	// Then the new routes serve the next requests
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/validate", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(mustMarshal(t, defaultMetricsPayload([]string{"system.load.1"})))))
+	assert.Equal(t, []string{"system.load.1"}, forwardedMetrics(t, <-resultChan))
+}
+
+func TestHandler_Router_RoutePattern(t *testing.T) {
+	// Given server is running with the default routes
+	resultChan, ts, h, sc := setupCaptureServer(t, "", "")
+	defer ts.Close()
+	router := h.Router()
+
+	// When a client sends a request to a path under the catch-all route
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/some/client/path", nil))
+	<-resultChan
+
+	// Then the response of upstream is counted by the route pattern, not the
+	// path
+	sc.assertCount(t, "proxy_filter.upstream_responses.count", 1, []string{"one", "two", "three", "route:/", "status_code:418", "status_class:4xx"}, 1, true)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// RUMRule drops the RUM events of the application ApplicationID whose view
+// path matches ViewPath, using path.Match syntax, the fields left empty
+// matching any event. With SampleRate set between 0 and 1 it keeps that share
+// of the views instead, each view being kept or dropped as a whole.
+type RUMRule struct {
+	ApplicationID string
+	ViewPath      string
+	SampleRate    float64
+}
+
+// ParseRUMRule parses a rule written as comma separated key=value pairs with
+// the keys application, path and sample, e.g.
+// application=8d1c2f0a,path=/admin/*,sample=0.1.
+func ParseRUMRule(s string) (RUMRule, error) {
+	var rule RUMRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return RUMRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "application":
+			rule.ApplicationID = kv[1]
+		case "path":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return RUMRule{}, newError(ErrRuleInvalid, err)
+			}
+			rule.ViewPath = kv[1]
+		case "sample":
+			rate, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || rate <= 0 || rate >= 1 {
+				return RUMRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected a sample rate between 0 and 1, got %q", kv[1]))
+			}
+			rule.SampleRate = rate
+		default:
+			return RUMRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// rumEvent is what the rules look at in a RUM event.
+type rumEvent struct {
+	Application struct {
+		ID string `json:"id"`
+	} `json:"application"`
+	View struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	} `json:"view"`
+}
+
+func (rr RUMRule) match(e rumEvent) bool {
+	if rr.ApplicationID == "" && rr.ViewPath == "" {
+		return false
+	}
+	if rr.ApplicationID != "" && e.Application.ID != rr.ApplicationID {
+		return false
+	}
+	if rr.ViewPath != "" {
+		u, err := url.Parse(e.View.URL)
+		if err != nil {
+			return false
+		}
+		if ok, _ := path.Match(rr.ViewPath, u.Path); !ok {
+			return false
+		}
+	}
+	if rr.SampleRate == 0 {
+		return true
+	}
+	// Hashing the view keeps or drops all of its events together.
+	hash := fnv.New32a()
+	_, _ = io.WriteString(hash, e.View.ID)
+	return float64(hash.Sum32())/(1<<32) >= rr.SampleRate
+}
+
+// RUMFilter filters the newline delimited events the browser SDK sends to
+// /api/v2/rum with Config.RUMRules. Kept events are forwarded as they came,
+// and requests left without any event are answered without being forwarded,
+// with 202 unless Config.DropResponses says otherwise.
+func (h *Handler) RUMFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).rumFilter)
+}
+
+func (h *Handler) rumFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.RUMRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterRUM(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if buf == nil {
+		h.writeDropped(w, r, DropResponse{Status: http.StatusAccepted, Body: "{}"})
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// filterRUM returns the request body without the dropped events, or nil when
+// every event was dropped.
+func (h *Handler) filterRUM(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	var kept [][]byte
+	var dropped int64
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e rumEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		if h.dropRUMEvent(e) {
+			meta.RecordDrop("rum")
+			dropped++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	_ = h.statsDClient.Count(filteredRUMEventsCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	if dropped == 0 {
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(bytes.Join(kept, []byte("\n")))
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, nil
+}
+
+func (h *Handler) dropRUMEvent(e rumEvent) bool {
+	for _, rule := range h.cfg.RUMRules {
+		if rule.match(e) {
+			return true
+		}
+	}
+	return false
+}
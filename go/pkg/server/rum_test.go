@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func rumEvent(app, view, url string) string {
+	return fmt.Sprintf(`{"type":"view","application":{"id":%q},"view":{"id":%q,"url":%q}}`, app, view, url)
+}
+
+func TestParseRUMRule(t *testing.T) {
+	rule, err := server.ParseRUMRule("application=8d1c2f0a,path=/admin/*,sample=0.1")
+	require.NoError(t, err)
+	assert.Equal(t, server.RUMRule{ApplicationID: "8d1c2f0a", ViewPath: "/admin/*", SampleRate: 0.1}, rule)
+
+	for _, s := range []string{"", "application=", "path=[", "sample=1", "sample=0", "session=abc"} {
+		_, err = server.ParseRUMRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_RUMFilter(t *testing.T) {
+	rules := []server.RUMRule{{ApplicationID: "internal"}, {ViewPath: "/admin/*"}}
+	tests := []struct {
+		name            string
+		events          []string
+		expected        []string
+		expectedDropped int64
+	}{
+		{
+			name: "Drop by application and view path",
+			events: []string{
+				rumEvent("shop", "v1", "https://shop.example.com/cart"),
+				rumEvent("internal", "v2", "https://tools.example.com/cart"),
+				rumEvent("shop", "v3", "https://shop.example.com/admin/users?page=2"),
+				rumEvent("shop", "v4", "https://shop.example.com/admin"),
+			},
+			expected: []string{
+				rumEvent("shop", "v1", "https://shop.example.com/cart"),
+				rumEvent("shop", "v4", "https://shop.example.com/admin"),
+			},
+			expectedDropped: 2,
+		},
+		{
+			name:            "Every event dropped",
+			events:          []string{rumEvent("internal", "v1", "https://tools.example.com/")},
+			expectedDropped: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with RUM rules
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", server.Config{RUMRules: rules, Tags: []string{"one"}})
+			defer ts.Close()
+
+			// When the browser SDK sends events
+			req := httptest.NewRequest("POST", "/api/v2/rum?ddsource=browser", strings.NewReader(strings.Join(tc.events, "\n")))
+			req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+			rec := httptest.NewRecorder()
+			h.RUMFilter(rec, req)
+
+			// Then only the kept events are forwarded
+			sc.assertCount(t, "proxy_filter.filtered_rum_events.count", tc.expectedDropped, []string{"one"}, 1, true)
+			if tc.expected == nil {
+				assert.Equal(t, http.StatusAccepted, rec.Code)
+				return
+			}
+			assert.Equal(t, http.StatusTeapot, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, strings.Join(tc.expected, "\n"), actual.body)
+		})
+	}
+}
+
+func TestHandler_RUMFilter_Sample(t *testing.T) {
+	// Given server is running sampling half of the views of an application
+	rules := []server.RUMRule{{ApplicationID: "shop", SampleRate: 0.5}}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", server.Config{RUMRules: rules})
+	defer ts.Close()
+
+	// When events of many views are sent, two per view
+	var events []string
+	for i := 0; i < 200; i++ {
+		view := fmt.Sprintf("view-%d", i)
+		events = append(events, rumEvent("shop", view, "https://shop.example.com/"), rumEvent("shop", view, "https://shop.example.com/"))
+	}
+	req := httptest.NewRequest("POST", "/api/v2/rum", strings.NewReader(strings.Join(events, "\n")))
+	rec := httptest.NewRecorder()
+	h.RUMFilter(rec, req)
+
+	// Then about half of the views are kept, with both of their events
+	require.Equal(t, http.StatusTeapot, rec.Code)
+	kept := strings.Split((<-resultChan).body, "\n")
+	assert.InDelta(t, 200, len(kept), 40)
+	for i := 0; i < len(kept); i += 2 {
+		require.Less(t, i+1, len(kept))
+		assert.Equal(t, kept[i], kept[i+1])
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// RuntimeRule is a drop rule added through the admin API, written as
+// -drop-rule takes it, e.g. metric=app.debug.,host=*.staging.*.
+type RuntimeRule struct {
+	ID   string `json:"id"`
+	Rule string `json:"rule"`
+}
+
+// ActiveFilter is a filter the series go through, from the config or added
+// at runtime.
+type ActiveFilter struct {
+	Filter  string `json:"filter"`
+	Runtime bool   `json:"runtime,omitempty"`
+	ID      string `json:"id,omitempty"`
+	// Group is the rule group of the filter, which only applies on the routes
+	// bound to it.
+	Group string `json:"group,omitempty"`
+}
+
+// runtimeRules holds the drop rules managed through the admin API. They run
+// after the filters of the config, are kept on Reload, and are lost when the
+// proxy restarts.
+type runtimeRules struct {
+	mu     sync.Mutex
+	nextID int64
+	ids    []string
+	rules  map[string]filter.Rule
+	chain  filter.Chain
+}
+
+func newRuntimeRules() *runtimeRules {
+	return &runtimeRules{rules: make(map[string]filter.Rule)}
+}
+
+// filters returns the rules in the order they were added, for a request to
+// use throughout.
+func (rr *runtimeRules) filters() filter.Chain {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return rr.chain
+}
+
+func (rr *runtimeRules) list() []RuntimeRule {
+	return rr.snapshot().list()
+}
+
+func (rr *runtimeRules) get(id string) (RuntimeRule, bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rule, ok := rr.rules[id]
+	return RuntimeRule{ID: id, Rule: rule.String()}, ok
+}
+
+// set adds a rule, or replaces the one with id when it is set, reporting
+// false when there is none.
+func (rr *runtimeRules) set(id string, rule filter.Rule) (RuntimeRule, bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if id == "" {
+		rr.nextID++
+		id = strconv.FormatInt(rr.nextID, 10)
+		rr.ids = append(rr.ids, id)
+	} else if _, ok := rr.rules[id]; !ok {
+		return RuntimeRule{}, false
+	}
+	rr.rules[id] = rule
+	rr.rebuild()
+	return RuntimeRule{ID: id, Rule: rule.String()}, true
+}
+
+func (rr *runtimeRules) delete(id string) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if _, ok := rr.rules[id]; !ok {
+		return false
+	}
+	delete(rr.rules, id)
+	for i := range rr.ids {
+		if rr.ids[i] == id {
+			rr.ids = append(rr.ids[:i:i], rr.ids[i+1:]...)
+			break
+		}
+	}
+	rr.rebuild()
+	return true
+}
+
+// runtimeSnapshot is the state of the runtime rules at a version of the rule
+// set.
+type runtimeSnapshot struct {
+	ids   []string
+	rules map[string]filter.Rule
+}
+
+func (s runtimeSnapshot) list() []RuntimeRule {
+	res := make([]RuntimeRule, 0, len(s.ids))
+	for _, id := range s.ids {
+		res = append(res, RuntimeRule{ID: id, Rule: s.rules[id].String()})
+	}
+	return res
+}
+
+func (rr *runtimeRules) snapshot() runtimeSnapshot {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	s := runtimeSnapshot{ids: append([]string(nil), rr.ids...), rules: make(map[string]filter.Rule, len(rr.rules))}
+	for id, rule := range rr.rules {
+		s.rules[id] = rule
+	}
+	return s
+}
+
+// restore brings back the rules of a snapshot, the IDs given since not being
+// given again.
+func (rr *runtimeRules) restore(s runtimeSnapshot) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.ids = append([]string(nil), s.ids...)
+	rr.rules = make(map[string]filter.Rule, len(s.rules))
+	for id, rule := range s.rules {
+		rr.rules[id] = rule
+	}
+	rr.rebuild()
+}
+
+// rebuild replaces the chain rather than changing it, requests in flight
+// holding on to the one they started with.
+func (rr *runtimeRules) rebuild() {
+	chain := make(filter.Chain, 0, len(rr.ids))
+	for _, id := range rr.ids {
+		chain = append(chain, rr.rules[id])
+	}
+	rr.chain = chain
+}
+
+// RuntimeRules serves the drop rules added at runtime under /rules, mount it
+// on the admin listener:
+//
+//	GET    /rules                             lists them
+//	POST   /rules                             adds {"rule": "metric=app.debug."}
+//	GET    /rules/<id>                        shows one
+//	PUT    /rules/<id>                        replaces it with {"rule": "..."}
+//	DELETE /rules/<id>                        removes it
+//	GET    /rules/active                      lists every filter the series go through
+//	GET    /rules/versions                    lists the versions of the rule set
+//	POST   /rules/versions/<version>/rollback brings one back
+//
+// Rules apply to the requests that come once they are changed. Who changed
+// them is taken from the X-Changed-By header, or the remote address.
+func (h *Handler) RuntimeRules(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/rules"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, h.runtime.list())
+	case id == "" && r.Method == http.MethodPost:
+		rule, ok := readRuntimeRule(w, r)
+		if !ok {
+			return
+		}
+		var created RuntimeRule
+		h.changeRuntimeRules(r, func() (string, bool) {
+			created, _ = h.runtime.set("", rule)
+			return fmt.Sprintf("add rule %s", created.ID), true
+		})
+		writeJSON(w, http.StatusCreated, created)
+	case id == "active" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, h.ActiveFilters())
+	case id == "versions" || strings.HasPrefix(id, "versions/"):
+		h.serveRuleVersions(w, r, strings.TrimPrefix(strings.TrimPrefix(id, "versions"), "/"))
+	case id == "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case r.Method == http.MethodGet:
+		if rule, ok := h.runtime.get(id); ok {
+			writeJSON(w, http.StatusOK, rule)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPut:
+		rule, ok := readRuntimeRule(w, r)
+		if !ok {
+			return
+		}
+		var updated RuntimeRule
+		if h.changeRuntimeRules(r, func() (string, bool) {
+			var ok bool
+			updated, ok = h.runtime.set(id, rule)
+			return fmt.Sprintf("update rule %s", id), ok
+		}) {
+			writeJSON(w, http.StatusOK, updated)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodDelete:
+		if h.changeRuntimeRules(r, func() (string, bool) {
+			return fmt.Sprintf("delete rule %s", id), h.runtime.delete(id)
+		}) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// ActiveFilters returns the filters the series go through now, in order, the
+// ones of the config first, then the rule groups by name.
+func (h *Handler) ActiveFilters() []ActiveFilter {
+	return activeFilters(h.live.rules.Load().(*handlerRules), h.runtime.list())
+}
+
+// changeRuntimeRules runs change, which returns what it changed and whether
+// it did, recording the rule set as a new version when it did.
+func (h *Handler) changeRuntimeRules(r *http.Request, change func() (string, bool)) bool {
+	h.live.mu.Lock()
+	defer h.live.mu.Unlock()
+	what, ok := change()
+	if ok {
+		h.recordVersion(changedBy(r), what)
+	}
+	return ok
+}
+
+// readRuntimeRule reads the rule of a POST or PUT, answering 400 when it is
+// invalid.
+func readRuntimeRule(w http.ResponseWriter, r *http.Request) (filter.Rule, bool) {
+	var body struct {
+		Rule string `json:"rule"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("expected {\"rule\": \"...\"}, %v", err), http.StatusBadRequest)
+		return filter.Rule{}, false
+	}
+	rule, err := filter.ParseRule(body.Rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return filter.Rule{}, false
+	}
+	return rule, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
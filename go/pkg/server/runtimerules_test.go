@@ -0,0 +1,122 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_RuntimeRules(t *testing.T) {
+	// Given server is running with a prefix filter
+	resultChan, ts, h, _ := setupCaptureServer(t, "", "some.metric")
+	defer ts.Close()
+	admin := h.Admin()
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	send := func() []string {
+		body := mustMarshal(t, defaultMetricsPayload([]string{"some.metric.load", "app.debug.count", "system.load.1"}))
+		req := httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.MetricsFilter(httptest.NewRecorder(), req)
+		return forwardedMetrics(t, <-resultChan)
+	}
+
+	// When a rule is added
+	rec := call("POST", "/rules", `{"rule": "metric=app.debug."}`)
+
+	// Then it is created
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created server.RuntimeRule
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, server.RuntimeRule{ID: "1", Rule: "metric=app.debug."}, created)
+
+	// And it applies after the filters of the config
+	assert.Equal(t, []string{"system.load.1"}, send())
+	rec = call("GET", "/rules/active", "")
+	assert.JSONEq(t, `[{"filter": "metric=some.metric"}, {"filter": "metric=app.debug.", "runtime": true, "id": "1"}]`, rec.Body.String())
+
+	// When it is updated
+	rec = call("PUT", "/rules/1", `{"rule": "metric=system."}`)
+
+	// Then the new rule applies
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"app.debug.count"}, send())
+	assert.JSONEq(t, `[{"id": "1", "rule": "metric=system."}]`, call("GET", "/rules", "").Body.String())
+
+	// When it is deleted
+	rec = call("DELETE", "/rules/1", "")
+
+	// Then only the config filters apply
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"app.debug.count", "system.load.1"}, send())
+	assert.Equal(t, http.StatusNotFound, call("GET", "/rules/1", "").Code)
+}
+
+func TestHandler_RuntimeRules_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "Invalid rule",
+			method:         "POST",
+			path:           "/rules",
+			body:           `{"rule": "prefix=app."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not JSON",
+			method:         "POST",
+			path:           "/rules",
+			body:           "metric=app.",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown rule",
+			method:         "PUT",
+			path:           "/rules/42",
+			body:           `{"rule": "metric=app."}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Unknown method",
+			method:         "PATCH",
+			path:           "/rules",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an admin API
+			h := server.NewHandler(server.Config{}, http.DefaultClient, &stubStatsdClient{})
+
+			// When an invalid request is made
+			rec := httptest.NewRecorder()
+			h.Admin().ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+
+			// Then it is rejected
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.JSONEq(t, `[]`, httptestGet(h.Admin(), "/rules"))
+		})
+	}
+}
+
+func httptestGet(h http.Handler, path string) string {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec.Body.String()
+}
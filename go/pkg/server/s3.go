@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// S3Store stores archives as objects of an S3 bucket, signing its requests
+// with AWS Signature Version 4. It cannot prune, the retention of a bucket is
+// set with its lifecycle rules.
+type S3Store struct {
+	Bucket string
+	Region string
+	// Prefix is prepended to the keys, e.g. proxy-filter/.
+	Prefix string
+	// Endpoint, when set, is the base URL of an S3 compatible service, e.g.
+	// http://127.0.0.1:9000, addressed with the bucket in the path. Otherwise
+	// the bucket is addressed on https://<bucket>.s3.<region>.amazonaws.com.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set with temporary credentials.
+	SessionToken string
+	Client       *http.Client
+	// Clock dates the signatures, it defaults to clock.Real.
+	Clock clock.Clock
+}
+
+func (s S3Store) Put(key string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.ObjectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	s.sign(req, body, s.now())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("got %d from %s, %s", resp.StatusCode, req.URL.Host, msg)
+	}
+	return nil
+}
+
+// ObjectURL returns the URL of the object at key, the Prefix prepended.
+func (s S3Store) ObjectURL(key string) string {
+	if s.Endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.Endpoint, "/"), s.Bucket, s3EscapePath(s.Prefix+key))
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, s3EscapePath(s.Prefix+key))
+}
+
+// SignRequest signs req, a request without a body such as a GET of an
+// ObjectURL, with the credentials of the store.
+func (s S3Store) SignRequest(req *http.Request) {
+	s.sign(req, nil, s.now())
+}
+
+func (s S3Store) now() time.Time {
+	if s.Clock == nil {
+		return clock.Real.Now()
+	}
+	return s.Clock.Now()
+}
+
+// sign adds the AWS Signature Version 4 of req, whose body is body, at now.
+func (s S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(req.Header.Get(key))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3EscapePath escapes every byte of a key but the unreserved characters and
+// its slashes, as Signature Version 4 expects.
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,80 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestS3Store_Put(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		expectedErr bool
+	}{
+		{
+			name:   "Stored",
+			status: http.StatusOK,
+		},
+		{
+			name:        "Denied",
+			status:      http.StatusForbidden,
+			expectedErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an S3 compatible service
+			var received *http.Request
+			var receivedBody []byte
+			s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				receivedBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tc.status)
+			}))
+			defer s3.Close()
+			store := server.S3Store{
+				Bucket:          "archives",
+				Region:          "eu-west-1",
+				Prefix:          "proxy-filter/",
+				Endpoint:        s3.URL,
+				AccessKeyID:     "AKIDEXAMPLE",
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+				Client:          s3.Client(),
+				Clock:           clock.NewFake(time.Date(2022, 4, 15, 5, 30, 0, 0, time.UTC)),
+			}
+
+			// When an archive is stored
+			err := store.Put("2022/04/15/05/api_v1_series/1.http.gz", []byte("archive"))
+
+			// Then it is put in the bucket with a signed request
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			require.NotNil(t, received)
+			assert.Equal(t, "PUT", received.Method)
+			assert.Equal(t, "/archives/proxy-filter/2022/04/15/05/api_v1_series/1.http.gz", received.URL.Path)
+			assert.Equal(t, []byte("archive"), receivedBody)
+			sum := sha256.Sum256([]byte("archive"))
+			assert.Equal(t, hex.EncodeToString(sum[:]), received.Header.Get("X-Amz-Content-Sha256"))
+			assert.Equal(t, "20220415T053000Z", received.Header.Get("X-Amz-Date"))
+			assert.Equal(t, "token", received.Header.Get("X-Amz-Security-Token"))
+			assert.Regexp(t,
+				`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20220415/eu-west-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature=[0-9a-f]{64}$`,
+				received.Header.Get("Authorization"))
+		})
+	}
+}
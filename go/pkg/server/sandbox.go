@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+)
+
+// Sandbox asserts the proxy writes nothing to disk once started, so it can run
+// on a read-only root filesystem. It compares the bytes the process has sent
+// to the storage layer with how many it had sent when created, which only
+// linux accounts.
+type Sandbox struct {
+	written int64
+}
+
+// NewSandbox starts asserting no more writes happen from now on.
+func NewSandbox() (*Sandbox, error) {
+	written, err := storageWriteBytes()
+	if err != nil {
+		return nil, err
+	}
+	return &Sandbox{written: written}, nil
+}
+
+// Check returns an error when the process wrote to disk since the sandbox was
+// created. Call it periodically.
+func (s *Sandbox) Check() error {
+	written, err := storageWriteBytes()
+	if err != nil {
+		return err
+	}
+	if written > s.written {
+		return fmt.Errorf("wrote %d bytes to disk while sandboxed", written-s.written)
+	}
+	return nil
+}
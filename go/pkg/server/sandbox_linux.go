@@ -0,0 +1,27 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func storageWriteBytes() (int64, error) {
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v := strings.TrimPrefix(s.Text(), "write_bytes: "); v != s.Text() {
+			return strconv.ParseInt(v, 10, 64)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no write_bytes in /proc/self/io")
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+)
+
+func storageWriteBytes() (int64, error) {
+	return 0, errors.New("disk write accounting is not supported on this platform")
+}
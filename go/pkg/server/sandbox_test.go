@@ -0,0 +1,40 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestSandbox_Check(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("disk writes are only accounted on linux")
+	}
+	// Given a sandbox
+	s, err := server.NewSandbox()
+	require.NoError(t, err)
+
+	// When nothing is written
+	// Then the check passes
+	assert.NoError(t, s.Check())
+
+	// When a file is written
+	f, err := os.Create(filepath.Join(t.TempDir(), "spool"))
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 1<<20))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+
+	// Then the check fails
+	if err = s.Check(); err == nil {
+		t.Skip("the temp dir is not accounted, e.g. on tmpfs")
+	}
+	assert.Contains(t, err.Error(), "while sandboxed")
+}
@@ -0,0 +1,447 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// Field numbers of the v2 series intake MetricPayload protobuf, as defined by
+// the agent payload protos.
+const (
+	v2PayloadSeries = 1
+
+	v2SeriesResources = 1
+	v2SeriesMetric    = 2
+	v2SeriesTags      = 3
+	v2SeriesPoints    = 4
+	v2SeriesType      = 5
+	v2SeriesInterval  = 8
+
+	v2ResourceType = 1
+	v2ResourceName = 2
+
+	v2PointValue     = 1
+	v2PointTimestamp = 2
+)
+
+// v2SeriesTypes maps the MetricType enum to the v1 series types.
+var v2SeriesTypes = map[uint64]string{1: "count", 2: "rate", 3: "gauge"}
+
+// ResourceRule rewrites the resources of v2 series with the type Type, e.g.
+// device. It removes them unless Name is set, in which case it replaces their
+// name.
+type ResourceRule struct {
+	Type string
+	Name string
+}
+
+// ParseResourceRule parses a rule written as type, removing the resources of
+// that type, or type=name, renaming them.
+func ParseResourceRule(s string) (ResourceRule, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if kv[0] == "" || (len(kv) == 2 && kv[1] == "") {
+		return ResourceRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected type or type=name in %q", s))
+	}
+	rule := ResourceRule{Type: kv[0]}
+	if len(kv) == 2 {
+		rule.Name = kv[1]
+	}
+	return rule, nil
+}
+
+// MetricsFilterV2 filters the protobuf payloads of the v2 series intake. The
+// filters see each series as a v1 series whose host is its host resource, and
+// the kept series are rewritten by Config.Transform, have their resources
+// rewritten by Config.ResourceRules, their missing unit set from Config.Units
+// and Config.ProvenanceTag added.
+func (h *Handler) MetricsFilterV2(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).metricsFilterV2)
+}
+
+func (h *Handler) metricsFilterV2(w http.ResponseWriter, r *http.Request) {
+	if len(h.filters) == 0 && h.cfg.Transform == nil && len(h.cfg.ResourceRules) == 0 && h.cfg.Units == nil && h.cfg.ProvenanceTag == "" && !h.exports() {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	if h.cfg.StreamSeries && !h.cfg.ExportOnly {
+		var enriched int64
+		h.streamProtobuf(w, r, v2PayloadSeries, decodeSeriesV2, h.rewriteSeriesV2(r.Context(), &enriched))
+		h.countEnriched(enriched)
+		return
+	}
+
+	buf, err := h.filterMetricsV2(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if h.cfg.ExportOnly {
+		h.writeExported(w)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+func (h *Handler) filterMetricsV2(r *http.Request) (*bytes.Buffer, error) {
+	var enriched int64
+	buf, err := h.filterProtobuf(r, v2PayloadSeries, decodeSeriesV2, h.rewriteSeriesV2(r.Context(), &enriched))
+	if err == nil {
+		h.countEnriched(enriched)
+	}
+	return buf, err
+}
+
+// rewriteSeriesV2 returns the rewrite of the kept v2 series, counting in
+// enriched the series it sets the unit of. The exporters get the v1 view of
+// the rewritten series.
+func (h *Handler) rewriteSeriesV2(ctx context.Context, enriched *int64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b, ok := h.enrichUnit(h.tagProvenance(h.rewriteResources(h.transformSeriesV2(ctx, b))))
+		if ok {
+			*enriched++
+		}
+		if len(h.cfg.Exporters) > 0 {
+			if series, err := decodeSeriesV2(b); err == nil {
+				h.export(series)
+			}
+		}
+		return b
+	}
+}
+
+func (h *Handler) countEnriched(enriched int64) {
+	if h.cfg.Units != nil {
+		_ = h.statsDClient.Count(enrichedUnitsCountName, enriched, h.cfg.Tags, 1)
+	}
+}
+
+// filterProtobuf filters the repeated field of a protobuf payload holding its
+// series. decode gives the filters a v1 view of each series, and rewrite
+// changes the ones that are kept. Every other field is copied as it is.
+func (h *Handler) filterProtobuf(r *http.Request, field protowire.Number, decode func([]byte) (datadog.Series, error), rewrite func([]byte) []byte) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	begin := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, begin))
+
+	start := h.clock.Now()
+	decoded := body
+	var out []byte
+	var dropped int64
+	slo := h.newSLOCounts()
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return nil, newError(ErrDecode, protowire.ParseError(n))
+		}
+		m := protowire.ConsumeFieldValue(num, typ, body[n:])
+		if m < 0 {
+			return nil, newError(ErrDecode, protowire.ParseError(m))
+		}
+		f := body[:n+m]
+		body = body[n+m:]
+		if num != field || typ != protowire.BytesType {
+			out = append(out, f...)
+			continue
+		}
+		raw, _ := protowire.ConsumeBytes(f[n:])
+		series, err := decode(raw)
+		if err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		slo.add(series.Metric)
+		if h.dropSeries(r.Context(), &series) {
+			dropped++
+			continue
+		}
+		slo.keep(series.Metric)
+		out = protowire.AppendTag(out, field, protowire.BytesType)
+		out = protowire.AppendBytes(out, rewrite(raw))
+	}
+	_ = h.statsDClient.Count(metricsFilteredCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if dropped == 0 && bytes.Equal(out, decoded) {
+		h.recordSLOs(slo, clock.Since(h.clock, begin))
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(out)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	h.recordSLOs(slo, clock.Since(h.clock, begin))
+	return buf, nil
+}
+
+// DecodeSeriesV2 decodes a v2 series intake payload into the series the
+// filters see, e.g. to check the decoding against the agent payload protos.
+func DecodeSeriesV2(body []byte) ([]datadog.Series, error) {
+	return decodeRepeated(body, v2PayloadSeries, decodeSeriesV2)
+}
+
+// decodeRepeated decodes every value of the repeated field of a protobuf
+// payload holding its series.
+func decodeRepeated(body []byte, field protowire.Number, decode func([]byte) (datadog.Series, error)) ([]datadog.Series, error) {
+	var series []datadog.Series
+	err := walkFields(body, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != field || typ != protowire.BytesType {
+			return nil
+		}
+		s, err := decode(v)
+		if err != nil {
+			return err
+		}
+		series = append(series, s)
+		return nil
+	})
+	return series, err
+}
+
+// decodeSeriesV2 decodes what the filters look at in a v2 series.
+func decodeSeriesV2(b []byte) (datadog.Series, error) {
+	var series datadog.Series
+	var tags []string
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == v2SeriesMetric && typ == protowire.BytesType:
+			series.Metric = string(v)
+		case num == v2SeriesTags && typ == protowire.BytesType:
+			tags = append(tags, string(v))
+		case num == v2SeriesResources && typ == protowire.BytesType:
+			typ, name, err := decodeResourceV2(v)
+			if err != nil {
+				return err
+			}
+			if typ == "host" {
+				series.SetHost(name)
+			}
+		case num == v2SeriesType && typ == protowire.VarintType:
+			t, _ := protowire.ConsumeVarint(v)
+			if name, ok := v2SeriesTypes[t]; ok {
+				series.SetType(name)
+			}
+		case num == v2SeriesInterval && typ == protowire.VarintType:
+			interval, _ := protowire.ConsumeVarint(v)
+			if interval > 0 {
+				series.SetInterval(int64(interval))
+			}
+		case num == v2SeriesPoints && typ == protowire.BytesType:
+			var value, ts float64
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == v2PointValue && typ == protowire.Fixed64Type:
+					bits, _ := protowire.ConsumeFixed64(v)
+					value = math.Float64frombits(bits)
+				case num == v2PointTimestamp && typ == protowire.VarintType:
+					t, _ := protowire.ConsumeVarint(v)
+					ts = float64(int64(t))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			series.Points = append(series.Points, []*float64{&ts, &value})
+		}
+		return nil
+	})
+	if tags != nil {
+		series.SetTags(tags)
+	}
+	return series, err
+}
+
+func decodeResourceV2(b []byte) (string, string, error) {
+	var typ, name string
+	err := walkFields(b, func(num protowire.Number, t protowire.Type, v []byte) error {
+		switch {
+		case num == v2ResourceType && t == protowire.BytesType:
+			typ = string(v)
+		case num == v2ResourceName && t == protowire.BytesType:
+			name = string(v)
+		}
+		return nil
+	})
+	return typ, name, err
+}
+
+// transformSeriesV2 applies Config.Transform to a v2 series known to be well
+// formed. The series is encoded again only when the transform changes it.
+func (h *Handler) transformSeriesV2(ctx context.Context, b []byte) []byte {
+	if h.cfg.Transform == nil {
+		return b
+	}
+	series, err := decodeSeriesV2(b)
+	if err != nil {
+		return b
+	}
+	before, _ := decodeSeriesV2(b)
+	h.cfg.Transform.Transform(ctx, &series)
+	if reflect.DeepEqual(before, series) {
+		return b
+	}
+	return encodeSeriesV2(b, series)
+}
+
+// encodeSeriesV2 replaces the host resource, metric, tags, points, type and
+// interval of a v2 series known to be well formed with those of series,
+// copying every other field as it is.
+func encodeSeriesV2(b []byte, series datadog.Series) []byte {
+	out := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		field := b[:n+m]
+		b = b[n+m:]
+		switch num {
+		case v2SeriesMetric, v2SeriesTags, v2SeriesPoints, v2SeriesType, v2SeriesInterval:
+			continue
+		case v2SeriesResources:
+			raw, _ := protowire.ConsumeBytes(field[n:])
+			if resType, _, _ := decodeResourceV2(raw); resType == "host" {
+				continue
+			}
+		}
+		out = append(out, field...)
+	}
+	if series.HasHost() {
+		var res []byte
+		res = protowire.AppendTag(res, v2ResourceType, protowire.BytesType)
+		res = protowire.AppendString(res, "host")
+		res = protowire.AppendTag(res, v2ResourceName, protowire.BytesType)
+		res = protowire.AppendString(res, series.GetHost())
+		out = protowire.AppendTag(out, v2SeriesResources, protowire.BytesType)
+		out = protowire.AppendBytes(out, res)
+	}
+	out = protowire.AppendTag(out, v2SeriesMetric, protowire.BytesType)
+	out = protowire.AppendString(out, series.Metric)
+	for _, tag := range series.GetTags() {
+		out = protowire.AppendTag(out, v2SeriesTags, protowire.BytesType)
+		out = protowire.AppendString(out, tag)
+	}
+	for _, p := range series.Points {
+		if len(p) < 2 || p[0] == nil || p[1] == nil {
+			continue
+		}
+		var point []byte
+		point = protowire.AppendTag(point, v2PointValue, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, math.Float64bits(*p[1]))
+		point = protowire.AppendTag(point, v2PointTimestamp, protowire.VarintType)
+		point = protowire.AppendVarint(point, uint64(int64(*p[0])))
+		out = protowire.AppendTag(out, v2SeriesPoints, protowire.BytesType)
+		out = protowire.AppendBytes(out, point)
+	}
+	for t, name := range v2SeriesTypes {
+		if name == series.GetType() {
+			out = protowire.AppendTag(out, v2SeriesType, protowire.VarintType)
+			out = protowire.AppendVarint(out, t)
+		}
+	}
+	if series.GetInterval() > 0 {
+		out = protowire.AppendTag(out, v2SeriesInterval, protowire.VarintType)
+		out = protowire.AppendVarint(out, uint64(series.GetInterval()))
+	}
+	return out
+}
+
+// rewriteResources applies Config.ResourceRules to a v2 series known to be
+// well formed, copying every other field as it is.
+func (h *Handler) rewriteResources(b []byte) []byte {
+	if len(h.cfg.ResourceRules) == 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		field := b[:n+m]
+		b = b[n+m:]
+		if num != v2SeriesResources || typ != protowire.BytesType {
+			out = append(out, field...)
+			continue
+		}
+		raw, _ := protowire.ConsumeBytes(field[n:])
+		resType, _, _ := decodeResourceV2(raw)
+		rule, ok := h.resourceRule(resType)
+		if !ok {
+			out = append(out, field...)
+			continue
+		}
+		if rule.Name == "" {
+			continue
+		}
+		var res []byte
+		res = protowire.AppendTag(res, v2ResourceType, protowire.BytesType)
+		res = protowire.AppendString(res, resType)
+		res = protowire.AppendTag(res, v2ResourceName, protowire.BytesType)
+		res = protowire.AppendString(res, rule.Name)
+		out = protowire.AppendTag(out, v2SeriesResources, protowire.BytesType)
+		out = protowire.AppendBytes(out, res)
+	}
+	return out
+}
+
+func (h *Handler) resourceRule(typ string) (ResourceRule, bool) {
+	for _, rule := range h.cfg.ResourceRules {
+		if rule.Type == typ {
+			return rule, true
+		}
+	}
+	return ResourceRule{}, false
+}
+
+// walkFields calls fn with the value of every field of a protobuf message,
+// the content for length delimited fields and the encoded value otherwise.
+func walkFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		v := b[:m]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+		b = b[m:]
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+type resourceV2 struct{ typ, name string }
+
+type seriesV2 struct {
+	metric    string
+	resources []resourceV2
+	tags      []string
+	unit      string
+}
+
+func encodeSeriesV2(series ...seriesV2) []byte {
+	var b []byte
+	for _, s := range series {
+		var d []byte
+		for _, r := range s.resources {
+			var rb []byte
+			rb = protowire.AppendTag(rb, 1, protowire.BytesType)
+			rb = protowire.AppendString(rb, r.typ)
+			rb = protowire.AppendTag(rb, 2, protowire.BytesType)
+			rb = protowire.AppendString(rb, r.name)
+			d = protowire.AppendTag(d, 1, protowire.BytesType)
+			d = protowire.AppendBytes(d, rb)
+		}
+		d = protowire.AppendTag(d, 2, protowire.BytesType)
+		d = protowire.AppendString(d, s.metric)
+		for _, tag := range s.tags {
+			d = protowire.AppendTag(d, 3, protowire.BytesType)
+			d = protowire.AppendString(d, tag)
+		}
+		var pb []byte
+		pb = protowire.AppendTag(pb, 1, protowire.Fixed64Type)
+		pb = protowire.AppendFixed64(pb, math.Float64bits(1.5))
+		pb = protowire.AppendTag(pb, 2, protowire.VarintType)
+		pb = protowire.AppendVarint(pb, 1650000000)
+		d = protowire.AppendTag(d, 4, protowire.BytesType)
+		d = protowire.AppendBytes(d, pb)
+		d = protowire.AppendTag(d, 5, protowire.VarintType)
+		d = protowire.AppendVarint(d, 3)
+		if s.unit != "" {
+			d = protowire.AppendTag(d, 6, protowire.BytesType)
+			d = protowire.AppendString(d, s.unit)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	return b
+}
+
+func TestParseResourceRule(t *testing.T) {
+	rule, err := server.ParseResourceRule("device")
+	require.NoError(t, err)
+	assert.Equal(t, server.ResourceRule{Type: "device"}, rule)
+
+	rule, err = server.ParseResourceRule("host=masked")
+	require.NoError(t, err)
+	assert.Equal(t, server.ResourceRule{Type: "host", Name: "masked"}, rule)
+
+	for _, s := range []string{"", "=masked", "host="} {
+		_, err = server.ParseResourceRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_MetricsFilterV2(t *testing.T) {
+	overlay := []resourceV2{{typ: "host", name: "web-1"}, {typ: "device", name: "/dev/overlay"}}
+	tests := []struct {
+		name     string
+		cfg      server.Config
+		deflate  bool
+		sent     []seriesV2
+		expected []seriesV2
+	}{
+		{
+			name: "Filter metric name",
+			cfg:  server.Config{MetricsPrefixFilter: "some.metric"},
+			sent: []seriesV2{
+				{metric: "metric.one", resources: overlay, tags: []string{"env:prod"}},
+				{metric: "some.metric.load", resources: overlay},
+			},
+			expected: []seriesV2{
+				{metric: "metric.one", resources: overlay, tags: []string{"env:prod"}},
+			},
+		},
+		{
+			name: "Strip device and rename host",
+			cfg:  server.Config{ResourceRules: []server.ResourceRule{{Type: "device"}, {Type: "host", Name: "masked"}}},
+			sent: []seriesV2{
+				{metric: "metric.one", resources: overlay, tags: []string{"env:prod"}},
+			},
+			expected: []seriesV2{
+				{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "masked"}}, tags: []string{"env:prod"}},
+			},
+		},
+		{
+			name: "Transform series",
+			cfg:  server.Config{Transform: transform.AddTags{"proxied:true"}},
+			sent: []seriesV2{
+				{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}}, tags: []string{"env:prod"}},
+			},
+			expected: []seriesV2{
+				{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}}, tags: []string{"env:prod", "proxied:true"}},
+			},
+		},
+		{
+			name:    "Filter on host resource deflate",
+			cfg:     server.Config{ResourceRules: []server.ResourceRule{{Type: "device"}}},
+			deflate: true,
+			sent: []seriesV2{
+				{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}}},
+				{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-2"}, {typ: "device", name: "sda"}}},
+			},
+			expected: []seriesV2{
+				{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}}},
+				{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-2"}}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", tc.cfg)
+			defer ts.Close()
+
+			// And a v2 payload
+			body := encodeSeriesV2(tc.sent...)
+			req := httptest.NewRequest("POST", "/api/v2/series", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			if tc.deflate {
+				buf := new(bytes.Buffer)
+				zw := zlib.NewWriter(buf)
+				_, _ = zw.Write(body)
+				_ = zw.Close()
+				req = httptest.NewRequest("POST", "/api/v2/series", buf)
+				req.Header.Set("Content-Encoding", "deflate")
+			}
+
+			// When we make the request
+			rec := httptest.NewRecorder()
+			h.MetricsFilterV2(rec, req)
+
+			// Then the filtered payload is forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			forwarded := []byte(actual.body)
+			if tc.deflate {
+				zr, err := zlib.NewReader(bytes.NewReader(forwarded))
+				require.NoError(t, err)
+				forwarded, err = io.ReadAll(zr)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, encodeSeriesV2(tc.expected...), forwarded)
+		})
+	}
+}
+
+func TestHandler_MetricsFilterV2_Unchanged(t *testing.T) {
+	// Given server is running with a resource rule
+	cfg := server.Config{ResourceRules: []server.ResourceRule{{Type: "device"}}}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// And a deflate payload the rule leaves as it is
+	buf := new(bytes.Buffer)
+	zw := zlib.NewWriter(buf)
+	_, _ = zw.Write(encodeSeriesV2(seriesV2{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}}}))
+	_ = zw.Close()
+	sent := buf.String()
+	req := httptest.NewRequest("POST", "/api/v2/series", buf)
+	req.Header.Set("Content-Encoding", "deflate")
+
+	// When we make the request
+	rec := httptest.NewRecorder()
+	h.MetricsFilterV2(rec, req)
+
+	// Then the payload is forwarded as it came
+	assert.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	assert.Equal(t, sent, actual.body)
+}
+
+func TestDecodeSeriesV2(t *testing.T) {
+	// Given a v2 payload
+	body := encodeSeriesV2(
+		seriesV2{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}}, tags: []string{"env:prod"}},
+		seriesV2{metric: "metric.two"},
+	)
+
+	// When we decode it
+	series, err := server.DecodeSeriesV2(body)
+	require.NoError(t, err)
+
+	// Then the series are seen as v1 series
+	require.Len(t, series, 2)
+	assert.Equal(t, "metric.one", series[0].Metric)
+	assert.Equal(t, "web-1", series[0].GetHost())
+	assert.Equal(t, []string{"env:prod"}, series[0].GetTags())
+	assert.Equal(t, "gauge", series[0].GetType())
+	require.Len(t, series[0].Points, 1)
+	assert.Equal(t, 1650000000.0, *series[0].Points[0][0])
+	assert.Equal(t, 1.5, *series[0].Points[0][1])
+	assert.False(t, series[1].HasHost())
+
+	// And a truncated payload is an error
+	_, err = server.DecodeSeriesV2(body[:len(body)-3])
+	assert.Error(t, err)
+}
@@ -0,0 +1,690 @@
+// Package server implements the filtering proxy handlers. Embed them by
+// creating a Handler with NewHandler and mounting its methods on a mux.
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/codec"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+const (
+	metricsFilteredCountName          = "proxy_filter.filtered_metrics.count"
+	contentTypeMismatchCountName      = "proxy_filter.content_type_mismatch.count"
+	unsupportedEncodingCountName      = "proxy_filter.unsupported_content_encoding.count"
+	upstreamResponseMismatchCountName = "proxy_filter.upstream_response_mismatch.count"
+	coalescedRequestsCountName        = "proxy_filter.coalesced_requests.count"
+	droppedRequestsCountName          = "proxy_filter.dropped_requests.count"
+	rewrittenResponsesCountName       = "proxy_filter.rewritten_responses.count"
+	upstreamRejectionsCountName       = "proxy_filter.upstream_rejections.count"
+	upstreamResponsesCountName        = "proxy_filter.upstream_responses.count"
+	mergedSeriesCountName             = "proxy_filter.merged_series.count"
+	unchangedPayloadsCountName        = "proxy_filter.unchanged_payloads.count"
+	backendFailuresCountName          = "proxy_filter.backend_failures.count"
+	shardedSeriesCountName            = "proxy_filter.sharded_series.count"
+	shardFailuresCountName            = "proxy_filter.shard_failures.count"
+	mirrorFailuresCountName           = "proxy_filter.mirror.failures.count"
+	mirrorSkippedCountName            = "proxy_filter.mirror.skipped.count"
+	archiveLostCountName              = "proxy_filter.archive.lost.count"
+	sloBurnRateGaugeName              = "proxy_filter.slo.burn_rate"
+	agentRequestsCountName            = "proxy_filter.agent_requests.count"
+	rejectedConnectionsCountName      = "proxy_filter.rejected_connections.count"
+	filteredServiceChecksCountName    = "proxy_filter.filtered_service_checks.count"
+	filteredEventsCountName           = "proxy_filter.filtered_events.count"
+	filteredLogsCountName             = "proxy_filter.filtered_logs.count"
+	filteredProcessesCountName        = "proxy_filter.filtered_processes.count"
+	filteredResourcesCountName        = "proxy_filter.filtered_orchestrator_resources.count"
+	filteredConnectionsCountName      = "proxy_filter.filtered_connections.count"
+	filteredImagesCountName           = "proxy_filter.filtered_images.count"
+	filteredTracesCountName           = "proxy_filter.filtered_traces.count"
+	filteredRUMEventsCountName        = "proxy_filter.filtered_rum_events.count"
+	filteredCIEventsCountName         = "proxy_filter.filtered_ci_events.count"
+	filteredProfilesCountName         = "proxy_filter.filtered_profiles.count"
+	filteredDogStatsDCountName        = "proxy_filter.dogstatsd.filtered_metrics.count"
+	scrubbedMetadataCountName         = "proxy_filter.scrubbed_metadata.count"
+	enrichedUnitsCountName            = "proxy_filter.enriched_units.count"
+	decisionDropsCountName            = "proxy_filter.decision.dropped.count"
+	decisionAuditsCountName           = "proxy_filter.decision.audited.count"
+	maintenanceRequestsCountName      = "proxy_filter.maintenance.requests.count"
+	dropAllRequestsCountName          = "proxy_filter.drop_all.requests.count"
+	passthroughSentBytesCountName     = "proxy_filter.passthrough.sent_bytes.count"
+	passthroughReceivedBytesCountName = "proxy_filter.passthrough.received_bytes.count"
+	unroutedConnectionsCountName      = "proxy_filter.passthrough.unrouted.count"
+	drainedRequestsCountName          = "proxy_filter.shutdown.drained_requests.count"
+	forceClosedRequestsCountName      = "proxy_filter.shutdown.force_closed_requests.count"
+	drainDurationGaugeName            = "proxy_filter.shutdown.drain_duration"
+	cappedTagSetsCountName            = "proxy_filter.statsd.capped_tag_sets.count"
+	fdsOpenGaugeName                  = "proxy_filter.fds.open"
+	fdsSocketsGaugeName               = "proxy_filter.fds.sockets"
+	fdsLimitGaugeName                 = "proxy_filter.fds.limit"
+	requestDurationDistributionName   = "proxy_filter.request.duration"
+	upstreamDurationDistributionName  = "proxy_filter.upstream.duration"
+	requestSizeDistributionName       = "proxy_filter.request.size"
+	responseSizeDistributionName      = "proxy_filter.response.size"
+	topDroppedMetricsCountName        = "proxy_filter.top_dropped_metrics.count"
+	upstreamHealthyGaugeName          = "proxy_filter.upstream.healthy"
+	upstreamProbeLatencyGaugeName     = "proxy_filter.upstream.probe_latency"
+	snappyTooLargeCountName           = "proxy_filter.snappy_too_large.count"
+)
+
+type Config struct {
+	BaseEndpoint        string
+	MetricsPrefixFilter string
+	Tags                []string
+	Filter              filter.Filter
+	BatchFilter         BatchFilter
+	// Transform rewrites every series kept by the filters.
+	Transform transform.Transform
+	// MergeDuplicates merges the series of a payload with the same name, type,
+	// host and tags into one.
+	MergeDuplicates bool
+	// StreamSeries filters the JSON payloads sent to /api/v1/series, and the
+	// protobuf ones sent to the v2 series and sketches intakes, a series at a
+	// time as they are read, forwarding the kept ones as it goes instead of
+	// decoding the whole payload first. It bounds the memory a payload takes,
+	// at the cost of re-encoding the payloads without drops too, and does not
+	// apply to /api/v1/series when MergeDuplicates or Shards need the whole
+	// payload. It cannot be used with BatchFilter, see CheckBatchFilter.
+	StreamSeries bool
+	// Backends lists base endpoints every request is also sent to, besides
+	// BaseEndpoint, the primary. BackendMode decides which response the client
+	// gets. With PrimaryWins the client does not wait for the other backends,
+	// which are given BackendTimeout (30s when 0) to answer.
+	Backends       []string
+	BackendMode    BackendMode
+	BackendTimeout time.Duration
+	// MirrorEndpoint, when set, is a base endpoint every POST request is also
+	// sent to as it came, before any filter, e.g. to keep the full resolution
+	// data in-house while the filtered payloads go to BaseEndpoint. Mirrored
+	// requests are sent in the background, MirrorMaxInFlight at most at once
+	// (100 when 0), and never change the response the client gets. Their
+	// bodies are read whole, which StreamSeries then does not bound.
+	MirrorEndpoint    string
+	MirrorMaxInFlight int
+	// Archiver, when set, keeps every POST request as it came, before any
+	// filter. Their bodies are read whole, which StreamSeries then does not
+	// bound.
+	Archiver *Archiver
+	// Compression, when set, re-compresses the bodies the handlers filter,
+	// changed or not, with its algorithm and level, whatever encoding they
+	// came with. The requests of handlers without any rule to apply are
+	// forwarded as they came.
+	Compression *Compression
+	// MaxSnappyDecodedSize bounds the size snappy bodies decode to, those
+	// declaring a larger one failing before they are decoded, with 400 when
+	// snappy is the last encoding applied, DefaultMaxSnappyDecodedSize when 0.
+	MaxSnappyDecodedSize int
+	// ProvenanceTag is added to every series forwarded to the v1 and v2
+	// series intakes, see ProvenanceTag. It names the rule set of the config,
+	// the rules added through the admin API and rolled back to giving it
+	// another hash, as listed by RuleVersions.
+	ProvenanceTag string
+	// Shards spreads the series sent to /api/v1/series across upstream orgs,
+	// Sharder picking the org of each series, by the hash of its namespace
+	// when nil. Sharded series are not sent to Backends.
+	Shards  []Shard
+	Sharder Sharder
+	// SLOs are targets for the proxy's own behaviour whose burn rates are
+	// tracked.
+	SLOs []SLO
+	// FDWarnRatio is the share of the file descriptor limit above which
+	// CheckFDs reports the proxy as not ready, disabled when 0.
+	FDWarnRatio float64
+	// UpstreamCheckTimeout is how long the readiness probe waits to connect
+	// to BaseEndpoint, which it does not check when 0.
+	UpstreamCheckTimeout time.Duration
+	// UpstreamProbePath is the path of the base endpoint ProbeUpstream
+	// sends requests to, e.g. /api/v1/validate, with UpstreamProbeAPIKey as
+	// their DD-API-KEY header when set.
+	UpstreamProbePath   string
+	UpstreamProbeAPIKey string
+	// UpstreamProbeFailures is how many probes in a row must fail for the
+	// proxy to report itself as not ready, 1 when 0.
+	UpstreamProbeFailures int
+	// MaxAgents bounds how many agents the admin API lists in /agents, the
+	// ones seen the longest ago being forgotten first. Agents are not tracked
+	// when 0.
+	MaxAgents int
+	// AdminTokens restricts the admin API to requests carrying one of them as
+	// a bearer token, it is open when empty.
+	AdminTokens []AdminToken
+	// ServiceCheckRules drops the service checks matching any of them.
+	ServiceCheckRules []ServiceCheckRule
+	// EventRules drops the events matching any of them.
+	EventRules []EventRule
+	// CIRules drops the CI Visibility events matching any of them.
+	CIRules []CIRule
+	// RUMRules drops or samples the RUM events matching any of them.
+	RUMRules []RUMRule
+	// ProfileRules drops the profile uploads matching any of them.
+	ProfileRules []ProfileRule
+	// MetadataRules scrubs fields of the host metadata posted to /intake/.
+	MetadataRules []MetadataRule
+	// LogRules drops the logs matching any of them.
+	LogRules []LogRule
+	// TraceRules drops the traces whose root span matches any of them.
+	TraceRules []TraceRule
+	// ProcessRules drops the processes and containers matching any of them.
+	ProcessRules []ProcessRule
+	// ProcessArgPatterns scrubs the process arguments matching any of them.
+	ProcessArgPatterns []*regexp.Regexp
+	// ConnectionRules drops the network connections matching any of them.
+	ConnectionRules []ConnectionRule
+	// ImageRules drops the container images and SBOMs matching any of them.
+	ImageRules []ImageRule
+	// OrchestratorRules drops the Kubernetes resources matching any of them.
+	OrchestratorRules []OrchestratorRule
+	// ResourceRules rewrites the resources of the series sent to the v2
+	// series intake, the first rule for a resource type applies.
+	ResourceRules []ResourceRule
+	// Units sets the unit of the v2 series sent without one.
+	Units *Units
+	// Codecs decodes metrics payloads by their Content-Type, it defaults to
+	// codec.Default.
+	Codecs *codec.Registry
+	// ContentTypes maps routes to the media types they accept, requests with
+	// any other Content-Type are rejected with 415.
+	ContentTypes map[string][]string
+	// ValidateResponses lists routes whose successful upstream responses must
+	// carry a JSON body, counting the ones that do not.
+	ValidateResponses []string
+	// CoalesceRoutes lists routes whose identical GET requests in flight at
+	// the same time share a single upstream request and its response.
+	CoalesceRoutes []string
+	// Routes binds the paths served by Router to their endpoints and rule
+	// groups, DefaultRoutes applying when empty.
+	Routes []Route
+	// RuleGroups holds named sets of drop rules, which apply to the series of
+	// the routes bound to them after Filter.
+	RuleGroups map[string]filter.Chain
+	// StandardMetricPrefixes are the prefixes of the metrics that are not
+	// custom metrics, which the drop-all circuit keeps when limited to
+	// custom metrics. It defaults to DefaultStandardMetricPrefixes.
+	StandardMetricPrefixes []string
+	// DropRequests answers the requests matching any of its rules without
+	// forwarding them. Rules are checked after the middleware, which may set
+	// the tenant.
+	DropRequests []RequestRule
+	// DropResponses sets what is answered for the paths they match when a
+	// whole payload is dropped, instead of 202.
+	DropResponses []DropResponse
+	// RewriteResponses rewrites the status or body of upstream responses
+	// before they are sent back, the first matching rule applies.
+	RewriteResponses []ResponseRule
+	// DecisionSinks receive the decision record of every request, once it is
+	// handled.
+	DecisionSinks []DecisionSink
+	// Exporters receive every series forwarded to the v1 and v2 series
+	// intakes, and from Prometheus remote-write, e.g. to send them to an
+	// OpenTelemetry collector too. With ExportOnly, those requests are
+	// answered once their series are exported instead of being forwarded.
+	Exporters  []Exporter
+	ExportOnly bool
+	// DropSinks receive every series the filters drop, which are otherwise
+	// discarded.
+	DropSinks []DropSink
+	// Clock is used for every time measurement, it defaults to clock.Real.
+	Clock clock.Clock
+	// ErrorHandler, when set, is called instead of writing the default error
+	// response. The error wraps one of the Err values of this package.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func NewHandler(cfg Config, httpClient *http.Client, statsDClient StatsdClient) Handler {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	codecs := cfg.Codecs
+	if codecs == nil {
+		codecs = codec.Default
+	}
+	h := Handler{httpClient: httpClient, statsDClient: statsDClient, clock: clk, codecs: codecs, usage: newUsageTracker(), fds: &fdState{}, live: &liveRules{}, runtime: newRuntimeRules(), maintenance: &toggle{}, dropAll: &toggle{}, health: &healthState{started: clk.Now()}}
+	h.live.rules.Store(newHandlerRules(cfg, httpClient))
+	h.recordVersion("config", "start")
+	for _, slo := range cfg.SLOs {
+		h.slos = append(h.slos, &sloTracker{slo: slo})
+	}
+	if cfg.MaxAgents > 0 {
+		h.fleet = newFleetTracker(cfg.MaxAgents)
+	}
+	if cfg.MirrorEndpoint != "" {
+		h.mirrorSlots = newMirrorSlots(cfg.MirrorMaxInFlight)
+	}
+	return *h.current()
+}
+
+type Handler struct {
+	cfg           Config
+	httpClient    *http.Client
+	statsDClient  StatsdClient
+	filters       filter.Chain
+	middleware    []Middleware
+	clock         clock.Clock
+	coalesce      *coalescer
+	codecs        *codec.Registry
+	usage         *usageTracker
+	backends      *backendTracker
+	slos          []*sloTracker
+	fds           *fdState
+	health        *healthState
+	fleet         *fleetTracker
+	grpcTransport http.RoundTripper
+	mirrorSlots   chan struct{}
+	live          *liveRules
+	runtime       *runtimeRules
+	router        *http.ServeMux
+	// ruleSet is set on the copy of the Handler a request uses to the rule
+	// set in effect as it started.
+	ruleSet     ruleSet
+	maintenance *toggle
+	// inMaintenance is set on the copy of the Handler a request uses when
+	// the maintenance switch was on as it started.
+	inMaintenance bool
+	dropAll       *toggle
+	// dropAllScope is set on the copy of the Handler a request uses when the
+	// drop-all circuit was on as it started.
+	dropAllScope *DropAllScope
+}
+
+// ProxyHandle forwards the requests of the routes without filters as they
+// come. gRPC calls are streamed over HTTP/2, in cleartext when the base
+// endpoint is http.
+func (h *Handler) ProxyHandle(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).proxyHandle)
+}
+
+func (h *Handler) proxyHandle(w http.ResponseWriter, r *http.Request) {
+	if isGRPC(r) {
+		h.proxyGRPC(w, r)
+		return
+	}
+	body := r.Body
+	h.proxyRequest(w, r, body)
+}
+
+func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, body io.ReadCloser) {
+	url := h.cfg.BaseEndpoint + r.URL.Path
+	if h.coalesces(r) {
+		h.coalesceRequest(w, r, url)
+		return
+	}
+	if len(h.cfg.Backends) > 0 {
+		h.fanOut(w, r, body)
+		return
+	}
+	req, err := newUpstreamRequest(r.Context(), r, url, body)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, newError(ErrUpstream, err))
+		return
+	}
+
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	resp, err := h.httpClient.Do(req)
+	meta.RecordTiming("upstream", clock.Since(h.clock, start))
+	if err != nil {
+		h.writeError(w, r, http.StatusBadGateway, newError(ErrUpstream, err))
+		return
+	}
+	h.countUpstreamResponse(r, resp.StatusCode)
+
+	defer resp.Body.Close()
+	h.writeResponse(w, r, resp)
+}
+
+// newUpstreamRequest creates the request forwarding r to url.
+func newUpstreamRequest(ctx context.Context, r *http.Request, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = r.URL.RawQuery
+
+	for key := range r.Header {
+		req.Header.Add(key, r.Header.Get(key))
+	}
+	return req, nil
+}
+
+// writeResponse sends the upstream response back to the client, after it has
+// been validated, explained and rewritten.
+func (h *Handler) writeResponse(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	for key := range resp.Header {
+		w.Header().Add(key, resp.Header.Get(key))
+	}
+	respBody := h.explainRejection(w, r, resp, h.validateResponse(r, resp))
+	status, respBody := h.rewriteResponse(w, r, resp.StatusCode, respBody)
+	w.WriteHeader(status)
+	_, _ = io.Copy(w, respBody)
+}
+
+// countUpstreamResponse counts a response of upstream to r by route pattern,
+// status code and status class, e.g. status_code:429 and status_class:4xx, so that
+// rejections and errors of the intake can be told apart from those of the
+// proxy.
+func (h *Handler) countUpstreamResponse(r *http.Request, status int, extra ...string) {
+	tags := h.tags(append([]string{"route:" + routePattern(r), fmt.Sprintf("status_code:%d", status), fmt.Sprintf("status_class:%dxx", status/100)}, extra...)...)
+	_ = h.statsDClient.Count(upstreamResponsesCountName, 1, tags, 1)
+}
+
+func (h *Handler) MetricsFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).metricsFilter)
+}
+
+func (h *Handler) metricsFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.filters) == 0 && h.cfg.BatchFilter == nil && h.cfg.Transform == nil && !h.cfg.MergeDuplicates && len(h.cfg.Shards) == 0 && h.cfg.ProvenanceTag == "" && !h.exports() {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+	if h.streams(r) {
+		h.streamMetrics(w, r)
+		return
+	}
+
+	bufs, err := h.filterMetrics(r)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrCallout) {
+			status = http.StatusServiceUnavailable
+		}
+		h.writeError(w, r, status, err)
+		return
+	}
+	if h.cfg.ExportOnly {
+		h.writeExported(w)
+		return
+	}
+	if len(h.cfg.Shards) > 0 {
+		h.sendShards(w, r, bufs)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(bufs[0]))
+}
+
+// filterMetrics returns the request body without the dropped series, split in
+// one body per shard when Config.Shards is set. The shards without any series
+// get a nil body, unless every shard is without, then the first one gets an
+// empty payload.
+func (h *Handler) filterMetrics(r *http.Request) ([]*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	begin := h.clock.Now()
+	start := begin
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	c, ok := h.codecs.Lookup(mediaType)
+	if !ok {
+		return nil, newError(ErrDecode, fmt.Errorf("no codec for %q", mediaType))
+	}
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+
+	payload, err := c.Decode(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	series := payload.Series()
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	filteredSeries := make([]datadog.Series, 0, len(series))
+	for i := range series {
+		if !h.dropSeries(r.Context(), &series[i]) {
+			filteredSeries = append(filteredSeries, series[i])
+		}
+	}
+	if h.cfg.BatchFilter != nil && len(filteredSeries) > 0 {
+		filteredSeries, err = h.dropBatch(r.Context(), filteredSeries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	slo := h.newSLOCounts()
+	for i := range series {
+		slo.add(series[i].Metric)
+	}
+	for i := range filteredSeries {
+		slo.keep(filteredSeries[i].Metric)
+		h.rewriteSeries(r.Context(), &filteredSeries[i])
+	}
+	dropped := int64(len(series) - len(filteredSeries))
+	_ = h.statsDClient.Count(metricsFilteredCountName, dropped, h.cfg.Tags, 1)
+	var merged int
+	if h.cfg.MergeDuplicates {
+		filteredSeries, merged = transform.Merge(filteredSeries)
+		if merged > 0 {
+			_ = h.statsDClient.Count(mergedSeriesCountName, int64(merged), h.cfg.Tags, 1)
+		}
+	}
+	// A transform may change any series, so only payloads that none could
+	// have changed are forwarded as they came.
+	if dropped == 0 && merged == 0 && h.cfg.Transform == nil && h.cfg.ProvenanceTag == "" && len(h.cfg.Shards) == 0 {
+		meta.RecordTiming("filter", clock.Since(h.clock, start))
+		h.recordSLOs(slo, clock.Since(h.clock, begin))
+		buf, err := h.unchangedBody(r, raw)
+		if err != nil {
+			return nil, err
+		}
+		return []*bytes.Buffer{buf}, nil
+	}
+	groups := [][]datadog.Series{filteredSeries}
+	if len(h.cfg.Shards) > 0 {
+		groups = h.shardSeries(filteredSeries)
+	}
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	bufs := make([]*bytes.Buffer, len(groups))
+	for i := range groups {
+		if len(groups[i]) == 0 && (i > 0 || len(filteredSeries) > 0) {
+			continue
+		}
+		payload.SetSeries(groups[i])
+		bufs[i] = new(bytes.Buffer)
+		rw := h.getWriterForRequest(r, bufs[i])
+		err = payload.Encode(rw)
+		_ = rw.Close()
+		if err != nil {
+			return nil, newError(ErrEncode, err)
+		}
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	h.recordSLOs(slo, clock.Since(h.clock, begin))
+	return bufs, nil
+}
+
+// getReaderFromRequest returns the body of r decompressed according to its
+// Content-Encoding, undoing stacked encodings from the last one applied. Gzip
+// bodies made of several concatenated members are read to the end of the last
+// one, and snappy bodies are a single block decoding to at most
+// Config.MaxSnappyDecodedSize.
+func (h *Handler) getReaderFromRequest(r *http.Request) (io.ReadCloser, error) {
+	rc := r.Body
+	encodings := contentEncodings(r)
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		if rc, err = newDecoder(encodings[i], rc, h.maxSnappyDecodedSize()); err != nil {
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// bufferBody reads the still encoded body of r whole and replaces it with a
+// reader over the bytes read, so that it can be forwarded as it came when the
+// filters leave the payload unchanged.
+func bufferBody(r *http.Request) ([]byte, error) {
+	raw, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	return raw, nil
+}
+
+// unchangedBody returns the body of a request left unchanged by the filters,
+// saving the encoding of a payload equal to the one decoded, unless
+// Config.Compression asks for its own.
+func (h *Handler) unchangedBody(r *http.Request, raw []byte) (*bytes.Buffer, error) {
+	_ = h.statsDClient.Count(unchangedPayloadsCountName, 1, h.cfg.Tags, 1)
+	if h.cfg.Compression == nil {
+		return bytes.NewBuffer(raw), nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	defer rc.Close()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = io.Copy(rw, rc)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	return buf, nil
+}
+
+// getWriterForRequest returns a writer to w compressing with the
+// Content-Encoding of r, so the filtered body can be forwarded as it came, or
+// with Config.Compression when set, changing the Content-Encoding of r to
+// match.
+func (h *Handler) getWriterForRequest(r *http.Request, w io.Writer) io.WriteCloser {
+	if c := h.cfg.Compression; c != nil {
+		if c.Algorithm == "identity" {
+			r.Header.Del("Content-Encoding")
+		} else {
+			r.Header.Set("Content-Encoding", c.Algorithm)
+		}
+		return c.newWriter(w)
+	}
+	encodings := contentEncodings(r)
+	if len(encodings) == 0 {
+		return &nopWriterCloser{w}
+	}
+	stack := make(stackedWriter, 0, len(encodings))
+	for i := len(encodings) - 1; i >= 0; i-- {
+		next := newEncoder(encodings[i], w)
+		stack = append(stack, next)
+		w = next
+	}
+	return stack
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if h.cfg.ErrorHandler != nil {
+		h.cfg.ErrorHandler(w, r, err)
+		return
+	}
+	fmt.Println(fmt.Sprintf("Got an error handling %s, %v", r.URL.Path, err))
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "%v", err)
+}
+
+func (h *Handler) dropSeries(ctx context.Context, series *datadog.Series) bool {
+	for _, a := range h.filters.Audited(ctx, series) {
+		RequestMetaFrom(ctx).RecordAudit(filterName(a))
+	}
+	f := h.filters.First(ctx, series)
+	if f == nil {
+		return false
+	}
+	name := filterName(f)
+	RequestMetaFrom(ctx).RecordDrop(name)
+	h.sinkDropped(ctx, name, series)
+	return true
+}
+
+// rewriteSeries applies Config.Transform and Config.ProvenanceTag to a series
+// kept by the filters.
+func (h *Handler) rewriteSeries(ctx context.Context, series *datadog.Series) {
+	if h.cfg.Transform != nil {
+		h.cfg.Transform.Transform(ctx, series)
+	}
+	if h.cfg.ProvenanceTag != "" {
+		series.SetTags(append(series.GetTags(), h.cfg.ProvenanceTag))
+	}
+	h.export(*series)
+}
+
+func filterName(f filter.Filter) string {
+	if s, ok := f.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", f)
+}
+
+func (h *Handler) dropBatch(ctx context.Context, series []datadog.Series) ([]datadog.Series, error) {
+	drops, err := h.cfg.BatchFilter.DropBatch(ctx, series)
+	if err != nil {
+		return nil, err
+	}
+	if len(drops) != len(series) {
+		return nil, newError(ErrCallout, fmt.Errorf("got %d verdicts for %d series", len(drops), len(series)))
+	}
+	kept := series[:0]
+	for i := range series {
+		if drops[i] {
+			RequestMetaFrom(ctx).RecordDrop("callout")
+			h.sinkDropped(ctx, "callout", &series[i])
+			continue
+		}
+		kept = append(kept, series[i])
+	}
+	return kept, nil
+}
+
+func (h *Handler) tags(extra ...string) []string {
+	tags := make([]string, 0, len(h.cfg.Tags)+len(extra))
+	tags = append(tags, h.cfg.Tags...)
+	return append(tags, extra...)
+}
+
+type nopWriterCloser struct {
+	io.Writer
+}
+
+func (n *nopWriterCloser) Close() error {
+	return nil
+}
+
+// gauger is implemented by statsd clients that can send gauges, as
+// *statsd.Client does.
+type gauger interface {
+	Gauge(name string, value float64, tags []string, rate float64) error
+}
+
+// distributer is implemented by statsd clients that can send distributions,
+// as *statsd.Client does.
+type distributer interface {
+	Distribution(name string, value float64, tags []string, rate float64) error
+}
+
+// StatsdClient is the part of the DogStatsD client the handlers use, which
+// *statsd.Client satisfies.
+type StatsdClient interface {
+	Count(name string, value int64, tags []string, rate float64) error
+}
@@ -0,0 +1,658 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/codec"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+type result struct {
+	path,
+	body,
+	contentRequestTypeHeader,
+	apiKey,
+	userAgent,
+	method string
+	params url.Values
+}
+
+func TestHandler_ProxyHandle(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		method           string
+		sentBody         string
+		expectedResponse string
+		expectedParams   []struct{ key, value string }
+	}{
+		{
+			name:             "GET",
+			method:           "GET",
+			expectedResponse: "{expected response}",
+		},
+		{
+			name:     "POST",
+			method:   "POST",
+			sentBody: "{expected payload}",
+		},
+		{
+			name:             "GET with params",
+			method:           "GET",
+			expectedResponse: "{expected response}",
+			expectedParams: []struct{ key, value string }{{
+				key:   "key_one",
+				value: "value_one",
+			}, {
+				key:   "key_two",
+				value: "value_two",
+			}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, _ := setupCaptureServer(t, tc.expectedResponse, "")
+			ps := httptest.NewServer(http.HandlerFunc(h.ProxyHandle))
+
+			defer func() {
+				ts.Close()
+				ps.Close()
+			}()
+
+			// And we create a request
+			expectedPath := "/some/test/path"
+			req, err := http.NewRequest(tc.method, ps.URL+expectedPath, nil)
+			if tc.sentBody != "" {
+				req, err = http.NewRequest(tc.method, ps.URL+expectedPath, strings.NewReader(tc.sentBody))
+			}
+			if len(tc.expectedParams) > 0 {
+				q := req.URL.Query()
+				for _, item := range tc.expectedParams {
+					q.Add(item.key, item.value)
+				}
+				req.URL.RawQuery = q.Encode()
+			}
+			require.NoError(t, err)
+			req.Header.Add("Content-Type", "text/test")
+			req.Header.Add("User-Agent", "unit-test")
+			req.Header.Add("DD-API-KEY", "SECRET API KEY")
+
+			// When we make the request
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.NotNil(t, resp)
+			assert.Equal(t, 418, resp.StatusCode)
+
+			// Then a request is proxied to test server
+			actual := <-resultChan
+			assert.NotNil(t, actual)
+			assert.Equal(t, expectedPath, actual.path)
+			assert.Equal(t, tc.method, actual.method)
+			assert.Equal(t, "text/test", actual.contentRequestTypeHeader)
+			assert.Equal(t, "SECRET API KEY", actual.apiKey)
+			assert.Equal(t, "unit-test", actual.userAgent)
+
+			// And the payload matches
+			rb, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResponse, string(rb))
+			if len(tc.expectedParams) > 0 {
+				expectedParams := make(url.Values)
+				for _, item := range tc.expectedParams {
+					expectedParams.Add(item.key, item.value)
+				}
+				assert.Equal(t, expectedParams.Encode(), actual.params.Encode())
+			}
+		})
+	}
+}
+
+func TestHandler_MetricsFilter(t *testing.T) {
+	type Compress int64
+	const (
+		None Compress = iota
+		Gzip
+		Deflate
+		// GzipMembers splits the body across two concatenated gzip members.
+		GzipMembers
+		Snappy
+		Brotli
+	)
+
+	tests := []struct {
+		name            string
+		filterPrefix    string
+		payload         datadog.MetricsPayload
+		expectedPayload datadog.MetricsPayload
+		expectedCount   int64
+		compressRequest Compress
+	}{
+		{
+			name:            "Filter nothing",
+			payload:         defaultMetricsPayload([]string{"metric.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.one", "metric.two"}),
+		},
+		{
+			name:            "Filter no matches",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.one", "metric.two"}),
+		},
+		{
+			name:            "Filter metrics",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.one", "some.metric.load", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.one", "metric.two"}),
+		},
+		{
+			name:            "Filter nothing gzip",
+			payload:         defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			compressRequest: Gzip,
+		},
+		{
+			name:            "Filter no matches gzip",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			compressRequest: Gzip,
+		},
+		{
+			name:            "Filter metrics gzip",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.gzip.one", "some.metric.load", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			compressRequest: Gzip,
+		},
+		{
+			name:            "Filter nothing deflate",
+			payload:         defaultMetricsPayload([]string{"metric.deflate.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.deflate.one", "metric.two"}),
+			compressRequest: Deflate,
+		},
+		{
+			name:            "Filter no matches deflate",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.deflate.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.deflate.one", "metric.two"}),
+			compressRequest: Deflate,
+		},
+		{
+			name:            "Filter metrics deflate",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.deflate.one", "some.metric.load", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.deflate.one", "metric.two"}),
+			compressRequest: Deflate,
+		},
+		{
+			name:            "Filter nothing gzip members",
+			payload:         defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			compressRequest: GzipMembers,
+		},
+		{
+			name:            "Filter metrics gzip members",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.gzip.one", "some.metric.load", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.gzip.one", "metric.two"}),
+			compressRequest: GzipMembers,
+		},
+		{
+			name:            "Filter nothing snappy",
+			payload:         defaultMetricsPayload([]string{"metric.snappy.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.snappy.one", "metric.two"}),
+			compressRequest: Snappy,
+		},
+		{
+			name:            "Filter metrics snappy",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.snappy.one", "some.metric.load", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.snappy.one", "metric.two"}),
+			compressRequest: Snappy,
+		},
+		{
+			name:            "Filter nothing brotli",
+			payload:         defaultMetricsPayload([]string{"metric.brotli.one", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.brotli.one", "metric.two"}),
+			compressRequest: Brotli,
+		},
+		{
+			name:            "Filter metrics brotli",
+			filterPrefix:    "some.metric",
+			payload:         defaultMetricsPayload([]string{"metric.brotli.one", "some.metric.load", "metric.two"}),
+			expectedPayload: defaultMetricsPayload([]string{"metric.brotli.one", "metric.two"}),
+			compressRequest: Brotli,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, sc := setupCaptureServer(t, "/filter/endpoint", tc.filterPrefix)
+			ps := httptest.NewServer(http.HandlerFunc(h.MetricsFilter))
+
+			defer func() {
+				ts.Close()
+				ps.Close()
+			}()
+
+			// And we create a request
+			b := new(bytes.Buffer)
+			var err error
+
+			switch tc.compressRequest {
+			case Gzip:
+				gz := gzip.NewWriter(b)
+				err = json.NewEncoder(gz).Encode(tc.payload)
+				_ = gz.Close()
+			case Deflate:
+				gz := zlib.NewWriter(b)
+				err = json.NewEncoder(gz).Encode(tc.payload)
+				_ = gz.Close()
+			case GzipMembers:
+				var body []byte
+				body, err = json.Marshal(tc.payload)
+				for _, part := range [][]byte{body[:len(body)/2], body[len(body)/2:]} {
+					gz := gzip.NewWriter(b)
+					_, _ = gz.Write(part)
+					_ = gz.Close()
+				}
+			case Snappy:
+				var body []byte
+				body, err = json.Marshal(tc.payload)
+				b.Write(snappy.Encode(nil, body))
+			case Brotli:
+				br := brotli.NewWriter(b)
+				err = json.NewEncoder(br).Encode(tc.payload)
+				_ = br.Close()
+			default:
+				err = json.NewEncoder(b).Encode(tc.payload)
+			}
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("POST", ps.URL+"/filter/endpoint", b)
+			require.NoError(t, err)
+			req.Header.Add("Content-Type", "application/json")
+
+			switch tc.compressRequest {
+			case Gzip, GzipMembers:
+				req.Header.Add("Content-Encoding", "gzip")
+			case Deflate:
+				req.Header.Add("Content-Encoding", "deflate")
+			case Snappy:
+				req.Header.Add("Content-Encoding", "snappy")
+			case Brotli:
+				req.Header.Add("Content-Encoding", "br")
+			}
+
+			// When we make the request
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			respBody, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, 418, resp.StatusCode, fmt.Sprintf("Got an error: %v", string(respBody)))
+
+			// Then a request is proxied to test server
+			actual := <-resultChan
+			assert.NotNil(t, actual)
+			assert.Equal(t, "POST", actual.method)
+			assert.Equal(t, "application/json", actual.contentRequestTypeHeader)
+			assert.Equal(t, "/filter/endpoint", actual.path)
+
+			// And the payload matches
+			var actualPayload datadog.MetricsPayload
+			switch tc.compressRequest {
+			case Gzip, GzipMembers:
+				gz, err := gzip.NewReader(strings.NewReader(actual.body))
+				require.NoError(t, err)
+				err = json.NewDecoder(gz).Decode(&actualPayload)
+			case Deflate:
+				gz, err := zlib.NewReader(strings.NewReader(actual.body))
+				require.NoError(t, err)
+				err = json.NewDecoder(gz).Decode(&actualPayload)
+			case Snappy:
+				var body []byte
+				body, err = snappy.Decode(nil, []byte(actual.body))
+				require.NoError(t, err)
+				err = json.Unmarshal(body, &actualPayload)
+			case Brotli:
+				err = json.NewDecoder(brotli.NewReader(strings.NewReader(actual.body))).Decode(&actualPayload)
+			default:
+				err = json.Unmarshal([]byte(actual.body), &actualPayload)
+			}
+
+			require.NoError(t, err)
+
+			assert.Equal(t, actualPayload, tc.expectedPayload)
+			value := len(tc.payload.Series) - len(tc.expectedPayload.Series)
+			// called true only if we are going to filter metrics
+			called := tc.filterPrefix != ""
+			sc.assertCount(t, "proxy_filter.filtered_metrics.count", int64(value), []string{"one", "two", "three"}, 1, called)
+		})
+	}
+}
+
+func TestHandler_MetricsFilter_Filter(t *testing.T) {
+	// Given server is running with a series filter
+	cfg := server.Config{
+		Filter: stubFilter{metric: "metric.two"},
+		Tags:   []string{"one", "two", "three"},
+	}
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+	ps := httptest.NewServer(http.HandlerFunc(h.MetricsFilter))
+
+	defer func() {
+		ts.Close()
+		ps.Close()
+	}()
+
+	// And we create a request
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(defaultMetricsPayload([]string{"metric.one", "metric.two", "metric.three"}))
+	require.NoError(t, err)
+	req, err := http.NewRequest("POST", ps.URL+"/api/v1/series", b)
+	require.NoError(t, err)
+	req.Header.Add("Content-Type", "application/json")
+
+	// When we make the request
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 418, resp.StatusCode)
+
+	// Then the series picked by the filter is dropped
+	actual := <-resultChan
+	var actualPayload datadog.MetricsPayload
+	require.NoError(t, json.Unmarshal([]byte(actual.body), &actualPayload))
+	assert.Equal(t, defaultMetricsPayload([]string{"metric.one", "metric.three"}), actualPayload)
+	sc.assertCount(t, "proxy_filter.filtered_metrics.count", 1, []string{"one", "two", "three"}, 1, true)
+}
+
+func TestHandler_MetricsFilter_Transform(t *testing.T) {
+	// Given server is running with a transform and no filters
+	cfg := server.Config{Transform: transform.AddTags{"proxied:true"}}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(defaultMetricsPayload([]string{"metric.one"}))
+	require.NoError(t, err)
+
+	// When we make the request
+	rec := httptest.NewRecorder()
+	h.MetricsFilter(rec, httptest.NewRequest("POST", "/api/v1/series", b))
+
+	// Then the series is forwarded with the tag
+	require.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	var actualPayload datadog.MetricsPayload
+	require.NoError(t, json.Unmarshal([]byte(actual.body), &actualPayload))
+	expected := defaultMetricsPayload([]string{"metric.one"})
+	expected.Series[0].SetTags(append(expected.Series[0].GetTags(), "proxied:true"))
+	assert.Equal(t, expected, actualPayload)
+}
+
+func TestHandler_MetricsFilter_MergeDuplicates(t *testing.T) {
+	// Given server is running merging duplicates
+	cfg := server.Config{MergeDuplicates: true, Tags: []string{"one"}}
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(defaultMetricsPayload([]string{"metric.one", "metric.two", "metric.one"}))
+	require.NoError(t, err)
+
+	// When we make the request
+	rec := httptest.NewRecorder()
+	h.MetricsFilter(rec, httptest.NewRequest("POST", "/api/v1/series", b))
+
+	// Then the duplicate series are forwarded as one
+	require.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	var actualPayload datadog.MetricsPayload
+	require.NoError(t, json.Unmarshal([]byte(actual.body), &actualPayload))
+	expected := defaultMetricsPayload([]string{"metric.one", "metric.two"})
+	expected.Series[0].Points = append(expected.Series[0].Points, expected.Series[1].Points...)
+	assert.Equal(t, expected, actualPayload)
+
+	// And the merge is counted
+	sc.assertCount(t, "proxy_filter.merged_series.count", 1, []string{"one"}, 1, true)
+}
+
+func TestHandler_MetricsFilter_Unchanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		metrics   []string
+		unchanged bool
+	}{
+		{
+			name:      "Nothing dropped",
+			metrics:   []string{"metric.one", "metric.two"},
+			unchanged: true,
+		},
+		{
+			name:    "Series dropped",
+			metrics: []string{"metric.one", "some.metric.load"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with a prefix filter
+			cfg := server.Config{MetricsPrefixFilter: "some.metric", Tags: []string{"one"}}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// And a gzip payload
+			b := new(bytes.Buffer)
+			zw := gzip.NewWriter(b)
+			require.NoError(t, json.NewEncoder(zw).Encode(defaultMetricsPayload(tc.metrics)))
+			require.NoError(t, zw.Close())
+			sent := b.String()
+			req := httptest.NewRequest("POST", "/api/v1/series", b)
+			req.Header.Set("Content-Encoding", "gzip")
+
+			// When we make the request
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+
+			// Then only a payload without drops is forwarded as it came
+			require.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			if !tc.unchanged {
+				assert.NotEqual(t, sent, actual.body)
+				sc.assertNotCounted(t, "proxy_filter.unchanged_payloads.count")
+				return
+			}
+			assert.Equal(t, sent, actual.body)
+			sc.assertCount(t, "proxy_filter.unchanged_payloads.count", 1, []string{"one"}, 1, true)
+		})
+	}
+}
+
+type envelope struct {
+	Source  string                 `json:"source"`
+	Payload datadog.MetricsPayload `json:"payload"`
+}
+
+func (e *envelope) Series() []datadog.Series          { return e.Payload.Series }
+func (e *envelope) SetSeries(series []datadog.Series) { e.Payload.Series = series }
+func (e *envelope) Encode(w io.Writer) error          { return json.NewEncoder(w).Encode(e) }
+
+func TestHandler_MetricsFilter_Codec(t *testing.T) {
+	// Given server is running with a codec for an envelope format
+	codecs := &codec.Registry{}
+	codecs.Register("application/x-envelope", codec.Func(func(r io.Reader) (codec.Payload, error) {
+		e := &envelope{}
+		return e, json.NewDecoder(r).Decode(e)
+	}))
+	cfg := server.Config{MetricsPrefixFilter: "some.metric", Codecs: codecs}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(envelope{Source: "edge", Payload: defaultMetricsPayload([]string{"metric.one", "some.metric.load"})})
+	require.NoError(t, err)
+
+	// When we make the request
+	req := httptest.NewRequest("POST", "/api/v1/series", b)
+	req.Header.Set("Content-Type", "application/x-envelope")
+	rec := httptest.NewRecorder()
+	h.MetricsFilter(rec, req)
+
+	// Then the envelope is forwarded with the filtered series
+	require.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	var actualEnvelope envelope
+	require.NoError(t, json.Unmarshal([]byte(actual.body), &actualEnvelope))
+	assert.Equal(t, envelope{Source: "edge", Payload: defaultMetricsPayload([]string{"metric.one"})}, actualEnvelope)
+
+	// When there is no codec for the payload
+	req = httptest.NewRequest("POST", "/api/v1/series", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	h.MetricsFilter(rec, req)
+
+	// Then it cannot be decoded
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func setupCaptureServer(t *testing.T, expectedResponse, metricsPrefixFilter string) (chan result, *httptest.Server, server.Handler, *stubStatsdClient) {
+	cfg := server.Config{
+		MetricsPrefixFilter: metricsPrefixFilter,
+		Tags:                []string{"one", "two", "three"},
+	}
+	return setupCaptureServerWithConfig(t, expectedResponse, cfg)
+}
+
+func setupCaptureServerWithConfig(t *testing.T, expectedResponse string, cfg server.Config) (chan result, *httptest.Server, server.Handler, *stubStatsdClient) {
+	resultChan := make(chan result, 1)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		res := result{
+			path:                     r.URL.Path,
+			body:                     string(body),
+			contentRequestTypeHeader: r.Header.Get("Content-Type"),
+			method:                   r.Method,
+			apiKey:                   r.Header.Get("DD-API-KEY"),
+			userAgent:                r.Header.Get("User-Agent"),
+			params:                   r.URL.Query(),
+		}
+		defer func(res result) {
+			resultChan <- res
+		}(res)
+		w.Header().Add("Content-Type", "application/test")
+		w.WriteHeader(418)
+		if expectedResponse != "" {
+			_, _ = io.WriteString(w, expectedResponse)
+		}
+	}))
+
+	cfg.BaseEndpoint = ts.URL
+
+	sd := &stubStatsdClient{}
+	h := server.NewHandler(cfg, ts.Client(), sd)
+
+	return resultChan, ts, h, sd
+}
+
+type stubStatsdClient struct {
+	counts map[string]stubCount
+	called bool
+	sync.Mutex
+}
+
+type stubCount struct {
+	value int64
+	tags  []string
+	rate  float64
+}
+
+func (s *stubStatsdClient) Count(name string, value int64, tags []string, rate float64) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]stubCount)
+	}
+	s.counts[name] = stubCount{value: value, tags: tags, rate: rate}
+	s.called = true
+	return
+}
+
+func (s *stubStatsdClient) assertCount(t *testing.T, name string, value int64, tags []string, rate float64, called bool) {
+	s.Lock()
+	defer s.Unlock()
+	if !called {
+		require.False(t, called)
+		return
+	}
+	require.True(t, s.called)
+	c, ok := s.counts[name]
+	require.True(t, ok, "%s was not counted", name)
+	assert.Equal(t, tags, c.tags)
+	assert.Equal(t, rate, c.rate)
+	assert.Equal(t, value, c.value)
+}
+
+func (s *stubStatsdClient) assertNotCounted(t *testing.T, name string) {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.counts[name]
+	require.False(t, ok, "%s was counted", name)
+}
+
+type stubFilter struct {
+	metric string
+}
+
+func (s stubFilter) Filter(_ context.Context, series *datadog.Series) filter.Decision {
+	if series.Metric == s.metric {
+		return filter.Drop
+	}
+	return filter.Keep
+}
+
+func defaultMetricsPayload(metricName []string) (payload datadog.MetricsPayload) {
+	payload.Series = make([]datadog.Series, len(metricName))
+	for i := range metricName {
+		payload.Series[i] = datadog.Series{
+			Metric: metricName[i],
+			Type:   datadog.PtrString("gauge"),
+			Points: [][]*float64{
+				{
+					datadog.PtrFloat64(float64(1231231231232)),
+					datadog.PtrFloat64(float64(1)),
+				},
+			},
+			Tags: &[]string{
+				"test:ExampleSubmitmetricsreturnsPayloadacceptedresponse",
+			},
+		}
+	}
+	return
+}
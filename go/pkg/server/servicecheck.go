@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// ServiceCheckRule drops the service checks whose name starts with
+// CheckPrefix and that carry every one of Tags.
+type ServiceCheckRule struct {
+	CheckPrefix string
+	Tags        []string
+}
+
+// ParseServiceCheckRule parses a rule written as comma separated key=value
+// pairs with the keys check and tag, tag being repeatable, e.g.
+// check=custom.,tag=env:dev.
+func ParseServiceCheckRule(s string) (ServiceCheckRule, error) {
+	var rule ServiceCheckRule
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return ServiceCheckRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "check":
+			rule.CheckPrefix = kv[1]
+		case "tag":
+			rule.Tags = append(rule.Tags, kv[1])
+		default:
+			return ServiceCheckRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether the service check is dropped by the rule.
+func (sr ServiceCheckRule) Match(check string, tags []string) bool {
+	if sr.CheckPrefix == "" && len(sr.Tags) == 0 {
+		return false
+	}
+	if !strings.HasPrefix(check, sr.CheckPrefix) {
+		return false
+	}
+	return hasTags(tags, sr.Tags)
+}
+
+// hasTags reports whether tags holds every one of want.
+func hasTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceCheck is what the rules look at in a service check.
+type serviceCheck struct {
+	Check string   `json:"check"`
+	Tags  []string `json:"tags"`
+}
+
+// ServiceChecksFilter filters the service checks sent to /api/v1/check_run
+// with Config.ServiceCheckRules. Kept checks are forwarded as they came.
+func (h *Handler) ServiceChecksFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).serviceChecksFilter)
+}
+
+func (h *Handler) serviceChecksFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.ServiceCheckRules) == 0 {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterServiceChecks(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+func (h *Handler) filterServiceChecks(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	var checks []json.RawMessage
+	err = json.NewDecoder(rc).Decode(&checks)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	kept := make([]json.RawMessage, 0, len(checks))
+	for _, raw := range checks {
+		var check serviceCheck
+		if err := json.Unmarshal(raw, &check); err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		if h.dropServiceCheck(check) {
+			meta.RecordDrop("service_check")
+			continue
+		}
+		kept = append(kept, raw)
+	}
+	_ = h.statsDClient.Count(filteredServiceChecksCountName, int64(len(checks)-len(kept)), h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if len(kept) == len(checks) {
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	err = json.NewEncoder(rw).Encode(kept)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, nil
+}
+
+func (h *Handler) dropServiceCheck(check serviceCheck) bool {
+	for _, rule := range h.cfg.ServiceCheckRules {
+		if rule.Match(check.Check, check.Tags) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package server_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseServiceCheckRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		expected server.ServiceCheckRule
+		invalid  bool
+	}{
+		{
+			name:     "Check",
+			rule:     "check=custom.",
+			expected: server.ServiceCheckRule{CheckPrefix: "custom."},
+		},
+		{
+			name:     "Check and tags",
+			rule:     "check=custom.,tag=env:dev,tag=team:web",
+			expected: server.ServiceCheckRule{CheckPrefix: "custom.", Tags: []string{"env:dev", "team:web"}},
+		},
+		{
+			name:    "Unknown key",
+			rule:    "host=web-1",
+			invalid: true,
+		},
+		{
+			name:    "Missing value",
+			rule:    "check=",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseServiceCheckRule(tc.rule)
+			if tc.invalid {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestHandler_ServiceChecksFilter(t *testing.T) {
+	const checks = `[
+		{"check":"custom.noisy","host_name":"web-1","status":0,"tags":["env:dev"]},
+		{"check":"custom.noisy","host_name":"web-2","status":2,"tags":["env:prod"]},
+		{"check":"datadog.agent.up","host_name":"web-1","status":0,"tags":["env:dev"]}
+	]`
+	tests := []struct {
+		name            string
+		rules           []server.ServiceCheckRule
+		expectedBody    string
+		expectedDropped int64
+	}{
+		{
+			name:         "No rules",
+			expectedBody: checks,
+		},
+		{
+			name:  "Check prefix",
+			rules: []server.ServiceCheckRule{{CheckPrefix: "custom."}},
+			expectedBody: `[
+				{"check":"datadog.agent.up","host_name":"web-1","status":0,"tags":["env:dev"]}
+			]`,
+			expectedDropped: 2,
+		},
+		{
+			name:  "Check prefix and tag",
+			rules: []server.ServiceCheckRule{{CheckPrefix: "custom.", Tags: []string{"env:dev"}}},
+			expectedBody: `[
+				{"check":"custom.noisy","host_name":"web-2","status":2,"tags":["env:prod"]},
+				{"check":"datadog.agent.up","host_name":"web-1","status":0,"tags":["env:dev"]}
+			]`,
+			expectedDropped: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with service check rules
+			cfg := server.Config{ServiceCheckRules: tc.rules, Tags: []string{"one"}}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When the agent sends service checks
+			req := httptest.NewRequest("POST", "/api/v1/check_run", strings.NewReader(checks))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServiceChecksFilter(rec, req)
+
+			// Then only the kept checks are forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			assert.JSONEq(t, tc.expectedBody, actual.body)
+			sc.assertCount(t, "proxy_filter.filtered_service_checks.count", tc.expectedDropped, []string{"one"}, 1, len(tc.rules) > 0)
+		})
+	}
+}
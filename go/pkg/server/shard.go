@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// Shard is one of the upstream orgs series are spread across, reached at
+// Endpoint, the base endpoint when empty, with APIKey.
+type Shard struct {
+	Endpoint string
+	APIKey   string
+}
+
+// ParseShard parses a shard written as comma separated key=value pairs with
+// the keys api_key and endpoint, e.g.
+// api_key=0123abcd,endpoint=https://api.datadoghq.eu.
+func ParseShard(s string) (Shard, error) {
+	var shard Shard
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Shard{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "api_key":
+			shard.APIKey = kv[1]
+		case "endpoint":
+			shard.Endpoint = strings.TrimSuffix(kv[1], "/")
+		default:
+			return Shard{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	if shard.APIKey == "" {
+		return Shard{}, newError(ErrRuleInvalid, fmt.Errorf("expected an api_key in %q", s))
+	}
+	return shard, nil
+}
+
+// Sharder picks which of n shards a series is sent to. Implementations must
+// be safe for concurrent use.
+type Sharder interface {
+	Shard(series *datadog.Series, n int) int
+}
+
+// NamespaceSharder sends every series of a namespace, the part of the metric
+// name before its first dot, to the same shard chosen by its hash.
+type NamespaceSharder struct{}
+
+func (NamespaceSharder) Shard(series *datadog.Series, n int) int {
+	namespace := series.Metric
+	if i := strings.IndexByte(namespace, '.'); i >= 0 {
+		namespace = namespace[:i]
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(namespace))
+	return int(hash.Sum32() % uint32(n))
+}
+
+// shardSeries groups the series by the shard Config.Sharder picks for them.
+func (h *Handler) shardSeries(series []datadog.Series) [][]datadog.Series {
+	sharder := h.cfg.Sharder
+	if sharder == nil {
+		sharder = NamespaceSharder{}
+	}
+	groups := make([][]datadog.Series, len(h.cfg.Shards))
+	for i := range series {
+		s := sharder.Shard(&series[i], len(groups))
+		groups[s] = append(groups[s], series[i])
+	}
+	for i := range groups {
+		_ = h.statsDClient.Count(shardedSeriesCountName, int64(len(groups[i])), h.tags(fmt.Sprintf("shard:%d", i)), 1)
+	}
+	return groups
+}
+
+// sendShards sends the payload of every shard at once, nil payloads being
+// skipped, and answers the client with the first failure or else with the
+// response of the first shard sent to.
+func (h *Handler) sendShards(w http.ResponseWriter, r *http.Request, payloads []*bytes.Buffer) {
+	reqs := make([]*http.Request, len(payloads))
+	for i, payload := range payloads {
+		if payload == nil {
+			continue
+		}
+		shard := h.cfg.Shards[i]
+		endpoint := shard.Endpoint
+		if endpoint == "" {
+			endpoint = h.cfg.BaseEndpoint
+		}
+		req, err := newUpstreamRequest(r.Context(), r, endpoint+r.URL.Path, payload)
+		if err != nil {
+			h.writeError(w, r, http.StatusInternalServerError, newError(ErrUpstream, err))
+			return
+		}
+		req.Header.Set("DD-API-KEY", shard.APIKey)
+		if q := req.URL.Query(); q.Get("api_key") != "" {
+			q.Set("api_key", shard.APIKey)
+			req.URL.RawQuery = q.Encode()
+		}
+		reqs[i] = req
+	}
+
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	results := make([]backendResult, len(reqs))
+	var wg sync.WaitGroup
+	for i := range reqs {
+		if reqs[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := h.httpClient.Do(reqs[i])
+			results[i] = backendResult{resp: resp, err: err}
+			if err == nil {
+				h.countUpstreamResponse(r, resp.StatusCode, fmt.Sprintf("shard:%d", i))
+			}
+			if !results[i].ok() {
+				_ = h.statsDClient.Count(shardFailuresCountName, 1, h.tags("route:"+routePattern(r), fmt.Sprintf("shard:%d", i)), 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	meta.RecordTiming("upstream", clock.Since(h.clock, start))
+
+	chosen := -1
+	for i := range reqs {
+		if reqs[i] == nil {
+			continue
+		}
+		if chosen < 0 || (results[chosen].ok() && !results[i].ok()) {
+			chosen = i
+		}
+	}
+	for i := range results {
+		if results[i].resp != nil && i != chosen {
+			_, _ = io.Copy(io.Discard, results[i].resp.Body)
+			_ = results[i].resp.Body.Close()
+		}
+	}
+	if results[chosen].err != nil {
+		h.writeError(w, r, http.StatusBadGateway, newError(ErrUpstream, results[chosen].err))
+		return
+	}
+	resp := results[chosen].resp
+	defer resp.Body.Close()
+	h.writeResponse(w, r, resp)
+}
@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+// prefixSharder sends the series whose metric starts with "b" to the second
+// shard and the others to the first.
+type prefixSharder struct{}
+
+func (prefixSharder) Shard(series *datadog.Series, _ int) int {
+	if strings.HasPrefix(series.Metric, "b") {
+		return 1
+	}
+	return 0
+}
+
+func TestParseShard(t *testing.T) {
+	shard, err := server.ParseShard("api_key=0123abcd,endpoint=https://api.datadoghq.eu/")
+	require.NoError(t, err)
+	assert.Equal(t, server.Shard{APIKey: "0123abcd", Endpoint: "https://api.datadoghq.eu"}, shard)
+
+	for _, s := range []string{"", "api_key=", "endpoint=https://api.datadoghq.eu", "org=a"} {
+		_, err = server.ParseShard(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestNamespaceSharder(t *testing.T) {
+	var sharder server.NamespaceSharder
+	for _, n := range []int{1, 2, 7} {
+		a := sharder.Shard(&datadog.Series{Metric: "app.requests"}, n)
+		assert.Equal(t, a, sharder.Shard(&datadog.Series{Metric: "app.latency.p99"}, n))
+		assert.Equal(t, a, sharder.Shard(&datadog.Series{Metric: "app"}, n))
+		assert.GreaterOrEqual(t, a, 0)
+		assert.Less(t, a, n)
+	}
+}
+
+func TestHandler_MetricsFilter_Shards(t *testing.T) {
+	tests := []struct {
+		name         string
+		metrics      []string
+		failKey      string
+		expected     map[string][]string
+		expectedCode int
+	}{
+		{
+			name:         "Split across shards",
+			metrics:      []string{"a.one", "b.one", "a.two"},
+			expected:     map[string][]string{"key-a": {"a.one", "a.two"}, "key-b": {"b.one"}},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "Single shard",
+			metrics:      []string{"b.one"},
+			expected:     map[string][]string{"key-b": {"b.one"}},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "Failure wins",
+			metrics:      []string{"a.one", "b.one"},
+			failKey:      "key-b",
+			expected:     map[string][]string{"key-a": {"a.one"}, "key-b": {"b.one"}},
+			expectedCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given two orgs behind the same upstream
+			var mu sync.Mutex
+			received := make(map[string][]string)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var payload datadog.MetricsPayload
+				body, _ := io.ReadAll(r.Body)
+				require.NoError(t, json.Unmarshal(body, &payload))
+				key := r.Header.Get("DD-API-KEY")
+				mu.Lock()
+				for _, s := range payload.Series {
+					received[key] = append(received[key], s.Metric)
+				}
+				mu.Unlock()
+				if key == tc.failKey {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer ts.Close()
+			cfg := server.Config{
+				BaseEndpoint: ts.URL,
+				Shards:       []server.Shard{{APIKey: "key-a"}, {APIKey: "key-b", Endpoint: ts.URL}},
+				Sharder:      prefixSharder{},
+			}
+			h := server.NewHandler(cfg, ts.Client(), &stubStatsdClient{})
+
+			// When the agent sends series
+			var series []datadog.Series
+			for _, m := range tc.metrics {
+				series = append(series, datadog.Series{Metric: m, Points: [][]*float64{}})
+			}
+			body, err := json.Marshal(datadog.MetricsPayload{Series: series})
+			require.NoError(t, err)
+			req := httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("DD-API-KEY", "agent-key")
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+
+			// Then every org gets its own series
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			assert.Equal(t, tc.expected, received)
+		})
+	}
+}
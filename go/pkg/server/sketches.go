@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the SketchPayload protobuf sent to the sketches intake, as
+// defined by the agent payload protos.
+const (
+	sketchPayloadSketches = 1
+
+	sketchMetric        = 1
+	sketchHost          = 2
+	sketchDistributions = 3
+	sketchTags          = 4
+	sketchDogsketches   = 7
+
+	// Both Distribution and Dogsketch messages start with these.
+	sketchPointTimestamp = 1
+	sketchPointCount     = 2
+)
+
+// SketchesFilter filters the protobuf payloads of distribution metrics sent to
+// the sketches intake. The filters see each sketch as a v1 series of type
+// distribution, with a point holding the count of values of each of its
+// sketches. Config.Transform rewrites the name, host and tags of the kept
+// sketches, their values being left as they are.
+func (h *Handler) SketchesFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).sketchesFilter)
+}
+
+func (h *Handler) sketchesFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.filters) == 0 && h.cfg.Transform == nil {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+	rewrite := func(b []byte) []byte { return h.transformSketch(r.Context(), b) }
+	if h.cfg.StreamSeries {
+		h.streamProtobuf(w, r, sketchPayloadSketches, decodeSketch, rewrite)
+		return
+	}
+
+	buf, err := h.filterProtobuf(r, sketchPayloadSketches, decodeSketch, rewrite)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// DecodeSketches decodes a sketches intake payload into the series the
+// filters see, e.g. to check the decoding against the agent payload protos.
+func DecodeSketches(body []byte) ([]datadog.Series, error) {
+	return decodeRepeated(body, sketchPayloadSketches, decodeSketch)
+}
+
+// decodeSketch decodes what the filters look at in a sketch.
+func decodeSketch(b []byte) (datadog.Series, error) {
+	series := datadog.Series{Type: datadog.PtrString("distribution")}
+	var tags []string
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == sketchMetric && typ == protowire.BytesType:
+			series.Metric = string(v)
+		case num == sketchHost && typ == protowire.BytesType:
+			series.SetHost(string(v))
+		case num == sketchTags && typ == protowire.BytesType:
+			tags = append(tags, string(v))
+		case (num == sketchDistributions || num == sketchDogsketches) && typ == protowire.BytesType:
+			var ts, count float64
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if typ != protowire.VarintType {
+					return nil
+				}
+				n, _ := protowire.ConsumeVarint(v)
+				switch num {
+				case sketchPointTimestamp:
+					ts = float64(int64(n))
+				case sketchPointCount:
+					count = float64(int64(n))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			series.Points = append(series.Points, []*float64{&ts, &count})
+		}
+		return nil
+	})
+	if tags != nil {
+		series.SetTags(tags)
+	}
+	return series, err
+}
+
+// transformSketch applies Config.Transform to a sketch known to be well
+// formed, encoding it again with the name, host and tags the transform sets
+// when it changes them.
+func (h *Handler) transformSketch(ctx context.Context, b []byte) []byte {
+	if h.cfg.Transform == nil {
+		return b
+	}
+	series, err := decodeSketch(b)
+	if err != nil {
+		return b
+	}
+	before, _ := decodeSketch(b)
+	h.cfg.Transform.Transform(ctx, &series)
+	if series.Metric == before.Metric && series.GetHost() == before.GetHost() && reflect.DeepEqual(series.GetTags(), before.GetTags()) {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	out = protowire.AppendTag(out, sketchMetric, protowire.BytesType)
+	out = protowire.AppendString(out, series.Metric)
+	if series.HasHost() {
+		out = protowire.AppendTag(out, sketchHost, protowire.BytesType)
+		out = protowire.AppendString(out, series.GetHost())
+	}
+	for _, tag := range series.GetTags() {
+		out = protowire.AppendTag(out, sketchTags, protowire.BytesType)
+		out = protowire.AppendString(out, tag)
+	}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if num != sketchMetric && num != sketchHost && num != sketchTags {
+			out = append(out, b[:n+m]...)
+		}
+		b = b[n+m:]
+	}
+	return out
+}
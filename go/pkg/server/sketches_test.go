@@ -0,0 +1,96 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func encodeSketch(metric, host string, tags ...string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, metric)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, host)
+	for _, tag := range tags {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, tag)
+	}
+	var d []byte
+	d = protowire.AppendTag(d, 1, protowire.VarintType)
+	d = protowire.AppendVarint(d, 1650000000)
+	d = protowire.AppendTag(d, 2, protowire.VarintType)
+	d = protowire.AppendVarint(d, 12)
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	return protowire.AppendBytes(b, d)
+}
+
+func encodeSketchPayload(sketches ...[]byte) []byte {
+	var b []byte
+	for _, s := range sketches {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, s)
+	}
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, nil)
+}
+
+func TestHandler_SketchesFilter(t *testing.T) {
+	latency := encodeSketch("app.request.latency", "web-1", "env:prod")
+	size := encodeSketch("app.response.size", "web-1.staging", "env:staging")
+	tests := []struct {
+		name     string
+		cfg      server.Config
+		expected []byte
+	}{
+		{
+			name:     "No filters",
+			expected: encodeSketchPayload(latency, size),
+		},
+		{
+			name:     "Filter metric name",
+			cfg:      server.Config{MetricsPrefixFilter: "app.request."},
+			expected: encodeSketchPayload(size),
+		},
+		{
+			name:     "Filter host",
+			cfg:      server.Config{Filter: filter.Rule{HostPattern: "*.staging"}},
+			expected: encodeSketchPayload(latency),
+		},
+		{
+			name:     "Transform name and tags",
+			cfg:      server.Config{Transform: transform.AddTags{"proxied:true"}},
+			expected: encodeSketchPayload(encodeSketch("app.request.latency", "web-1", "env:prod", "proxied:true"), encodeSketch("app.response.size", "web-1.staging", "env:staging", "proxied:true")),
+		},
+		{
+			name:     "Empty filter keeps sketches with values",
+			cfg:      server.Config{Filter: filter.Empty{}},
+			expected: encodeSketchPayload(latency, size),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", tc.cfg)
+			defer ts.Close()
+
+			// When we send sketches
+			req := httptest.NewRequest("POST", "/api/beta/sketches", bytes.NewReader(encodeSketchPayload(latency, size)))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			rec := httptest.NewRecorder()
+			h.SketchesFilter(rec, req)
+
+			// Then the filtered sketches are forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, tc.expected, []byte(actual.body))
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sloBuckets = 60
+
+// SLOKind is what an SLO measures.
+type SLOKind string
+
+const (
+	// SLODrops measures the share of series dropped outside the prefixes of
+	// the SLO, which the policy means to drop, each such dropped series
+	// spending the error budget.
+	SLODrops SLOKind = "drops"
+	// SLOLatency measures the latency the proxy adds to metrics payloads by
+	// decoding, filtering and encoding them, each payload slower than the
+	// threshold spending the error budget.
+	SLOLatency SLOKind = "latency"
+)
+
+// SLO is a target for the proxy's own behaviour. Objective is the share of
+// good events, e.g. 0.99, over Window. A drops SLO only counts the series
+// whose metric starts with none of Prefixes.
+type SLO struct {
+	Name      string
+	Kind      SLOKind
+	Objective float64
+	Threshold time.Duration
+	Window    time.Duration
+	Prefixes  []string
+}
+
+// ParseSLO parses an SLO written as comma separated key=value pairs with the
+// keys name, kind, objective, threshold, window and prefix, e.g.
+// name=filter-latency,kind=latency,objective=0.99,threshold=20ms,window=1h or
+// name=policy-drops,kind=drops,objective=0.99,prefix=debug.,prefix=test.
+// The window defaults to an hour, and prefix is repeatable.
+func ParseSLO(s string) (SLO, error) {
+	slo := SLO{Window: time.Hour}
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		var err error
+		switch kv[0] {
+		case "name":
+			slo.Name = kv[1]
+		case "kind":
+			slo.Kind = SLOKind(kv[1])
+			if slo.Kind != SLODrops && slo.Kind != SLOLatency {
+				return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("unknown kind %q", kv[1]))
+			}
+		case "objective":
+			slo.Objective, err = strconv.ParseFloat(kv[1], 64)
+			if err != nil || slo.Objective <= 0 || slo.Objective >= 1 {
+				return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("bad objective %q, expected a number between 0 and 1", kv[1]))
+			}
+		case "threshold":
+			slo.Threshold, err = time.ParseDuration(kv[1])
+			if err != nil || slo.Threshold <= 0 {
+				return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("bad threshold %q", kv[1]))
+			}
+		case "prefix":
+			slo.Prefixes = append(slo.Prefixes, kv[1])
+		case "window":
+			slo.Window, err = time.ParseDuration(kv[1])
+			if err != nil || slo.Window < sloBuckets*time.Second {
+				return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("bad window %q, expected at least a minute", kv[1]))
+			}
+		default:
+			return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	switch {
+	case slo.Name == "":
+		return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("missing name in %q", s))
+	case slo.Kind == "":
+		return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("missing kind in %q", s))
+	case slo.Objective == 0:
+		return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("missing objective in %q", s))
+	case slo.Kind == SLOLatency && slo.Threshold == 0:
+		return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("missing threshold in %q", s))
+	case slo.Kind != SLODrops && len(slo.Prefixes) > 0:
+		return SLO{}, newError(ErrRuleInvalid, fmt.Errorf("prefix only applies to drops SLOs in %q", s))
+	}
+	return slo, nil
+}
+
+// SLOStatus is how an SLO fares over its window. A BurnRate of 1 spends the
+// error budget exactly over the window, above 1 spends it faster.
+type SLOStatus struct {
+	Name     string  `json:"name"`
+	Kind     SLOKind `json:"kind"`
+	Good     int64   `json:"good"`
+	Bad      int64   `json:"bad"`
+	BurnRate float64 `json:"burn_rate"`
+}
+
+type sloBucket struct {
+	index     int64
+	good, bad int64
+}
+
+// sloTracker counts the good and bad events of an SLO in a ring of buckets
+// covering its window.
+type sloTracker struct {
+	slo     SLO
+	mu      sync.Mutex
+	buckets [sloBuckets]sloBucket
+}
+
+func (s *sloTracker) bucketIndex(now time.Time) int64 {
+	return now.UnixNano() / int64(s.slo.Window/sloBuckets)
+}
+
+func (s *sloTracker) record(now time.Time, good, bad int64) {
+	index := s.bucketIndex(now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.buckets[index%sloBuckets]
+	if b.index != index {
+		*b = sloBucket{index: index}
+	}
+	b.good += good
+	b.bad += bad
+}
+
+func (s *sloTracker) status(now time.Time) SLOStatus {
+	index := s.bucketIndex(now)
+	res := SLOStatus{Name: s.slo.Name, Kind: s.slo.Kind}
+	s.mu.Lock()
+	for _, b := range s.buckets {
+		if b.index > index-sloBuckets && b.index <= index {
+			res.Good += b.good
+			res.Bad += b.bad
+		}
+	}
+	s.mu.Unlock()
+	if total := res.Good + res.Bad; total > 0 {
+		res.BurnRate = float64(res.Bad) / float64(total) / (1 - s.slo.Objective)
+	}
+	return res
+}
+
+// sloCounts counts the series of a metrics payload each drops SLO applies to,
+// those outside its prefixes, and how many of them were kept.
+type sloCounts struct {
+	slos        []*sloTracker
+	total, kept []int64
+}
+
+func (h *Handler) newSLOCounts() *sloCounts {
+	return &sloCounts{slos: h.slos, total: make([]int64, len(h.slos)), kept: make([]int64, len(h.slos))}
+}
+
+// add counts a series of the payload, kept or not.
+func (c *sloCounts) add(metric string) {
+	for i, s := range c.slos {
+		if s.applies(metric) {
+			c.total[i]++
+		}
+	}
+}
+
+// keep counts a series of the payload the filters kept.
+func (c *sloCounts) keep(metric string) {
+	for i, s := range c.slos {
+		if s.applies(metric) {
+			c.kept[i]++
+		}
+	}
+}
+
+// applies reports whether the drops SLO counts the series of metric.
+func (s *sloTracker) applies(metric string) bool {
+	if s.slo.Kind != SLODrops {
+		return false
+	}
+	for _, p := range s.slo.Prefixes {
+		if strings.HasPrefix(metric, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordSLOs records a metrics payload whose series were counted in counts,
+// that took took to decode, filter and encode.
+func (h *Handler) recordSLOs(counts *sloCounts, took time.Duration) {
+	now := h.clock.Now()
+	for i, s := range counts.slos {
+		switch s.slo.Kind {
+		case SLODrops:
+			s.record(now, counts.kept[i], counts.total[i]-counts.kept[i])
+		case SLOLatency:
+			if took > s.slo.Threshold {
+				s.record(now, 0, 1)
+			} else {
+				s.record(now, 1, 0)
+			}
+		}
+	}
+}
+
+// SLOs returns the status of every SLO in Config.SLOs over its window.
+func (h *Handler) SLOs() []SLOStatus {
+	now := h.clock.Now()
+	res := make([]SLOStatus, len(h.slos))
+	for i, s := range h.slos {
+		res[i] = s.status(now)
+	}
+	return res
+}
+
+// SLOStatus serves SLOs as JSON.
+func (h *Handler) SLOStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.SLOs())
+}
+
+// ReportSLOs sends the burn rate of every SLO as a gauge, when the statsd
+// client can send gauges. Call it periodically.
+func (h *Handler) ReportSLOs() {
+	g, ok := h.statsDClient.(gauger)
+	if !ok {
+		return
+	}
+	for _, s := range h.SLOs() {
+		_ = g.Gauge(sloBurnRateGaugeName, s.BurnRate, h.tags("slo:"+s.Name, "kind:"+string(s.Kind)), 1)
+	}
+}
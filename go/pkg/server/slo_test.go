@@ -0,0 +1,155 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestParseSLO(t *testing.T) {
+	tests := []struct {
+		name     string
+		slo      string
+		expected server.SLO
+		invalid  bool
+	}{
+		{
+			name:     "Drops",
+			slo:      "name=policy-drops,kind=drops,objective=0.99",
+			expected: server.SLO{Name: "policy-drops", Kind: server.SLODrops, Objective: 0.99, Window: time.Hour},
+		},
+		{
+			name:     "Drops outside prefixes",
+			slo:      "name=collateral-drops,kind=drops,objective=0.99,prefix=debug.,prefix=test.",
+			expected: server.SLO{Name: "collateral-drops", Kind: server.SLODrops, Objective: 0.99, Window: time.Hour, Prefixes: []string{"debug.", "test."}},
+		},
+		{
+			name:     "Latency",
+			slo:      "name=filter-latency,kind=latency,objective=0.99,threshold=20ms,window=6h",
+			expected: server.SLO{Name: "filter-latency", Kind: server.SLOLatency, Objective: 0.99, Threshold: 20 * time.Millisecond, Window: 6 * time.Hour},
+		},
+		{
+			name:    "Latency without threshold",
+			slo:     "name=filter-latency,kind=latency,objective=0.99",
+			invalid: true,
+		},
+		{
+			name:    "Latency with prefix",
+			slo:     "name=filter-latency,kind=latency,objective=0.99,threshold=20ms,prefix=debug.",
+			invalid: true,
+		},
+		{
+			name:    "Objective out of range",
+			slo:     "name=policy-drops,kind=drops,objective=99",
+			invalid: true,
+		},
+		{
+			name:    "Unknown kind",
+			slo:     "name=errors,kind=errors,objective=0.99",
+			invalid: true,
+		},
+		{
+			name:    "Window too short",
+			slo:     "name=policy-drops,kind=drops,objective=0.99,window=10s",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := server.ParseSLO(tc.slo)
+			if tc.invalid {
+				assert.ErrorIs(t, err, server.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+type stubGaugeClient struct {
+	stubStatsdClient
+	mu     sync.Mutex
+	gauges map[string]float64
+}
+
+func (s *stubGaugeClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gauges == nil {
+		s.gauges = make(map[string]float64)
+	}
+	s.gauges[name+" "+tags[len(tags)-2]] = value
+	return nil
+}
+
+func TestHandler_SLOs(t *testing.T) {
+	// Given a proxy tracking two drop SLOs, one of them expecting the drops of
+	// metric.drop, and a latency SLO on a fake clock
+	clk := clock.NewFake(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	resultChan, ts, _, _ := setupCaptureServer(t, "", "")
+	defer ts.Close()
+	sc := &stubGaugeClient{}
+	cfg := server.Config{
+		BaseEndpoint: ts.URL,
+		Clock:        clk,
+		// Filtering metric.slow takes 30ms
+		Filter: filter.Func(func(_ context.Context, series *datadog.Series) filter.Decision {
+			switch series.Metric {
+			case "metric.slow":
+				clk.Advance(30 * time.Millisecond)
+			case "metric.drop":
+				return filter.Drop
+			}
+			return filter.Keep
+		}),
+		SLOs: []server.SLO{
+			{Name: "policy-drops", Kind: server.SLODrops, Objective: 0.5, Window: time.Hour},
+			{Name: "collateral-drops", Kind: server.SLODrops, Objective: 0.5, Window: time.Hour, Prefixes: []string{"metric.drop"}},
+			{Name: "filter-latency", Kind: server.SLOLatency, Objective: 0.5, Threshold: 20 * time.Millisecond, Window: time.Hour},
+		},
+	}
+	h := server.NewHandler(cfg, ts.Client(), sc)
+
+	// When we send a fast payload with a dropped series and a slow payload
+	for _, metrics := range [][]string{{"metric.one", "metric.two", "metric.three", "metric.drop"}, {"metric.slow"}} {
+		b := new(bytes.Buffer)
+		require.NoError(t, json.NewEncoder(b).Encode(defaultMetricsPayload(metrics)))
+		h.MetricsFilter(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/series", b))
+		<-resultChan
+	}
+
+	// Then the burn rates are computed, the expected drops being left out
+	assert.Equal(t, []server.SLOStatus{
+		{Name: "policy-drops", Kind: server.SLODrops, Good: 4, Bad: 1, BurnRate: 0.4},
+		{Name: "collateral-drops", Kind: server.SLODrops, Good: 4},
+		{Name: "filter-latency", Kind: server.SLOLatency, Good: 1, Bad: 1, BurnRate: 1},
+	}, h.SLOs())
+
+	// And reported as gauges
+	h.ReportSLOs()
+	assert.InDelta(t, 0.4, sc.gauges["proxy_filter.slo.burn_rate slo:policy-drops"], 0.0001)
+	assert.InDelta(t, 1.0, sc.gauges["proxy_filter.slo.burn_rate slo:filter-latency"], 0.0001)
+
+	// When the window has passed
+	clk.Advance(time.Hour)
+
+	// Then the events are forgotten
+	assert.Equal(t, []server.SLOStatus{
+		{Name: "policy-drops", Kind: server.SLODrops},
+		{Name: "collateral-drops", Kind: server.SLODrops},
+		{Name: "filter-latency", Kind: server.SLOLatency},
+	}, h.SLOs())
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// streams reports whether the series of r are filtered as they are read, see
+// Config.StreamSeries. Filtering the series of a payload together, merging
+// them or sharding them needs the whole payload first.
+func (h *Handler) streams(r *http.Request) bool {
+	if !h.cfg.StreamSeries || h.cfg.ExportOnly || h.cfg.BatchFilter != nil || h.cfg.MergeDuplicates || len(h.cfg.Shards) > 0 {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "" || mediaType == "application/json"
+}
+
+// streamMetrics forwards the series of r kept by the filters as they are
+// decoded.
+func (h *Handler) streamMetrics(w http.ResponseWriter, r *http.Request) {
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, newError(ErrDecode, err))
+		return
+	}
+	dec := json.NewDecoder(rc)
+	if err := expectDelim(dec, '{'); err != nil {
+		_ = rc.Close()
+		h.writeError(w, r, http.StatusInternalServerError, newError(ErrDecode, err))
+		return
+	}
+	h.streamBody(w, r, rc, func(w io.Writer) error {
+		return h.streamSeries(r, dec, w)
+	})
+}
+
+// streamProtobuf forwards the protobuf payload of r as it is read, without the
+// series of its repeated field dropped by the filters, as filterProtobuf does.
+// A payload takes as much memory as its largest field.
+func (h *Handler) streamProtobuf(w http.ResponseWriter, r *http.Request, field protowire.Number, decode func([]byte) (datadog.Series, error), rewrite func([]byte) []byte) {
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, newError(ErrDecode, err))
+		return
+	}
+	h.streamBody(w, r, rc, func(w io.Writer) error {
+		return h.streamFields(r, bufio.NewReader(rc), w, field, decode, rewrite)
+	})
+}
+
+// streamBody forwards what stream writes, as it reads the decoded body rc of
+// r, through a pipe feeding the upstream request. An error part way through
+// aborts the upstream request.
+func (h *Handler) streamBody(w http.ResponseWriter, r *http.Request, rc io.ReadCloser, stream func(w io.Writer) error) {
+	pr, pw := io.Pipe()
+	// The writer may change the Content-Encoding of r, so it must exist before
+	// the upstream request copies the headers.
+	rw := h.getWriterForRequest(r, pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := stream(rw)
+		_ = rc.Close()
+		if cerr := rw.Close(); err == nil && cerr != nil {
+			err = newError(ErrEncode, cerr)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Aborted streaming request to %s: %v", r.URL.Path, err))
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	h.proxyRequest(w, r, pr)
+	_ = pr.Close()
+	<-done
+}
+
+// streamSeries reads the rest of a Datadog JSON payload whose opening brace
+// dec has read, a series at a time, writing it to w without the dropped
+// series. Every field other than the series is copied as it is.
+func (h *Handler) streamSeries(r *http.Request, dec *json.Decoder, w io.Writer) error {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	bw := bufio.NewWriter(w)
+	_ = bw.WriteByte('{')
+	var dropped int64
+	slo := h.newSLOCounts()
+	for first := true; dec.More(); first = false {
+		tok, err := dec.Token()
+		if err != nil {
+			return newError(ErrDecode, err)
+		}
+		key, _ := tok.(string)
+		if !first {
+			_ = bw.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		_, _ = bw.Write(k)
+		_ = bw.WriteByte(':')
+		if key != "series" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return newError(ErrDecode, err)
+			}
+			_, _ = bw.Write(raw)
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return newError(ErrDecode, err)
+		}
+		_ = bw.WriteByte('[')
+		var kept int
+		for dec.More() {
+			var series datadog.Series
+			if err := dec.Decode(&series); err != nil {
+				return newError(ErrDecode, err)
+			}
+			slo.add(series.Metric)
+			if h.dropSeries(r.Context(), &series) {
+				dropped++
+				continue
+			}
+			slo.keep(series.Metric)
+			h.rewriteSeries(r.Context(), &series)
+			b, err := json.Marshal(series)
+			if err != nil {
+				return newError(ErrEncode, err)
+			}
+			if kept > 0 {
+				_ = bw.WriteByte(',')
+			}
+			kept++
+			if _, err := bw.Write(b); err != nil {
+				return newError(ErrEncode, err)
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return newError(ErrDecode, err)
+		}
+		_ = bw.WriteByte(']')
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return newError(ErrDecode, err)
+	}
+	_, _ = bw.WriteString("}\n")
+	if err := bw.Flush(); err != nil {
+		return newError(ErrEncode, err)
+	}
+	_ = h.statsDClient.Count(metricsFilteredCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	h.recordSLOs(slo, clock.Since(h.clock, start))
+	return nil
+}
+
+// streamFields reads a protobuf payload from br a field at a time, writing it
+// to w without the dropped series of the repeated field.
+func (h *Handler) streamFields(r *http.Request, br *bufio.Reader, w io.Writer, field protowire.Number, decode func([]byte) (datadog.Series, error), rewrite func([]byte) []byte) error {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	bw := bufio.NewWriter(w)
+	var out []byte
+	var dropped int64
+	slo := h.newSLOCounts()
+	for {
+		tag, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newError(ErrDecode, err)
+		}
+		num, typ := protowire.DecodeTag(tag)
+		v, err := readFieldValue(br, typ)
+		if err != nil {
+			return newError(ErrDecode, err)
+		}
+		out = protowire.AppendTag(out[:0], num, typ)
+		if num != field || typ != protowire.BytesType {
+			out = append(out, v...)
+		} else {
+			raw, _ := protowire.ConsumeBytes(v)
+			series, err := decode(raw)
+			if err != nil {
+				return newError(ErrDecode, err)
+			}
+			slo.add(series.Metric)
+			if h.dropSeries(r.Context(), &series) {
+				dropped++
+				continue
+			}
+			slo.keep(series.Metric)
+			out = protowire.AppendBytes(out, rewrite(raw))
+		}
+		if _, err := bw.Write(out); err != nil {
+			return newError(ErrEncode, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return newError(ErrEncode, err)
+	}
+	_ = h.statsDClient.Count(metricsFilteredCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	h.recordSLOs(slo, clock.Since(h.clock, start))
+	return nil
+}
+
+// readFieldValue reads the value of a protobuf field of type typ from br,
+// encoded as it is on the wire.
+func readFieldValue(br *bufio.Reader, typ protowire.Type) ([]byte, error) {
+	var v []byte
+	switch typ {
+	case protowire.VarintType:
+		for {
+			c, err := br.ReadByte()
+			if err != nil {
+				return nil, noEOF(err)
+			}
+			if v = append(v, c); c < 0x80 {
+				return v, nil
+			}
+			if len(v) == binary.MaxVarintLen64 {
+				return nil, errors.New("variable length integer overflow")
+			}
+		}
+	case protowire.Fixed32Type, protowire.Fixed64Type:
+		v = make([]byte, 4)
+		if typ == protowire.Fixed64Type {
+			v = make([]byte, 8)
+		}
+		if _, err := io.ReadFull(br, v); err != nil {
+			return nil, noEOF(err)
+		}
+		return v, nil
+	case protowire.BytesType:
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		// The content is read as it comes, so a bogus length cannot make it
+		// allocate more than the payload holds.
+		buf := bytes.NewBuffer(protowire.AppendVarint(nil, n))
+		if m, err := io.CopyN(buf, br, int64(n)); err != nil || uint64(m) != n {
+			return nil, noEOF(err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported wire type %d", typ)
+	}
+}
+
+// noEOF turns the end of the body in the middle of a field into an error.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// expectDelim reads the next token of dec, failing unless it is delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v, found %v", delim, tok)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestHandler_MetricsFilter_Stream(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      server.Config
+		gzip     bool
+		sent     []string
+		expected []string
+		dropped  int64
+	}{
+		{
+			name:     "Filter metric name",
+			cfg:      server.Config{MetricsPrefixFilter: "some.metric"},
+			sent:     []string{"metric.one", "some.metric.load", "metric.two"},
+			expected: []string{"metric.one", "metric.two"},
+			dropped:  1,
+		},
+		{
+			name:     "Filter metric name gzip",
+			cfg:      server.Config{MetricsPrefixFilter: "some.metric"},
+			gzip:     true,
+			sent:     []string{"some.metric.load", "metric.one"},
+			expected: []string{"metric.one"},
+			dropped:  1,
+		},
+		{
+			name:     "Every series dropped",
+			cfg:      server.Config{MetricsPrefixFilter: "some.metric"},
+			sent:     []string{"some.metric.load"},
+			expected: []string{},
+			dropped:  1,
+		},
+		{
+			name:     "Transform kept series",
+			cfg:      server.Config{MetricsPrefixFilter: "some.metric", Transform: transform.AddTags{"proxied:true"}},
+			sent:     []string{"metric.one"},
+			expected: []string{"metric.one"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running streaming series
+			tc.cfg.StreamSeries = true
+			tc.cfg.Tags = []string{"one"}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", tc.cfg)
+			defer ts.Close()
+
+			// And a payload
+			b := new(bytes.Buffer)
+			require.NoError(t, json.NewEncoder(b).Encode(defaultMetricsPayload(tc.sent)))
+			req := httptest.NewRequest("POST", "/api/v1/series", b)
+			req.Header.Set("Content-Type", "application/json")
+			if tc.gzip {
+				buf := new(bytes.Buffer)
+				zw := gzip.NewWriter(buf)
+				_, _ = zw.Write(b.Bytes())
+				_ = zw.Close()
+				req = httptest.NewRequest("POST", "/api/v1/series", buf)
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			// When we make the request
+			rec := httptest.NewRecorder()
+			h.MetricsFilter(rec, req)
+
+			// Then the kept series are forwarded
+			require.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			forwarded := actual.body
+			if tc.gzip {
+				zr, err := gzip.NewReader(strings.NewReader(actual.body))
+				require.NoError(t, err)
+				body, err := io.ReadAll(zr)
+				require.NoError(t, err)
+				forwarded = string(body)
+			}
+			var actualPayload datadog.MetricsPayload
+			require.NoError(t, json.Unmarshal([]byte(forwarded), &actualPayload))
+			expected := defaultMetricsPayload(tc.expected)
+			if tc.cfg.Transform != nil {
+				for i := range expected.Series {
+					expected.Series[i].SetTags(append(expected.Series[i].GetTags(), "proxied:true"))
+				}
+			}
+			assert.Equal(t, expected, actualPayload)
+			sc.assertCount(t, "proxy_filter.filtered_metrics.count", tc.dropped, []string{"one"}, 1, true)
+		})
+	}
+}
+
+func TestHandler_MetricsFilter_StreamOtherFields(t *testing.T) {
+	// Given server is running streaming series
+	cfg := server.Config{MetricsPrefixFilter: "some.metric", StreamSeries: true}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a payload with a field besides the series is sent
+	body := `{"source":"agent","series":[{"metric":"some.metric.load","points":[[1650000000,1]]},{"metric":"metric.one","points":[[1650000000,1]]}],"extra":{"a":[1,2]}}`
+	rec := httptest.NewRecorder()
+	h.MetricsFilter(rec, httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(body)))
+
+	// Then the other fields are copied as they are
+	require.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	assert.JSONEq(t, `{"source":"agent","series":[{"metric":"metric.one","points":[[1650000000,1]]}],"extra":{"a":[1,2]}}`, actual.body)
+}
+
+func TestHandler_MetricsFilter_StreamNotAnObject(t *testing.T) {
+	// Given server is running streaming series
+	cfg := server.Config{MetricsPrefixFilter: "some.metric", StreamSeries: true}
+	_, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a body that is not a JSON object is sent
+	rec := httptest.NewRecorder()
+	h.MetricsFilter(rec, httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(`["metric.one"]`)))
+
+	// Then it is rejected without reaching upstream
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestHandler_MetricsFilterV2_Stream(t *testing.T) {
+	// Given server is running streaming series with a prefix filter and a resource rule
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		ResourceRules:       []server.ResourceRule{{Type: "device"}},
+		StreamSeries:        true,
+		Tags:                []string{"one"},
+	}
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// And a deflate v2 payload
+	buf := new(bytes.Buffer)
+	zw := zlib.NewWriter(buf)
+	_, _ = zw.Write(encodeSeriesV2(
+		seriesV2{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}, {typ: "device", name: "sda"}}, tags: []string{"env:prod"}},
+		seriesV2{metric: "some.metric.load"},
+		seriesV2{metric: "metric.two", unit: "byte"},
+	))
+	_ = zw.Close()
+	req := httptest.NewRequest("POST", "/api/v2/series", buf)
+	req.Header.Set("Content-Encoding", "deflate")
+
+	// When we make the request
+	rec := httptest.NewRecorder()
+	h.MetricsFilterV2(rec, req)
+
+	// Then the kept series are forwarded rewritten
+	require.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	zr, err := zlib.NewReader(strings.NewReader(actual.body))
+	require.NoError(t, err)
+	forwarded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, encodeSeriesV2(
+		seriesV2{metric: "metric.one", resources: []resourceV2{{typ: "host", name: "web-1"}}, tags: []string{"env:prod"}},
+		seriesV2{metric: "metric.two", unit: "byte"},
+	), forwarded)
+	sc.assertCount(t, "proxy_filter.filtered_metrics.count", 1, []string{"one"}, 1, true)
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	requestsMetricName      = "proxy_filter_requests_total"
+	receivedBytesMetricName = "proxy_filter_received_bytes_total"
+	droppedSeriesMetricName = "proxy_filter_dropped_series_total"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the buckets of
+// the histograms of durations.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the upper bounds, in bytes, of the buckets of the
+// histograms of body sizes.
+var DefaultSizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Telemetry keeps the proxy's own metrics to serve them in the Prometheus
+// text format on the admin API at /metrics, in addition to sending them to
+// DogStatsD. As a StatsdClient it keeps every count and gauge sent through
+// it, such as the drops and the upstream errors, and every distribution as a
+// histogram, such as the request durations and sizes, before passing them on
+// to the client it wraps. As a DecisionSink it counts the requests by route
+// pattern and status, the bytes received and the series dropped.
+//
+// A metric name such as proxy_filter.dropped_requests.count is served as
+// proxy_filter_dropped_requests_total, its tags of the form key:value as
+// labels. The distributions named .duration are served in seconds, e.g.
+// proxy_filter_request_duration_seconds, and those named .size in bytes.
+type Telemetry struct {
+	client StatsdClient
+
+	mu       sync.Mutex
+	families map[string]*telemetryFamily
+}
+
+// telemetryFamily is the series of a metric, by their labels.
+type telemetryFamily struct {
+	kind string
+	// bounds are the upper bounds of the buckets of a histogram.
+	bounds []float64
+	series map[string]*telemetrySeries
+}
+
+type telemetrySeries struct {
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewTelemetry wraps client, which can be nil to only serve the metrics to
+// Prometheus.
+func NewTelemetry(client StatsdClient) *Telemetry {
+	return &Telemetry{client: client, families: make(map[string]*telemetryFamily)}
+}
+
+func (t *Telemetry) Count(name string, value int64, tags []string, rate float64) error {
+	t.add(counterName(name), telemetryLabels(tags), float64(value))
+	if t.client == nil {
+		return nil
+	}
+	return t.client.Count(name, value, tags, rate)
+}
+
+func (t *Telemetry) Gauge(name string, value float64, tags []string, rate float64) error {
+	t.mu.Lock()
+	t.series(promName(name, false), "gauge", telemetryLabels(tags)).value = value
+	t.mu.Unlock()
+	g, ok := t.client.(gauger)
+	if !ok {
+		return nil
+	}
+	return g.Gauge(name, value, tags, rate)
+}
+
+func (t *Telemetry) Record(rec FilterDecisionRecord) {
+	t.add(requestsMetricName, telemetryLabels([]string{"route:" + rec.Route, "method:" + rec.Method, "status_code:" + strconv.Itoa(rec.Status)}), 1)
+	if rec.Bytes > 0 {
+		t.add(receivedBytesMetricName, telemetryLabels([]string{"route:" + rec.Route}), float64(rec.Bytes))
+	}
+	for name, count := range rec.Dropped {
+		t.add(droppedSeriesMetricName, telemetryLabels([]string{"route:" + rec.Route, "filter:" + name}), float64(count))
+	}
+}
+
+func (t *Telemetry) Distribution(name string, value float64, tags []string, rate float64) error {
+	bounds := DefaultDurationBuckets
+	metric := promName(name, false)
+	switch {
+	case strings.HasSuffix(name, ".duration"):
+		metric += "_seconds"
+	case strings.HasSuffix(name, ".size"):
+		metric, bounds = metric+"_bytes", DefaultSizeBuckets
+	}
+	t.mu.Lock()
+	s := t.series(metric, "histogram", telemetryLabels(tags))
+	f := t.families[metric]
+	if f.bounds == nil {
+		f.bounds = bounds
+	}
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(f.bounds))
+	}
+	for i, le := range f.bounds {
+		if value <= le {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+	t.mu.Unlock()
+	d, ok := t.client.(distributer)
+	if !ok {
+		return nil
+	}
+	return d.Distribution(name, value, tags, rate)
+}
+
+func (t *Telemetry) add(name, labels string, value float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.series(name, "counter", labels).value += value
+}
+
+// series returns the series of name with labels, creating it when new. The
+// caller must hold mu.
+func (t *Telemetry) series(name, kind, labels string) *telemetrySeries {
+	f, ok := t.families[name]
+	if !ok {
+		f = &telemetryFamily{kind: kind, series: make(map[string]*telemetrySeries)}
+		t.families[name] = f
+	}
+	s, ok := f.series[labels]
+	if !ok {
+		s = &telemetrySeries{}
+		f.series[labels] = s
+	}
+	return s
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format,
+// sorted by name and labels.
+func (t *Telemetry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.families))
+	for name := range t.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := t.families[name]
+		_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind != "histogram" {
+				_, _ = fmt.Fprintf(w, "%s%s %s\n", name, inBraces(k), formatValue(s.value))
+				continue
+			}
+			for i, le := range f.bounds {
+				_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", name, inBraces(joinLabels(k, `le="`+formatValue(le)+`"`)), s.buckets[i])
+			}
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", name, inBraces(joinLabels(k, `le="+Inf"`)), s.count)
+			_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", name, inBraces(k), formatValue(s.sum))
+			_, _ = fmt.Fprintf(w, "%s_count%s %d\n", name, inBraces(k), s.count)
+		}
+	}
+}
+
+// counterName turns the name of a statsd count into that of a Prometheus
+// counter, which ends in _total rather than .count.
+func counterName(name string) string {
+	return promName(strings.TrimSuffix(name, ".count"), false) + "_total"
+}
+
+// telemetryLabels turns tags into sorted Prometheus labels, as the drop sink
+// does: a tag without a value is a label valued true, and the first value of
+// a repeated key is kept.
+func telemetryLabels(tags []string) string {
+	values := make(map[string]string, len(tags))
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		key, value := tag, "true"
+		if i := strings.IndexByte(tag, ':'); i >= 0 {
+			key, value = tag[:i], tag[i+1:]
+		}
+		key = promName(key, false)
+		if _, ok := values[key]; ok || key == "" || value == "" {
+			continue
+		}
+		values[key] = value
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+`="`+labelValueEscaper.Replace(values[k])+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func inBraces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestTelemetry(t *testing.T) {
+	// Given telemetry wrapping a statsd client
+	sc := &stubStatsdClient{}
+	tel := server.NewTelemetry(sc)
+
+	// When counts, gauges, distributions and decision records go through it
+	_ = tel.Count("proxy_filter.upstream_rejections.count", 1, []string{"env:prod", "route:/api/v1/series", "status_code:429"}, 1)
+	_ = tel.Count("proxy_filter.upstream_rejections.count", 2, []string{"env:prod", "route:/api/v1/series", "status_code:429"}, 1)
+	_ = tel.Count("proxy_filter.passthrough.sent_bytes.count", 512, []string{"host:a\"b", "bare", "host:c"}, 1)
+	_ = tel.Gauge("proxy_filter.fds.open", 12, nil, 1)
+	_ = tel.Distribution("proxy_filter.request.duration", 0.02, []string{"route:/api/v1/series"}, 1)
+	_ = tel.Distribution("proxy_filter.request.duration", 3, []string{"route:/api/v1/series"}, 1)
+	_ = tel.Distribution("proxy_filter.response.size", 2000, nil, 1)
+	tel.Record(server.FilterDecisionRecord{Route: "/api/v1/series", Method: "POST", Status: 202, Bytes: 100, Dropped: map[string]int64{"metric=app.": 3}})
+	tel.Record(server.FilterDecisionRecord{Route: "/api/v1/series", Method: "POST", Status: 202})
+
+	// Then the counts are passed on to statsd
+	sc.assertCount(t, "proxy_filter.upstream_rejections.count", 2, []string{"env:prod", "route:/api/v1/series", "status_code:429"}, 1, true)
+
+	// And they are all served in the Prometheus text format
+	rec := httptest.NewRecorder()
+	tel.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE proxy_filter_dropped_series_total counter
+proxy_filter_dropped_series_total{filter="metric=app.",route="/api/v1/series"} 3
+# TYPE proxy_filter_fds_open gauge
+proxy_filter_fds_open 12
+# TYPE proxy_filter_passthrough_sent_bytes_total counter
+proxy_filter_passthrough_sent_bytes_total{bare="true",host="a\"b"} 512
+# TYPE proxy_filter_received_bytes_total counter
+proxy_filter_received_bytes_total{route="/api/v1/series"} 100
+# TYPE proxy_filter_request_duration_seconds histogram
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="0.005"} 0
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="0.01"} 0
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="0.025"} 1
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="0.05"} 1
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="0.1"} 1
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="0.25"} 1
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="0.5"} 1
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="1"} 1
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="2.5"} 1
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="5"} 2
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="10"} 2
+proxy_filter_request_duration_seconds_bucket{route="/api/v1/series",le="+Inf"} 2
+proxy_filter_request_duration_seconds_sum{route="/api/v1/series"} 3.02
+proxy_filter_request_duration_seconds_count{route="/api/v1/series"} 2
+# TYPE proxy_filter_requests_total counter
+proxy_filter_requests_total{method="POST",route="/api/v1/series",status_code="202"} 2
+# TYPE proxy_filter_response_size_bytes histogram
+proxy_filter_response_size_bytes_bucket{le="256"} 0
+proxy_filter_response_size_bytes_bucket{le="1024"} 0
+proxy_filter_response_size_bytes_bucket{le="4096"} 1
+proxy_filter_response_size_bytes_bucket{le="16384"} 1
+proxy_filter_response_size_bytes_bucket{le="65536"} 1
+proxy_filter_response_size_bytes_bucket{le="262144"} 1
+proxy_filter_response_size_bytes_bucket{le="1.048576e+06"} 1
+proxy_filter_response_size_bytes_bucket{le="4.194304e+06"} 1
+proxy_filter_response_size_bytes_bucket{le="1.6777216e+07"} 1
+proxy_filter_response_size_bytes_bucket{le="+Inf"} 1
+proxy_filter_response_size_bytes_sum 2000
+proxy_filter_response_size_bytes_count 1
+# TYPE proxy_filter_upstream_rejections_total counter
+proxy_filter_upstream_rejections_total{env="prod",route="/api/v1/series",status_code="429"} 3
+`, rec.Body.String())
+}
+
+func TestHandler_Telemetry(t *testing.T) {
+	// Given a proxy keeping telemetry
+	tel := server.NewTelemetry(nil)
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		DecisionSinks:       []server.DecisionSink{tel},
+	}
+	resultChan, ts, _, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+	cfg.BaseEndpoint = ts.URL
+	h := server.NewHandler(cfg, ts.Client(), tel)
+
+	// When a request is made
+	body := mustMarshal(t, defaultMetricsPayload([]string{"some.metric.load", "system.load.1"}))
+	h.MetricsFilter(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body)))
+	<-resultChan
+
+	// And a client sends a request to a path under the catch-all route
+	h.Router().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/some/client/path", nil))
+	<-resultChan
+
+	// Then it is served on the admin API
+	rec := httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	lines := strings.Split(rec.Body.String(), "\n")
+	assert.Contains(t, lines, `proxy_filter_requests_total{method="POST",route="/api/v1/series",status_code="418"} 1`)
+	assert.Contains(t, lines, `proxy_filter_dropped_series_total{filter="metric=some.metric",route="/api/v1/series"} 1`)
+	assert.Contains(t, lines, `proxy_filter_request_duration_seconds_count{route="/api/v1/series"} 1`)
+	assert.Contains(t, lines, `proxy_filter_upstream_duration_seconds_count{route="/api/v1/series"} 1`)
+	assert.Contains(t, lines, `proxy_filter_request_size_bytes_sum{route="/api/v1/series"} `+strconv.Itoa(len(body)))
+	assert.Contains(t, lines, `proxy_filter_received_bytes_total{route="/api/v1/series"} `+strconv.Itoa(len(body)))
+
+	// And labelled with the route pattern rather than the path
+	assert.Contains(t, lines, `proxy_filter_requests_total{method="GET",route="/",status_code="418"} 1`)
+	assert.NotContains(t, rec.Body.String(), "/some/client/path")
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Toggle is the state of a switch of the admin API.
+type Toggle struct {
+	Enabled bool `json:"enabled"`
+	// Until is when the switch turns itself off, unset when it stays on.
+	Until *time.Time `json:"until,omitempty"`
+	// At and By are when and by whom the switch was last turned on or off.
+	At *time.Time `json:"at,omitempty"`
+	By string     `json:"by,omitempty"`
+	// Scope is what the switch applies to while on, for the switches that
+	// take one.
+	Scope interface{} `json:"scope,omitempty"`
+}
+
+// toggle is a switch of the admin API, shared by the copies of a Handler.
+// Requests read it without taking its lock.
+type toggle struct {
+	mu    sync.Mutex
+	state Toggle
+	on    int32
+	until int64
+	scope atomic.Value
+}
+
+// scopeValue wraps the scope of a toggle, which atomic.Value cannot hold
+// when nil.
+type scopeValue struct {
+	v interface{}
+}
+
+// enabled reports whether the switch is on at now. A nil toggle is off.
+func (t *toggle) enabled(now time.Time) bool {
+	if t == nil || atomic.LoadInt32(&t.on) == 0 {
+		return false
+	}
+	until := atomic.LoadInt64(&t.until)
+	return until == 0 || now.UnixNano() < until
+}
+
+func (t *toggle) get(now time.Time) Toggle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.state
+	if state.Until != nil && !now.Before(*state.Until) {
+		state.Enabled, state.Until, state.Scope = false, nil, nil
+	}
+	return state
+}
+
+// scopeOf returns the scope the switch was last turned on with.
+func (t *toggle) scopeOf() interface{} {
+	s, _ := t.scope.Load().(scopeValue)
+	return s.v
+}
+
+// set turns the switch on with scope, for d only when d is not 0, or off.
+func (t *toggle) set(enabled bool, d time.Duration, scope interface{}, by string, now time.Time) Toggle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if enabled {
+		// Stored before the switch is on, the scope stays while it is off
+		// for the requests that just saw it on.
+		t.scope.Store(scopeValue{v: scope})
+	} else {
+		scope = nil
+	}
+	t.state = Toggle{Enabled: enabled, At: &now, By: by, Scope: scope}
+	var until int64
+	if enabled && d > 0 {
+		end := now.Add(d)
+		t.state.Until, until = &end, end.UnixNano()
+	}
+	atomic.StoreInt64(&t.until, until)
+	var on int32
+	if enabled {
+		on = 1
+	}
+	atomic.StoreInt32(&t.on, on)
+	return t.state
+}
+
+// serveToggle serves GET, which returns the state of t, and PUT, which sets
+// it from a body such as {"enabled": true, "for": "15m"}, for being how long
+// it stays on, forever when unset. When parseScope is set, it reads the
+// scope field of the body.
+func (h *Handler) serveToggle(w http.ResponseWriter, r *http.Request, t *toggle, name string, parseScope func(json.RawMessage) (interface{}, error)) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, t.get(h.clock.Now()))
+	case http.MethodPut:
+		var req struct {
+			Enabled bool            `json:"enabled"`
+			For     string          `json:"for"`
+			Scope   json.RawMessage `json:"scope"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		if req.For != "" {
+			var err error
+			if d, err = time.ParseDuration(req.For); err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("bad duration %q", req.For), http.StatusBadRequest)
+				return
+			}
+		}
+		var scope interface{}
+		if parseScope != nil && req.Enabled {
+			var err error
+			if scope, err = parseScope(req.Scope); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		state := t.set(req.Enabled, d, scope, changedBy(r), h.clock.Now())
+		switch {
+		case !state.Enabled:
+			fmt.Println(fmt.Sprintf("%s turned off by %s", name, state.By))
+		case state.Until != nil:
+			fmt.Println(fmt.Sprintf("%s turned on by %s for %s", name, state.By, d))
+		default:
+			fmt.Println(fmt.Sprintf("%s turned on by %s", name, state.By))
+		}
+		writeJSON(w, http.StatusOK, state)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
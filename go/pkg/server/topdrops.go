@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DroppedMetric is how many series of a metric the filters dropped.
+type DroppedMetric struct {
+	Metric string `json:"metric"`
+	Count  int64  `json:"count"`
+	// Error is by how much Count may be over, for a metric that took the
+	// place of a less dropped one once the tracked names were full.
+	Error int64 `json:"error,omitempty"`
+	// Filter is the filter that last dropped a series of the metric.
+	Filter string `json:"filter"`
+}
+
+type trackedDrop struct {
+	DroppedMetric
+	sent int64
+}
+
+// TopDrops is a DropSink counting the dropped series by metric name, served
+// on the admin API at /drops/top as the top ?n=, 10 by default, most dropped
+// metrics. It tracks a bounded number of names: once full, a new name takes
+// the place of the least dropped one, inheriting its count, so that the most
+// dropped metrics are kept however many names churn through.
+type TopDrops struct {
+	size int
+
+	mu      sync.Mutex
+	metrics map[string]*trackedDrop
+}
+
+// NewTopDrops creates a sink tracking up to size metric names.
+func NewTopDrops(size int) *TopDrops {
+	return &TopDrops{size: size, metrics: make(map[string]*trackedDrop)}
+}
+
+func (t *TopDrops) Dropped(rec DroppedSeries) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	name := rec.Series.Metric
+	m, ok := t.metrics[name]
+	if !ok {
+		if t.size <= 0 {
+			return
+		}
+		m = &trackedDrop{DroppedMetric: DroppedMetric{Metric: name}}
+		if len(t.metrics) >= t.size {
+			least := t.least()
+			delete(t.metrics, least.Metric)
+			m.Count, m.Error, m.sent = least.Count, least.Count, least.Count
+		}
+		t.metrics[name] = m
+	}
+	m.Count++
+	m.Filter = rec.Filter
+}
+
+// least returns the least dropped metric tracked. The caller must hold mu.
+func (t *TopDrops) least() *trackedDrop {
+	var least *trackedDrop
+	for _, m := range t.metrics {
+		if least == nil || m.Count < least.Count || m.Count == least.Count && m.Metric < least.Metric {
+			least = m
+		}
+	}
+	return least
+}
+
+// Top returns the n most dropped metrics, most dropped first.
+func (t *TopDrops) Top(n int) []DroppedMetric {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked := t.top(n)
+	top := make([]DroppedMetric, 0, len(tracked))
+	for _, m := range tracked {
+		top = append(top, m.DroppedMetric)
+	}
+	return top
+}
+
+// top returns the n most dropped metrics. The caller must hold mu.
+func (t *TopDrops) top(n int) []*trackedDrop {
+	top := make([]*trackedDrop, 0, len(t.metrics))
+	for _, m := range t.metrics {
+		top = append(top, m)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Metric < top[j].Metric
+	})
+	if n >= 0 && n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
+
+// Send counts the series of the n most dropped metrics dropped since they
+// were last sent, tagged by metric, so that cardinality stays bounded by n.
+// Call it periodically.
+func (t *TopDrops) Send(client StatsdClient, n int, tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range t.top(n) {
+		if m.Count == m.sent {
+			continue
+		}
+		mtags := make([]string, 0, len(tags)+1)
+		mtags = append(mtags, tags...)
+		_ = client.Count(topDroppedMetricsCountName, m.Count-m.sent, append(mtags, "metric:"+m.Metric), 1)
+		m.sent = m.Count
+	}
+}
+
+func (t *TopDrops) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("bad n %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t.Top(n))
+}
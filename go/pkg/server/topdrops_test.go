@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestTopDrops(t *testing.T) {
+	// Given a sink tracking two metric names
+	top := server.NewTopDrops(2)
+	drop := func(metric string, times int) {
+		for i := 0; i < times; i++ {
+			top.Dropped(server.DroppedSeries{Filter: "metric=app.", Series: datadog.Series{Metric: metric}})
+		}
+	}
+
+	// When two metrics are dropped
+	drop("app.a", 3)
+	drop("app.b", 1)
+
+	// Then they are counted, most dropped first
+	assert.Equal(t, []server.DroppedMetric{
+		{Metric: "app.a", Count: 3, Filter: "metric=app."},
+		{Metric: "app.b", Count: 1, Filter: "metric=app."},
+	}, top.Top(10))
+	assert.Equal(t, []server.DroppedMetric{{Metric: "app.a", Count: 3, Filter: "metric=app."}}, top.Top(1))
+
+	// When a third metric is dropped
+	drop("app.c", 3)
+
+	// Then it takes the place of the least dropped one, inheriting its count
+	assert.Equal(t, []server.DroppedMetric{
+		{Metric: "app.c", Count: 4, Error: 1, Filter: "metric=app."},
+		{Metric: "app.a", Count: 3, Filter: "metric=app."},
+	}, top.Top(10))
+
+	// When the top one is sent to statsd
+	sc := &stubStatsdClient{}
+	top.Send(sc, 1, []string{"env:prod"})
+
+	// Then the drops since it took its place are counted, tagged by metric
+	sc.assertCount(t, "proxy_filter.top_dropped_metrics.count", 3, []string{"env:prod", "metric:app.c"}, 1, true)
+
+	// When it is sent again without new drops
+	sc = &stubStatsdClient{}
+	top.Send(sc, 1, []string{"env:prod"})
+
+	// Then nothing is counted
+	assert.False(t, sc.called)
+}
+
+func TestHandler_TopDrops(t *testing.T) {
+	// Given a proxy tracking the dropped metrics
+	top := server.NewTopDrops(10)
+	cfg := server.Config{
+		MetricsPrefixFilter: "some.metric",
+		DropSinks:           []server.DropSink{top},
+	}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a payload with dropped series is sent
+	body := mustMarshal(t, defaultMetricsPayload([]string{"some.metric.a", "some.metric.b", "some.metric.a", "system.load.1"}))
+	h.MetricsFilter(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/series", bytes.NewReader(body)))
+	<-resultChan
+
+	// Then the most dropped metrics are served on the admin API
+	rec := httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, httptest.NewRequest("GET", "/drops/top?n=1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var served []server.DroppedMetric
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, []server.DroppedMetric{{Metric: "some.metric.a", Count: 2, Filter: "metric=some.metric"}}, served)
+
+	// And a bad n is rejected
+	rec = httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, httptest.NewRequest("GET", "/drops/top?n=all", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// TraceRule drops the traces whose root span matches every field it sets.
+// Tags must all be among the meta of the span, written as key:value, and
+// ResourcePattern must match part of its resource.
+type TraceRule struct {
+	Service         string
+	Tags            []string
+	ResourcePattern *regexp.Regexp
+}
+
+// ParseTraceRule parses a rule written as comma separated key=value pairs with
+// the keys service, tag and resource, tag being repeatable, e.g.
+// service=web,resource=^GET /healthz$. The resource key must come last as its
+// regex takes the rest of the rule, commas included.
+func ParseTraceRule(s string) (TraceRule, error) {
+	if s == "" {
+		return TraceRule{}, newError(ErrRuleInvalid, fmt.Errorf("empty rule"))
+	}
+	var rule TraceRule
+	rest := s
+	for rest != "" {
+		var field string
+		if strings.HasPrefix(rest, "resource=") {
+			field, rest = rest, ""
+		} else {
+			field = rest
+			if i := strings.IndexByte(rest, ','); i >= 0 {
+				field, rest = rest[:i], rest[i+1:]
+			} else {
+				rest = ""
+			}
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return TraceRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected key=value in %q", field))
+		}
+		switch kv[0] {
+		case "service":
+			rule.Service = kv[1]
+		case "tag":
+			if !strings.Contains(kv[1], ":") {
+				return TraceRule{}, newError(ErrRuleInvalid, fmt.Errorf("expected tag=key:value in %q", field))
+			}
+			rule.Tags = append(rule.Tags, kv[1])
+		case "resource":
+			re, err := regexp.Compile(kv[1])
+			if err != nil {
+				return TraceRule{}, newError(ErrRuleInvalid, err)
+			}
+			rule.ResourcePattern = re
+		default:
+			return TraceRule{}, newError(ErrRuleInvalid, fmt.Errorf("unknown key %q", kv[0]))
+		}
+	}
+	return rule, nil
+}
+
+// span is what the rules look at in a span.
+type span struct {
+	Service  string
+	Resource string
+	ParentID uint64
+	Meta     map[string]string
+}
+
+func (tr TraceRule) match(s span) bool {
+	if tr.Service == "" && len(tr.Tags) == 0 && tr.ResourcePattern == nil {
+		return false
+	}
+	if tr.Service != "" && s.Service != tr.Service {
+		return false
+	}
+	for _, tag := range tr.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if v, ok := s.Meta[kv[0]]; !ok || v != kv[1] {
+			return false
+		}
+	}
+	return tr.ResourcePattern == nil || tr.ResourcePattern.MatchString(s.Resource)
+}
+
+// TracesFilter filters the msgpack trace payloads sent to /v0.4/traces with
+// Config.TraceRules. The rules look at the root span of each trace, or its
+// first span when it has no root, and a trace is dropped whole.
+func (h *Handler) TracesFilter(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).tracesFilter)
+}
+
+func (h *Handler) tracesFilter(w http.ResponseWriter, r *http.Request) {
+	if len(h.cfg.TraceRules) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, kept, err := h.filterTraces(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if r.Header.Get("X-Datadog-Trace-Count") != "" {
+		r.Header.Set("X-Datadog-Trace-Count", strconv.Itoa(kept))
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// filterTraces returns the request body without the dropped traces, and how
+// many traces it still holds.
+func (h *Handler) filterTraces(r *http.Request) (*bytes.Buffer, int, error) {
+	meta := RequestMetaFrom(r.Context())
+	start := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, 0, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, 0, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, 0, newError(ErrDecode, err)
+	}
+	n, b, err := msgp.ReadArrayHeaderBytes(body)
+	if err != nil {
+		return nil, 0, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, start))
+
+	start = h.clock.Now()
+	var kept [][]byte
+	for i := uint32(0); i < n; i++ {
+		rest, err := msgp.Skip(b)
+		if err != nil {
+			return nil, 0, newError(ErrDecode, err)
+		}
+		trace := b[:len(b)-len(rest)]
+		b = rest
+		root, err := rootSpan(trace)
+		if err != nil {
+			return nil, 0, newError(ErrDecode, err)
+		}
+		if h.dropTrace(root) {
+			meta.RecordDrop("trace")
+			continue
+		}
+		kept = append(kept, trace)
+	}
+	_ = h.statsDClient.Count(filteredTracesCountName, int64(n)-int64(len(kept)), h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if len(kept) == int(n) {
+		buf, err := h.unchangedBody(r, raw)
+		return buf, len(kept), err
+	}
+
+	start = h.clock.Now()
+	out := msgp.AppendArrayHeader(nil, uint32(len(kept)))
+	for _, trace := range kept {
+		out = append(out, trace...)
+	}
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(out)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, 0, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	return buf, len(kept), nil
+}
+
+func (h *Handler) dropTrace(root span) bool {
+	for _, rule := range h.cfg.TraceRules {
+		if rule.match(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootSpan decodes the spans of a msgpack trace, returning the one without a
+// parent, or the first one if none is.
+func rootSpan(trace []byte) (span, error) {
+	n, b, err := msgp.ReadArrayHeaderBytes(trace)
+	if err != nil {
+		return span{}, err
+	}
+	var root span
+	for i := uint32(0); i < n; i++ {
+		var s span
+		s, b, err = decodeSpan(b)
+		if err != nil {
+			return span{}, err
+		}
+		if i == 0 || s.ParentID == 0 {
+			root = s
+		}
+		if s.ParentID == 0 {
+			break
+		}
+	}
+	return root, nil
+}
+
+func decodeSpan(b []byte) (span, []byte, error) {
+	var s span
+	n, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return span{}, nil, err
+	}
+	for i := uint32(0); i < n; i++ {
+		var key string
+		if key, b, err = msgp.ReadStringBytes(b); err != nil {
+			return span{}, nil, err
+		}
+		if msgp.IsNil(b) {
+			b = b[1:]
+			continue
+		}
+		switch key {
+		case "service":
+			s.Service, b, err = msgp.ReadStringBytes(b)
+		case "resource":
+			s.Resource, b, err = msgp.ReadStringBytes(b)
+		case "parent_id":
+			s.ParentID, b, err = readID(b)
+		case "meta":
+			s.Meta, b, err = readStringMap(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+		if err != nil {
+			return span{}, nil, err
+		}
+	}
+	return s, b, nil
+}
+
+// readID reads a span ID, which tracers encode as signed or unsigned integers.
+func readID(b []byte) (uint64, []byte, error) {
+	if msgp.NextType(b) == msgp.IntType {
+		v, rest, err := msgp.ReadInt64Bytes(b)
+		return uint64(v), rest, err
+	}
+	return msgp.ReadUint64Bytes(b)
+}
+
+func readStringMap(b []byte) (map[string]string, []byte, error) {
+	n, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := make(map[string]string, n)
+	for i := uint32(0); i < n; i++ {
+		var k, v string
+		if k, b, err = msgp.ReadStringBytes(b); err != nil {
+			return nil, nil, err
+		}
+		if v, b, err = msgp.ReadStringBytes(b); err != nil {
+			return nil, nil, err
+		}
+		m[k] = v
+	}
+	return m, b, nil
+}
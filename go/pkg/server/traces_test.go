@@ -0,0 +1,125 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+type testSpan struct {
+	service, resource string
+	spanID, parentID  uint64
+	meta              map[string]string
+}
+
+func encodeTrace(b []byte, spans ...testSpan) []byte {
+	b = msgp.AppendArrayHeader(b, uint32(len(spans)))
+	for _, s := range spans {
+		b = msgp.AppendMapHeader(b, 6)
+		b = msgp.AppendString(b, "service")
+		b = msgp.AppendString(b, s.service)
+		b = msgp.AppendString(b, "resource")
+		b = msgp.AppendString(b, s.resource)
+		b = msgp.AppendString(b, "span_id")
+		b = msgp.AppendUint64(b, s.spanID)
+		b = msgp.AppendString(b, "parent_id")
+		b = msgp.AppendUint64(b, s.parentID)
+		b = msgp.AppendString(b, "duration")
+		b = msgp.AppendInt64(b, 1200)
+		b = msgp.AppendString(b, "meta")
+		if s.meta == nil {
+			b = msgp.AppendNil(b)
+			continue
+		}
+		b = msgp.AppendMapStrStr(b, s.meta)
+	}
+	return b
+}
+
+func encodeTraces(traces ...[]testSpan) []byte {
+	b := msgp.AppendArrayHeader(nil, uint32(len(traces)))
+	for _, trace := range traces {
+		b = encodeTrace(b, trace...)
+	}
+	return b
+}
+
+func TestParseTraceRule(t *testing.T) {
+	rule, err := server.ParseTraceRule("service=web,tag=env:dev,resource=^GET /(healthz|ready)$")
+	require.NoError(t, err)
+	assert.Equal(t, server.TraceRule{Service: "web", Tags: []string{"env:dev"}, ResourcePattern: regexp.MustCompile("^GET /(healthz|ready)$")}, rule)
+
+	for _, s := range []string{"", "service=", "tag=env", "resource=(", "host=web-1"} {
+		_, err = server.ParseTraceRule(s)
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_TracesFilter(t *testing.T) {
+	healthcheck := []testSpan{
+		{service: "web", resource: "GET /healthz", spanID: 1},
+		{service: "db", resource: "SELECT 1", spanID: 2, parentID: 1},
+	}
+	checkout := []testSpan{
+		{service: "web", resource: "POST /checkout", spanID: 3, meta: map[string]string{"env": "prod"}},
+		{service: "db", resource: "SELECT 1", spanID: 4, parentID: 3},
+	}
+	sandbox := []testSpan{
+		{service: "cart", resource: "GET /cart", spanID: 6, parentID: 5, meta: map[string]string{"env": "sandbox"}},
+		{service: "web", resource: "GET /cart", spanID: 5, meta: map[string]string{"env": "sandbox"}},
+	}
+	tests := []struct {
+		name            string
+		rules           []server.TraceRule
+		expected        []byte
+		expectedDropped int64
+	}{
+		{
+			name:     "Resource",
+			rules:    []server.TraceRule{{Service: "web", ResourcePattern: regexp.MustCompile("^GET /healthz$")}},
+			expected: encodeTraces(checkout, sandbox),
+			// The db span of the healthcheck does not match, its root does
+			expectedDropped: 1,
+		},
+		{
+			name:            "Tag on root span",
+			rules:           []server.TraceRule{{Service: "web", Tags: []string{"env:sandbox"}}},
+			expected:        encodeTraces(healthcheck, checkout),
+			expectedDropped: 1,
+		},
+		{
+			name:            "No match",
+			rules:           []server.TraceRule{{Service: "db", ResourcePattern: regexp.MustCompile("SELECT")}},
+			expected:        encodeTraces(healthcheck, checkout, sandbox),
+			expectedDropped: 0,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running with trace rules
+			cfg := server.Config{TraceRules: tc.rules, Tags: []string{"one"}}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+			defer ts.Close()
+
+			// When a tracer sends traces
+			req := httptest.NewRequest("POST", "/v0.4/traces", bytes.NewReader(encodeTraces(healthcheck, checkout, sandbox)))
+			req.Header.Set("Content-Type", "application/msgpack")
+			req.Header.Set("X-Datadog-Trace-Count", "3")
+			rec := httptest.NewRecorder()
+			h.TracesFilter(rec, req)
+
+			// Then only the kept traces are forwarded
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			assert.Equal(t, tc.expected, []byte(actual.body))
+			sc.assertCount(t, "proxy_filter.filtered_traces.count", tc.expectedDropped, []string{"one"}, 1, true)
+		})
+	}
+}
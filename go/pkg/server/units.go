@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// v2SeriesUnit is the field number of the unit of a v2 series.
+const v2SeriesUnit = 6
+
+// Units maps metric names to the unit they are reported in.
+type Units struct {
+	names    map[string]string
+	patterns []unitPattern
+}
+
+type unitPattern struct {
+	pattern string
+	unit    string
+}
+
+// LoadUnits reads a mapping of a metric per line written as name=unit, e.g.
+// http.request.duration=millisecond. Names can be patterns in path.Match
+// syntax, which are tried in order after the exact names. Blank lines and
+// lines starting with # are skipped.
+func LoadUnits(r io.Reader) (*Units, error) {
+	u := &Units{names: make(map[string]string)}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, newError(ErrRuleInvalid, fmt.Errorf("line %d: expected name=unit in %q", n, line))
+		}
+		name, unit := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !strings.ContainsAny(name, `*?[\`) {
+			u.names[name] = unit
+			continue
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, newError(ErrRuleInvalid, fmt.Errorf("line %d: %w", n, err))
+		}
+		u.patterns = append(u.patterns, unitPattern{pattern: name, unit: unit})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Lookup returns the unit of metric.
+func (u *Units) Lookup(metric string) (string, bool) {
+	if unit, ok := u.names[metric]; ok {
+		return unit, true
+	}
+	for _, p := range u.patterns {
+		if ok, _ := path.Match(p.pattern, metric); ok {
+			return p.unit, true
+		}
+	}
+	return "", false
+}
+
+// enrichUnit sets the unit of a v2 series known to be well formed from
+// Config.Units when it has none, and reports whether it did.
+func (h *Handler) enrichUnit(b []byte) ([]byte, bool) {
+	if h.cfg.Units == nil {
+		return b, false
+	}
+	var metric string
+	hasUnit := false
+	_ = walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == v2SeriesMetric && typ == protowire.BytesType:
+			metric = string(v)
+		case num == v2SeriesUnit && typ == protowire.BytesType && len(v) > 0:
+			hasUnit = true
+		}
+		return nil
+	})
+	if hasUnit {
+		return b, false
+	}
+	unit, ok := h.cfg.Units.Lookup(metric)
+	if !ok {
+		return b, false
+	}
+	out := make([]byte, 0, len(b)+len(unit)+2)
+	out = append(out, b...)
+	out = protowire.AppendTag(out, v2SeriesUnit, protowire.BytesType)
+	return protowire.AppendString(out, unit), true
+}
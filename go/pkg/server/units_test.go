@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+const unitsFile = `
+# Units of the exporters
+http.request.duration = millisecond
+queue.*.size=message
+`
+
+func TestLoadUnits(t *testing.T) {
+	// Given a mapping file
+	units, err := server.LoadUnits(strings.NewReader(unitsFile))
+	require.NoError(t, err)
+
+	// Then exact names and patterns are looked up
+	for metric, expected := range map[string]string{
+		"http.request.duration": "millisecond",
+		"queue.orders.size":     "message",
+		"queue.size":            "",
+	} {
+		unit, ok := units.Lookup(metric)
+		assert.Equal(t, expected != "", ok, metric)
+		assert.Equal(t, expected, unit, metric)
+	}
+
+	for _, s := range []string{"http.request.duration", "=millisecond", "queue.[.size=message"} {
+		_, err = server.LoadUnits(strings.NewReader(s))
+		assert.ErrorIs(t, err, server.ErrRuleInvalid, s)
+	}
+}
+
+func TestHandler_MetricsFilterV2_Units(t *testing.T) {
+	// Given server is running with a mapping of units
+	units, err := server.LoadUnits(strings.NewReader(unitsFile))
+	require.NoError(t, err)
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", server.Config{Units: units, Tags: []string{"one"}})
+	defer ts.Close()
+
+	// When we send series with and without units
+	body := encodeSeriesV2(
+		seriesV2{metric: "http.request.duration"},
+		seriesV2{metric: "queue.orders.size", unit: "item"},
+		seriesV2{metric: "queue.jobs.size"},
+		seriesV2{metric: "cpu.user"},
+	)
+	req := httptest.NewRequest("POST", "/api/v2/series", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.MetricsFilterV2(rec, req)
+
+	// Then the series missing a unit get the mapped one
+	assert.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	expected := encodeSeriesV2(
+		seriesV2{metric: "http.request.duration", unit: "millisecond"},
+		seriesV2{metric: "queue.orders.size", unit: "item"},
+		seriesV2{metric: "queue.jobs.size", unit: "message"},
+		seriesV2{metric: "cpu.user"},
+	)
+	assert.Equal(t, expected, []byte(actual.body))
+
+	// And the enrichments are counted
+	sc.assertCount(t, "proxy_filter.enriched_units.count", 2, []string{"one"}, 1, true)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// maxUsageEntries bounds the combinations the usage tracker keeps, the
+// requests using new ones once it is full being counted under the route
+// usageOverflowRoute.
+const maxUsageEntries = 1000
+
+const usageOverflowRoute = "other"
+
+// ProtocolUsage is how many requests from a tenant to a route used a
+// combination of HTTP version, Content-Encoding and Content-Type. The route
+// is the pattern the request matched, e.g. /intake/.
+type ProtocolUsage struct {
+	Tenant          string `json:"tenant,omitempty"`
+	Route           string `json:"route"`
+	Protocol        string `json:"protocol"`
+	ContentEncoding string `json:"content_encoding"`
+	ContentType     string `json:"content_type"`
+	Count           int64  `json:"count"`
+}
+
+type usageKey struct {
+	tenant, route, protocol, contentEncoding, contentType string
+}
+
+type usageTracker struct {
+	mu     sync.Mutex
+	counts map[usageKey]int64
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{counts: make(map[usageKey]int64)}
+}
+
+// record counts the request, logging the combinations seen for the first time.
+// Once maxUsageEntries combinations are known, new ones are counted together.
+func (u *usageTracker) record(r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	key := usageKey{
+		tenant:          tenantOf(r),
+		route:           routePattern(r),
+		protocol:        r.Proto,
+		contentEncoding: r.Header.Get("Content-Encoding"),
+		contentType:     contentType,
+	}
+	u.mu.Lock()
+	if _, ok := u.counts[key]; !ok && len(u.counts) >= maxUsageEntries {
+		key = usageKey{route: usageOverflowRoute}
+	}
+	u.counts[key]++
+	first := u.counts[key] == 1 && key.route != usageOverflowRoute
+	u.mu.Unlock()
+	if first {
+		fmt.Println(fmt.Sprintf("First request to %s using %s with Content-Encoding %q and Content-Type %q", key.route, key.protocol, key.contentEncoding, key.contentType))
+	}
+}
+
+func (u *usageTracker) usage() []ProtocolUsage {
+	u.mu.Lock()
+	res := make([]ProtocolUsage, 0, len(u.counts))
+	for k, count := range u.counts {
+		res = append(res, ProtocolUsage{
+			Tenant:          k.tenant,
+			Route:           k.route,
+			Protocol:        k.protocol,
+			ContentEncoding: k.contentEncoding,
+			ContentType:     k.contentType,
+			Count:           count,
+		})
+	}
+	u.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.ContentEncoding != b.ContentEncoding {
+			return a.ContentEncoding < b.ContentEncoding
+		}
+		return a.ContentType < b.ContentType
+	})
+	return res
+}
+
+// Usage returns the protocols, encodings and content types clients used per
+// tenant and route since the handler was created, sorted by tenant and route.
+func (h *Handler) Usage() []ProtocolUsage {
+	return h.usage.usage()
+}
+
+// ProtocolUsage serves Usage as JSON, only showing the usage of their own
+// tenant to tenant scoped admin tokens.
+func (h *Handler) ProtocolUsage(w http.ResponseWriter, r *http.Request) {
+	usage := h.Usage()
+	if tenant, scoped := AdminTenantFrom(r.Context()); scoped {
+		own := usage[:0]
+		for i := range usage {
+			if usage[i].Tenant == tenant {
+				own = append(own, usage[i])
+			}
+		}
+		usage = own
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,75 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Usage(t *testing.T) {
+	// Given an upstream
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	h := server.NewHandler(server.Config{BaseEndpoint: ts.URL}, ts.Client(), &stubStatsdClient{})
+
+	// When clients make requests using different encodings and protocols
+	for _, req := range []struct {
+		proto, path, encoding, contentType string
+	}{
+		{proto: "HTTP/1.1", path: "/api/v1/series", encoding: "gzip", contentType: "application/json"},
+		{proto: "HTTP/1.1", path: "/api/v1/series", encoding: "gzip", contentType: "application/json; charset=utf-8"},
+		{proto: "HTTP/2.0", path: "/api/v1/series", encoding: "deflate", contentType: "application/json"},
+		{proto: "HTTP/1.1", path: "/api/v1/validate"},
+	} {
+		r := httptest.NewRequest("POST", req.path, nil)
+		r.Proto = req.proto
+		if req.encoding != "" {
+			r.Header.Set("Content-Encoding", req.encoding)
+		}
+		if req.contentType != "" {
+			r.Header.Set("Content-Type", req.contentType)
+		}
+		h.ProxyHandle(httptest.NewRecorder(), r)
+	}
+
+	// Then the usage is served sorted by route
+	rec := httptest.NewRecorder()
+	h.ProtocolUsage(rec, httptest.NewRequest("GET", "/usage", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var actual []server.ProtocolUsage
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&actual))
+	assert.Equal(t, []server.ProtocolUsage{
+		{Route: "/api/v1/series", Protocol: "HTTP/1.1", ContentEncoding: "gzip", ContentType: "application/json", Count: 2},
+		{Route: "/api/v1/series", Protocol: "HTTP/2.0", ContentEncoding: "deflate", ContentType: "application/json", Count: 1},
+		{Route: "/api/v1/validate", Protocol: "HTTP/1.1", Count: 1},
+	}, actual)
+}
+
+func TestHandler_Usage_Bounded(t *testing.T) {
+	// Given an upstream
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	h := server.NewHandler(server.Config{BaseEndpoint: ts.URL}, ts.Client(), &stubStatsdClient{})
+
+	// When clients send more distinct content types than are tracked, to
+	// paths of the same route
+	for i := 0; i < 1002; i++ {
+		r := httptest.NewRequest("POST", fmt.Sprintf("/some/path/%d", i), nil)
+		r.Header.Set("Content-Type", fmt.Sprintf("application/x-%d", i))
+		h.Router().ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// Then the usage is keyed by the route pattern, the overflow counted as
+	// other
+	actual := h.Usage()
+	require.Len(t, actual, 1001)
+	assert.Equal(t, server.ProtocolUsage{Route: "/", Protocol: "HTTP/1.1", ContentType: "application/x-0", Count: 1}, actual[0])
+	assert.Equal(t, server.ProtocolUsage{Route: "other", Count: 2}, actual[1000])
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+)
+
+// v5MetricLength is the number of elements of the metrics of v5 intake
+// payloads: name, timestamp, value and attributes.
+const v5MetricLength = 4
+
+// IntakeFilter filters the payloads sent to /intake/ according to their media
+// type, the msgpack ones with MetricsFilterV5 and the others with
+// EventsFilter.
+func (h *Handler) IntakeFilter(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/msgpack" || mediaType == "application/x-msgpack" {
+		h.MetricsFilterV5(w, r)
+		return
+	}
+	h.EventsFilter(w, r)
+}
+
+// MetricsFilterV5 filters the metrics of the msgpack payloads legacy v5
+// agents and libraries send to the /intake/ endpoint. Their metrics are
+// tuples of name, timestamp, value and attributes such as hostname, tags,
+// type, interval and device_name, which the filters see as a v1 series with a
+// device tag. Every other field is copied as it is.
+func (h *Handler) MetricsFilterV5(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, (*Handler).metricsFilterV5)
+}
+
+func (h *Handler) metricsFilterV5(w http.ResponseWriter, r *http.Request) {
+	if len(h.filters) == 0 || r.Method != http.MethodPost {
+		h.proxyRequest(w, r, r.Body)
+		return
+	}
+
+	buf, err := h.filterMetricsV5(r)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h.proxyRequest(w, r, io.NopCloser(buf))
+}
+
+// filterMetricsV5 returns the request body without the dropped metrics.
+func (h *Handler) filterMetricsV5(r *http.Request) (*bytes.Buffer, error) {
+	meta := RequestMetaFrom(r.Context())
+	begin := h.clock.Now()
+	raw, err := bufferBody(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	rc, err := h.getReaderFromRequest(r)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	body, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	n, b, err := msgp.ReadMapHeaderBytes(body)
+	if err != nil {
+		return nil, newError(ErrDecode, err)
+	}
+	meta.RecordTiming("decode", clock.Since(h.clock, begin))
+
+	start := h.clock.Now()
+	out := msgp.AppendMapHeader(nil, n)
+	var dropped int64
+	slo := h.newSLOCounts()
+	for i := uint32(0); i < n; i++ {
+		var key string
+		rest, err := msgp.Skip(b)
+		if err == nil {
+			key, _, err = msgp.ReadStringBytes(b)
+		}
+		if err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		out = append(out, b[:len(b)-len(rest)]...)
+		b = rest
+		if rest, err = msgp.Skip(b); err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		value := b[:len(b)-len(rest)]
+		b = rest
+		if key != "metrics" || msgp.IsNil(value) {
+			out = append(out, value...)
+			continue
+		}
+
+		m, metrics, err := msgp.ReadArrayHeaderBytes(value)
+		if err != nil {
+			return nil, newError(ErrDecode, err)
+		}
+		var kept [][]byte
+		for j := uint32(0); j < m; j++ {
+			rest, err := msgp.Skip(metrics)
+			if err != nil {
+				return nil, newError(ErrDecode, err)
+			}
+			metric := metrics[:len(metrics)-len(rest)]
+			metrics = rest
+			series, err := decodeMetricV5(metric)
+			if err != nil {
+				return nil, newError(ErrDecode, err)
+			}
+			slo.add(series.Metric)
+			if h.dropSeries(r.Context(), &series) {
+				dropped++
+				continue
+			}
+			slo.keep(series.Metric)
+			kept = append(kept, metric)
+		}
+		out = msgp.AppendArrayHeader(out, uint32(len(kept)))
+		for _, metric := range kept {
+			out = append(out, metric...)
+		}
+	}
+	_ = h.statsDClient.Count(metricsFilteredCountName, dropped, h.cfg.Tags, 1)
+	meta.RecordTiming("filter", clock.Since(h.clock, start))
+	if dropped == 0 {
+		h.recordSLOs(slo, clock.Since(h.clock, begin))
+		return h.unchangedBody(r, raw)
+	}
+
+	start = h.clock.Now()
+	buf := new(bytes.Buffer)
+	rw := h.getWriterForRequest(r, buf)
+	_, err = rw.Write(out)
+	if cerr := rw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, newError(ErrEncode, err)
+	}
+	meta.RecordTiming("encode", clock.Since(h.clock, start))
+	h.recordSLOs(slo, clock.Since(h.clock, begin))
+	return buf, nil
+}
+
+// decodeMetricV5 decodes a v5 intake metric into the series the filters see.
+func decodeMetricV5(b []byte) (datadog.Series, error) {
+	var series datadog.Series
+	n, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return series, err
+	}
+	if n != v5MetricLength {
+		return series, fmt.Errorf("expected a metric of %d elements, found %d", v5MetricLength, n)
+	}
+	if series.Metric, b, err = msgp.ReadStringBytes(b); err != nil {
+		return series, err
+	}
+	var ts, value float64
+	if ts, b, err = readNumber(b); err != nil {
+		return series, err
+	}
+	if value, b, err = readNumber(b); err != nil {
+		return series, err
+	}
+	series.Points = [][]*float64{{&ts, &value}}
+	if msgp.IsNil(b) {
+		return series, nil
+	}
+
+	n, b, err = msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return series, err
+	}
+	var tags []string
+	for i := uint32(0); i < n; i++ {
+		var key string
+		if key, b, err = msgp.ReadStringBytes(b); err != nil {
+			return series, err
+		}
+		if msgp.IsNil(b) {
+			b = b[1:]
+			continue
+		}
+		var s string
+		switch key {
+		case "hostname":
+			if s, b, err = msgp.ReadStringBytes(b); err == nil {
+				series.SetHost(s)
+			}
+		case "type":
+			if s, b, err = msgp.ReadStringBytes(b); err == nil {
+				series.SetType(s)
+			}
+		case "device_name":
+			if s, b, err = msgp.ReadStringBytes(b); err == nil {
+				tags = append(tags, "device:"+s)
+			}
+		case "interval":
+			var interval float64
+			if interval, b, err = readNumber(b); err == nil && interval > 0 {
+				series.SetInterval(int64(interval))
+			}
+		case "tags":
+			var m uint32
+			if m, b, err = msgp.ReadArrayHeaderBytes(b); err != nil {
+				return series, err
+			}
+			for j := uint32(0); j < m && err == nil; j++ {
+				if s, b, err = msgp.ReadStringBytes(b); err == nil {
+					tags = append(tags, s)
+				}
+			}
+		default:
+			b, err = msgp.Skip(b)
+		}
+		if err != nil {
+			return series, err
+		}
+	}
+	if tags != nil {
+		series.SetTags(tags)
+	}
+	return series, nil
+}
+
+// readNumber reads a msgpack integer or float as a float64.
+func readNumber(b []byte) (float64, []byte, error) {
+	switch msgp.NextType(b) {
+	case msgp.IntType:
+		v, rest, err := msgp.ReadInt64Bytes(b)
+		return float64(v), rest, err
+	case msgp.UintType:
+		v, rest, err := msgp.ReadUint64Bytes(b)
+		return float64(v), rest, err
+	default:
+		return msgp.ReadFloat64Bytes(b)
+	}
+}
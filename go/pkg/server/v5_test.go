@@ -0,0 +1,153 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func encodeMetricV5(name string, attrs map[string]interface{}) []byte {
+	b := msgp.AppendArrayHeader(nil, 4)
+	b = msgp.AppendString(b, name)
+	b = msgp.AppendInt64(b, 1650000000)
+	b = msgp.AppendFloat64(b, 1.5)
+	b, _ = msgp.AppendIntf(b, attrs)
+	return b
+}
+
+func encodeIntakeV5(metrics ...[]byte) []byte {
+	b := msgp.AppendMapHeader(nil, 3)
+	b = msgp.AppendString(b, "apiKey")
+	b = msgp.AppendString(b, "abc")
+	b = msgp.AppendString(b, "metrics")
+	b = msgp.AppendArrayHeader(b, uint32(len(metrics)))
+	for _, m := range metrics {
+		b = append(b, m...)
+	}
+	b = msgp.AppendString(b, "internalHostname")
+	return msgp.AppendString(b, "web-1")
+}
+
+func TestHandler_MetricsFilterV5(t *testing.T) {
+	load := encodeMetricV5("system.load.1", map[string]interface{}{"hostname": "web-1", "type": "gauge"})
+	disk := encodeMetricV5("some.metric.disk", map[string]interface{}{"hostname": "web-1", "tags": []interface{}{"env:prod"}, "device_name": "sda", "interval": 10})
+	tests := []struct {
+		name            string
+		cfg             server.Config
+		deflate         bool
+		sent            [][]byte
+		expected        [][]byte
+		expectedDropped int64
+	}{
+		{
+			name:            "Filter metric name",
+			cfg:             server.Config{MetricsPrefixFilter: "some.metric"},
+			sent:            [][]byte{load, disk},
+			expected:        [][]byte{load},
+			expectedDropped: 1,
+		},
+		{
+			name:            "Filter device tag deflate",
+			cfg:             server.Config{Filter: stubTagFilter{tag: "device:sda"}},
+			deflate:         true,
+			sent:            [][]byte{disk, load},
+			expected:        [][]byte{load},
+			expectedDropped: 1,
+		},
+		{
+			name:     "Nothing dropped",
+			cfg:      server.Config{MetricsPrefixFilter: "other.metric"},
+			sent:     [][]byte{load, disk},
+			expected: [][]byte{load, disk},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given server is running
+			tc.cfg.Tags = []string{"one"}
+			resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", tc.cfg)
+			defer ts.Close()
+
+			// And a v5 msgpack payload
+			body := encodeIntakeV5(tc.sent...)
+			if tc.deflate {
+				buf := new(bytes.Buffer)
+				zw := zlib.NewWriter(buf)
+				_, _ = zw.Write(body)
+				_ = zw.Close()
+				body = buf.Bytes()
+			}
+			req := httptest.NewRequest("POST", "/intake/", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/msgpack")
+			if tc.deflate {
+				req.Header.Set("Content-Encoding", "deflate")
+			}
+
+			// When we make the request
+			rec := httptest.NewRecorder()
+			h.IntakeFilter(rec, req)
+
+			// Then the kept metrics are forwarded with every other field
+			assert.Equal(t, 418, rec.Code)
+			actual := <-resultChan
+			forwarded := []byte(actual.body)
+			if tc.deflate {
+				forwarded = inflate(t, forwarded)
+			}
+			assert.Equal(t, encodeIntakeV5(tc.expected...), forwarded)
+			sc.assertCount(t, "proxy_filter.filtered_metrics.count", tc.expectedDropped, []string{"one"}, 1, true)
+		})
+	}
+}
+
+func TestHandler_IntakeFilter_JSON(t *testing.T) {
+	// Given server is running with event rules
+	cfg := server.Config{EventRules: []server.EventRule{{TitlePrefix: "Scaled"}}, Tags: []string{"one"}}
+	resultChan, ts, h, sc := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+
+	// When a JSON intake payload is sent
+	body := `{"internalHostname":"web-1","events":{"kubernetes":[{"title":"Scaled up"},{"title":"Deployed web"}]}}`
+	req := httptest.NewRequest("POST", "/intake/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.IntakeFilter(rec, req)
+
+	// Then the events filter handles it
+	assert.Equal(t, 418, rec.Code)
+	actual := <-resultChan
+	assert.JSONEq(t, `{"internalHostname":"web-1","events":{"kubernetes":[{"title":"Deployed web"}]}}`, actual.body)
+	sc.assertCount(t, "proxy_filter.filtered_events.count", 1, []string{"one"}, 1, true)
+}
+
+type stubTagFilter struct {
+	tag string
+}
+
+func (s stubTagFilter) Filter(_ context.Context, series *datadog.Series) filter.Decision {
+	for _, tag := range series.GetTags() {
+		if tag == s.tag {
+			return filter.Drop
+		}
+	}
+	return filter.Keep
+}
+
+func inflate(t *testing.T, b []byte) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	buf := new(bytes.Buffer)
+	_, err = buf.ReadFrom(zr)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
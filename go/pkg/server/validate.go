@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+const maxValidatedBodySize = 64 * 1024
+
+// validateResponse checks that a successful response from upstream for a route
+// listed in Config.ValidateResponses looks like an accepted payload, i.e. a
+// JSON body, and counts the ones that do not. It returns the body to send back
+// to the client.
+func (h *Handler) validateResponse(r *http.Request, resp *http.Response) io.Reader {
+	if !h.validatesResponses(r.URL.Path) || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.Body
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxValidatedBodySize))
+	if err != nil {
+		return io.MultiReader(bytes.NewReader(body), resp.Body)
+	}
+	if reason := acceptedPayloadMismatch(resp, body); reason != "" {
+		fmt.Println(fmt.Sprintf("Upstream response to %s with status %d does not look accepted, %s", r.URL.Path, resp.StatusCode, reason))
+		_ = h.statsDClient.Count(upstreamResponseMismatchCountName, 1, h.tags("route:"+routePattern(r), fmt.Sprintf("status_code:%d", resp.StatusCode)), 1)
+	}
+	return io.MultiReader(bytes.NewReader(body), resp.Body)
+}
+
+func (h *Handler) validatesResponses(route string) bool {
+	for i := range h.cfg.ValidateResponses {
+		if h.cfg.ValidateResponses[i] == route {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptedPayloadMismatch(resp *http.Response, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return fmt.Sprintf("got Content-Type %q", mediaType)
+	}
+	if len(body) == maxValidatedBodySize {
+		return "body is too large for an accepted payload response"
+	}
+
+	rc, err := decodeResponseBody(resp, body)
+	if err != nil {
+		return fmt.Sprintf("could not decompress body, %v", err)
+	}
+	defer rc.Close()
+
+	var v map[string]interface{}
+	if err = json.NewDecoder(rc).Decode(&v); err != nil {
+		return fmt.Sprintf("body is not a JSON object, %v", err)
+	}
+	return ""
+}
+
+// decodeResponseBody decompresses a response body already read according to
+// the Content-Encoding of the response.
+func decodeResponseBody(resp *http.Response, body []byte) (io.ReadCloser, error) {
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		return gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		return zlib.NewReader(bytes.NewReader(body))
+	default:
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
@@ -0,0 +1,96 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_ValidateResponses(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		status       int
+		contentType  string
+		body         string
+		expectCalled bool
+	}{
+		{
+			name:        "Accepted payload",
+			path:        "/api/v1/series",
+			status:      http.StatusAccepted,
+			contentType: "application/json",
+			body:        `{"status":"ok"}`,
+		},
+		{
+			name:         "HTML error page",
+			path:         "/api/v1/series",
+			status:       http.StatusOK,
+			contentType:  "text/html",
+			body:         "<html>Service unavailable</html>",
+			expectCalled: true,
+		},
+		{
+			name:         "JSON content type without JSON body",
+			path:         "/api/v1/series",
+			status:       http.StatusAccepted,
+			contentType:  "application/json",
+			body:         "ok",
+			expectCalled: true,
+		},
+		{
+			name:        "Error status is not validated",
+			path:        "/api/v1/series",
+			status:      http.StatusForbidden,
+			contentType: "text/html",
+			body:        "<html>Forbidden</html>",
+		},
+		{
+			name:        "Route not validated",
+			path:        "/api/v1/validate",
+			status:      http.StatusOK,
+			contentType: "text/html",
+			body:        "<html>OK</html>",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given an upstream returning the response
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				w.WriteHeader(tc.status)
+				_, _ = io.WriteString(w, tc.body)
+			}))
+			defer ts.Close()
+
+			// And the proxy validates responses for series
+			sc := &stubStatsdClient{}
+			cfg := server.Config{
+				BaseEndpoint:      ts.URL,
+				ValidateResponses: []string{"/api/v1/series"},
+				Tags:              []string{"one"},
+			}
+			h := server.NewHandler(cfg, ts.Client(), sc)
+
+			// When we make a request
+			rec := httptest.NewRecorder()
+			h.ProxyHandle(rec, httptest.NewRequest("POST", tc.path, nil))
+
+			// Then the response is passed on untouched
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.body, rec.Body.String())
+
+			// And a mismatch is counted
+			sc.assertCount(t, "proxy_filter.upstream_response_mismatch.count", 1, []string{"one", "route:/api/v1/series", "status_code:" + strconv.Itoa(tc.status)}, 1, tc.expectCalled)
+			if !tc.expectCalled {
+				sc.assertNotCounted(t, "proxy_filter.upstream_response_mismatch.count")
+			}
+		})
+	}
+}
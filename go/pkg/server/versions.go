@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// maxRuleVersions is how many versions of the rule set are kept to roll back
+// to, the oldest being forgotten first.
+const maxRuleVersions = 100
+
+// RuleVersion is a version of the rule set, recorded each time it changes.
+type RuleVersion struct {
+	Version int64     `json:"version"`
+	At      time.Time `json:"at"`
+	// By is who changed the rules, the X-Changed-By header of admin requests
+	// or their remote address, or what reloaded them.
+	By     string `json:"by"`
+	Change string `json:"change"`
+	// Added and Removed are the filters the version added to and removed
+	// from the previous one.
+	Added   []string       `json:"added,omitempty"`
+	Removed []string       `json:"removed,omitempty"`
+	Filters []ActiveFilter `json:"filters"`
+	// ProvenanceTag is the tag the series forwarded with this version carry,
+	// when Config.ProvenanceTag is set.
+	ProvenanceTag string `json:"provenance_tag,omitempty"`
+}
+
+type ruleVersion struct {
+	RuleVersion
+	rules   *handlerRules
+	runtime runtimeSnapshot
+}
+
+// recordVersion records the rule set in effect as a new version. It must be
+// called with h.live.mu held.
+func (h *Handler) recordVersion(by, change string) {
+	l := h.live
+	rules := l.rules.Load().(*handlerRules)
+	runtime := h.runtime.snapshot()
+	l.nextVersion++
+	v := ruleVersion{
+		RuleVersion: RuleVersion{Version: l.nextVersion, At: h.clock.Now(), By: by, Change: change, Filters: activeFilters(rules, runtime.list()), ProvenanceTag: liveProvenanceTag(rules.cfg.ProvenanceTag, runtime.list())},
+		rules:       rules,
+		runtime:     runtime,
+	}
+	if n := len(l.versions); n > 0 {
+		v.Added, v.Removed = diffFilters(l.versions[n-1].Filters, v.Filters)
+	}
+	l.versions = append(l.versions, v)
+	l.active.Store(ruleSet{version: v.Version, provenanceTag: v.ProvenanceTag})
+	if len(l.versions) > maxRuleVersions {
+		l.versions = append(l.versions[:0:0], l.versions[len(l.versions)-maxRuleVersions:]...)
+	}
+}
+
+// RuleVersions returns the versions of the rule set kept, the oldest first.
+func (h *Handler) RuleVersions() []RuleVersion {
+	h.live.mu.Lock()
+	defer h.live.mu.Unlock()
+	res := make([]RuleVersion, 0, len(h.live.versions))
+	for i := range h.live.versions {
+		res = append(res, h.live.versions[i].RuleVersion)
+	}
+	return res
+}
+
+// Rollback brings back the rule set of a version, both the filters of the
+// config it was loaded from and the rules added through the admin API, as a
+// new version. The next reload replaces the filters of the config again. It
+// reports false when the version is not kept.
+func (h *Handler) Rollback(version int64, by string) bool {
+	h.live.mu.Lock()
+	defer h.live.mu.Unlock()
+	for i := range h.live.versions {
+		v := h.live.versions[i]
+		if v.Version != version {
+			continue
+		}
+		h.live.rules.Store(v.rules)
+		h.runtime.restore(v.runtime)
+		h.recordVersion(by, fmt.Sprintf("rollback to version %d", version))
+		return true
+	}
+	return false
+}
+
+// serveRuleVersions serves GET /rules/versions and
+// POST /rules/versions/<version>/rollback.
+func (h *Handler) serveRuleVersions(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "" && r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, h.RuleVersions())
+		return
+	}
+	if !strings.HasSuffix(path, "/rollback") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	version, err := strconv.ParseInt(strings.TrimSuffix(path, "/rollback"), 10, 64)
+	if err != nil || !h.Rollback(version, changedBy(r)) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	versions := h.RuleVersions()
+	writeJSON(w, http.StatusOK, versions[len(versions)-1])
+}
+
+// changedBy returns who made an admin request changing the rules.
+func changedBy(r *http.Request) string {
+	if by := r.Header.Get("X-Changed-By"); by != "" {
+		return by
+	}
+	return r.RemoteAddr
+}
+
+func activeFilters(rules *handlerRules, runtime []RuntimeRule) []ActiveFilter {
+	res := []ActiveFilter{}
+	var add func(c filter.Chain)
+	add = func(c filter.Chain) {
+		for _, f := range c {
+			if inner, ok := f.(filter.Chain); ok {
+				add(inner)
+				continue
+			}
+			res = append(res, ActiveFilter{Filter: filterName(f)})
+		}
+	}
+	add(rules.filters)
+	for _, rule := range runtime {
+		res = append(res, ActiveFilter{Filter: rule.Rule, Runtime: true, ID: rule.ID})
+	}
+	return append(res, groupFilters(rules.cfg.RuleGroups)...)
+}
+
+// diffFilters returns the filters of to that are not in from, and the ones of
+// from that are not in to, the ones of rule groups written as
+// group=<name>,<filter>.
+func diffFilters(from, to []ActiveFilter) (added, removed []string) {
+	count := make(map[string]int)
+	for _, f := range from {
+		count[f.key()]++
+	}
+	for _, f := range to {
+		if count[f.key()] > 0 {
+			count[f.key()]--
+			continue
+		}
+		added = append(added, f.key())
+	}
+	for _, f := range from {
+		if count[f.key()] > 0 {
+			count[f.key()]--
+			removed = append(removed, f.key())
+		}
+	}
+	return added, removed
+}
+
+func (f ActiveFilter) key() string {
+	if f.Group != "" {
+		return "group=" + f.Group + "," + f.Filter
+	}
+	return f.Filter
+}
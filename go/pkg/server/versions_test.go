@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/clock"
+	"github.com/carlosroman/proxy-filter/go/pkg/server"
+)
+
+func TestHandler_Rollback(t *testing.T) {
+	// Given server is running with a prefix filter
+	at := time.Date(2022, 4, 15, 5, 30, 0, 0, time.UTC)
+	cfg := server.Config{MetricsPrefixFilter: "some.metric", Clock: clock.NewFake(at)}
+	resultChan, ts, h, _ := setupCaptureServerWithConfig(t, "", cfg)
+	defer ts.Close()
+	admin := h.Admin()
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Changed-By", "jane")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// And its rules changed through the admin API and a reload
+	require.Equal(t, http.StatusCreated, call("POST", "/rules", `{"rule": "metric=app.debug."}`).Code)
+	h.Reload(server.Config{BaseEndpoint: ts.URL, MetricsPrefixFilter: "system."}, "SIGHUP")
+
+	// When it is rolled back to the first version
+	rec := call("POST", "/rules/versions/1/rollback", "")
+
+	// Then the rules of that version apply
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"app.debug.count", "system.load.1"}, func() []string {
+		body := mustMarshal(t, defaultMetricsPayload([]string{"some.metric.load", "app.debug.count", "system.load.1"}))
+		req := httptest.NewRequest("POST", "/api/v1/series", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		h.MetricsFilter(httptest.NewRecorder(), req)
+		return forwardedMetrics(t, <-resultChan)
+	}())
+
+	// And every change is recorded as a version
+	var versions []server.RuleVersion
+	require.NoError(t, json.Unmarshal(call("GET", "/rules/versions", "").Body.Bytes(), &versions))
+	expected := []server.RuleVersion{
+		{Version: 1, At: at, By: "config", Change: "start", Filters: []server.ActiveFilter{{Filter: "metric=some.metric"}}},
+		{Version: 2, At: at, By: "jane", Change: "add rule 1", Added: []string{"metric=app.debug."}, Filters: []server.ActiveFilter{{Filter: "metric=some.metric"}, {Filter: "metric=app.debug.", Runtime: true, ID: "1"}}},
+		{Version: 3, At: at, By: "SIGHUP", Change: "reload", Added: []string{"metric=system."}, Removed: []string{"metric=some.metric"}, Filters: []server.ActiveFilter{{Filter: "metric=system."}, {Filter: "metric=app.debug.", Runtime: true, ID: "1"}}},
+		{Version: 4, At: at, By: "jane", Change: "rollback to version 1", Added: []string{"metric=some.metric"}, Removed: []string{"metric=system.", "metric=app.debug."}, Filters: []server.ActiveFilter{{Filter: "metric=some.metric"}}},
+	}
+	assert.Equal(t, expected, versions)
+}
+
+func TestHandler_Rollback_Unknown(t *testing.T) {
+	// Given an admin API
+	h := server.NewHandler(server.Config{}, http.DefaultClient, &stubStatsdClient{})
+
+	// When a version that is not kept is rolled back to
+	rec := httptest.NewRecorder()
+	h.Admin().ServeHTTP(rec, httptest.NewRequest("POST", "/rules/versions/42/rollback", nil))
+
+	// Then it is not found
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Len(t, h.RuleVersions(), 1)
+}
@@ -0,0 +1,79 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// Downsample keeps one point per Interval seconds in the series whose name
+// starts with MetricPrefix. Counts are summed over the interval, which becomes
+// the series interval, gauges and rates keep the last point.
+type Downsample struct {
+	MetricPrefix string
+	Interval     int64
+}
+
+// ParseDownsample parses a rule written as comma separated key=value pairs
+// with the keys metric and interval, e.g. metric=app.,interval=60.
+func ParseDownsample(s string) (Downsample, error) {
+	var d Downsample
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Downsample{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("expected key=value in %q", field)}
+		}
+		switch kv[0] {
+		case "metric":
+			d.MetricPrefix = kv[1]
+		case "interval":
+			interval, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || interval <= 0 {
+				return Downsample{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("bad interval %q", kv[1])}
+			}
+			d.Interval = interval
+		default:
+			return Downsample{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("unknown key %q", kv[0])}
+		}
+	}
+	if d.Interval == 0 {
+		return Downsample{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("missing interval")}
+	}
+	return d, nil
+}
+
+func (d Downsample) Transform(_ context.Context, series *datadog.Series) {
+	if d.Interval <= 0 || len(series.Points) < 2 || !strings.HasPrefix(series.Metric, d.MetricPrefix) {
+		return
+	}
+	sum := series.GetType() == "count"
+	points := series.Points[:0]
+	bucket := int64(-1)
+	for _, point := range series.Points {
+		if len(point) < 2 || point[0] == nil || point[1] == nil {
+			continue
+		}
+		b := int64(*point[0]) / d.Interval
+		if len(points) == 0 || b != bucket {
+			bucket = b
+			points = append(points, []*float64{point[0], point[1]})
+			continue
+		}
+		last := points[len(points)-1]
+		if sum {
+			v := *last[1] + *point[1]
+			last[1] = &v
+			continue
+		}
+		last[0], last[1] = point[0], point[1]
+	}
+	series.Points = points
+	if sum {
+		series.SetInterval(d.Interval)
+	}
+}
@@ -0,0 +1,64 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestParseDownsample(t *testing.T) {
+	actual, err := transform.ParseDownsample("metric=app.,interval=60")
+	require.NoError(t, err)
+	assert.Equal(t, transform.Downsample{MetricPrefix: "app.", Interval: 60}, actual)
+
+	for _, rule := range []string{"metric=app.", "metric=app.,interval=0", "metric=app.,every=60"} {
+		_, err = transform.ParseDownsample(rule)
+		assert.ErrorIs(t, err, filter.ErrRuleInvalid, rule)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	points := func(tv ...float64) [][]*float64 {
+		res := make([][]*float64, 0, len(tv)/2)
+		for i := 0; i+1 < len(tv); i += 2 {
+			res = append(res, []*float64{datadog.PtrFloat64(tv[i]), datadog.PtrFloat64(tv[i+1])})
+		}
+		return res
+	}
+	tests := []struct {
+		name             string
+		series           datadog.Series
+		expectedPoints   [][]*float64
+		expectedInterval int64
+	}{
+		{
+			name:           "Gauge keeps last point per interval",
+			series:         datadog.Series{Metric: "app.load", Type: datadog.PtrString("gauge"), Points: points(0, 1, 10, 2, 50, 3, 60, 4, 70, 5)},
+			expectedPoints: points(50, 3, 70, 5),
+		},
+		{
+			name:             "Count sums points per interval",
+			series:           datadog.Series{Metric: "app.requests", Type: datadog.PtrString("count"), Points: points(0, 1, 10, 2, 50, 3, 60, 4, 70, 5)},
+			expectedPoints:   points(0, 6, 60, 9),
+			expectedInterval: 60,
+		},
+		{
+			name:           "Other metrics untouched",
+			series:         datadog.Series{Metric: "db.load", Points: points(0, 1, 10, 2)},
+			expectedPoints: points(0, 1, 10, 2),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			transform.Downsample{MetricPrefix: "app.", Interval: 60}.Transform(context.Background(), &tc.series)
+			assert.Equal(t, tc.expectedPoints, tc.series.Points)
+			assert.Equal(t, tc.expectedInterval, tc.series.GetInterval())
+		})
+	}
+}
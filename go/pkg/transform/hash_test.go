@@ -0,0 +1,55 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestHashTagValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		tags     *[]string
+		expected *[]string
+	}{
+		{
+			name: "No tags on series",
+		},
+		{
+			name:     "Configured keys hashed",
+			secret:   "secret",
+			tags:     &[]string{"env:prod", "user_id:42", "session:abc", "user_id"},
+			expected: &[]string{"env:prod", "user_id:93c121e7aa437a1e", "session:9946dad4e00e913f", "user_id"},
+		},
+		{
+			name:     "Same value hashed the same",
+			secret:   "secret",
+			tags:     &[]string{"user_id:42", "user_id:42"},
+			expected: &[]string{"user_id:93c121e7aa437a1e", "user_id:93c121e7aa437a1e"},
+		},
+		{
+			name:     "Other secret",
+			secret:   "other",
+			tags:     &[]string{"user_id:42"},
+			expected: &[]string{"user_id:bbc9a7ab0cc314dd"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			h := transform.NewHashTagValues([]byte(tc.secret), "user_id", "session")
+			series := datadog.Series{Metric: "metric.one", Tags: tc.tags}
+
+			// When
+			h.Transform(context.Background(), &series)
+
+			// Then
+			assert.Equal(t, tc.expected, series.Tags)
+		})
+	}
+}
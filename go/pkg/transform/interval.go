@@ -0,0 +1,85 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// Interval fixes the interval of the series whose name starts with
+// MetricPrefix and can convert them between counts and rates. Setting
+// Interval replaces the series interval, setting To to count or rate converts
+// the series from the other type using the resulting interval.
+type Interval struct {
+	MetricPrefix string
+	Interval     int64
+	To           string
+}
+
+// ParseInterval parses a rule written as comma separated key=value pairs with
+// the keys metric, interval and to, e.g. metric=app.requests,interval=10,to=rate.
+func ParseInterval(s string) (Interval, error) {
+	var rule Interval
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Interval{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("expected key=value in %q", field)}
+		}
+		switch kv[0] {
+		case "metric":
+			rule.MetricPrefix = kv[1]
+		case "interval":
+			interval, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || interval <= 0 {
+				return Interval{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("bad interval %q", kv[1])}
+			}
+			rule.Interval = interval
+		case "to":
+			if kv[1] != "count" && kv[1] != "rate" {
+				return Interval{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("bad to %q, expected count or rate", kv[1])}
+			}
+			rule.To = kv[1]
+		default:
+			return Interval{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("unknown key %q", kv[0])}
+		}
+	}
+	if rule.Interval == 0 && rule.To == "" {
+		return Interval{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("nothing to change")}
+	}
+	return rule, nil
+}
+
+func (i Interval) Transform(_ context.Context, series *datadog.Series) {
+	if !strings.HasPrefix(series.Metric, i.MetricPrefix) {
+		return
+	}
+	if i.Interval > 0 {
+		series.SetInterval(i.Interval)
+	}
+	interval := series.GetInterval()
+	if interval <= 0 {
+		return
+	}
+	var factor float64
+	switch {
+	case i.To == "rate" && series.GetType() == "count":
+		factor = 1 / float64(interval)
+	case i.To == "count" && series.GetType() == "rate":
+		factor = float64(interval)
+	default:
+		return
+	}
+	for _, point := range series.Points {
+		if len(point) < 2 || point[1] == nil {
+			continue
+		}
+		v := *point[1] * factor
+		point[1] = &v
+	}
+	series.SetType(i.To)
+}
@@ -0,0 +1,87 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestParseInterval(t *testing.T) {
+	actual, err := transform.ParseInterval("metric=app.requests,interval=10,to=rate")
+	require.NoError(t, err)
+	assert.Equal(t, transform.Interval{MetricPrefix: "app.requests", Interval: 10, To: "rate"}, actual)
+
+	for _, rule := range []string{"metric=app.requests", "metric=app.requests,interval=-1", "metric=app.requests,to=gauge", "metric=app.requests,every=10"} {
+		_, err = transform.ParseInterval(rule)
+		assert.ErrorIs(t, err, filter.ErrRuleInvalid, rule)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	points := func(values ...float64) [][]*float64 {
+		res := make([][]*float64, len(values))
+		for i, v := range values {
+			res[i] = []*float64{datadog.PtrFloat64(1650000000), datadog.PtrFloat64(v)}
+		}
+		return res
+	}
+	withInterval := func(s datadog.Series, interval int64) datadog.Series {
+		s.SetInterval(interval)
+		return s
+	}
+	tests := []struct {
+		name     string
+		rule     transform.Interval
+		series   datadog.Series
+		expected datadog.Series
+	}{
+		{
+			name:     "Interval fixed",
+			rule:     transform.Interval{MetricPrefix: "app.", Interval: 10},
+			series:   withInterval(datadog.Series{Metric: "app.requests", Type: datadog.PtrString("count"), Points: points(20)}, 60),
+			expected: withInterval(datadog.Series{Metric: "app.requests", Type: datadog.PtrString("count"), Points: points(20)}, 10),
+		},
+		{
+			name:     "Count to rate with fixed interval",
+			rule:     transform.Interval{MetricPrefix: "app.", Interval: 10, To: "rate"},
+			series:   withInterval(datadog.Series{Metric: "app.requests", Type: datadog.PtrString("count"), Points: points(20)}, 60),
+			expected: withInterval(datadog.Series{Metric: "app.requests", Type: datadog.PtrString("rate"), Points: points(2)}, 10),
+		},
+		{
+			name:     "Rate to count",
+			rule:     transform.Interval{MetricPrefix: "app.", To: "count"},
+			series:   withInterval(datadog.Series{Metric: "app.requests", Type: datadog.PtrString("rate"), Points: points(2)}, 10),
+			expected: withInterval(datadog.Series{Metric: "app.requests", Type: datadog.PtrString("count"), Points: points(20)}, 10),
+		},
+		{
+			name:     "Gauge not converted",
+			rule:     transform.Interval{MetricPrefix: "app.", To: "rate"},
+			series:   withInterval(datadog.Series{Metric: "app.load", Type: datadog.PtrString("gauge"), Points: points(2)}, 10),
+			expected: withInterval(datadog.Series{Metric: "app.load", Type: datadog.PtrString("gauge"), Points: points(2)}, 10),
+		},
+		{
+			name:     "No interval to convert with",
+			rule:     transform.Interval{MetricPrefix: "app.", To: "rate"},
+			series:   datadog.Series{Metric: "app.requests", Type: datadog.PtrString("count"), Points: points(20)},
+			expected: datadog.Series{Metric: "app.requests", Type: datadog.PtrString("count"), Points: points(20)},
+		},
+		{
+			name:     "Other metrics untouched",
+			rule:     transform.Interval{MetricPrefix: "app.", Interval: 10, To: "rate"},
+			series:   withInterval(datadog.Series{Metric: "db.requests", Type: datadog.PtrString("count"), Points: points(20)}, 60),
+			expected: withInterval(datadog.Series{Metric: "db.requests", Type: datadog.PtrString("count"), Points: points(20)}, 60),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.rule.Transform(context.Background(), &tc.series)
+			assert.Equal(t, tc.expected, tc.series)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package transform
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// Merge merges the series with the same name, type, host and tags, in any
+// order, into the first of them, appending the points of the others sorted by
+// timestamp. It returns the merged series and how many were merged away.
+func Merge(series []datadog.Series) ([]datadog.Series, int) {
+	if len(series) < 2 {
+		return series, 0
+	}
+	seen := make(map[string]int, len(series))
+	res := series[:0]
+	merged := 0
+	for i := range series {
+		key := seriesKey(&series[i])
+		j, ok := seen[key]
+		if !ok {
+			seen[key] = len(res)
+			res = append(res, series[i])
+			continue
+		}
+		res[j].Points = append(res[j].Points, series[i].Points...)
+		merged++
+	}
+	if merged > 0 {
+		for i := range res {
+			sortPoints(res[i].Points)
+		}
+	}
+	return res, merged
+}
+
+func seriesKey(series *datadog.Series) string {
+	tags := append([]string(nil), series.GetTags()...)
+	sort.Strings(tags)
+	return series.Metric + "\x00" + series.GetType() + "\x00" + series.GetHost() + "\x00" + strings.Join(tags, "\x00")
+}
+
+func sortPoints(points [][]*float64) {
+	sort.SliceStable(points, func(i, j int) bool {
+		return timestamp(points[i]) < timestamp(points[j])
+	})
+}
+
+func timestamp(point []*float64) float64 {
+	if len(point) == 0 || point[0] == nil {
+		return 0
+	}
+	return *point[0]
+}
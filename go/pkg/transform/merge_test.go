@@ -0,0 +1,65 @@
+package transform_test
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestMerge(t *testing.T) {
+	point := func(ts, v float64) []*float64 {
+		return []*float64{datadog.PtrFloat64(ts), datadog.PtrFloat64(v)}
+	}
+	tests := []struct {
+		name           string
+		series         []datadog.Series
+		expected       []datadog.Series
+		expectedMerged int
+	}{
+		{
+			name: "Duplicates merged",
+			series: []datadog.Series{
+				{Metric: "app.load", Host: datadog.PtrString("web-1"), Tags: &[]string{"env:prod", "service:web"}, Points: [][]*float64{point(20, 2)}},
+				{Metric: "app.cpu", Host: datadog.PtrString("web-1"), Points: [][]*float64{point(10, 5)}},
+				{Metric: "app.load", Host: datadog.PtrString("web-1"), Tags: &[]string{"service:web", "env:prod"}, Points: [][]*float64{point(10, 1)}},
+			},
+			expected: []datadog.Series{
+				{Metric: "app.load", Host: datadog.PtrString("web-1"), Tags: &[]string{"env:prod", "service:web"}, Points: [][]*float64{point(10, 1), point(20, 2)}},
+				{Metric: "app.cpu", Host: datadog.PtrString("web-1"), Points: [][]*float64{point(10, 5)}},
+			},
+			expectedMerged: 1,
+		},
+		{
+			name: "Different hosts kept apart",
+			series: []datadog.Series{
+				{Metric: "app.load", Host: datadog.PtrString("web-1"), Points: [][]*float64{point(10, 1)}},
+				{Metric: "app.load", Host: datadog.PtrString("web-2"), Points: [][]*float64{point(10, 2)}},
+			},
+			expected: []datadog.Series{
+				{Metric: "app.load", Host: datadog.PtrString("web-1"), Points: [][]*float64{point(10, 1)}},
+				{Metric: "app.load", Host: datadog.PtrString("web-2"), Points: [][]*float64{point(10, 2)}},
+			},
+		},
+		{
+			name: "Different tags kept apart",
+			series: []datadog.Series{
+				{Metric: "app.load", Tags: &[]string{"env:prod"}, Points: [][]*float64{point(10, 1)}},
+				{Metric: "app.load", Tags: &[]string{"env:dev"}, Points: [][]*float64{point(10, 2)}},
+			},
+			expected: []datadog.Series{
+				{Metric: "app.load", Tags: &[]string{"env:prod"}, Points: [][]*float64{point(10, 1)}},
+				{Metric: "app.load", Tags: &[]string{"env:dev"}, Points: [][]*float64{point(10, 2)}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, merged := transform.Merge(tc.series)
+			assert.Equal(t, tc.expected, actual)
+			assert.Equal(t, tc.expectedMerged, merged)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package transform
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// DefaultRedaction replaces redacted tag values when no placeholder is set.
+const DefaultRedaction = "redacted"
+
+// Redact replaces the parts of tag values matching any of its patterns, such
+// as emails or IP addresses, with a placeholder. Tag keys are left untouched,
+// and tags without a key, e.g. 10.0.0.12, are redacted whole.
+type Redact struct {
+	Patterns    []*regexp.Regexp
+	Placeholder string
+}
+
+// NewRedact compiles patterns into a Redact using placeholder, or
+// DefaultRedaction if it is empty.
+func NewRedact(placeholder string, patterns ...string) (*Redact, error) {
+	if placeholder == "" {
+		placeholder = DefaultRedaction
+	}
+	r := &Redact{Placeholder: placeholder}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		r.Patterns = append(r.Patterns, re)
+	}
+	return r, nil
+}
+
+func (r *Redact) Transform(_ context.Context, series *datadog.Series) {
+	if !series.HasTags() {
+		return
+	}
+	tags := series.GetTags()
+	for i, tag := range tags {
+		key := tagKey(tag)
+		if len(key) == len(tag) {
+			tags[i] = r.redact(tag)
+			continue
+		}
+		tags[i] = key + ":" + r.redact(tag[len(key)+1:])
+	}
+	series.SetTags(tags)
+}
+
+func (r *Redact) redact(value string) string {
+	for _, re := range r.Patterns {
+		value = re.ReplaceAllLiteralString(value, r.Placeholder)
+	}
+	return value
+}
@@ -0,0 +1,69 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name        string
+		placeholder string
+		tags        *[]string
+		expected    *[]string
+	}{
+		{
+			name: "No tags on series",
+		},
+		{
+			name:     "Email and IP redacted",
+			tags:     &[]string{"env:prod", "user:jane@example.com", "client_ip:10.0.0.12"},
+			expected: &[]string{"env:prod", "user:redacted", "client_ip:redacted"},
+		},
+		{
+			name:        "Partial match with placeholder",
+			placeholder: "xxx",
+			tags:        &[]string{"path:/login?token=abc123"},
+			expected:    &[]string{"path:/login?xxx"},
+		},
+		{
+			name:     "Tag without a key redacted",
+			tags:     &[]string{"10.0.0.12", "jane@example.com", "prod"},
+			expected: &[]string{"redacted", "redacted", "prod"},
+		},
+		{
+			name:     "Key is left untouched",
+			tags:     &[]string{"token=abc123:value"},
+			expected: &[]string{"token=abc123:value"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			r, err := transform.NewRedact(tc.placeholder,
+				`[^@\s]+@[^@\s]+`,
+				`\b\d{1,3}(\.\d{1,3}){3}\b`,
+				`token=\w+`,
+			)
+			require.NoError(t, err)
+			series := datadog.Series{Metric: "metric.one", Tags: tc.tags}
+
+			// When
+			r.Transform(context.Background(), &series)
+
+			// Then
+			assert.Equal(t, tc.expected, series.Tags)
+		})
+	}
+}
+
+func TestNewRedact_InvalidPattern(t *testing.T) {
+	_, err := transform.NewRedact("", "(")
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// Rename renames the series named From to To. With Prefix set, From and To are
+// name prefixes instead, e.g. legacy.app. to app. renames legacy.app.load to
+// app.load.
+type Rename struct {
+	From   string
+	To     string
+	Prefix bool
+}
+
+// ParseRename parses a rename written as from=to, where a trailing * on both
+// sides renames a prefix, e.g. legacy.app.*=app.*.
+func ParseRename(s string) (Rename, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return Rename{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("expected from=to")}
+	}
+	fromPrefix, toPrefix := strings.HasSuffix(kv[0], "*"), strings.HasSuffix(kv[1], "*")
+	if fromPrefix != toPrefix {
+		return Rename{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("both or neither of from and to must end with *")}
+	}
+	if strings.Contains(strings.TrimSuffix(kv[0], "*"), "*") || strings.Contains(strings.TrimSuffix(kv[1], "*"), "*") {
+		return Rename{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("* is only allowed at the end")}
+	}
+	return Rename{
+		From:   strings.TrimSuffix(kv[0], "*"),
+		To:     strings.TrimSuffix(kv[1], "*"),
+		Prefix: fromPrefix,
+	}, nil
+}
+
+func (r Rename) Transform(_ context.Context, series *datadog.Series) {
+	switch {
+	case r.Prefix && strings.HasPrefix(series.Metric, r.From):
+		series.Metric = r.To + series.Metric[len(r.From):]
+	case !r.Prefix && series.Metric == r.From:
+		series.Metric = r.To
+	}
+}
@@ -0,0 +1,105 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestParseRename(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		expected transform.Rename
+		invalid  bool
+	}{
+		{
+			name:     "Exact",
+			rule:     "legacy.app.load=app.load",
+			expected: transform.Rename{From: "legacy.app.load", To: "app.load"},
+		},
+		{
+			name:     "Prefix",
+			rule:     "legacy.app.*=app.*",
+			expected: transform.Rename{From: "legacy.app.", To: "app.", Prefix: true},
+		},
+		{
+			name:     "Prefix removed",
+			rule:     "legacy.*=*",
+			expected: transform.Rename{From: "legacy.", Prefix: true},
+		},
+		{
+			name:    "Missing to",
+			rule:    "legacy.app.load",
+			invalid: true,
+		},
+		{
+			name:    "Prefix on one side",
+			rule:    "legacy.app.*=app.load",
+			invalid: true,
+		},
+		{
+			name:    "Wildcard in the middle",
+			rule:    "legacy.*.load=app.*",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := transform.ParseRename(tc.rule)
+			if tc.invalid {
+				assert.ErrorIs(t, err, filter.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name     string
+		rename   transform.Rename
+		metric   string
+		expected string
+	}{
+		{
+			name:     "Exact match",
+			rename:   transform.Rename{From: "legacy.app.load", To: "app.load"},
+			metric:   "legacy.app.load",
+			expected: "app.load",
+		},
+		{
+			name:     "Exact only matches whole name",
+			rename:   transform.Rename{From: "legacy.app", To: "app"},
+			metric:   "legacy.app.load",
+			expected: "legacy.app.load",
+		},
+		{
+			name:     "Prefix match",
+			rename:   transform.Rename{From: "legacy.app.", To: "app.", Prefix: true},
+			metric:   "legacy.app.load",
+			expected: "app.load",
+		},
+		{
+			name:     "Prefix no match",
+			rename:   transform.Rename{From: "legacy.app.", To: "app.", Prefix: true},
+			metric:   "other.app.load",
+			expected: "other.app.load",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			series := datadog.Series{Metric: tc.metric}
+			tc.rename.Transform(context.Background(), &series)
+			assert.Equal(t, tc.expected, series.Metric)
+		})
+	}
+}
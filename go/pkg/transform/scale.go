@@ -0,0 +1,64 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+)
+
+// Scale multiplies the point values of the series whose name starts with
+// MetricPrefix by Factor, e.g. 1.0/1024/1024 to convert bytes to MiB.
+type Scale struct {
+	MetricPrefix string
+	Factor       float64
+}
+
+// ParseScale parses a scaling rule written as comma separated key=value pairs
+// with the keys metric, multiply and divide, e.g.
+// metric=app.memory.,divide=1048576.
+func ParseScale(s string) (Scale, error) {
+	scale := Scale{Factor: 1}
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Scale{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("expected key=value in %q", field)}
+		}
+		switch kv[0] {
+		case "metric":
+			scale.MetricPrefix = kv[1]
+		case "multiply", "divide":
+			v, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || v == 0 {
+				return Scale{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("bad %s %q", kv[0], kv[1])}
+			}
+			if kv[0] == "divide" {
+				v = 1 / v
+			}
+			scale.Factor *= v
+		default:
+			return Scale{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("unknown key %q", kv[0])}
+		}
+	}
+	if scale.MetricPrefix == "" {
+		return Scale{}, &filter.RuleError{Rule: s, Err: fmt.Errorf("missing metric")}
+	}
+	return scale, nil
+}
+
+func (s Scale) Transform(_ context.Context, series *datadog.Series) {
+	if !strings.HasPrefix(series.Metric, s.MetricPrefix) {
+		return
+	}
+	for _, point := range series.Points {
+		if len(point) < 2 || point[1] == nil {
+			continue
+		}
+		v := *point[1] * s.Factor
+		point[1] = &v
+	}
+}
@@ -0,0 +1,82 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/filter"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestParseScale(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		expected transform.Scale
+		invalid  bool
+	}{
+		{
+			name:     "Divide",
+			rule:     "metric=app.memory.,divide=1024",
+			expected: transform.Scale{MetricPrefix: "app.memory.", Factor: 1.0 / 1024},
+		},
+		{
+			name:     "Multiply",
+			rule:     "metric=app.latency,multiply=0.001",
+			expected: transform.Scale{MetricPrefix: "app.latency", Factor: 0.001},
+		},
+		{
+			name:    "Missing metric",
+			rule:    "divide=1024",
+			invalid: true,
+		},
+		{
+			name:    "Divide by zero",
+			rule:    "metric=app.memory.,divide=0",
+			invalid: true,
+		},
+		{
+			name:    "Unknown key",
+			rule:    "metric=app.memory.,add=1",
+			invalid: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := transform.ParseScale(tc.rule)
+			if tc.invalid {
+				assert.ErrorIs(t, err, filter.ErrRuleInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	points := func(values ...float64) [][]*float64 {
+		res := make([][]*float64, len(values))
+		for i, v := range values {
+			res[i] = []*float64{datadog.PtrFloat64(1650000000), datadog.PtrFloat64(v)}
+		}
+		return res
+	}
+	scale := transform.Scale{MetricPrefix: "app.memory.", Factor: 1.0 / 1024}
+
+	t.Run("Matching series scaled", func(t *testing.T) {
+		series := datadog.Series{Metric: "app.memory.rss", Points: points(2048, 512)}
+		scale.Transform(context.Background(), &series)
+		assert.Equal(t, points(2, 0.5), series.Points)
+	})
+
+	t.Run("Other series untouched", func(t *testing.T) {
+		series := datadog.Series{Metric: "app.cpu", Points: points(2048)}
+		scale.Transform(context.Background(), &series)
+		assert.Equal(t, points(2048), series.Points)
+	})
+}
@@ -0,0 +1,128 @@
+package transform
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+)
+
+// AddTags appends its tags to every series that does not have them yet.
+type AddTags []string
+
+func (a AddTags) Transform(_ context.Context, series *datadog.Series) {
+	tags := series.GetTags()
+	for _, tag := range a {
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > 0 {
+		series.SetTags(tags)
+	}
+}
+
+// StripTagKeys removes the tags with any of its keys from every series, e.g.
+// user_email removes user_email:jane@example.com.
+type StripTagKeys []string
+
+func (s StripTagKeys) Transform(_ context.Context, series *datadog.Series) {
+	if !series.HasTags() {
+		return
+	}
+	tags := series.GetTags()
+	kept := tags[:0]
+	for _, tag := range tags {
+		if !contains(s, tagKey(tag)) {
+			kept = append(kept, tag)
+		}
+	}
+	series.SetTags(kept)
+}
+
+// KeepTagKeys keeps, on the series of each metric it lists, only the tags
+// with one of the keys listed for it, e.g. the ones Metrics without Limits
+// indexes. The series of other metrics are left as they are.
+type KeepTagKeys map[string][]string
+
+func (k KeepTagKeys) Transform(_ context.Context, series *datadog.Series) {
+	keys, ok := k[series.Metric]
+	if !ok || !series.HasTags() {
+		return
+	}
+	tags := series.GetTags()
+	kept := tags[:0]
+	for _, tag := range tags {
+		if contains(keys, tagKey(tag)) {
+			kept = append(kept, tag)
+		}
+	}
+	series.SetTags(kept)
+}
+
+// StripMetricTagKeys removes, from the series of each metric it lists, the
+// tags with one of the keys listed for it, e.g. the ones Metrics without
+// Limits excludes. The series of other metrics are left as they are.
+type StripMetricTagKeys map[string]StripTagKeys
+
+func (s StripMetricTagKeys) Transform(ctx context.Context, series *datadog.Series) {
+	if keys, ok := s[series.Metric]; ok {
+		keys.Transform(ctx, series)
+	}
+}
+
+// LimitTagValues enforces a maximum length in bytes on tag values, truncating
+// longer values or, with Drop set, removing their tags. A tag without a key
+// is a value of its own, removed when truncating leaves nothing of it.
+type LimitTagValues struct {
+	MaxLength int
+	Drop      bool
+}
+
+func (l LimitTagValues) Transform(_ context.Context, series *datadog.Series) {
+	if l.MaxLength <= 0 || !series.HasTags() {
+		return
+	}
+	tags := series.GetTags()
+	kept := tags[:0]
+	for _, tag := range tags {
+		prefix, value := "", tag
+		if i := strings.IndexByte(tag, ':'); i >= 0 {
+			prefix, value = tag[:i+1], tag[i+1:]
+		}
+		if len(value) <= l.MaxLength {
+			kept = append(kept, tag)
+			continue
+		}
+		if l.Drop {
+			continue
+		}
+		value = value[:l.MaxLength]
+		// Do not leave half of a multi-byte character behind.
+		for len(value) > 0 && !utf8.ValidString(value) {
+			value = value[:len(value)-1]
+		}
+		if prefix == "" && value == "" {
+			continue
+		}
+		kept = append(kept, prefix+value)
+	}
+	series.SetTags(kept)
+}
+
+func tagKey(tag string) string {
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+func contains(tags []string, tag string) bool {
+	for i := range tags {
+		if tags[i] == tag {
+			return true
+		}
+	}
+	return false
+}
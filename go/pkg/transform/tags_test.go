@@ -0,0 +1,188 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestAddTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     *[]string
+		add      transform.AddTags
+		expected *[]string
+	}{
+		{
+			name:     "No tags on series",
+			add:      transform.AddTags{"proxied:true"},
+			expected: &[]string{"proxied:true"},
+		},
+		{
+			name:     "Appended",
+			tags:     &[]string{"env:prod"},
+			add:      transform.AddTags{"proxied:true", "cluster:eu1"},
+			expected: &[]string{"env:prod", "proxied:true", "cluster:eu1"},
+		},
+		{
+			name:     "Already there",
+			tags:     &[]string{"proxied:true"},
+			add:      transform.AddTags{"proxied:true"},
+			expected: &[]string{"proxied:true"},
+		},
+		{
+			name: "Nothing to add",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			series := datadog.Series{Metric: "metric.one", Tags: tc.tags}
+			tc.add.Transform(context.Background(), &series)
+			assert.Equal(t, tc.expected, series.Tags)
+		})
+	}
+}
+
+func TestStripTagKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     *[]string
+		expected *[]string
+	}{
+		{
+			name: "No tags on series",
+		},
+		{
+			name:     "Stripped",
+			tags:     &[]string{"env:prod", "user_email:jane@example.com", "session", "service:web"},
+			expected: &[]string{"env:prod", "service:web"},
+		},
+		{
+			name:     "Only value matches",
+			tags:     &[]string{"owner:user_email"},
+			expected: &[]string{"owner:user_email"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			series := datadog.Series{Metric: "metric.one", Tags: tc.tags}
+			transform.StripTagKeys{"user_email", "session"}.Transform(context.Background(), &series)
+			assert.Equal(t, tc.expected, series.Tags)
+		})
+	}
+}
+
+func TestKeepTagKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   string
+		tags     *[]string
+		expected *[]string
+	}{
+		{
+			name:     "Configured metric",
+			metric:   "app.requests",
+			tags:     &[]string{"env:prod", "user_email:jane@example.com", "service:web", "session"},
+			expected: &[]string{"env:prod", "service:web"},
+		},
+		{
+			name:     "Other metric",
+			metric:   "app.errors",
+			tags:     &[]string{"env:prod", "session"},
+			expected: &[]string{"env:prod", "session"},
+		},
+		{
+			name:   "No tags on series",
+			metric: "app.requests",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			series := datadog.Series{Metric: tc.metric, Tags: tc.tags}
+			transform.KeepTagKeys{"app.requests": {"env", "service"}}.Transform(context.Background(), &series)
+			assert.Equal(t, tc.expected, series.Tags)
+		})
+	}
+}
+
+func TestStripMetricTagKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   string
+		tags     *[]string
+		expected *[]string
+	}{
+		{
+			name:     "Configured metric",
+			metric:   "app.requests",
+			tags:     &[]string{"env:prod", "user_email:jane@example.com", "service:web", "session"},
+			expected: &[]string{"env:prod", "service:web"},
+		},
+		{
+			name:     "Other metric",
+			metric:   "app.errors",
+			tags:     &[]string{"env:prod", "session"},
+			expected: &[]string{"env:prod", "session"},
+		},
+		{
+			name:   "No tags on series",
+			metric: "app.requests",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			series := datadog.Series{Metric: tc.metric, Tags: tc.tags}
+			transform.StripMetricTagKeys{"app.requests": {"user_email", "session"}}.Transform(context.Background(), &series)
+			assert.Equal(t, tc.expected, series.Tags)
+		})
+	}
+}
+
+func TestLimitTagValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    transform.LimitTagValues
+		tags     *[]string
+		expected *[]string
+	}{
+		{
+			name:  "No tags on series",
+			limit: transform.LimitTagValues{MaxLength: 5},
+		},
+		{
+			name:     "Truncated",
+			limit:    transform.LimitTagValues{MaxLength: 6},
+			tags:     &[]string{"env:prod", "query:SELECT * FROM users", "standalone-tag-longer-than-limit"},
+			expected: &[]string{"env:prod", "query:SELECT", "standa"},
+		},
+		{
+			name:     "Truncated on a character boundary",
+			limit:    transform.LimitTagValues{MaxLength: 2},
+			tags:     &[]string{"city:Zürich"},
+			expected: &[]string{"city:Z"},
+		},
+		{
+			name:     "Tag without a key truncated to nothing",
+			limit:    transform.LimitTagValues{MaxLength: 1},
+			tags:     &[]string{"env:prod", "ürich"},
+			expected: &[]string{"env:p"},
+		},
+		{
+			name:     "Dropped",
+			limit:    transform.LimitTagValues{MaxLength: 6, Drop: true},
+			tags:     &[]string{"env:prod", "query:SELECT * FROM users", "standalone-tag-longer-than-limit"},
+			expected: &[]string{"env:prod"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			series := datadog.Series{Metric: "metric.one", Tags: tc.tags}
+			tc.limit.Transform(context.Background(), &series)
+			assert.Equal(t, tc.expected, series.Tags)
+		})
+	}
+}
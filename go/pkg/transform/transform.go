@@ -0,0 +1,46 @@
+// Package transform rewrites the series of a metrics payload before they are
+// forwarded.
+package transform
+
+import (
+	"context"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+)
+
+// Transform rewrites a series in place. Implementations must be safe for
+// concurrent use.
+type Transform interface {
+	Transform(ctx context.Context, series *datadog.Series)
+}
+
+// Func adapts a function to a Transform.
+type Func func(ctx context.Context, series *datadog.Series)
+
+func (f Func) Transform(ctx context.Context, series *datadog.Series) {
+	f(ctx, series)
+}
+
+// Chain runs its transforms in order.
+type Chain []Transform
+
+func (c Chain) Transform(ctx context.Context, series *datadog.Series) {
+	for _, t := range c {
+		t.Transform(ctx, series)
+	}
+}
+
+// ForAgents applies Apply only to series sent by agents whose version is
+// in Versions, e.g. to leave older agents' payloads untouched.
+type ForAgents struct {
+	Versions agent.Range
+	Apply    Transform
+}
+
+func (f ForAgents) Transform(ctx context.Context, series *datadog.Series) {
+	if f.Versions.Contains(agent.VersionFrom(ctx)) {
+		f.Apply.Transform(ctx, series)
+	}
+}
@@ -0,0 +1,56 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/api/v1/datadog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosroman/proxy-filter/go/pkg/agent"
+	"github.com/carlosroman/proxy-filter/go/pkg/transform"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) transform.Transform {
+		return transform.Func(func(_ context.Context, _ *datadog.Series) {
+			order = append(order, name)
+		})
+	}
+	chain := transform.Chain{record("first"), record("second")}
+	chain.Transform(context.Background(), &datadog.Series{})
+	assert.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestForAgents(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  agent.Version
+		expected []string
+	}{
+		{
+			name:     "Agent in range",
+			version:  agent.Version{Major: 7, Minor: 41},
+			expected: []string{"proxied:true"},
+		},
+		{
+			name:    "Agent out of range",
+			version: agent.Version{Major: 7, Minor: 39},
+		},
+		{
+			name: "Unknown agent",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := transform.ForAgents{
+				Versions: agent.Range{Min: agent.Version{Major: 7, Minor: 40}},
+				Apply:    transform.AddTags{"proxied:true"},
+			}
+			series := datadog.Series{Metric: "metric.one"}
+			tr.Transform(agent.WithVersion(context.Background(), tc.version), &series)
+			assert.Equal(t, tc.expected, series.GetTags())
+		})
+	}
+}